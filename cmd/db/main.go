@@ -11,6 +11,8 @@ import (
 
 const (
 	dropTables = `
+		DROP TABLE IF EXISTS product_tiers;
+		DROP TABLE IF EXISTS price_history;
 		DROP TABLE IF EXISTS order_items;
 		DROP TABLE IF EXISTS orders;
 		DROP TABLE IF EXISTS products;
@@ -21,53 +23,86 @@ const (
 			id TEXT PRIMARY KEY,
 			name TEXT NOT NULL,
 			price REAL NOT NULL,
-			category TEXT NOT NULL
+			category TEXT NOT NULL,
+			image_url TEXT,
+			deleted_at TIMESTAMP,
+			qty_step INTEGER NOT NULL DEFAULT 1 CHECK (qty_step > 0)
 		);
 
 		CREATE TABLE orders (
 			id TEXT PRIMARY KEY,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			coupon_code TEXT
+			coupon_code TEXT,
+			customer_id TEXT,
+			total REAL
 		);
+		CREATE INDEX idx_orders_created_at ON orders (created_at);
+		CREATE INDEX idx_orders_customer ON orders (customer_id, created_at);
+		CREATE INDEX idx_orders_coupon ON orders (coupon_code);
 
 		CREATE TABLE order_items (
 			order_id TEXT NOT NULL,
 			product_id TEXT NOT NULL,
 			quantity INTEGER NOT NULL,
+			-- Price charged per unit, with bulk pricing, so receipts survive price changes
+			unit_price REAL,
 			FOREIGN KEY (order_id) REFERENCES orders(id),
 			FOREIGN KEY (product_id) REFERENCES products(id),
 			PRIMARY KEY (order_id, product_id)
 		);
+
+		CREATE TABLE price_history (
+			product_id TEXT NOT NULL,
+			price REAL NOT NULL,
+			changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (product_id) REFERENCES products(id)
+		);
+		CREATE INDEX idx_price_history_product ON price_history (product_id, changed_at);
+
+		CREATE TABLE product_tiers (
+			product_id TEXT NOT NULL,
+			min_quantity INTEGER NOT NULL,
+			unit_discount REAL NOT NULL,
+			FOREIGN KEY (product_id) REFERENCES products(id),
+			PRIMARY KEY (product_id, min_quantity)
+		);
 	`
 
 	seedProducts = `
+		-- Image URLs are placeholders until the menu UI has real photos
 		-- Waffles
-		INSERT INTO products (id, name, price, category) VALUES
-			('1', 'Chicken Waffle', 12.99, 'Waffle'),
-			('2', 'Belgian Waffle', 9.99, 'Waffle'),
-			('3', 'Strawberry Waffle', 10.99, 'Waffle'),
-			('4', 'Chocolate Waffle', 10.99, 'Waffle');
+		INSERT INTO products (id, name, price, category, image_url) VALUES
+			('1', 'Chicken Waffle', 12.99, 'Waffle', 'https://orderfoodonline.deno.dev/public/images/image-chicken-waffle-thumbnail.jpg'),
+			('2', 'Belgian Waffle', 9.99, 'Waffle', 'https://orderfoodonline.deno.dev/public/images/image-belgian-waffle-thumbnail.jpg'),
+			('3', 'Strawberry Waffle', 10.99, 'Waffle', 'https://orderfoodonline.deno.dev/public/images/image-strawberry-waffle-thumbnail.jpg'),
+			('4', 'Chocolate Waffle', 10.99, 'Waffle', 'https://orderfoodonline.deno.dev/public/images/image-chocolate-waffle-thumbnail.jpg');
 
 		-- Burgers
-		INSERT INTO products (id, name, price, category) VALUES
-			('5', 'Classic Burger', 8.99, 'Burger'),
-			('6', 'Cheese Burger', 9.99, 'Burger'),
-			('7', 'Veggie Burger', 8.49, 'Burger'),
-			('8', 'Bacon Burger', 11.99, 'Burger');
+		INSERT INTO products (id, name, price, category, image_url) VALUES
+			('5', 'Classic Burger', 8.99, 'Burger', 'https://orderfoodonline.deno.dev/public/images/image-classic-burger-thumbnail.jpg'),
+			('6', 'Cheese Burger', 9.99, 'Burger', 'https://orderfoodonline.deno.dev/public/images/image-cheese-burger-thumbnail.jpg'),
+			('7', 'Veggie Burger', 8.49, 'Burger', 'https://orderfoodonline.deno.dev/public/images/image-veggie-burger-thumbnail.jpg'),
+			('8', 'Bacon Burger', 11.99, 'Burger', 'https://orderfoodonline.deno.dev/public/images/image-bacon-burger-thumbnail.jpg');
 
 		-- Drinks
-		INSERT INTO products (id, name, price, category) VALUES
-			('9', 'Coffee', 3.99, 'Drink'),
-			('10', 'Orange Juice', 4.49, 'Drink'),
-			('11', 'Soda', 2.99, 'Drink'),
-			('12', 'Iced Tea', 3.49, 'Drink');
+		INSERT INTO products (id, name, price, category, image_url) VALUES
+			('9', 'Coffee', 3.99, 'Drink', 'https://orderfoodonline.deno.dev/public/images/image-coffee-thumbnail.jpg'),
+			('10', 'Orange Juice', 4.49, 'Drink', 'https://orderfoodonline.deno.dev/public/images/image-orange-juice-thumbnail.jpg'),
+			('11', 'Soda', 2.99, 'Drink', 'https://orderfoodonline.deno.dev/public/images/image-soda-thumbnail.jpg'),
+			('12', 'Iced Tea', 3.49, 'Drink', 'https://orderfoodonline.deno.dev/public/images/image-iced-tea-thumbnail.jpg');
 
 		-- Desserts
-		INSERT INTO products (id, name, price, category) VALUES
-			('13', 'Ice Cream', 5.99, 'Dessert'),
-			('14', 'Brownie', 6.49, 'Dessert'),
-			('15', 'Cheesecake', 7.99, 'Dessert'),
-			('16', 'Apple Pie', 6.99, 'Dessert');
+		INSERT INTO products (id, name, price, category, image_url) VALUES
+			('13', 'Ice Cream', 5.99, 'Dessert', 'https://orderfoodonline.deno.dev/public/images/image-ice-cream-thumbnail.jpg'),
+			('14', 'Brownie', 6.49, 'Dessert', 'https://orderfoodonline.deno.dev/public/images/image-brownie-thumbnail.jpg'),
+			('15', 'Cheesecake', 7.99, 'Dessert', 'https://orderfoodonline.deno.dev/public/images/image-cheesecake-thumbnail.jpg'),
+			('16', 'Apple Pie', 6.99, 'Dessert', 'https://orderfoodonline.deno.dev/public/images/image-apple-pie-thumbnail.jpg');
+
+		-- Bulk pricing: per-unit discount when ordering at least min_quantity
+		INSERT INTO product_tiers (product_id, min_quantity, unit_discount) VALUES
+			('9', 5, 0.50),
+			('9', 10, 1.00),
+			('11', 6, 0.50);
 	`
 )
 
@@ -109,4 +144,5 @@ func main() {
 	fmt.Println("- 4 Burgers (Classic, Cheese, Veggie, Bacon)")
 	fmt.Println("- 4 Drinks (Coffee, Orange Juice, Soda, Iced Tea)")
 	fmt.Println("- 4 Desserts (Ice Cream, Brownie, Cheesecake, Apple Pie)")
+	fmt.Println("- Bulk pricing tiers for Coffee and Soda")
 }
@@ -3,71 +3,424 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"order-food-online/internal/precompute"
 )
 
+// config holds the command-line options of the tool
+type config struct {
+	inputDir        string
+	outputFile      string
+	workers         int
+	readConcurrency int
+	shardBuckets    bool
+	summary         bool
+	groupByLength   bool
+	manifest        bool
+	allowMixed      bool
+	allowDuplicates bool
+	tempFileMode    string
+	maxBucketMB     int
+	requireAll      bool
+	append          bool
+	tagged          bool
+	topK            int
+	maxOutput       int
+	estimate        float64
+	skipBadBuckets  bool
+	sort            string
+	dryRun          bool
+	partitionBy     string
+	prefixLength    int
+	invalidUTF8     string
+	lengthUnit      string
+	buckets         int
+	minLength       int
+	maxLength       int
+	noEarlyExit     bool
+	minFiles        int
+	verbose         bool
+	tokenize        bool
+	trustedFiles    string
+	progressFile    string
+	diffAgainst     string
+	format          string
+	bucketStats     string
+	compress        bool
+	mkdir           bool
+	resumeDir       string
+}
+
 func main() {
+	var cfg config
+
 	// Define command-line flags
-	inputDir := flag.String("input", "", "Directory containing coupon code files (required)")
-	outputFile := flag.String("output", "valid_codes.txt", "Output file path (default: valid_codes.txt)")
-	workers := flag.Int("workers", 0, "Number of worker goroutines to use (default: auto-detect based on CPU cores)")
+	flag.StringVar(&cfg.inputDir, "input", "", "Directory containing coupon code files, or the tagged file with --tagged (required)")
+	flag.StringVar(&cfg.outputFile, "output", "valid_codes.txt", "Output file path (default: valid_codes.txt)")
+	flag.IntVar(&cfg.workers, "workers", 0, "Number of worker goroutines to use (default: auto-detect based on CPU cores)")
+	flag.IntVar(&cfg.readConcurrency, "read-concurrency", 1, "Number of input files to read at the same time while partitioning (raise for SSDs)")
+	flag.BoolVar(&cfg.shardBuckets, "shard-buckets", false, "Give each reader its own bucket files instead of sharing them, removing write contention on fast SSDs (uses --read-concurrency times as many temp files)")
+	flag.BoolVar(&cfg.compress, "compress", false, "Gzip the output file, adding .gz to its name if needed (also implied by an --output ending in .gz)")
+	flag.StringVar(&cfg.format, "format", "text", "Format of the output file: text (one code per line) or json ({\"count\": N, \"codes\": [...]})")
+	flag.BoolVar(&cfg.mkdir, "mkdir", false, "Create the directory of the output file, and its parents, if it doesn't exist")
+	flag.BoolVar(&cfg.summary, "summary", true, "Write a JSON summary of the run next to the output file (e.g. valid_codes.summary.json)")
+	flag.BoolVar(&cfg.groupByLength, "group-by-length", false, "Write one output file per code length in --length-unit, e.g. valid_codes_8.txt")
+	flag.BoolVar(&cfg.manifest, "manifest", false, "Write output-manifest.json listing every file produced, next to the output file")
+	flag.BoolVar(&cfg.allowDuplicates, "allow-duplicate-files", false, "Read an input file once per name when symlinks or hard links point to it, instead of skipping the repeats")
+	flag.BoolVar(&cfg.allowMixed, "allow-mixed-formats", false, "Process input files even if they don't share a format (plain, gzip, CSV or JSON), warning instead of failing")
+	flag.StringVar(&cfg.tempFileMode, "temp-file-mode", "0600", "Octal permissions of the bucket temp files written while partitioning")
+	flag.IntVar(&cfg.buckets, "buckets", 1000, "Number of bucket files codes are partitioned into: fewer for small inputs, more to keep buckets of huge inputs small (each is an open file while partitioning)")
+	flag.IntVar(&cfg.maxBucketMB, "max-bucket-mb", 0, "Process buckets larger than this many MB by sorting them on disk instead of in memory (default: no cap)")
+	flag.IntVar(&cfg.minLength, "min-len", 8, "Minimum length of a valid code, in --length-unit")
+	flag.IntVar(&cfg.maxLength, "max-len", 10, "Maximum length of a valid code, in --length-unit")
+	flag.StringVar(&cfg.lengthUnit, "length-unit", "bytes", "What --min-len and --max-len count: bytes, or graphemes for codes typed by people, where an accented letter or emoji is one character")
+	flag.BoolVar(&cfg.noEarlyExit, "no-early-exit", false, "Keep tracking the files of codes already found valid, so memory use is predictable for benchmarks and profiling (uses more memory)")
+	flag.IntVar(&cfg.minFiles, "min-files", 2, "Number of input files a code must appear in to be valid")
+	flag.BoolVar(&cfg.requireAll, "require-all", false, "Only keep codes that appear in every input file, instead of in at least --min-files")
+	flag.BoolVar(&cfg.append, "append", false, "Append newly found codes to the output file instead of overwriting it, skipping codes already there")
+	flag.BoolVar(&cfg.tagged, "tagged", false, "Treat --input as a single file of code,fileId rows instead of a directory of code files")
+	flag.IntVar(&cfg.topK, "top-k", 0, "Only output the K codes found in the most files, most frequent first (default: all valid codes)")
+	flag.IntVar(&cfg.maxOutput, "max-output", 0, "Write at most this many valid codes, stopping once more are collected, as a guard against filling the disk (default: no limit)")
+	flag.Float64Var(&cfg.estimate, "estimate", 0, "Only estimate the number of valid codes from this fraction of the codes, e.g. 0.01, and exit without writing output")
+	flag.StringVar(&cfg.resumeDir, "resume-dir", "", "Keep bucket files in this directory with a checkpoint after each input file, resuming an interrupted run over the same input from it (requires --read-concurrency 1)")
+	flag.BoolVar(&cfg.skipBadBuckets, "skip-bad-buckets", false, "Skip a bucket temp file that can't be processed instead of failing the run; its codes are missing from the output")
+	flag.StringVar(&cfg.sort, "sort", "", "Order of the output codes: alpha, length, count (requires --top-k) or none (default: alpha, or count with --top-k)")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "List the input files, effective parameters and estimated temp disk usage, then exit without processing")
+	flag.StringVar(&cfg.partitionBy, "partition-by", "hash", "How codes are assigned to buckets: hash (even spread) or prefix (by leading characters, so buckets hold contiguous ranges of codes)")
+	flag.IntVar(&cfg.prefixLength, "prefix-length", 2, "Number of leading characters used by --partition-by prefix, from 1 to 3")
+	flag.BoolVar(&cfg.tokenize, "tokenize", false, "Split each input line on whitespace and treat every token as a candidate code, for files with several codes per line")
+	flag.StringVar(&cfg.trustedFiles, "trusted-files", "", "Comma-separated file name patterns, e.g. official_*.txt, of trusted sources; a code is then only valid if one of its files is trusted")
+	flag.StringVar(&cfg.invalidUTF8, "invalid-utf8", "keep", "What to do with codes that aren't valid UTF-8: keep them, skip them, or error to fail the run")
+	flag.BoolVar(&cfg.verbose, "verbose", false, "Also report how many buckets each worker processed")
+	flag.StringVar(&cfg.progressFile, "progress-file", "", "Also write progress events as JSON lines to this file, for supervisors tailing a long run")
+	flag.StringVar(&cfg.bucketStats, "bucket-stats", "", "Also write the line count, size, distinct and valid codes of every processed bucket as CSV to this file, to diagnose skew (not supported with --top-k)")
+	flag.StringVar(&cfg.diffAgainst, "diff-against", "", "Compare the valid codes of --input against those of this older input directory, writing the added, removed and unchanged codes next to the output file")
 	flag.Parse()
 
 	// Validate input
-	if *inputDir == "" {
+	if cfg.inputDir == "" {
 		fmt.Fprintf(os.Stderr, "Error: --input flag is required\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	// Check if input directory exists
-	if _, err := os.Stat(*inputDir); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: Input directory '%s' does not exist\n", *inputDir)
+	if _, err := os.Stat(cfg.inputDir); os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Error: Input directory '%s' does not exist\n", cfg.inputDir)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Promo Code Pre-compute Tool\n")
-	fmt.Printf("============================\n\n")
-	fmt.Printf("Input directory: %s\n", *inputDir)
-	fmt.Printf("Output file: %s\n", *outputFile)
-	fmt.Println()
+	if err := run(cfg, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run finds the valid codes and writes every requested output file, reporting progress to out
+func run(cfg config, out io.Writer) error {
+	// The writers compress by extension, so either one selects compression
+	if strings.HasSuffix(cfg.outputFile, ".gz") {
+		cfg.compress = true
+	} else if cfg.compress {
+		cfg.outputFile += ".gz"
+	}
+
+	fmt.Fprintf(out, "Promo Code Pre-compute Tool\n")
+	fmt.Fprintf(out, "============================\n\n")
+	fmt.Fprintf(out, "Input directory: %s\n", cfg.inputDir)
+	fmt.Fprintf(out, "Output file: %s\n", cfg.outputFile)
+	fmt.Fprintln(out)
+
+	switch cfg.format {
+	case "", "text":
+	case "json":
+		if cfg.append || cfg.groupByLength || cfg.diffAgainst != "" {
+			return fmt.Errorf("--format json can't be combined with --append, --group-by-length or --diff-against")
+		}
+	default:
+		return fmt.Errorf("invalid --format %q: must be text or json", cfg.format)
+	}
+	if cfg.bucketStats != "" && (cfg.topK > 0 || cfg.diffAgainst != "") {
+		return fmt.Errorf("--bucket-stats can't be combined with --top-k or --diff-against")
+	}
+	if cfg.compress && (cfg.append || cfg.groupByLength || cfg.diffAgainst != "") {
+		return fmt.Errorf("--compress can't be combined with --append, --group-by-length or --diff-against")
+	}
+	if cfg.append && cfg.groupByLength {
+		return fmt.Errorf("--append can't be combined with --group-by-length")
+	}
+	if cfg.dryRun && cfg.tagged {
+		return fmt.Errorf("--dry-run can't be combined with --tagged")
+	}
+	if cfg.diffAgainst != "" && (cfg.tagged || cfg.dryRun || cfg.append || cfg.groupByLength || cfg.maxOutput > 0) {
+		return fmt.Errorf("--diff-against can't be combined with --tagged, --dry-run, --append, --group-by-length or --max-output")
+	}
+
+	// An empty mode leaves the choice to precompute
+	var tempFileMode uint64
+	if cfg.tempFileMode != "" {
+		mode, err := strconv.ParseUint(cfg.tempFileMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid --temp-file-mode %q: must be an octal permission such as 0600", cfg.tempFileMode)
+		}
+		tempFileMode = mode
+	}
 
 	// Track start time for elapsed time reporting
 	programStart := time.Now()
 
 	// Progress callback that shows elapsed time
+	// Files may be partitioned concurrently, so writes to out are serialised
+	var outMu sync.Mutex
 	progressCallback := func(msg string) {
 		elapsed := time.Since(programStart)
-		fmt.Printf("[%s] %s\n", formatElapsed(elapsed), msg)
+		outMu.Lock()
+		defer outMu.Unlock()
+		fmt.Fprintf(out, "[%s] %s\n", formatElapsed(elapsed), msg)
+	}
+	if cfg.progressFile != "" {
+		file, err := os.Create(cfg.progressFile)
+		if err != nil {
+			return fmt.Errorf("creating progress file: %w", err)
+		}
+		defer file.Close()
+		toFile := precompute.JSONLinesProgress(file)
+		toOut := progressCallback
+		progressCallback = func(msg string) {
+			toOut(msg)
+			toFile(msg)
+		}
 	}
 
 	// Find valid codes using hash partition
 	startTime := time.Now()
-	validCodes, err := precompute.FindValidCodesHashPartition(*inputDir, progressCallback, *workers)
+	opts := precompute.Options{
+		Workers:             cfg.workers,
+		ReadConcurrency:     cfg.readConcurrency,
+		ShardBuckets:        cfg.shardBuckets,
+		AllowMixedFormats:   cfg.allowMixed,
+		AllowDuplicateFiles: cfg.allowDuplicates,
+		TempFileMode:        os.FileMode(tempFileMode),
+		MaxBucketBytes:      int64(cfg.maxBucketMB) * 1024 * 1024,
+		Buckets:             cfg.buckets,
+		DisableEarlyExit:    cfg.noEarlyExit,
+		MinLength:           cfg.minLength,
+		MaxLength:           cfg.maxLength,
+		LengthUnit:          cfg.lengthUnit,
+		MinFiles:            cfg.minFiles,
+		RequireAll:          cfg.requireAll,
+		TopK:                cfg.topK,
+		MaxOutput:           cfg.maxOutput,
+		Tokenize:            cfg.tokenize,
+		TrustedFiles:        splitPatterns(cfg.trustedFiles),
+		ResumeDir:           cfg.resumeDir,
+		SkipBadBuckets:      cfg.skipBadBuckets,
+		BucketStats:         cfg.bucketStats != "",
+		Sort:                cfg.sort,
+		PartitionBy:         cfg.partitionBy,
+		PrefixLength:        cfg.prefixLength,
+		InvalidUTF8:         cfg.invalidUTF8,
+		Progress:            progressCallback,
+		Verbose:             cfg.verbose,
+	}
+	if cfg.estimate > 0 {
+		estimate, err := precompute.EstimateValidCount(cfg.inputDir, cfg.estimate, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Estimate: %s\n", estimate.Note)
+		return nil
+	}
+	if cfg.dryRun {
+		return dryRun(cfg.inputDir, opts, out)
+	}
+
+	// Fail before a long run rather than when writing its output
+	if cfg.mkdir {
+		if err := os.MkdirAll(filepath.Dir(cfg.outputFile), 0755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+	} else if err := precompute.CheckOutputDir(cfg.outputFile); err != nil {
+		return fmt.Errorf("%w (use --mkdir to create it)", err)
+	}
+
+	if cfg.diffAgainst != "" {
+		return diffRun(cfg.diffAgainst, cfg.inputDir, cfg.outputFile, opts, out)
+	}
+
+	find := precompute.FindValidCodes
+	if cfg.tagged {
+		find = precompute.FindValidCodesTagged
+	}
+	result, err := find(cfg.inputDir, opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
-		os.Exit(1)
+		return err
 	}
+	validCodes := result.Codes
 
 	processingTime := time.Since(startTime)
 
 	// Write output
 	progressCallback("Writing output file...")
 
-	if err := precompute.WriteTextFile(validCodes, *outputFile); err != nil {
-		fmt.Fprintf(os.Stderr, "\nError writing output: %v\n", err)
-		os.Exit(1)
+	var artifacts []artifact
+	if cfg.groupByLength {
+		paths, err := precompute.WriteTextFilesByLength(validCodes, cfg.outputFile, cfg.lengthUnit)
+		if err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+		for _, path := range paths {
+			artifacts = append(artifacts, artifact{Path: path, Role: roleCodesByLength})
+		}
+	} else {
+		if cfg.append {
+			appended, err := precompute.WriteTextFileAppend(validCodes, cfg.outputFile)
+			if err != nil {
+				return fmt.Errorf("writing output: %w", err)
+			}
+			progressCallback(fmt.Sprintf("Appended %d new codes, %d were already in the output", appended, len(validCodes)-appended))
+		} else if cfg.format == "json" {
+			if err := precompute.WriteJSONFile(validCodes, cfg.outputFile); err != nil {
+				return fmt.Errorf("writing output: %w", err)
+			}
+		} else if err := precompute.WriteTextFile(validCodes, cfg.outputFile); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+		artifacts = append(artifacts, artifact{Path: cfg.outputFile, Role: roleCodes})
+	}
+
+	if cfg.summary {
+		summaryFile := precompute.SummaryPath(cfg.outputFile)
+		if err := precompute.WriteSummaryFile(result.Stats, summaryFile); err != nil {
+			return fmt.Errorf("writing summary: %w", err)
+		}
+		artifacts = append(artifacts, artifact{Path: summaryFile, Role: roleSummary})
+	}
+
+	if cfg.bucketStats != "" {
+		if err := precompute.WriteBucketStatsFile(result.Stats.Buckets, cfg.bucketStats); err != nil {
+			return fmt.Errorf("writing bucket stats: %w", err)
+		}
+		artifacts = append(artifacts, artifact{Path: cfg.bucketStats, Role: roleBucketStats})
+	}
+
+	if cfg.manifest {
+		manifestFile := manifestPath(cfg.outputFile)
+		if err := writeManifest(artifacts, manifestFile); err != nil {
+			return fmt.Errorf("writing manifest: %w", err)
+		}
+		artifacts = append(artifacts, artifact{Path: manifestFile, Role: roleManifest})
 	}
 
 	// Summary
-	fmt.Printf("\n✓ Success!\n")
-	fmt.Printf("  Valid codes found: %d\n", len(validCodes))
-	fmt.Printf("  Processing time: %s\n", processingTime.Round(time.Second))
-	fmt.Printf("  Output file: %s\n", *outputFile)
-	fmt.Println()
+	fmt.Fprintf(out, "\n✓ Success!\n")
+	fmt.Fprintf(out, "  Valid codes found: %d\n", len(validCodes))
+	if result.Stats.SkippedBuckets > 0 {
+		fmt.Fprintf(out, "  Skipped buckets: %d (their codes are missing)\n", result.Stats.SkippedBuckets)
+	}
+	if result.Stats.Truncated {
+		fmt.Fprintf(out, "  Stopped at --max-output %d (other valid codes are missing)\n", cfg.maxOutput)
+	}
+	fmt.Fprintf(out, "  Processing time: %s\n", processingTime.Round(time.Second))
+	for _, a := range artifacts {
+		fmt.Fprintf(out, "  Output file: %s\n", a.Path)
+	}
+	fmt.Fprintln(out)
+
+	return nil
+}
+
+// dryRun reports the run FindValidCodes would do for inputDir, without doing it
+func dryRun(inputDir string, opts precompute.Options, out io.Writer) error {
+	plan, err := precompute.PlanRun(inputDir, opts)
+	if err != nil {
+		return err
+	}
+	p := plan.Parameters
+
+	fmt.Fprintf(out, "Dry run, nothing will be processed or written\n\n")
+	fmt.Fprintf(out, "Input files (%d):\n", len(plan.Files))
+	for i, file := range plan.Files {
+		fmt.Fprintf(out, "  [%d] %s\n", i, file)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Algorithm: %s\n", plan.Algorithm)
+	fmt.Fprintf(out, "Workers: %d\n", p.Workers)
+	fmt.Fprintf(out, "Read concurrency: %d\n", p.ReadConcurrency)
+	fmt.Fprintf(out, "Buckets: %d\n", p.Buckets)
+	if p.Shards > 0 {
+		fmt.Fprintf(out, "Shards per bucket: %d\n", p.Shards)
+	}
+	fmt.Fprintf(out, "Code length: %d-%d %s\n", p.MinLength, p.MaxLength, p.LengthUnit)
+	fmt.Fprintf(out, "Minimum files per code: %d\n", p.MinFiles)
+	if len(p.TrustedFiles) > 0 {
+		fmt.Fprintf(out, "Trusted files: %s\n", strings.Join(p.TrustedFiles, ", "))
+	}
+	if p.TopK > 0 {
+		fmt.Fprintf(out, "Top K: %d\n", p.TopK)
+	}
+	if p.MaxOutput > 0 {
+		fmt.Fprintf(out, "Maximum output: %d codes\n", p.MaxOutput)
+	}
+	fmt.Fprintf(out, "Sort: %s\n", p.Sort)
+	fmt.Fprintf(out, "Estimated temp disk usage: up to %.1f MB\n", float64(plan.TempBytes)/(1024*1024))
+
+	return nil
+}
+
+// splitPatterns splits a comma-separated list of file name patterns,
+// dropping blanks, or returns nil for an empty list
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// diffRun compares the valid codes of oldDir and newDir, writing each part
+// next to outputFile, e.g. valid_codes.added.txt
+func diffRun(oldDir, newDir, outputFile string, opts precompute.Options, out io.Writer) error {
+	diff, err := precompute.DiffValidCodes(oldDir, newDir, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\n✓ Compared %s to %s\n", newDir, oldDir)
+	parts := []struct {
+		name  string
+		codes []string
+	}{
+		{"added", diff.Added},
+		{"removed", diff.Removed},
+		{"unchanged", diff.Unchanged},
+	}
+	for _, part := range parts {
+		path := diffPath(outputFile, part.name)
+		if err := precompute.WriteTextFile(part.codes, path); err != nil {
+			return fmt.Errorf("writing %s codes: %w", part.name, err)
+		}
+		fmt.Fprintf(out, "  %s: %d codes in %s\n", part.name, len(part.codes), path)
+	}
+	fmt.Fprintln(out)
+
+	return nil
+}
+
+// diffPath returns the path of one part of a diff, e.g. valid_codes.txt
+// becomes valid_codes.added.txt
+func diffPath(outputFile, part string) string {
+	ext := filepath.Ext(outputFile)
+	return strings.TrimSuffix(outputFile, ext) + "." + part + ext
 }
 
 // formatElapsed formats a duration into a human-readable elapsed time string
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"order-food-online/internal/precompute"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFormatElapsed(t *testing.T) {
@@ -42,3 +53,474 @@ func TestFormatElapsed(t *testing.T) {
 		})
 	}
 }
+
+func TestRun_Manifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+
+	testData := map[string]string{
+		"file1.txt": "ABCDEFGH\nABCDEFGHI\nSHORT\n",
+		"file2.txt": "ABCDEFGH\nABCDEFGHI\n",
+		"file3.txt": "IJKLMNOP\n",
+	}
+	for filename, content := range testData {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte(content), 0644))
+	}
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	err := run(config{
+		inputDir:      inputDir,
+		outputFile:    outputFile,
+		summary:       true,
+		groupByLength: true,
+		manifest:      true,
+	}, io.Discard)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "output-manifest.json"))
+	require.NoError(t, err, "Manifest should be written next to the output")
+
+	var manifest struct {
+		Files []artifact `json:"files"`
+	}
+	require.NoError(t, json.Unmarshal(content, &manifest))
+
+	expected := []artifact{
+		{Path: filepath.Join(tmpDir, "valid_codes_8.txt"), Role: roleCodesByLength},
+		{Path: filepath.Join(tmpDir, "valid_codes_9.txt"), Role: roleCodesByLength},
+		{Path: filepath.Join(tmpDir, "valid_codes.summary.json"), Role: roleSummary},
+	}
+	require.Len(t, manifest.Files, len(expected))
+	for i, want := range expected {
+		assert.Equal(t, want.Path, manifest.Files[i].Path)
+		assert.Equal(t, want.Role, manifest.Files[i].Role)
+
+		info, err := os.Stat(want.Path)
+		require.NoError(t, err)
+		assert.Equal(t, info.Size(), manifest.Files[i].Size)
+	}
+}
+
+func TestRun_Append(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+
+	testData := map[string]string{
+		"file1.txt": "ABCDEFGH\nABCDEFGHI\n",
+		"file2.txt": "ABCDEFGH\nABCDEFGHI\n",
+		"file3.txt": "IJKLMNOP\n",
+	}
+	for filename, content := range testData {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte(content), 0644))
+	}
+
+	// A previous run already found ABCDEFGH
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	require.NoError(t, os.WriteFile(outputFile, []byte("EXISTING1\nABCDEFGH\n"), 0644))
+
+	cfg := config{inputDir: inputDir, outputFile: outputFile, append: true}
+	require.NoError(t, run(cfg, io.Discard))
+	// Running again must not append anything
+	require.NoError(t, run(cfg, io.Discard))
+
+	content, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	assert.Equal(t, "EXISTING1\nABCDEFGH\nABCDEFGHI\n", string(content))
+
+	cfg.groupByLength = true
+	assert.Error(t, run(cfg, io.Discard), "--append with --group-by-length should be rejected")
+}
+
+func TestRun_FormatJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+
+	testData := map[string]string{
+		"file1.txt": "SUPER100\nHAPPYHRS\nFIFTYOFF\n",
+		"file2.txt": "FIFTYOFF\nSUPER100\nHAPPYHRS\n",
+		"file3.txt": "IJKLMNOP\n",
+	}
+	for filename, content := range testData {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte(content), 0644))
+	}
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.json")
+	cfg := config{inputDir: inputDir, outputFile: outputFile, format: "json"}
+	require.NoError(t, run(cfg, io.Discard))
+
+	content, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	var decoded struct {
+		Count int      `json:"count"`
+		Codes []string `json:"codes"`
+	}
+	require.NoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, 3, decoded.Count)
+	assert.Equal(t, []string{"FIFTYOFF", "HAPPYHRS", "SUPER100"}, decoded.Codes)
+
+	cfg.append = true
+	assert.Error(t, run(cfg, io.Discard), "--format json with --append should be rejected")
+
+	cfg = config{inputDir: inputDir, outputFile: outputFile, format: "xml"}
+	assert.Error(t, run(cfg, io.Discard), "unknown --format should be rejected")
+}
+
+func TestRun_MaxOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+
+	var b strings.Builder
+	for i := range 100 {
+		fmt.Fprintf(&b, "CODE%05d\n", i)
+	}
+	for _, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte(b.String()), 0644))
+	}
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	var out strings.Builder
+	cfg := config{inputDir: inputDir, outputFile: outputFile, summary: true, maxOutput: 25}
+	require.NoError(t, run(cfg, &out))
+
+	content, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"), 25)
+	assert.Contains(t, out.String(), "Warning: stopped at the maximum output of 25 valid codes")
+	assert.Contains(t, out.String(), "Stopped at --max-output 25")
+
+	summary, err := os.ReadFile(precompute.SummaryPath(outputFile))
+	require.NoError(t, err)
+	var stats precompute.Stats
+	require.NoError(t, json.Unmarshal(summary, &stats))
+	assert.True(t, stats.Truncated)
+	assert.Equal(t, 25, stats.ValidCodes)
+}
+
+func TestRun_BucketStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+
+	var b strings.Builder
+	for i := range 100 {
+		fmt.Fprintf(&b, "CODE%05d\n", i)
+	}
+	for _, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte(b.String()), 0644))
+	}
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	statsFile := filepath.Join(tmpDir, "buckets.csv")
+	var out strings.Builder
+	require.NoError(t, run(config{inputDir: inputDir, outputFile: outputFile, bucketStats: statsFile}, &out))
+
+	f, err := os.Open(statsFile)
+	require.NoError(t, err)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.NotEmpty(t, rows)
+	assert.Equal(t, []string{"bucket", "lines", "bytes", "distinctCodes", "validCodes"}, rows[0])
+
+	// Each code is in all 3 files, so each non-empty bucket has 3 lines per code
+	seen := make(map[int]bool)
+	var lines, valid int
+	for _, row := range rows[1:] {
+		n := make([]int, len(row))
+		for i, field := range row {
+			n[i], err = strconv.Atoi(field)
+			require.NoError(t, err)
+		}
+		assert.False(t, seen[n[0]], "bucket %d listed twice", n[0])
+		seen[n[0]] = true
+		assert.Positive(t, n[1], "bucket %d should not be empty", n[0])
+		assert.Equal(t, 3*n[3], n[1])
+		assert.Greater(t, n[2], n[1])
+		assert.Equal(t, n[3], n[4])
+		lines += n[1]
+		valid += n[4]
+	}
+	assert.Equal(t, 300, lines)
+	assert.Equal(t, 100, valid)
+
+	err = run(config{inputDir: inputDir, outputFile: outputFile, bucketStats: statsFile, topK: 5}, &out)
+	assert.ErrorContains(t, err, "--bucket-stats can't be combined")
+}
+
+func TestRun_Compress(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+	for _, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte("HAPPYHRS\nSUPER100\n"), 0644))
+	}
+
+	tests := []struct {
+		name       string
+		outputFile string
+		compress   bool
+	}{
+		{name: "Flag", outputFile: "valid_codes.txt", compress: true},
+		{name: "Extension", outputFile: "valid_codes.txt.gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir := t.TempDir()
+			cfg := config{inputDir: inputDir, outputFile: filepath.Join(outDir, tt.outputFile), compress: tt.compress, summary: true}
+			var out strings.Builder
+			require.NoError(t, run(cfg, &out))
+
+			f, err := precompute.OpenOutputFile(filepath.Join(outDir, "valid_codes.txt.gz"))
+			require.NoError(t, err)
+			defer f.Close()
+			content, err := io.ReadAll(f)
+			require.NoError(t, err)
+			assert.Equal(t, "HAPPYHRS\nSUPER100\n", string(content))
+
+			assert.NoFileExists(t, filepath.Join(outDir, "valid_codes.txt"))
+			assert.FileExists(t, filepath.Join(outDir, "valid_codes.summary.json"))
+		})
+	}
+
+	t.Run("WithAppend", func(t *testing.T) {
+		cfg := config{inputDir: inputDir, outputFile: filepath.Join(t.TempDir(), "valid_codes.txt.gz"), append: true}
+		assert.ErrorContains(t, run(cfg, io.Discard), "--compress can't be combined")
+	})
+}
+
+func TestRun_Buckets(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+	for _, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte("HAPPYHRS\nSUPER100\n"), 0644))
+	}
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	var out strings.Builder
+	require.NoError(t, run(config{inputDir: inputDir, outputFile: outputFile, buckets: 4, dryRun: true}, &out))
+	assert.Contains(t, out.String(), "Buckets: 4\n")
+
+	require.NoError(t, run(config{inputDir: inputDir, outputFile: outputFile, buckets: 4}, io.Discard))
+	content, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	assert.Equal(t, "HAPPYHRS\nSUPER100\n", string(content))
+
+	err = run(config{inputDir: inputDir, outputFile: outputFile, buckets: -1}, io.Discard)
+	assert.ErrorContains(t, err, "number of buckets must be positive")
+}
+
+func TestRun_TrustedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+	files := map[string]string{
+		"official.txt":  "HAPPYHRS\nONLYHERE\n",
+		"partner1.txt":  "HAPPYHRS\nSUPER100\n",
+		"partner2.txt":  "SUPER100\n",
+		"unrelated.txt": "ONLYHERE\n",
+	}
+	for filename, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte(content), 0644))
+	}
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	var out strings.Builder
+	cfg := config{inputDir: inputDir, outputFile: outputFile, trustedFiles: "official.txt, unrelated*", dryRun: true}
+	require.NoError(t, run(cfg, &out))
+	assert.Contains(t, out.String(), "Trusted files: official.txt, unrelated*\n")
+
+	cfg.dryRun = false
+	require.NoError(t, run(cfg, io.Discard))
+	content, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	assert.Equal(t, "HAPPYHRS\nONLYHERE\n", string(content))
+}
+
+func TestRun_MissingOutputDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+	for _, filename := range []string{"file1.txt", "file2.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte("HAPPYHRS\n"), 0644))
+	}
+	outputDir := filepath.Join(tmpDir, "out", "daily")
+	outputFile := filepath.Join(outputDir, "valid_codes.txt")
+
+	t.Run("Missing", func(t *testing.T) {
+		err := run(config{inputDir: inputDir, outputFile: outputFile, summary: true}, io.Discard)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "output directory does not exist: "+outputDir)
+		assert.NoDirExists(t, outputDir)
+	})
+
+	t.Run("Mkdir", func(t *testing.T) {
+		require.NoError(t, run(config{inputDir: inputDir, outputFile: outputFile, summary: true, mkdir: true}, io.Discard))
+		content, err := os.ReadFile(outputFile)
+		require.NoError(t, err)
+		assert.Equal(t, "HAPPYHRS\n", string(content))
+		assert.FileExists(t, filepath.Join(outputDir, "valid_codes.summary.json"))
+	})
+}
+
+func TestRun_ResumeDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+	for _, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte("HAPPYHRS\n"), 0644))
+	}
+	resumeDir := filepath.Join(tmpDir, "resume")
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	require.NoError(t, run(config{inputDir: inputDir, outputFile: outputFile, readConcurrency: 1, resumeDir: resumeDir}, io.Discard))
+	content, err := os.ReadFile(outputFile)
+	require.NoError(t, err)
+	assert.Equal(t, "HAPPYHRS\n", string(content))
+	assert.NoDirExists(t, resumeDir)
+
+	err = run(config{inputDir: inputDir, outputFile: outputFile, readConcurrency: 2, resumeDir: resumeDir}, io.Discard)
+	assert.ErrorContains(t, err, "resuming requires reading one file at a time")
+}
+
+// TestRun_Estimate verifies --estimate counts codes as the configured run would
+func TestRun_Estimate(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, filename), []byte("SIX666\nABCDEFGH\n"), 0644))
+	}
+
+	tests := []struct {
+		name        string
+		cfg         config
+		expected    string
+		expectedErr string
+	}{
+		{name: "defaults", expected: "Estimate: 1 ± 0 valid codes"},
+		{name: "min length", cfg: config{minLength: 6}, expected: "Estimate: 2 ± 0 valid codes"},
+		{name: "min files", cfg: config{minFiles: 4}, expectedErr: "no code can be valid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.inputDir = tmpDir
+			cfg.outputFile = filepath.Join(t.TempDir(), "valid_codes.txt")
+			cfg.estimate = 1
+
+			var out strings.Builder
+			err := run(cfg, &out)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+			} else {
+				require.NoError(t, err)
+				assert.Contains(t, out.String(), tt.expected)
+			}
+			assert.NoFileExists(t, cfg.outputFile)
+		})
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+
+	for _, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte("ABCDEFGH\nABCDEFGHI\n"), 0644))
+	}
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	var out strings.Builder
+	err := run(config{inputDir: inputDir, outputFile: outputFile, workers: 3, summary: true, dryRun: true}, &out)
+	require.NoError(t, err)
+
+	output := out.String()
+	for i, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		assert.Contains(t, output, fmt.Sprintf("[%d] %s", i, filepath.Join(inputDir, filename)))
+	}
+	assert.Contains(t, output, "Algorithm: hash-partition")
+	assert.Contains(t, output, "Workers: 3")
+	assert.Contains(t, output, "Buckets: 1000")
+	assert.Contains(t, output, "Code length: 8-10")
+	assert.Contains(t, output, "Minimum files per code: 2")
+	assert.Contains(t, output, "Estimated temp disk usage")
+
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "Only the input directory should exist")
+	assert.Equal(t, "input", entries[0].Name())
+}
+
+func TestRun_ProgressFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+
+	for _, filename := range []string{"file1.txt", "file2.txt", "file3.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte("ABCDEFGH\nABCDEFGHI\n"), 0644))
+	}
+
+	progressFile := filepath.Join(tmpDir, "progress.jsonl")
+	cfg := config{inputDir: inputDir, outputFile: filepath.Join(tmpDir, "valid_codes.txt"), progressFile: progressFile}
+	var out strings.Builder
+	require.NoError(t, run(cfg, &out))
+
+	content, err := os.ReadFile(progressFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	require.NotEmpty(t, lines)
+
+	var messages []string
+	for _, line := range lines {
+		var event precompute.ProgressEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &event), "line %q should be a JSON event", line)
+		assert.False(t, event.Time.IsZero(), "line %q should be timestamped", line)
+		assert.Contains(t, out.String(), event.Message, "file and console should get the same messages")
+		messages = append(messages, event.Message)
+	}
+	assert.Equal(t, "Writing output file...", messages[len(messages)-1])
+}
+
+func TestRun_DiffAgainst(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputs := map[string]string{
+		"old": "KEEPCODE\nOLDCODE1\n",
+		"new": "KEEPCODE\nNEWCODE1\n",
+	}
+	for dir, content := range inputs {
+		require.NoError(t, os.Mkdir(filepath.Join(tmpDir, dir), 0755))
+		for _, filename := range []string{"file1.txt", "file2.txt"} {
+			require.NoError(t, os.WriteFile(filepath.Join(tmpDir, dir, filename), []byte(content), 0644))
+		}
+	}
+
+	outputFile := filepath.Join(tmpDir, "valid_codes.txt")
+	cfg := config{inputDir: filepath.Join(tmpDir, "new"), outputFile: outputFile, diffAgainst: filepath.Join(tmpDir, "old"), summary: true}
+	require.NoError(t, run(cfg, io.Discard))
+
+	expected := map[string]string{
+		"valid_codes.added.txt":     "NEWCODE1\n",
+		"valid_codes.removed.txt":   "OLDCODE1\n",
+		"valid_codes.unchanged.txt": "KEEPCODE\n",
+	}
+	for filename, content := range expected {
+		got, err := os.ReadFile(filepath.Join(tmpDir, filename))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(got), filename)
+	}
+	assert.NoFileExists(t, outputFile, "A diff doesn't write the valid codes themselves")
+
+	cfg.append = true
+	assert.Error(t, run(cfg, io.Discard), "--diff-against with --append should be rejected")
+
+	cfg.append = false
+	cfg.maxOutput = 10
+	assert.Error(t, run(cfg, io.Discard), "--diff-against with --max-output should be rejected")
+}
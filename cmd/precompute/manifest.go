@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Roles of the files produced by a run
+const (
+	roleCodes         = "codes"
+	roleCodesByLength = "codes-by-length"
+	roleSummary       = "summary"
+	roleBucketStats   = "bucket-stats"
+	roleManifest      = "manifest"
+)
+
+// artifact is a file produced by a run
+type artifact struct {
+	Path string `json:"path"`
+	Role string `json:"role"`
+	Size int64  `json:"size"`
+}
+
+// manifestPath returns the path of the manifest, in the same directory as the output file
+func manifestPath(outputFile string) string {
+	return filepath.Join(filepath.Dir(outputFile), "output-manifest.json")
+}
+
+// writeManifest writes the artifacts, with their size on disk, as indented JSON
+func writeManifest(artifacts []artifact, path string) error {
+	entries := make([]artifact, 0, len(artifacts))
+	for _, a := range artifacts {
+		info, err := os.Stat(a.Path)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", a.Path, err)
+		}
+		a.Size = info.Size()
+		entries = append(entries, a)
+	}
+
+	content, err := json.MarshalIndent(map[string]any{"files": entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return nil
+}
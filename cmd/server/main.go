@@ -2,26 +2,52 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"order-food-online/internal/api"
+	"order-food-online/internal/precompute"
 	"os"
-
-	"github.com/go-chi/chi/v5"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
 	promoCodesFile := flag.String("promocodes", "valid_codes.txt", "Path to the promo codes file")
+	timeout := flag.Duration("timeout", 30*time.Second, "Maximum time a request may take before returning 503")
+	rateLimit := flag.Float64("rate-limit", 0, "Requests per second allowed per client IP (0 disables rate limiting)")
+	rateBurst := flag.Int("rate-burst", 20, "Maximum burst of requests per client IP when rate limiting")
+	keyRateLimit := flag.Float64("key-rate-limit", 0, "Requests per second allowed per API key (0 disables the per-key limit)")
+	keyRateBurst := flag.Int("key-rate-burst", 20, "Maximum burst of requests per API key when limiting per key")
+	maxConcurrentOrders := flag.Int("max-concurrent-orders", 0, "Maximum orders placed at once before returning 503 (0 for no limit)")
+	couponTimezone := flag.String("coupon-timezone", "UTC", "IANA timezone whose end of day coupon expiry dates refer to, e.g. Australia/Sydney")
+	discounts := flag.String("discounts", "", "Comma separated code:discount pairs, the discount being a percentage or a flat amount, e.g. SAVE10:10%,FIVEOFF:5.00 (default: coupons give no discount)")
+	apiKeys := flag.String("api-keys", "", "Comma separated key:scope or key:scope:partner entries, scope being read or read-write, e.g. kiosk:read,k3y:read-write:acme (default: the built-in read-write key)")
+	taxRate := flag.Int("tax-rate", 0, "Tax rate in percent included in prices, shown on receipts, e.g. 10 for GST (0 leaves tax off receipts)")
+	allowedCategories := flag.String("allowed-categories", "", "Comma separated categories products may be given, e.g. Waffle,Burger,Drink,Dessert (default: any category)")
+	maxItemQuantity := flag.Int("max-item-quantity", 100, "Largest quantity of a single item accepted in an order")
+	maxQueryLength := flag.Int("max-query-length", 2048, "Longest query string accepted, in bytes, before returning 414")
+	duplicateWindow := flag.Duration("duplicate-window", 0, "Reject an order identical to one the same client placed within this window, e.g. 10s (0 disables the check)")
+	logRequests := flag.Bool("log-requests", true, "Log every request to stderr as a JSON line with its method, path, status, duration and size")
+	dbCheckInterval := flag.Duration("db-check-interval", 30*time.Second, "How often to ping the database, reopening it if the ping fails (0 disables the check)")
 	flag.Parse()
 
 	// Load promo codes
-	codes, err := loadPromoCodes(*promoCodesFile)
+	codes, expiries, err := loadPromoCodes(*promoCodesFile)
 	if err != nil {
 		log.Fatalf("Failed to load promo codes: %v", err)
 	}
-	log.Printf("Loaded %d promo codes", len(codes))
+	log.Printf("Loaded %d promo codes, %d with an expiry date", len(codes), len(expiries))
+	couponZone, err := time.LoadLocation(*couponTimezone)
+	if err != nil {
+		log.Fatalf("Invalid -coupon-timezone: %v", err)
+	}
 
 	// Initialize database
 	dbPath := getDBPath()
@@ -30,46 +56,160 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer db.Close()
 
-	// Create server with database connection
-	server := api.NewServer(codes, db)
+	const addr = ":8080"
+	opts := []api.Option{
+		api.WithDeploymentInfo(addr, dbPath, *promoCodesFile),
+		api.WithTimeout(*timeout),
+		api.WithRateLimit(*rateLimit, *rateBurst),
+		api.WithAPIKeyRateLimit(*keyRateLimit, *keyRateBurst),
+		api.WithMaxConcurrentOrders(*maxConcurrentOrders),
+		api.WithMaxItemQuantity(*maxItemQuantity),
+		api.WithMaxQueryLength(*maxQueryLength),
+		api.WithDuplicateWindow(*duplicateWindow),
+		api.WithCouponExpiry(expiries, couponZone),
+		api.WithTaxRate(*taxRate),
+		api.WithDBReconnect(*dbCheckInterval, func() (*sql.DB, error) {
+			return api.InitDB(dbPath)
+		}),
+	}
+	if *apiKeys != "" {
+		keys, partners, err := parseAPIKeys(*apiKeys)
+		if err != nil {
+			log.Fatalf("Invalid -api-keys: %v", err)
+		}
+		opts = append(opts, api.WithAPIKeys(keys), api.WithPartners(partners))
+	}
+	if *discounts != "" {
+		parsed, err := parseDiscounts(*discounts)
+		if err != nil {
+			log.Fatalf("Invalid -discounts: %v", err)
+		}
+		opts = append(opts, api.WithDiscounts(parsed))
+	}
+	if *allowedCategories != "" {
+		opts = append(opts, api.WithAllowedCategories(strings.Split(*allowedCategories, ",")))
+	}
+	if *logRequests {
+		opts = append(opts, api.WithRequestLog(slog.New(slog.NewJSONHandler(os.Stderr, nil))))
+	}
+	// A fixed salt keeps rejected coupon hashes comparable across restarts
+	if salt := os.Getenv("COUPON_LOG_SALT"); salt != "" {
+		opts = append(opts, api.WithCouponLog(nil, []byte(salt)))
+	}
 
-	mux := chi.NewMux()
-	h := api.HandlerFromMux(server, mux)
+	// Create server with database connection
+	server, err := api.NewServerValidated(codes, db, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	// Closes the current connection, which may have been reopened since
+	defer server.Close()
+	go server.MonitorDB(context.Background())
 
 	s := &http.Server{
-		Addr:    ":8080",
-		Handler: h,
+		Addr:    addr,
+		Handler: server.Routes(),
 	}
 
-	fmt.Println("Starting server on :8080")
+	fmt.Println("Starting server on " + addr)
 	if err := s.ListenAndServe(); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
 
-func loadPromoCodes(path string) ([]string, error) {
-	file, err := os.Open(path)
+// expiryPattern matches the YYYY-MM-DD shape of a promo code's expiry date
+var expiryPattern = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
+
+// loadPromoCodes reads one code per line, decompressing the file if path
+// ends in .gz as written by precompute --compress. A line may add the code's
+// last valid day after a comma, e.g. SUMMER25,2025-08-31. Codes may contain
+// commas themselves, so only a date after the last comma is an expiry.
+func loadPromoCodes(path string) ([]string, map[string]time.Time, error) {
+	file, err := precompute.OpenOutputFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open promo codes file: %w", err)
+		return nil, nil, fmt.Errorf("failed to open promo codes file: %w", err)
 	}
 	defer file.Close()
 
 	var codes []string
+	expiries := make(map[string]time.Time)
 	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	for lineNum := 1; scanner.Scan(); lineNum++ {
 		line := scanner.Text()
-		if line != "" {
-			codes = append(codes, line)
+		if line == "" {
+			continue
+		}
+		code := line
+		if i := strings.LastIndexByte(line, ','); i >= 0 && expiryPattern.MatchString(line[i+1:]) {
+			date, err := time.Parse(time.DateOnly, line[i+1:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid expiry date on line %d of promo codes file: %w", lineNum, err)
+			}
+			code = line[:i]
+			expiries[code] = date
 		}
+		codes = append(codes, code)
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading promo codes file: %w", err)
+		return nil, nil, fmt.Errorf("error reading promo codes file: %w", err)
 	}
 
-	return codes, nil
+	return codes, expiries, nil
+}
+
+// parseAPIKeys parses comma separated key:scope entries, optionally followed
+// by :partner naming who the key was issued to
+func parseAPIKeys(value string) (map[string]api.Scope, map[string]string, error) {
+	keys := make(map[string]api.Scope)
+	partners := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		key, rest, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || key == "" {
+			return nil, nil, fmt.Errorf("%q must be key:scope or key:scope:partner", entry)
+		}
+		scope, partner, hasPartner := strings.Cut(rest, ":")
+		if hasPartner && partner == "" {
+			return nil, nil, fmt.Errorf("empty partner name for key %q", key)
+		}
+		switch api.Scope(scope) {
+		case api.ScopeRead, api.ScopeReadWrite:
+			keys[key] = api.Scope(scope)
+		default:
+			return nil, nil, fmt.Errorf("unknown scope %q for key %q, must be %s or %s", scope, key, api.ScopeRead, api.ScopeReadWrite)
+		}
+		if hasPartner {
+			partners[key] = partner
+		}
+	}
+	return keys, partners, nil
+}
+
+// parseDiscounts parses comma separated code:discount pairs, where the
+// discount is a whole percentage such as 10% or an amount such as 5.00
+func parseDiscounts(value string) (map[string]api.Discount, error) {
+	discounts := make(map[string]api.Discount)
+	for _, pair := range strings.Split(value, ",") {
+		code, discount, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || code == "" {
+			return nil, fmt.Errorf("%q must be code:discount", pair)
+		}
+		if percent, isPercent := strings.CutSuffix(discount, "%"); isPercent {
+			n, err := strconv.Atoi(percent)
+			if err != nil || n < 0 || n > 100 {
+				return nil, fmt.Errorf("discount %q for code %q must be a whole percentage from 0%% to 100%%", discount, code)
+			}
+			discounts[code] = api.Discount{Percent: n}
+			continue
+		}
+		amount, err := api.ParseMoney(discount)
+		if err != nil || amount < 0 {
+			return nil, fmt.Errorf("discount %q for code %q must be a percentage such as 10%% or an amount such as 5.00", discount, code)
+		}
+		discounts[code] = api.Discount{Amount: amount}
+	}
+	return discounts, nil
 }
 
 func getDBPath() string {
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPromoCodes(t *testing.T) {
+	tests := []struct {
+		name             string
+		content          string
+		expectedCodes    []string
+		expectedExpiries map[string]time.Time
+		expectedErr      string
+	}{
+		{
+			name:             "PlainCodes",
+			content:          "HAPPYHRS\n\nFIFTYOFF\n",
+			expectedCodes:    []string{"HAPPYHRS", "FIFTYOFF"},
+			expectedExpiries: map[string]time.Time{},
+		},
+		{
+			name:          "Expiry",
+			content:       "SUMMER25,2025-08-31\nHAPPYHRS\n",
+			expectedCodes: []string{"SUMMER25", "HAPPYHRS"},
+			expectedExpiries: map[string]time.Time{
+				"SUMMER25": time.Date(2025, 8, 31, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			// Precompute writes codes verbatim, commas included
+			name:             "CommaInCode",
+			content:          "AB,CDEFGH\nA,B,C,DEFG\n",
+			expectedCodes:    []string{"AB,CDEFGH", "A,B,C,DEFG"},
+			expectedExpiries: map[string]time.Time{},
+		},
+		{
+			name:          "CommaInCodeWithExpiry",
+			content:       "AB,CDEFGH,2025-08-31\n",
+			expectedCodes: []string{"AB,CDEFGH"},
+			expectedExpiries: map[string]time.Time{
+				"AB,CDEFGH": time.Date(2025, 8, 31, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:             "CodeEndingInDigits",
+			content:          "CODE,2025\n",
+			expectedCodes:    []string{"CODE,2025"},
+			expectedExpiries: map[string]time.Time{},
+		},
+		{
+			name:        "InvalidDate",
+			content:     "HAPPYHRS\nSUMMER25,2025-02-30\n",
+			expectedErr: "invalid expiry date on line 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "valid_codes.txt")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+
+			codes, expiries, err := loadPromoCodes(path)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCodes, codes)
+			assert.Equal(t, tt.expectedExpiries, expiries)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/oapi-codegen/runtime"
@@ -16,24 +17,103 @@ const (
 	Api_keyScopes = "api_key.Scopes"
 )
 
+// Defines values for ApiKeyConfigScope.
+const (
+	Read      ApiKeyConfigScope = "read"
+	ReadWrite ApiKeyConfigScope = "read-write"
+)
+
+// Defines values for HealthStatus.
+const (
+	Ok          HealthStatus = "ok"
+	Unavailable HealthStatus = "unavailable"
+)
+
+// Defines values for OrderReqDiscountMode.
+const (
+	Best  OrderReqDiscountMode = "best"
+	Stack OrderReqDiscountMode = "stack"
+)
+
+// ApiKeyConfig defines model for ApiKeyConfig.
+type ApiKeyConfig struct {
+	// Key The key, redacted
+	Key string `json:"key"`
+
+	// Partner Partner the key was issued to
+	Partner *string           `json:"partner,omitempty"`
+	Scope   ApiKeyConfigScope `json:"scope"`
+}
+
+// ApiKeyConfigScope defines model for ApiKeyConfig.Scope.
+type ApiKeyConfigScope string
+
+// CouponUsage defines model for CouponUsage.
+type CouponUsage struct {
+	Code string `json:"code"`
+
+	// Orders Number of orders placed with the coupon
+	Orders int `json:"orders"`
+}
+
+// Health defines model for Health.
+type Health struct {
+	Status HealthStatus `json:"status"`
+}
+
+// HealthStatus defines model for Health.Status.
+type HealthStatus string
+
+// MenuCategory defines model for MenuCategory.
+type MenuCategory struct {
+	Category string    `json:"category"`
+	Products []Product `json:"products"`
+}
+
 // Order defines model for Order.
 type Order struct {
-	Id    *string `json:"id,omitempty"`
-	Items *[]struct {
+	// CouponCode Promo code applied to the order, omitted when none was used
+	CouponCode *string `json:"couponCode,omitempty"`
+
+	// CustomerId Customer the order was placed for, omitted for guest orders
+	CustomerId *string `json:"customerId,omitempty"`
+	Id         *string `json:"id,omitempty"`
+	Items      *[]struct {
 		// ProductId ID of the product
 		ProductId *string `json:"productId,omitempty"`
 
 		// Quantity Item count
 		Quantity *int `json:"quantity,omitempty"`
+
+		// UnitPrice Price of one unit when the order was placed, with bulk pricing tiers applied. Omitted for orders placed before unit prices were recorded.
+		UnitPrice *Money `json:"unitPrice,omitempty"`
 	} `json:"items,omitempty"`
 	Products *[]Product `json:"products,omitempty"`
+
+	// Subtotal Order total with bulk pricing tiers applied, before the coupon discount. Only returned when the order is placed.
+	Subtotal *Money `json:"subtotal,omitempty"`
+
+	// Total Order total with bulk pricing tiers and the coupon discount applied, with two decimals. Never negative.
+	Total *Money `json:"total,omitempty"`
 }
 
 // OrderReq Place a new order
 type OrderReq struct {
 	// CouponCode Optional promo code applied to the order
 	CouponCode *string `json:"couponCode,omitempty"`
-	Items      []struct {
+
+	// CustomerId Optional ID of the registered customer placing the order, up to 64 letters, digits, `-` or `_`. Omit for guest orders.
+	CustomerId *string `json:"customerId,omitempty"`
+
+	// DiscountMode How bulk pricing and the coupon discount combine when both apply. With `stack`, the default, bulk pricing applies first and the coupon discount is taken off the bulk priced subtotal. With `best` they don't stack: the order costs the lower of the bulk priced subtotal and the coupon discount taken off the list price subtotal.
+	DiscountMode *OrderReqDiscountMode `json:"discountMode,omitempty"`
+
+	// ExpectedTotal Optional total the client expects to pay, e.g. from its cached menu. If the order total differs by more than a cent, the order is not placed and a 409 is returned with both totals.
+	ExpectedTotal *Money `json:"expectedTotal,omitempty"`
+
+	// Id Optional ID for the order, a UUID such as 3fa85f64-5717-4562-b3fc-2c963f66afa6, for clients that correlate orders with their own systems. A malformed ID is rejected with 400 and one already used with 409. Omit to have the server generate one.
+	Id    *string `json:"id,omitempty"`
+	Items []struct {
 		// ProductId ID of the product (required)
 		ProductId string `json:"productId"`
 
@@ -42,45 +122,274 @@ type OrderReq struct {
 	} `json:"items"`
 }
 
+// OrderReqDiscountMode How bulk pricing and the coupon discount combine when both apply. With `stack`, the default, bulk pricing applies first and the coupon discount is taken off the bulk priced subtotal. With `best` they don't stack: the order costs the lower of the bulk priced subtotal and the coupon discount taken off the list price subtotal.
+type OrderReqDiscountMode string
+
+// PriceChange defines model for PriceChange.
+type PriceChange struct {
+	// ChangedAt When the price was set
+	ChangedAt *time.Time `json:"changedAt,omitempty"`
+
+	// Price Selling price from this point on, with two decimals
+	Price *Money `json:"price,omitempty"`
+}
+
+// PriceMismatch defines model for PriceMismatch.
+type PriceMismatch struct {
+	Error string `json:"error"`
+
+	// ExpectedTotal Total sent by the client
+	ExpectedTotal Money `json:"expectedTotal"`
+
+	// Total Total computed from current prices
+	Total Money `json:"total"`
+}
+
 // Product defines model for Product.
 type Product struct {
 	Category *string `json:"category,omitempty"`
 	Id       *string `json:"id,omitempty"`
+
+	// ImageUrl URL of the product image, omitted when the product has none
+	ImageUrl *string `json:"imageUrl,omitempty"`
 	Name     *string `json:"name,omitempty"`
 
-	// Price Selling price
-	Price *float32 `json:"price,omitempty"`
+	// Price Selling price, with two decimals
+	Price *Money `json:"price,omitempty"`
+}
+
+// ProductUpdate New name, price and category of a product
+type ProductUpdate struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+
+	// Price Selling price, with two decimals. Must not be negative.
+	Price Money `json:"price"`
+}
+
+// ServerConfig defines model for ServerConfig.
+type ServerConfig struct {
+	// Addr Address the server listens on, empty if unknown
+	Addr string `json:"addr"`
+
+	// AllowedCategories Categories products may be given, empty when any category is allowed
+	AllowedCategories []string       `json:"allowedCategories"`
+	ApiKeys           []ApiKeyConfig `json:"apiKeys"`
+
+	// CouponExpiries Number of promo codes with an expiry date
+	CouponExpiries int `json:"couponExpiries"`
+
+	// CouponTimezone Timezone whose end of day coupon expiry dates refer to
+	CouponTimezone string `json:"couponTimezone"`
+
+	// DbCheckInterval How often the database is pinged, as a Go duration, 0s when disabled
+	DbCheckInterval string `json:"dbCheckInterval"`
+
+	// DbPath Path of the SQLite database, empty if unknown
+	DbPath string `json:"dbPath"`
+
+	// Discounts Number of coupons giving a discount
+	Discounts int `json:"discounts"`
+
+	// DuplicateWindow Window identical orders are rejected in, as a Go duration, 0s when disabled
+	DuplicateWindow string `json:"duplicateWindow"`
+	KeyRateBurst    int    `json:"keyRateBurst"`
+
+	// KeyRateLimit Requests per second allowed per API key, 0 when disabled
+	KeyRateLimit float64 `json:"keyRateLimit"`
+
+	// MaxConcurrentOrders Orders placed at once before returning 503, 0 for no limit
+	MaxConcurrentOrders int `json:"maxConcurrentOrders"`
+	MaxItemQuantity     int `json:"maxItemQuantity"`
+
+	// MaxQueryLength Longest query string accepted, in bytes
+	MaxQueryLength int `json:"maxQueryLength"`
+
+	// PromoCodes Number of promo codes loaded
+	PromoCodes int `json:"promoCodes"`
+
+	// PromoCodesCompressed Whether the promo codes file is gzip compressed
+	PromoCodesCompressed bool `json:"promoCodesCompressed"`
+
+	// PromoCodesFile File the promo codes were loaded from, empty if unknown
+	PromoCodesFile string `json:"promoCodesFile"`
+	RateBurst      int    `json:"rateBurst"`
+
+	// RateLimit Requests per second allowed per client IP, 0 when disabled
+	RateLimit float64 `json:"rateLimit"`
+
+	// RequestLog Whether every request is logged
+	RequestLog bool `json:"requestLog"`
+	TaxPercent int  `json:"taxPercent"`
+
+	// Timeout How long a request may run, as a Go duration
+	Timeout string `json:"timeout"`
+}
+
+// ExportOrdersParams defines parameters for ExportOrders.
+type ExportOrdersParams struct {
+	// From Only export orders placed on or after this date (YYYY-MM-DD, UTC)
+	From *string `form:"from,omitempty" json:"from,omitempty"`
+
+	// To Only export orders placed on or before this date (YYYY-MM-DD, UTC)
+	To *string `form:"to,omitempty" json:"to,omitempty"`
+}
+
+// ListProductsParams defines parameters for ListProducts.
+type ListProductsParams struct {
+	// Sort Sort order of the products. One of `name`, `price` or `category`, prefixed with `-` for descending order. Defaults to category, then name.
+	Sort *string `form:"sort,omitempty" json:"sort,omitempty"`
+
+	// Limit Maximum number of products to return, between 1 and 200. Defaults to 50.
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Offset Number of products to skip, in the sort order. Defaults to 0.
+	Offset *int `form:"offset,omitempty" json:"offset,omitempty"`
+}
+
+// DeleteProductParams defines parameters for DeleteProduct.
+type DeleteProductParams struct {
+	// Soft Soft-delete the product if it appears in orders, instead of failing
+	Soft *bool `form:"soft,omitempty" json:"soft,omitempty"`
+}
+
+// ListRelatedProductsParams defines parameters for ListRelatedProducts.
+type ListRelatedProductsParams struct {
+	// Limit Maximum number of products to return, between 1 and 50. Defaults to 5.
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
 }
 
 // PlaceOrderJSONRequestBody defines body for PlaceOrder for application/json ContentType.
 type PlaceOrderJSONRequestBody = OrderReq
 
+// UpdateProductJSONRequestBody defines body for UpdateProduct for application/json ContentType.
+type UpdateProductJSONRequestBody = ProductUpdate
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// Get the server's effective configuration
+	// (GET /admin/config)
+	GetConfig(w http.ResponseWriter, r *http.Request)
+	// Get how many orders used a coupon
+	// (GET /coupons/{code}/usage)
+	GetCouponUsage(w http.ResponseWriter, r *http.Request, code string)
+	// List a customer's orders
+	// (GET /customers/{customerId}/orders)
+	ListCustomerOrders(w http.ResponseWriter, r *http.Request, customerId string)
+	// Check the server is healthy
+	// (GET /health)
+	HealthCheck(w http.ResponseWriter, r *http.Request)
+	// Check the server is healthy
+	// (GET /healthz)
+	GetHealth(w http.ResponseWriter, r *http.Request)
+	// Get the menu
+	// (GET /menu)
+	GetMenu(w http.ResponseWriter, r *http.Request)
 	// Place an order
 	// (POST /order)
 	PlaceOrder(w http.ResponseWriter, r *http.Request)
+	// Export orders as CSV
+	// (GET /orders/export.csv)
+	ExportOrders(w http.ResponseWriter, r *http.Request, params ExportOrdersParams)
+	// Find order by ID
+	// (GET /orders/{orderId})
+	GetOrder(w http.ResponseWriter, r *http.Request, orderId string)
+	// Get an order receipt
+	// (GET /orders/{orderId}/receipt)
+	GetOrderReceipt(w http.ResponseWriter, r *http.Request, orderId string)
 	// List products
 	// (GET /product)
-	ListProducts(w http.ResponseWriter, r *http.Request)
+	ListProducts(w http.ResponseWriter, r *http.Request, params ListProductsParams)
+	// Delete a product
+	// (DELETE /product/{productId})
+	DeleteProduct(w http.ResponseWriter, r *http.Request, productId int64, params DeleteProductParams)
 	// Find product by ID
 	// (GET /product/{productId})
 	GetProduct(w http.ResponseWriter, r *http.Request, productId int64)
+	// Update a product
+	// (PUT /product/{productId})
+	UpdateProduct(w http.ResponseWriter, r *http.Request, productId int64)
+	// Get product price history
+	// (GET /product/{productId}/price-history)
+	GetProductPriceHistory(w http.ResponseWriter, r *http.Request, productId int64)
+	// List related products
+	// (GET /product/{productId}/related)
+	ListRelatedProducts(w http.ResponseWriter, r *http.Request, productId int64, params ListRelatedProductsParams)
 }
 
 // Unimplemented server implementation that returns http.StatusNotImplemented for each endpoint.
 
 type Unimplemented struct{}
 
+// Get the server's effective configuration
+// (GET /admin/config)
+func (_ Unimplemented) GetConfig(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get how many orders used a coupon
+// (GET /coupons/{code}/usage)
+func (_ Unimplemented) GetCouponUsage(w http.ResponseWriter, r *http.Request, code string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// List a customer's orders
+// (GET /customers/{customerId}/orders)
+func (_ Unimplemented) ListCustomerOrders(w http.ResponseWriter, r *http.Request, customerId string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Check the server is healthy
+// (GET /health)
+func (_ Unimplemented) HealthCheck(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Check the server is healthy
+// (GET /healthz)
+func (_ Unimplemented) GetHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get the menu
+// (GET /menu)
+func (_ Unimplemented) GetMenu(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // Place an order
 // (POST /order)
 func (_ Unimplemented) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Export orders as CSV
+// (GET /orders/export.csv)
+func (_ Unimplemented) ExportOrders(w http.ResponseWriter, r *http.Request, params ExportOrdersParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Find order by ID
+// (GET /orders/{orderId})
+func (_ Unimplemented) GetOrder(w http.ResponseWriter, r *http.Request, orderId string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get an order receipt
+// (GET /orders/{orderId}/receipt)
+func (_ Unimplemented) GetOrderReceipt(w http.ResponseWriter, r *http.Request, orderId string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // List products
 // (GET /product)
-func (_ Unimplemented) ListProducts(w http.ResponseWriter, r *http.Request) {
+func (_ Unimplemented) ListProducts(w http.ResponseWriter, r *http.Request, params ListProductsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Delete a product
+// (DELETE /product/{productId})
+func (_ Unimplemented) DeleteProduct(w http.ResponseWriter, r *http.Request, productId int64, params DeleteProductParams) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
@@ -90,6 +399,24 @@ func (_ Unimplemented) GetProduct(w http.ResponseWriter, r *http.Request, produc
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Update a product
+// (PUT /product/{productId})
+func (_ Unimplemented) UpdateProduct(w http.ResponseWriter, r *http.Request, productId int64) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get product price history
+// (GET /product/{productId}/price-history)
+func (_ Unimplemented) GetProductPriceHistory(w http.ResponseWriter, r *http.Request, productId int64) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// List related products
+// (GET /product/{productId}/related)
+func (_ Unimplemented) ListRelatedProducts(w http.ResponseWriter, r *http.Request, productId int64, params ListRelatedProductsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // ServerInterfaceWrapper converts contexts to parameters.
 type ServerInterfaceWrapper struct {
 	Handler            ServerInterface
@@ -99,6 +426,130 @@ type ServerInterfaceWrapper struct {
 
 type MiddlewareFunc func(http.Handler) http.Handler
 
+// GetConfig operation middleware
+func (siw *ServerInterfaceWrapper) GetConfig(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, Api_keyScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetConfig(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetCouponUsage operation middleware
+func (siw *ServerInterfaceWrapper) GetCouponUsage(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "code" -------------
+	var code string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "code", chi.URLParam(r, "code"), &code, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "code", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, Api_keyScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetCouponUsage(w, r, code)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// ListCustomerOrders operation middleware
+func (siw *ServerInterfaceWrapper) ListCustomerOrders(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "customerId" -------------
+	var customerId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "customerId", chi.URLParam(r, "customerId"), &customerId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "customerId", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, Api_keyScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ListCustomerOrders(w, r, customerId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// HealthCheck operation middleware
+func (siw *ServerInterfaceWrapper) HealthCheck(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.HealthCheck(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetHealth operation middleware
+func (siw *ServerInterfaceWrapper) GetHealth(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetHealth(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetMenu operation middleware
+func (siw *ServerInterfaceWrapper) GetMenu(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetMenu(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // PlaceOrder operation middleware
 func (siw *ServerInterfaceWrapper) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 
@@ -119,11 +570,185 @@ func (siw *ServerInterfaceWrapper) PlaceOrder(w http.ResponseWriter, r *http.Req
 	handler.ServeHTTP(w, r)
 }
 
+// ExportOrders operation middleware
+func (siw *ServerInterfaceWrapper) ExportOrders(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, Api_keyScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ExportOrdersParams
+
+	// ------------- Optional query parameter "from" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "from", r.URL.Query(), &params.From)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "from", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "to" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "to", r.URL.Query(), &params.To)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "to", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ExportOrders(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetOrder operation middleware
+func (siw *ServerInterfaceWrapper) GetOrder(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "orderId" -------------
+	var orderId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "orderId", chi.URLParam(r, "orderId"), &orderId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "orderId", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, Api_keyScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetOrder(w, r, orderId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetOrderReceipt operation middleware
+func (siw *ServerInterfaceWrapper) GetOrderReceipt(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "orderId" -------------
+	var orderId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "orderId", chi.URLParam(r, "orderId"), &orderId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "orderId", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, Api_keyScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetOrderReceipt(w, r, orderId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // ListProducts operation middleware
 func (siw *ServerInterfaceWrapper) ListProducts(w http.ResponseWriter, r *http.Request) {
 
+	var err error
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ListProductsParams
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", r.URL.Query(), &params.Sort)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "sort", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", r.URL.Query(), &params.Limit)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "limit", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "offset" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "offset", r.URL.Query(), &params.Offset)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "offset", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ListProducts(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// DeleteProduct operation middleware
+func (siw *ServerInterfaceWrapper) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "productId" -------------
+	var productId int64
+
+	err = runtime.BindStyledParameterWithOptions("simple", "productId", chi.URLParam(r, "productId"), &productId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "productId", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, Api_keyScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params DeleteProductParams
+
+	// ------------- Optional query parameter "soft" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "soft", r.URL.Query(), &params.Soft)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "soft", Err: err})
+		return
+	}
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.ListProducts(w, r)
+		siw.Handler.DeleteProduct(w, r, productId, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -158,6 +783,98 @@ func (siw *ServerInterfaceWrapper) GetProduct(w http.ResponseWriter, r *http.Req
 	handler.ServeHTTP(w, r)
 }
 
+// UpdateProduct operation middleware
+func (siw *ServerInterfaceWrapper) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "productId" -------------
+	var productId int64
+
+	err = runtime.BindStyledParameterWithOptions("simple", "productId", chi.URLParam(r, "productId"), &productId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "productId", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, Api_keyScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.UpdateProduct(w, r, productId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetProductPriceHistory operation middleware
+func (siw *ServerInterfaceWrapper) GetProductPriceHistory(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "productId" -------------
+	var productId int64
+
+	err = runtime.BindStyledParameterWithOptions("simple", "productId", chi.URLParam(r, "productId"), &productId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "productId", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetProductPriceHistory(w, r, productId)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// ListRelatedProducts operation middleware
+func (siw *ServerInterfaceWrapper) ListRelatedProducts(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "productId" -------------
+	var productId int64
+
+	err = runtime.BindStyledParameterWithOptions("simple", "productId", chi.URLParam(r, "productId"), &productId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "productId", Err: err})
+		return
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ListRelatedProductsParams
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", r.URL.Query(), &params.Limit)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "limit", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ListRelatedProducts(w, r, productId, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 type UnescapedCookieParamError struct {
 	ParamName string
 	Err       error
@@ -271,15 +988,54 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 		ErrorHandlerFunc:   options.ErrorHandlerFunc,
 	}
 
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/admin/config", wrapper.GetConfig)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/coupons/{code}/usage", wrapper.GetCouponUsage)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/customers/{customerId}/orders", wrapper.ListCustomerOrders)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/health", wrapper.HealthCheck)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/healthz", wrapper.GetHealth)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/menu", wrapper.GetMenu)
+	})
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/order", wrapper.PlaceOrder)
 	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/orders/export.csv", wrapper.ExportOrders)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/orders/{orderId}", wrapper.GetOrder)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/orders/{orderId}/receipt", wrapper.GetOrderReceipt)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/product", wrapper.ListProducts)
 	})
+	r.Group(func(r chi.Router) {
+		r.Delete(options.BaseURL+"/product/{productId}", wrapper.DeleteProduct)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/product/{productId}", wrapper.GetProduct)
 	})
+	r.Group(func(r chi.Router) {
+		r.Put(options.BaseURL+"/product/{productId}", wrapper.UpdateProduct)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/product/{productId}/price-history", wrapper.GetProductPriceHistory)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/product/{productId}/related", wrapper.ListRelatedProducts)
+	})
 
 	return r
 }
@@ -1,46 +1,331 @@
 package api
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 //go:generate go tool oapi-codegen -config oapigen.yaml ./../../openapi/api-1.yaml
 
+// apiKey is the read-write key accepted when no keys are configured with WithAPIKeys
 const apiKey = "oolio"
 
+// Largest difference between an order total and the client's expected total
+// that is still accepted, absorbing rounding in clients that price in floats
+const expectedTotalTolerance Money = 1
+
+// Default largest quantity of a single item in an order
+const defaultMaxItemQuantity = 100
+
+// Default longest query string accepted by Routes, in bytes
+const defaultMaxQueryLength = 2048
+
+// Seconds a client is asked to wait when every order slot is taken
+const orderRetryAfter = "1"
+
+// customerIDPattern is the format of customer IDs attached to orders
+var customerIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Default and maximum number of products in a page of ListProducts
+const (
+	defaultProductsLimit = 50
+	maxProductsLimit     = 200
+)
+
+// Default and maximum number of products returned by ListRelatedProducts
+const (
+	defaultRelatedLimit = 5
+	maxRelatedLimit     = 50
+)
+
 // Server is an implementation of the ServerInterface generated by oapi-codegen.
 // It implments the HTTP handlers for the API.
 type Server struct {
 	promoCodes map[string]struct{}
-	db         *sql.DB
+	timeout    time.Duration
+	now        func() time.Time
+
+	// db is the database connection, guarded by dbMu as ReloadDB replaces it;
+	// handlers read it through conn
+	dbMu sync.RWMutex
+	db   *sql.DB
+
+	// openDB reopens the database for ReloadDB, which MonitorDB calls when a
+	// ping every dbCheckInterval fails
+	openDB          func() (*sql.DB, error)
+	dbCheckInterval time.Duration
+
+	// orderSlots bounds how many PlaceOrder calls run at once; nil means no limit
+	orderSlots chan struct{}
+
+	// maxItemQuantity is the largest quantity accepted for an item of an order
+	maxItemQuantity int
+
+	// maxQueryLength is the longest query string accepted, in bytes
+	maxQueryLength int
+
+	// duplicates rejects repeats of recent orders; nil disables the check
+	duplicates *duplicateDetector
+
+	// apiKeys maps each accepted api_key header value to its scope
+	apiKeys map[string]Scope
+	// partners maps API keys to the name of the partner each was issued to
+	partners map[string]string
+
+	// requestLog receives an entry per request; nil disables request logging
+	requestLog *slog.Logger
+
+	// Requests per second allowed per client IP; 0 disables rate limiting
+	rateLimit float64
+	rateBurst int
+
+	// Requests per second allowed per API key; 0 disables the limit
+	keyRateLimit float64
+	keyRateBurst int
+
+	// couponExpiry holds the last valid day of coupons that expire, which
+	// ends at midnight in couponZone
+	couponExpiry map[string]time.Time
+	couponZone   *time.Location
+
+	// discounts maps coupon codes to what they take off an order
+	discounts map[string]Discount
+
+	// taxPercent is the tax rate included in prices, shown on receipts
+	taxPercent int
+
+	// allowedCategories restricts the categories products may be given;
+	// empty allows any
+	allowedCategories []string
+
+	// couponLog records rejected coupon codes, hashed with couponSalt
+	couponLog  *slog.Logger
+	couponSalt []byte
+
+	// Where the server runs and was loaded from, only reported by GetConfig
+	addr           string
+	dbPath         string
+	promoCodesFile string
+}
+
+// Option configures optional behaviour of a Server
+type Option func(*Server)
+
+// WithTimeout sets how long a request may run before Routes responds with 503.
+// Defaults to 30 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.timeout = timeout
+	}
+}
+
+// WithClock sets the function used to timestamp new orders. Defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithRateLimit limits each client IP to rate requests per second with bursts
+// of up to burst requests. Rate limiting is disabled by default.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(s *Server) {
+		s.rateLimit = rate
+		s.rateBurst = burst
+	}
+}
+
+// WithAPIKeyRateLimit limits each API key to rate requests per second with
+// bursts of up to burst requests, protecting PlaceOrder from a single abusive
+// client. It applies on top of WithRateLimit. It is disabled by default.
+func WithAPIKeyRateLimit(rate float64, burst int) Option {
+	return func(s *Server) {
+		s.keyRateLimit = rate
+		s.keyRateBurst = burst
+	}
+}
+
+// WithRequestLog logs every request served by Routes to logger with
+// RequestLog. Requests aren't logged by default.
+func WithRequestLog(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.requestLog = logger
+	}
+}
+
+// WithAPIKeys sets the accepted API keys and the scope of each, replacing
+// the default read-write key. Read-only keys can't place orders.
+func WithAPIKeys(keys map[string]Scope) Option {
+	return func(s *Server) {
+		s.apiKeys = keys
+	}
+}
+
+// WithPartners names the partner each API key was issued to, so handlers can
+// tell callers apart with PartnerFromContext. Keys still need to be accepted
+// with WithAPIKeys; naming one here doesn't make it valid.
+func WithPartners(partners map[string]string) Option {
+	return func(s *Server) {
+		s.partners = partners
+	}
+}
+
+// WithCouponExpiry sets the last valid day of coupons that expire; coupons
+// not in expiresOn never expire. Only the date of each time is used: a coupon
+// stays valid until the end of that day in loc, not at midnight UTC. A nil
+// loc means UTC. Codes are normalized with NormalizeCoupon.
+func WithCouponExpiry(expiresOn map[string]time.Time, loc *time.Location) Option {
+	return func(s *Server) {
+		s.couponExpiry = normalizeCouponKeys(expiresOn)
+		if loc != nil {
+			s.couponZone = loc
+		}
+	}
+}
+
+// WithMaxItemQuantity sets the largest quantity accepted for each item of an
+// order, 100 by default. Orders over it get a 400. Zero or less keeps the default.
+func WithMaxItemQuantity(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxItemQuantity = n
+		}
+	}
+}
+
+// WithAllowedCategories restricts the categories UpdateProduct accepts to
+// categories, compared after normalization, so the menu can't grow stray
+// categories. Others get a 400. Any category is accepted by default.
+func WithAllowedCategories(categories []string) Option {
+	return func(s *Server) {
+		s.allowedCategories = categories
+	}
+}
+
+// WithMaxQueryLength sets the longest query string, in bytes, that Routes
+// accepts, 2048 by default. Longer ones get a 414 before reaching a handler.
+// Zero or less keeps the default.
+func WithMaxQueryLength(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxQueryLength = n
+		}
+	}
+}
+
+// WithMaxConcurrentOrders limits how many orders are placed at the same time,
+// protecting the single SQLite writer. Orders over the limit get a 503 with
+// Retry-After instead of queueing. Zero or less leaves orders unlimited, the default.
+func WithMaxConcurrentOrders(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.orderSlots = make(chan struct{}, n)
+		} else {
+			s.orderSlots = nil
+		}
+	}
 }
 
 // NewServer creates a new Server instance with the given valid promo codes and database connection.
 // It creates a map for efficient lookup of valid codes.
 // We also use sqlite for storing data.
-func NewServer(codes []string, db *sql.DB) ServerInterface {
+func NewServer(codes []string, db *sql.DB, opts ...Option) *Server {
 	s := &Server{
-		promoCodes: make(map[string]struct{}),
-		db:         db,
+		promoCodes:      make(map[string]struct{}, len(codes)),
+		db:              db,
+		timeout:         30 * time.Second,
+		now:             time.Now,
+		apiKeys:         map[string]Scope{apiKey: ScopeReadWrite},
+		couponZone:      time.UTC,
+		maxItemQuantity: defaultMaxItemQuantity,
+		maxQueryLength:  defaultMaxQueryLength,
+		couponLog:       slog.Default(),
+		couponSalt:      newCouponSalt(),
 	}
 	for _, code := range codes {
-		s.promoCodes[code] = struct{}{}
+		s.promoCodes[NormalizeCoupon(code)] = struct{}{}
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	return s
 }
 
+// NewServerValidated is NewServer for startup, when a misconfiguration should
+// fail fast rather than at the first request. It returns an error if db is nil,
+// and logs a warning if there are no promo codes, as every coupon is then rejected.
+func NewServerValidated(codes []string, db *sql.DB, opts ...Option) (*Server, error) {
+	if db == nil {
+		return nil, errors.New("a database connection is required")
+	}
+	if len(codes) == 0 {
+		log.Printf("Warning: no promo codes loaded, every coupon code will be rejected")
+	}
+	return NewServer(codes, db, opts...), nil
+}
+
+// Routes returns an http.Handler serving every API route with the server's middleware applied.
+// It can be used directly by an http.Server or mounted under a prefix of a larger router.
+func (s *Server) Routes() http.Handler {
+	r := chi.NewRouter()
+	// Outermost, so panics and timeouts are logged with the status sent
+	if s.requestLog != nil {
+		r.Use(RequestLog(s.requestLog))
+	}
+	r.Use(Recover())
+	r.Use(MaxQueryLength(s.maxQueryLength))
+	r.Use(RequireScope(s.apiKeys))
+	if len(s.partners) > 0 {
+		r.Use(IdentifyPartner(s.partners))
+	}
+	if s.rateLimit > 0 {
+		r.Use(RateLimit(s.rateLimit, s.rateBurst))
+	}
+	if s.keyRateLimit > 0 {
+		r.Use(APIKeyRateLimit(s.keyRateLimit, s.keyRateBurst))
+	}
+	// The orders export can take long to build and serves large bodies, which
+	// the timeout would buffer
+	r.Use(Timeout(s.timeout, "/orders/export.csv"))
+	r.NotFound(notFound)
+	return HandlerFromMux(s, r)
+}
+
 func (s *Server) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 	// Check API key authentication
-	if r.Header.Get("api_key") != apiKey {
-		writeError(w, http.StatusUnauthorized, "Invalid or missing API key")
+	if !s.requireAPIKey(w, r) {
 		return
 	}
 
+	// Take an order slot, or shed load rather than queue behind the writer
+	if s.orderSlots != nil {
+		select {
+		case s.orderSlots <- struct{}{}:
+			defer func() { <-s.orderSlots }()
+		default:
+			w.Header().Set("Retry-After", orderRetryAfter)
+			writeError(w, http.StatusServiceUnavailable, "Too many orders in progress, please retry")
+			return
+		}
+	}
+
 	// Parse request body
 	var orderReq OrderReq
 	if err := json.NewDecoder(r.Body).Decode(&orderReq); err != nil {
@@ -48,23 +333,51 @@ func (s *Server) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Coupon codes match regardless of case and surrounding whitespace
+	if orderReq.CouponCode != nil {
+		code := NormalizeCoupon(*orderReq.CouponCode)
+		orderReq.CouponCode = &code
+	}
+
 	// Validate request
 	if len(orderReq.Items) == 0 {
 		writeError(w, http.StatusBadRequest, "Order must contain at least one item")
 		return
 	}
 
+	if mode := orderReq.DiscountMode; mode != nil && *mode != Stack && *mode != Best {
+		writeError(w, http.StatusBadRequest, "Invalid discount mode, must be stack or best")
+		return
+	}
+
+	// Orders without a customer ID are guest orders
+	if orderReq.CustomerId != nil && !customerIDPattern.MatchString(*orderReq.CustomerId) {
+		writeError(w, http.StatusBadRequest, "Invalid customer ID, must be up to 64 letters, digits, - or _")
+		return
+	}
+
+	// Clients may choose the order ID, e.g. to correlate it with their systems
+	if orderReq.Id != nil && !isUUID(*orderReq.Id) {
+		writeError(w, http.StatusBadRequest, "Invalid order ID, must be a UUID such as 3fa85f64-5717-4562-b3fc-2c963f66afa6")
+		return
+	}
+
 	// Validate promo code if provided
-	if orderReq.CouponCode != nil && *orderReq.CouponCode != "" {
-		if _, valid := s.promoCodes[*orderReq.CouponCode]; !valid {
-			writeError(w, http.StatusUnprocessableEntity, "Invalid coupon code")
-			return
-		}
+	if err := s.validateCoupon(orderReq.CouponCode); errors.Is(err, ErrCouponExpired) {
+		writeError(w, statusForError(err), "Coupon code has expired")
+		return
+	} else if err != nil {
+		s.logCouponRejection(r, *orderReq.CouponCode)
+		writeError(w, statusForError(err), "Invalid coupon code")
+		return
 	}
 
 	// Extract product IDs and validate quantities
+	// A product listed more than once becomes a single item with the quantities
+	// added up, as an order holds one row per product
 	productIDs := make([]string, 0, len(orderReq.Items))
 	orderItems := make([]OrderItem, 0, len(orderReq.Items))
+	itemIndex := make(map[string]int, len(orderReq.Items))
 
 	for _, item := range orderReq.Items {
 		if item.Quantity <= 0 {
@@ -72,6 +385,19 @@ func (s *Server) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, "Item quantity must be greater than 0 for all items")
 			return
 		}
+		if item.Quantity > s.maxItemQuantity {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Quantity of product %s must be at most %d", item.ProductId, s.maxItemQuantity))
+			return
+		}
+		if i, ok := itemIndex[item.ProductId]; ok {
+			orderItems[i].Quantity += item.Quantity
+			if orderItems[i].Quantity > s.maxItemQuantity {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Quantity of product %s must be at most %d", item.ProductId, s.maxItemQuantity))
+				return
+			}
+			continue
+		}
+		itemIndex[item.ProductId] = len(orderItems)
 		productIDs = append(productIDs, item.ProductId)
 		orderItems = append(orderItems, OrderItem{
 			ProductID: item.ProductId,
@@ -80,38 +406,128 @@ func (s *Server) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate all products exist
-	if err := ValidateProductsExist(s.db, productIDs); err != nil {
+	_, err := withRetry(r.Context(), func() (struct{}, error) {
+		return struct{}{}, ValidateProductsExist(r.Context(), s.conn(), productIDs)
+	})
+	if isTransient(err) {
+		writeError(w, statusForError(err), "Database is busy, please retry")
+		return
+	}
+	if err != nil {
 		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid products: %v", err))
 		return
 	}
 
-	// Create the order
-	orderID, err := CreateOrder(s.db, orderReq.CouponCode, orderItems)
+	// Products sold in multiples, e.g. 6-packs, must be ordered in whole steps
+	steps, err := withRetry(r.Context(), func() (map[string]int, error) {
+		return GetQuantitySteps(r.Context(), s.conn(), productIDs)
+	})
 	if err != nil {
-		log.Printf("Failed to create order: %v", err)
-		writeError(w, http.StatusInternalServerError, "Failed to create order")
+		log.Printf("Failed to fetch quantity steps: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch product details")
 		return
 	}
+	for _, item := range orderItems {
+		if step := steps[item.ProductID]; step > 1 && item.Quantity%step != 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Quantity of product %s must be a multiple of %d", item.ProductID, step))
+			return
+		}
+	}
 
 	// Fetch product details for response
-	products, err := GetProductsByIDs(s.db, productIDs)
+	products, err := withRetry(r.Context(), func() ([]Product, error) {
+		return GetProductsByIDs(r.Context(), s.conn(), productIDs)
+	})
 	if err != nil {
 		log.Printf("Failed to fetch products: %v", err)
-		writeError(w, http.StatusInternalServerError, "Failed to fetch product details")
+		writeError(w, statusForError(err), "Failed to fetch product details")
+		return
+	}
+
+	tiers, err := withRetry(r.Context(), func() (map[string][]PriceTier, error) {
+		return GetPriceTiers(r.Context(), s.conn(), productIDs)
+	})
+	if err != nil {
+		log.Printf("Failed to fetch price tiers: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch product pricing")
+		return
+	}
+	var discount *Discount
+	if orderReq.CouponCode != nil {
+		if d, ok := s.discounts[*orderReq.CouponCode]; ok {
+			discount = &d
+		}
+	}
+	mode := Stack
+	if orderReq.DiscountMode != nil {
+		mode = *orderReq.DiscountMode
+	}
+	subtotal, total, err := priceOrder(orderItems, products, tiers, discount, mode)
+	if err != nil {
+		writeError(w, statusForError(err), "Order total is too large")
 		return
 	}
 
-	// Build response items
+	// A client pricing from a stale menu must not be charged a surprise total
+	if orderReq.ExpectedTotal != nil {
+		if diff := total - *orderReq.ExpectedTotal; diff > expectedTotalTolerance || diff < -expectedTotalTolerance {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(PriceMismatch{
+				Error:         "price mismatch",
+				ExpectedTotal: *orderReq.ExpectedTotal,
+				Total:         total,
+			})
+			return
+		}
+	}
+
+	// An identical order moments ago is most likely a double-submit
+	placedAt := s.now()
+	var hash orderHash
+	if s.duplicates != nil {
+		hash = hashOrder(r, orderReq.CustomerId, orderReq.CouponCode, orderItems)
+		if !s.duplicates.claim(hash, placedAt) {
+			writeError(w, http.StatusConflict, "Duplicate order, an identical order was just placed")
+			return
+		}
+	}
+
+	// Create the order, with the client's ID if it sent one
+	orderID := uuid.New().String()
+	if orderReq.Id != nil {
+		orderID = *orderReq.Id
+	}
+	_, err = withRetry(r.Context(), func() (struct{}, error) {
+		return struct{}{}, CreateOrderWithID(r.Context(), s.conn(), orderID, orderReq.CouponCode, orderReq.CustomerId, total, orderItems, placedAt)
+	})
+	if err != nil {
+		// The order wasn't placed, so retrying it is not a duplicate
+		if s.duplicates != nil {
+			s.duplicates.release(hash, placedAt)
+		}
+		if errors.Is(err, ErrOrderExists) {
+			writeError(w, statusForError(err), "Order ID already used")
+			return
+		}
+		log.Printf("Failed to create order: %v", err)
+		writeError(w, statusForError(err), "Failed to create order")
+		return
+	}
+
+	// Build response items, with duplicates merged as they were stored
 	responseItems := make([]struct {
 		ProductId *string `json:"productId,omitempty"`
 		Quantity  *int    `json:"quantity,omitempty"`
-	}, len(orderReq.Items))
+		UnitPrice *Money  `json:"unitPrice,omitempty"`
+	}, len(orderItems))
 
-	for i, item := range orderReq.Items {
-		productID := item.ProductId
+	for i, item := range orderItems {
+		productID := item.ProductID
 		quantity := item.Quantity
 		responseItems[i].ProductId = &productID
 		responseItems[i].Quantity = &quantity
+		responseItems[i].UnitPrice = item.UnitPrice
 	}
 
 	// Build response
@@ -119,39 +535,196 @@ func (s *Server) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 		Id:       &orderID,
 		Items:    &responseItems,
 		Products: &products,
+		Subtotal: &subtotal,
+		Total:    &total,
+	}
+	if orderReq.CouponCode != nil && *orderReq.CouponCode != "" {
+		response.CouponCode = orderReq.CouponCode
 	}
+	response.CustomerId = orderReq.CustomerId
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) ListProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := GetAllProducts(s.db)
+func (s *Server) ListProducts(w http.ResponseWriter, r *http.Request, params ListProductsParams) {
+	var sort string
+	if params.Sort != nil {
+		sort = *params.Sort
+	}
+	limit, offset := defaultProductsLimit, 0
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if params.Offset != nil {
+		offset = *params.Offset
+	}
+	if limit < 1 || limit > maxProductsLimit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit, must be between 1 and %d", maxProductsLimit))
+		return
+	}
+	if offset < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid offset, must not be negative")
+		return
+	}
+
+	products, err := withRetry(r.Context(), func() ([]Product, error) {
+		return GetProductsPage(r.Context(), s.conn(), sort, limit, offset)
+	})
+	if errors.Is(err, ErrInvalidSort) {
+		writeError(w, statusForError(err), "Invalid sort value, must be one of name, price or category with an optional - prefix")
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to fetch products: %v", err)
-		writeError(w, http.StatusInternalServerError, "Failed to fetch products")
+		writeError(w, statusForError(err), "Failed to fetch products")
+		return
+	}
+	total, err := withRetry(r.Context(), func() (int, error) {
+		return CountProducts(r.Context(), s.conn())
+	})
+	if err != nil {
+		log.Printf("Failed to count products: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch products")
 		return
 	}
+	// A page past the end is empty, not null
+	if products == nil {
+		products = []Product{}
+	}
 
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(products)
 }
 
+// GetMenu returns the products on the menu grouped by category
+func (s *Server) GetMenu(w http.ResponseWriter, r *http.Request) {
+	products, err := withRetry(r.Context(), func() ([]Product, error) {
+		return GetAllProducts(r.Context(), s.conn(), "category")
+	})
+	if err != nil {
+		log.Printf("Failed to fetch products: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch menu")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(groupByCategory(products))
+}
+
+// groupByCategory groups products already sorted by category into one
+// MenuCategory per category, in the same order
+func groupByCategory(products []Product) []MenuCategory {
+	menu := []MenuCategory{}
+	for _, p := range products {
+		if len(menu) == 0 || menu[len(menu)-1].Category != *p.Category {
+			menu = append(menu, MenuCategory{Category: *p.Category})
+		}
+		last := &menu[len(menu)-1]
+		last.Products = append(last.Products, p)
+	}
+	return menu
+}
+
 func (s *Server) GetProduct(w http.ResponseWriter, r *http.Request, productId int64) {
 	// Convert int64 to string for database lookup
 	productIDStr := strconv.FormatInt(productId, 10)
 
-	product, err := GetProductByID(s.db, productIDStr)
+	product, err := withRetry(r.Context(), func() (*Product, error) {
+		return GetProductByID(r.Context(), s.conn(), productIDStr)
+	})
+	if errors.Is(err, ErrProductNotFound) {
+		writeError(w, statusForError(err), "Product not found")
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to fetch product: %v", err)
-		writeError(w, http.StatusInternalServerError, "Failed to fetch product")
+		writeError(w, statusForError(err), "Failed to fetch product")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(product)
+}
+
+func (s *Server) DeleteProduct(w http.ResponseWriter, r *http.Request, productId int64, params DeleteProductParams) {
+	if !s.requireAPIKey(w, r) {
+		return
+	}
+
+	productIDStr := strconv.FormatInt(productId, 10)
+	soft := params.Soft != nil && *params.Soft
+
+	_, err := withRetry(r.Context(), func() (struct{}, error) {
+		return struct{}{}, DeleteProduct(r.Context(), s.conn(), productIDStr, soft)
+	})
+	if errors.Is(err, ErrProductNotFound) {
+		writeError(w, statusForError(err), "Product not found")
+		return
+	}
+	if errors.Is(err, ErrProductInUse) {
+		writeError(w, statusForError(err), "Product appears in orders, use soft=true to remove it from the menu instead")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete product: %v", err)
+		writeError(w, statusForError(err), "Failed to delete product")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateProduct replaces the name, price and category of a product and
+// returns it. The category is normalized and checked against the allowed
+// categories, if any.
+func (s *Server) UpdateProduct(w http.ResponseWriter, r *http.Request, productId int64) {
+	if !s.requireAPIKey(w, r) {
+		return
+	}
+
+	var update ProductUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	name := strings.TrimSpace(update.Name)
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "Product name must not be empty")
+		return
+	}
+	if update.Price < 0 {
+		writeError(w, http.StatusBadRequest, "Product price must not be negative")
 		return
 	}
 
-	if product == nil {
-		writeError(w, http.StatusNotFound, "Product not found")
+	productIDStr := strconv.FormatInt(productId, 10)
+	product, err := withRetry(r.Context(), func() (*Product, error) {
+		if err := UpdateProduct(r.Context(), s.conn(), productIDStr, name, update.Price, update.Category, s.allowedCategories); err != nil {
+			return nil, err
+		}
+		return GetProductByID(r.Context(), s.conn(), productIDStr)
+	})
+	if errors.Is(err, ErrProductNotFound) {
+		writeError(w, statusForError(err), "Product not found")
+		return
+	}
+	if errors.Is(err, ErrInvalidCategory) {
+		msg := "Product category must not be empty"
+		if len(s.allowedCategories) > 0 {
+			msg = "Invalid product category, must be one of " + strings.Join(s.allowedCategories, ", ")
+		}
+		writeError(w, statusForError(err), msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update product: %v", err)
+		writeError(w, statusForError(err), "Failed to update product")
 		return
 	}
 
@@ -160,6 +733,293 @@ func (s *Server) GetProduct(w http.ResponseWriter, r *http.Request, productId in
 	json.NewEncoder(w).Encode(product)
 }
 
+func (s *Server) GetProductPriceHistory(w http.ResponseWriter, r *http.Request, productId int64) {
+	productIDStr := strconv.FormatInt(productId, 10)
+
+	history, err := withRetry(r.Context(), func() ([]PriceChange, error) {
+		return GetPriceHistory(r.Context(), s.conn(), productIDStr)
+	})
+	if errors.Is(err, ErrProductNotFound) {
+		writeError(w, statusForError(err), "Product not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch price history: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch price history")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+}
+
+func (s *Server) ListRelatedProducts(w http.ResponseWriter, r *http.Request, productId int64, params ListRelatedProductsParams) {
+	limit := defaultRelatedLimit
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+	if limit < 1 || limit > maxRelatedLimit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit, must be between 1 and %d", maxRelatedLimit))
+		return
+	}
+
+	productIDStr := strconv.FormatInt(productId, 10)
+
+	related, err := withRetry(r.Context(), func() ([]Product, error) {
+		return GetRelatedProducts(r.Context(), s.conn(), productIDStr, limit)
+	})
+	if errors.Is(err, ErrProductNotFound) {
+		writeError(w, statusForError(err), "Product not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch related products: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch related products")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(related)
+}
+
+// ListCustomerOrders returns the orders placed with a customer ID, newest first
+func (s *Server) ListCustomerOrders(w http.ResponseWriter, r *http.Request, customerId string) {
+	if !s.requireAPIKey(w, r) {
+		return
+	}
+
+	if !customerIDPattern.MatchString(customerId) {
+		writeError(w, http.StatusBadRequest, "Invalid customer ID, must be up to 64 letters, digits, - or _")
+		return
+	}
+
+	orders, err := withRetry(r.Context(), func() ([]Order, error) {
+		return GetOrdersByCustomer(r.Context(), s.conn(), customerId)
+	})
+	if err != nil {
+		log.Printf("Failed to fetch customer orders: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch customer orders")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(orders)
+}
+
+// GetCouponUsage returns how many orders were placed with a coupon code.
+// Codes that aren't loaded promo codes are not found.
+func (s *Server) GetCouponUsage(w http.ResponseWriter, r *http.Request, code string) {
+	if !s.requireAPIKey(w, r) {
+		return
+	}
+
+	code = NormalizeCoupon(code)
+	if _, ok := s.promoCodes[code]; !ok {
+		writeError(w, http.StatusNotFound, "Coupon not found")
+		return
+	}
+
+	count, err := withRetry(r.Context(), func() (int, error) {
+		return CountCouponUsage(r.Context(), s.conn(), code)
+	})
+	if err != nil {
+		log.Printf("Failed to count coupon usage: %v", err)
+		writeError(w, statusForError(err), "Failed to count coupon usage")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CouponUsage{Code: code, Orders: count})
+}
+
+// GetOrder returns a placed order with its items
+func (s *Server) GetOrder(w http.ResponseWriter, r *http.Request, orderId string) {
+	if !s.requireAPIKey(w, r) {
+		return
+	}
+
+	order, err := withRetry(r.Context(), func() (*Order, error) {
+		return GetOrderByID(r.Context(), s.conn(), orderId)
+	})
+	if errors.Is(err, ErrOrderNotFound) {
+		writeError(w, statusForError(err), "Order not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch order: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch order")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(order)
+}
+
+// ExportOrders serves orders as CSV, optionally limited to an inclusive range of dates.
+// Range requests are supported, so large exports can be downloaded in parts.
+func (s *Server) ExportOrders(w http.ResponseWriter, r *http.Request, params ExportOrdersParams) {
+	if !s.requireAPIKey(w, r) {
+		return
+	}
+
+	from, err := parseDate(params.From)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid from date, must be YYYY-MM-DD")
+		return
+	}
+	to, err := parseDate(params.To)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid to date, must be YYYY-MM-DD")
+		return
+	}
+	if !to.IsZero() {
+		// Include every order placed on the to date
+		to = to.AddDate(0, 0, 1)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "Invalid date range, from must not be after to")
+		return
+	}
+
+	// The export is written to a temporary file and served from there, so a
+	// failing query is still reported as a JSON error and clients can resume an
+	// interrupted download with a Range request
+	f, err := os.CreateTemp("", "orders-*.csv")
+	if err != nil {
+		log.Printf("Failed to create order export file: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to export orders")
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	cw := csv.NewWriter(io.MultiWriter(f, hash))
+	if err := cw.Write([]string{"order_id", "created_at", "coupon_code", "total", "item_count"}); err != nil {
+		log.Printf("Failed to write export header: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to export orders")
+		return
+	}
+	err = ExportOrders(r.Context(), s.conn(), from, to, func(o OrderSummary) error {
+		var couponCode, total string
+		if o.CouponCode != nil {
+			couponCode = *o.CouponCode
+		}
+		if o.Total != nil {
+			total = o.Total.String()
+		}
+		return cw.Write([]string{
+			o.ID,
+			o.CreatedAt.UTC().Format(time.RFC3339),
+			couponCode,
+			total,
+			strconv.Itoa(o.ItemCount),
+		})
+	})
+	if err == nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+	if err != nil {
+		log.Printf("Failed to export orders: %v", err)
+		writeError(w, statusForError(err), "Failed to export orders")
+		return
+	}
+
+	// The export is rebuilt on every request, so the ETag lets ServeContent
+	// honour If-Range and send the whole export if orders changed since the
+	// download being resumed started
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="orders.csv"`)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, hash.Sum(nil)))
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// parseDate parses an optional YYYY-MM-DD query parameter as midnight UTC.
+// A nil or empty value gives the zero time.
+func parseDate(value *string) (time.Time, error) {
+	if value == nil || *value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, *value)
+}
+
+// requireAPIKey checks the api_key header, responding with 401 if it is missing or unknown.
+// It reports whether the request may proceed. Scopes are enforced by RequireScope.
+func (s *Server) requireAPIKey(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := s.apiKeys[r.Header.Get("api_key")]; !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid or missing API key")
+		return false
+	}
+	return true
+}
+
+// isUUID reports whether id is a UUID in its canonical, hyphenated form
+func isUUID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil && len(id) == 36
+}
+
+// NormalizeCoupon returns code trimmed of surrounding whitespace and upper
+// cased, the form promo codes are stored and looked up in, so "  save10 "
+// matches SAVE10
+func NormalizeCoupon(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// normalizeCouponKeys returns m with its coupon code keys normalized with NormalizeCoupon
+func normalizeCouponKeys[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return nil
+	}
+	normalized := make(map[string]V, len(m))
+	for code, v := range m {
+		normalized[NormalizeCoupon(code)] = v
+	}
+	return normalized
+}
+
+// validateCoupon checks an optional coupon code against the loaded promo codes
+// and their expiry, using the server clock.
+// A nil or empty code is valid, as coupons are optional.
+func (s *Server) validateCoupon(code *string) error {
+	if code == nil || *code == "" {
+		return nil
+	}
+	if _, valid := s.promoCodes[*code]; !valid {
+		return ErrCouponInvalid
+	}
+	if expiresOn, ok := s.couponExpiry[*code]; ok && couponExpired(expiresOn, s.now(), s.couponZone) {
+		return ErrCouponExpired
+	}
+	return nil
+}
+
+// couponExpired reports whether a coupon whose last valid day is the date of
+// expiresOn has expired at now. The day ends at midnight in loc; comparing
+// instants keeps the result independent of the zone now is in.
+func couponExpired(expiresOn, now time.Time, loc *time.Location) bool {
+	y, m, d := expiresOn.Date()
+	endOfDay := time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+	return !now.Before(endOfDay)
+}
+
+// notFound responds to requests for unknown routes with a JSON 404,
+// in place of chi's plain text default
+func notFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "not found",
+		"code":  "not_found",
+	})
+}
+
 // writeError writes a JSON error response
 func writeError(w http.ResponseWriter, statusCode int, message string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -2,12 +2,18 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -31,19 +37,40 @@ func setupTestDB(t *testing.T) *sql.DB {
 		id TEXT PRIMARY KEY,
 		name TEXT NOT NULL,
 		price REAL NOT NULL,
-		category TEXT NOT NULL
+		category TEXT NOT NULL,
+		image_url TEXT,
+		deleted_at TIMESTAMP,
+		qty_step INTEGER NOT NULL DEFAULT 1
 	);
 	CREATE TABLE orders (
 		id TEXT PRIMARY KEY,
-		coupon_code TEXT
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		coupon_code TEXT,
+		customer_id TEXT,
+		total REAL
 	);
+	CREATE INDEX idx_orders_coupon ON orders (coupon_code);
 	CREATE TABLE order_items (
 		order_id TEXT NOT NULL,
 		product_id TEXT NOT NULL,
 		quantity INTEGER NOT NULL,
+		unit_price REAL,
 		FOREIGN KEY(order_id) REFERENCES orders(id),
 		FOREIGN KEY(product_id) REFERENCES products(id)
 	);
+	CREATE TABLE price_history (
+		product_id TEXT NOT NULL,
+		price REAL NOT NULL,
+		changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(product_id) REFERENCES products(id)
+	);
+	CREATE TABLE product_tiers (
+		product_id TEXT NOT NULL,
+		min_quantity INTEGER NOT NULL,
+		unit_discount REAL NOT NULL,
+		FOREIGN KEY(product_id) REFERENCES products(id),
+		PRIMARY KEY(product_id, min_quantity)
+	);
 	`
 	_, err = db.Exec(createTables)
 	require.NoError(t, err)
@@ -54,6 +81,9 @@ func setupTestDB(t *testing.T) *sql.DB {
 	('PROD1', 'Burger', 10.5, 'Main'),
 	('PROD2', 'Fries', 5.0, 'Side'),
 	('PROD3', 'Coke', 2.5, 'Drink');
+	INSERT INTO product_tiers (product_id, min_quantity, unit_discount) VALUES
+	('PROD3', 10, 0.5),
+	('PROD3', 20, 1.0);
 	`
 	_, err = db.Exec(seedData)
 	require.NoError(t, err)
@@ -66,6 +96,33 @@ func setupTestDB(t *testing.T) *sql.DB {
 	return db
 }
 
+func TestNewServerValidated(t *testing.T) {
+	t.Run("NilDB", func(t *testing.T) {
+		s, err := NewServerValidated([]string{"SAVE10"}, nil)
+		require.Error(t, err)
+		assert.Nil(t, s)
+	})
+
+	tests := []struct {
+		name  string
+		codes []string
+	}{
+		{name: "WithCodes", codes: []string{"SAVE10"}},
+		{name: "NoCodes"}, // Only warned about
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewServerValidated(tt.codes, setupTestDB(t))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/product", nil)
+			w := httptest.NewRecorder()
+			s.Routes().ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
 func TestServer_PlaceOrder(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -74,10 +131,11 @@ func TestServer_PlaceOrder(t *testing.T) {
 		closeDB        bool
 		expectedStatus int
 		expectedError  string
+		expectedTotal  Money
 	}{
 		{
 			name:   "Success",
-			apiKey: "secret-api-key",
+			apiKey: apiKey,
 			requestBody: OrderReq{
 				Items: []struct {
 					ProductId string `json:"productId"`
@@ -88,6 +146,43 @@ func TestServer_PlaceOrder(t *testing.T) {
 				},
 			},
 			expectedStatus: http.StatusOK,
+			expectedTotal:  2600,
+		},
+		{
+			name:   "Success_BelowTier",
+			apiKey: apiKey,
+			requestBody: OrderReq{
+				Items: []struct {
+					ProductId string `json:"productId"`
+					Quantity  int    `json:"quantity"`
+				}{
+					{ProductId: "PROD3", Quantity: 9},
+				},
+			},
+			expectedStatus: http.StatusOK,
+			expectedTotal:  2250, // Full price, 9 x 2.5
+		},
+		{
+			name:   "Success_AtTier",
+			apiKey: apiKey,
+			requestBody: OrderReq{
+				Items: []struct {
+					ProductId string `json:"productId"`
+					Quantity  int    `json:"quantity"`
+				}{
+					{ProductId: "PROD3", Quantity: 10},
+				},
+			},
+			expectedStatus: http.StatusOK,
+			expectedTotal:  2000, // 0.5 off each unit, 10 x 2.0
+		},
+		{
+			name:   "Success_IgnoresClientPrices",
+			apiKey: apiKey,
+			// Prices sent by the client are not part of OrderReq, so the total comes from the database
+			requestBody:    `{"items":[{"productId":"PROD1","quantity":2,"price":0.01},{"productId":"PROD2","quantity":1,"price":0.01}],"total":0.02}`,
+			expectedStatus: http.StatusOK,
+			expectedTotal:  2600,
 		},
 		{
 			name:   "Unauthorized_MissingKey",
@@ -119,14 +214,14 @@ func TestServer_PlaceOrder(t *testing.T) {
 		},
 		{
 			name:           "BadRequest_InvalidJSON",
-			apiKey:         "secret-api-key",
+			apiKey:         apiKey,
 			requestBody:    "{invalid-json",
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "Invalid request body",
 		},
 		{
 			name:   "BadRequest_EmptyItems",
-			apiKey: "secret-api-key",
+			apiKey: apiKey,
 			requestBody: OrderReq{
 				Items: []struct {
 					ProductId string `json:"productId"`
@@ -138,7 +233,7 @@ func TestServer_PlaceOrder(t *testing.T) {
 		},
 		{
 			name:   "UnprocessableEntity_InvalidCoupon",
-			apiKey: "secret-api-key",
+			apiKey: apiKey,
 			requestBody: OrderReq{
 				CouponCode: func() *string { s := "INVALID"; return &s }(),
 				Items: []struct {
@@ -153,7 +248,7 @@ func TestServer_PlaceOrder(t *testing.T) {
 		},
 		{
 			name:   "Success_ValidCoupon",
-			apiKey: "secret-api-key",
+			apiKey: apiKey,
 			requestBody: OrderReq{
 				CouponCode: func() *string { s := "SAVE10"; return &s }(),
 				Items: []struct {
@@ -167,7 +262,7 @@ func TestServer_PlaceOrder(t *testing.T) {
 		},
 		{
 			name:   "BadRequest_InvalidProduct",
-			apiKey: "secret-api-key",
+			apiKey: apiKey,
 			requestBody: OrderReq{
 				Items: []struct {
 					ProductId string `json:"productId"`
@@ -179,9 +274,38 @@ func TestServer_PlaceOrder(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "Invalid products",
 		},
+		{
+			name:   "Success_QuantityStep",
+			apiKey: apiKey,
+			requestBody: OrderReq{
+				Items: []struct {
+					ProductId string `json:"productId"`
+					Quantity  int    `json:"quantity"`
+				}{
+					{ProductId: "PROD4", Quantity: 12},
+				},
+			},
+			expectedStatus: http.StatusOK,
+			expectedTotal:  4800,
+		},
+		{
+			name:   "BadRequest_QuantityStep",
+			apiKey: apiKey,
+			requestBody: OrderReq{
+				Items: []struct {
+					ProductId string `json:"productId"`
+					Quantity  int    `json:"quantity"`
+				}{
+					{ProductId: "PROD1", Quantity: 1},
+					{ProductId: "PROD4", Quantity: 4},
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Quantity of product PROD4 must be a multiple of 6",
+		},
 		{
 			name:   "BadRequest_NegativeQuantity",
-			apiKey: "secret-api-key",
+			apiKey: apiKey,
 			requestBody: OrderReq{
 				Items: []struct {
 					ProductId string `json:"productId"`
@@ -195,7 +319,7 @@ func TestServer_PlaceOrder(t *testing.T) {
 		},
 		{
 			name:   "InternalServerError_DBError",
-			apiKey: "secret-api-key",
+			apiKey: apiKey,
 			requestBody: OrderReq{
 				Items: []struct {
 					ProductId string `json:"productId"`
@@ -213,14 +337,15 @@ func TestServer_PlaceOrder(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			db := setupTestDB(t)
+			_, err := db.Exec("INSERT INTO products (id, name, price, category, qty_step) VALUES ('PROD4', 'Beer 6-pack', 4.0, 'Drink', 6)")
+			require.NoError(t, err)
 			if tt.closeDB {
 				db.Close()
 			}
-			server := NewServer([]string{"SAVE10", "WELCOME"}, db)
+			s := NewServer([]string{"SAVE10", "WELCOME"}, db)
 
 			// Create request body
 			var body []byte
-			var err error
 			if reqStr, ok := tt.requestBody.(string); ok {
 				body = []byte(reqStr)
 			} else {
@@ -236,7 +361,6 @@ func TestServer_PlaceOrder(t *testing.T) {
 
 			w := httptest.NewRecorder()
 
-			s := server.(*Server)
 			s.PlaceOrder(w, req)
 
 			resp := w.Result()
@@ -258,23 +382,317 @@ func TestServer_PlaceOrder(t *testing.T) {
 				if req, ok := tt.requestBody.(OrderReq); ok {
 					assert.Equal(t, len(req.Items), len(*orderResp.Items))
 				}
+				if tt.expectedTotal != 0 {
+					require.NotNil(t, orderResp.Total)
+					assert.Equal(t, tt.expectedTotal, *orderResp.Total)
+				}
+			}
+		})
+	}
+}
+
+// TestServer_PlaceOrder_Clock verifies orders are timestamped by the injected clock
+func TestServer_PlaceOrder_Clock(t *testing.T) {
+	db := setupTestDB(t)
+	// Not in UTC, to check the timestamp is converted before it is stored
+	placedAt := time.Date(2025, 6, 1, 21, 30, 0, 0, time.FixedZone("AEST", 10*60*60))
+	s := NewServer(nil, db, WithClock(func() time.Time { return placedAt }))
+
+	body := `{"items":[{"productId":"PROD1","quantity":1}]}`
+	req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+	req.Header.Set("api_key", apiKey)
+	w := httptest.NewRecorder()
+
+	s.PlaceOrder(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var order Order
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&order))
+
+	var createdAt time.Time
+	err := db.QueryRow("SELECT created_at FROM orders WHERE id = ?", *order.Id).Scan(&createdAt)
+	require.NoError(t, err)
+	assert.True(t, placedAt.Equal(createdAt), "created_at = %v, want %v", createdAt, placedAt)
+}
+
+func TestNormalizeCoupon(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{code: "SAVE10", expected: "SAVE10"},
+		{code: "  save10 ", expected: "SAVE10"},
+		{code: "\tSave10\n", expected: "SAVE10"},
+		{code: "   ", expected: ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NormalizeCoupon(tt.code), "code %q", tt.code)
+	}
+}
+
+// TestServer_PlaceOrder_NormalizedCoupon verifies a coupon code is matched
+// regardless of case and surrounding whitespace, in the request and in the
+// loaded codes, and is stored in its normalized form
+func TestServer_PlaceOrder_NormalizedCoupon(t *testing.T) {
+	db := setupTestDB(t)
+	s := NewServer([]string{"SAVE10", " welcome "}, db,
+		WithDiscounts(map[string]Discount{"save10": {Percent: 10}}))
+
+	for _, tt := range []struct{ code, stored string }{
+		{code: "  save10 ", stored: "SAVE10"},
+		{code: "Welcome", stored: "WELCOME"},
+	} {
+		body := `{"items":[{"productId":"PROD1","quantity":2}],"couponCode":"` + tt.code + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+		req.Header.Set("api_key", apiKey)
+		w := httptest.NewRecorder()
+
+		s.PlaceOrder(w, req)
+		require.Equal(t, http.StatusOK, w.Code, "code %q: %s", tt.code, w.Body)
+
+		var order Order
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&order))
+		require.NotNil(t, order.CouponCode)
+		assert.Equal(t, tt.stored, *order.CouponCode)
+
+		var stored string
+		require.NoError(t, db.QueryRow("SELECT coupon_code FROM orders WHERE id = ?", *order.Id).Scan(&stored))
+		assert.Equal(t, tt.stored, stored)
+		if tt.stored == "SAVE10" {
+			require.NotNil(t, order.Total)
+			assert.Equal(t, Money(1890), *order.Total, "the discount keyed by save10 should apply")
+		}
+	}
+}
+
+func TestServer_PlaceOrder_MaxItemQuantity(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           []Option
+		quantity       int
+		expectedStatus int
+	}{
+		{name: "AtDefaultLimit", quantity: 100, expectedStatus: http.StatusOK},
+		{name: "OverDefaultLimit", quantity: 101, expectedStatus: http.StatusBadRequest},
+		{name: "Absurd", quantity: 1_000_000, expectedStatus: http.StatusBadRequest},
+		{name: "AtConfiguredLimit", opts: []Option{WithMaxItemQuantity(5)}, quantity: 5, expectedStatus: http.StatusOK},
+		{name: "OverConfiguredLimit", opts: []Option{WithMaxItemQuantity(5)}, quantity: 6, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(nil, setupTestDB(t), tt.opts...)
+			body := fmt.Sprintf(`{"items":[{"productId":"PROD1","quantity":%d}]}`, tt.quantity)
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+			req.Header.Set("api_key", apiKey)
+			w := httptest.NewRecorder()
+
+			s.PlaceOrder(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusBadRequest {
+				var errResp map[string]string
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+				assert.Contains(t, errResp["error"], "Quantity of product PROD1 must be at most")
+			}
+		})
+	}
+}
+
+// TestServer_PlaceOrder_TotalOverflow verifies an order whose total doesn't
+// fit in int64 cents is rejected instead of charged a wrapped-around total
+func TestServer_PlaceOrder_TotalOverflow(t *testing.T) {
+	s := NewServer(nil, setupTestDB(t), WithMaxItemQuantity(math.MaxInt))
+	body := fmt.Sprintf(`{"items":[{"productId":"PROD2","quantity":%d},{"productId":"PROD1","quantity":1}]}`, int64(math.MaxInt64/500))
+	req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+	req.Header.Set("api_key", apiKey)
+	w := httptest.NewRecorder()
+
+	s.PlaceOrder(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var errResp map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "Order total is too large", errResp["error"])
+}
+
+// TestServer_PlaceOrder_DuplicateProducts verifies a product listed more than
+// once is stored as a single item with the quantities added up
+func TestServer_PlaceOrder_DuplicateProducts(t *testing.T) {
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatus     int
+		expectedQuantities map[string]int
+	}{
+		{
+			name:               "Merged",
+			body:               `{"items":[{"productId":"PROD1","quantity":2},{"productId":"PROD2","quantity":1},{"productId":"PROD1","quantity":3}]}`,
+			expectedStatus:     http.StatusOK,
+			expectedQuantities: map[string]int{"PROD1": 5, "PROD2": 1},
+		},
+		{
+			name:           "MergedOverMaxQuantity",
+			body:           `{"items":[{"productId":"PROD1","quantity":60},{"productId":"PROD1","quantity":41}]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			s := NewServer(nil, db)
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(tt.body))
+			req.Header.Set("api_key", apiKey)
+			w := httptest.NewRecorder()
+
+			s.PlaceOrder(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var order Order
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&order))
+			returned := make(map[string]int)
+			for _, item := range *order.Items {
+				returned[*item.ProductId] = *item.Quantity
 			}
+			assert.Equal(t, tt.expectedQuantities, returned)
+
+			rows, err := db.Query("SELECT product_id, quantity FROM order_items WHERE order_id = ?", *order.Id)
+			require.NoError(t, err)
+			defer rows.Close()
+			stored := make(map[string]int)
+			for rows.Next() {
+				var productID string
+				var quantity int
+				require.NoError(t, rows.Scan(&productID, &quantity))
+				stored[productID] = quantity
+			}
+			require.NoError(t, rows.Err())
+			assert.Equal(t, tt.expectedQuantities, stored)
+		})
+	}
+}
+
+// TestServer_PlaceOrder_ClientOrderID verifies an order is stored under the
+// ID the client sent, which must be an unused UUID
+func TestServer_PlaceOrder_ClientOrderID(t *testing.T) {
+	const orderID = "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+		expectedError  string
+	}{
+		{name: "Valid", id: orderID, expectedStatus: http.StatusOK},
+		{name: "Duplicate", id: orderID, expectedStatus: http.StatusConflict, expectedError: "Order ID already used"},
+		{name: "Malformed", id: "order-42", expectedStatus: http.StatusBadRequest, expectedError: "Invalid order ID"},
+		{name: "NoHyphens", id: "3fa85f6457174562b3fc2c963f66afa6", expectedStatus: http.StatusBadRequest, expectedError: "Invalid order ID"},
+		{name: "Braced", id: "{" + orderID + "}", expectedStatus: http.StatusBadRequest, expectedError: "Invalid order ID"},
+	}
+
+	db := setupTestDB(t)
+	s := NewServer(nil, db)
+
+	// Subtests run in order and share the database, so Duplicate reuses the ID placed by Valid
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"id":"` + tt.id + `","items":[{"productId":"PROD1","quantity":1}]}`
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+			req.Header.Set("api_key", apiKey)
+			w := httptest.NewRecorder()
+
+			s.PlaceOrder(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+
+			if tt.expectedError != "" {
+				var errResp map[string]string
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+				assert.Contains(t, errResp["error"], tt.expectedError)
+				return
+			}
+			var order Order
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&order))
+			assert.Equal(t, tt.id, *order.Id)
+			stored, err := GetOrderByID(context.Background(), db, tt.id)
+			require.NoError(t, err)
+			assert.Equal(t, tt.id, *stored.Id)
 		})
 	}
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+// TestServer_PlaceOrder_MaxConcurrent verifies orders over the concurrency cap are rejected, not queued
+func TestServer_PlaceOrder_MaxConcurrent(t *testing.T) {
+	db := setupTestDB(t)
+	s := NewServer(nil, db, WithMaxConcurrentOrders(2))
+
+	placeOrder := func() *httptest.ResponseRecorder {
+		body := `{"items":[{"productId":"PROD1","quantity":1}]}`
+		req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+		req.Header.Set("api_key", apiKey)
+		w := httptest.NewRecorder()
+		s.PlaceOrder(w, req)
+		return w
+	}
+
+	// Saturate the semaphore as if two orders were in progress
+	s.orderSlots <- struct{}{}
+	s.orderSlots <- struct{}{}
+
+	w := placeOrder()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	var errResp map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "Too many orders in progress, please retry", errResp["error"])
+
+	// Once an order finishes, the next one goes through and releases its slot
+	<-s.orderSlots
+	w = placeOrder()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, s.orderSlots, 1)
 }
 
 func TestServer_ListProducts(t *testing.T) {
 	tests := []struct {
 		name           string
+		sort           *string
 		closeDB        bool
 		expectedCount  int
+		expectedNames  []string
 		expectedStatus int
 	}{
 		{
 			name:           "Success",
 			expectedCount:  3, // We seeded 3 products
+			expectedNames:  []string{"Coke", "Burger", "Fries"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Success_PriceAscending",
+			sort:           func() *string { s := "price"; return &s }(),
+			expectedCount:  3,
+			expectedNames:  []string{"Coke", "Fries", "Burger"},
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:           "Success_PriceDescending",
+			sort:           func() *string { s := "-price"; return &s }(),
+			expectedCount:  3,
+			expectedNames:  []string{"Burger", "Fries", "Coke"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "BadRequest_InvalidSort",
+			sort:           func() *string { s := "price; DROP TABLE products"; return &s }(),
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:           "InternalServerError_DBError",
 			closeDB:        true,
@@ -288,13 +706,12 @@ func TestServer_ListProducts(t *testing.T) {
 			if tt.closeDB {
 				db.Close()
 			}
-			server := NewServer(nil, db)
-			s := server.(*Server)
+			s := NewServer(nil, db)
 
 			req := httptest.NewRequest(http.MethodGet, "/products", nil)
 			w := httptest.NewRecorder()
 
-			s.ListProducts(w, req)
+			s.ListProducts(w, req, ListProductsParams{Sort: tt.sort})
 
 			resp := w.Result()
 			defer resp.Body.Close()
@@ -306,11 +723,141 @@ func TestServer_ListProducts(t *testing.T) {
 				err := json.NewDecoder(resp.Body).Decode(&products)
 				require.NoError(t, err)
 				assert.Len(t, products, tt.expectedCount)
+
+				names := make([]string, 0, len(products))
+				for _, p := range products {
+					names = append(names, *p.Name)
+				}
+				assert.Equal(t, tt.expectedNames, names)
+			}
+		})
+	}
+}
+
+func TestServer_ListProducts_Pagination(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedNames  []string
+		expectedStatus int
+	}{
+		{
+			name:           "DefaultsToTheFirst50",
+			expectedNames:  []string{"Coke", "Burger", "Fries"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "FirstPage",
+			query:          "?limit=2",
+			expectedNames:  []string{"Coke", "Burger"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "SecondPage",
+			query:          "?limit=2&offset=2",
+			expectedNames:  []string{"Fries"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "SecondPageSorted",
+			query:          "?sort=-price&limit=1&offset=1",
+			expectedNames:  []string{"Fries"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "BeyondTheEnd",
+			query:          "?limit=2&offset=3",
+			expectedNames:  []string{},
+			expectedStatus: http.StatusOK,
+		},
+		{name: "NegativeLimit", query: "?limit=-1", expectedStatus: http.StatusBadRequest},
+		{name: "ZeroLimit", query: "?limit=0", expectedStatus: http.StatusBadRequest},
+		{name: "LimitTooLarge", query: "?limit=201", expectedStatus: http.StatusBadRequest},
+		{name: "NonNumericLimit", query: "?limit=ten", expectedStatus: http.StatusBadRequest},
+		{name: "NegativeOffset", query: "?offset=-1", expectedStatus: http.StatusBadRequest},
+		{name: "NonNumericOffset", query: "?offset=first", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			req := httptest.NewRequest(http.MethodGet, "/product"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			NewServer(nil, db).Routes().ServeHTTP(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, "3", w.Header().Get("X-Total-Count"))
+			var products []Product
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&products))
+			names := make([]string, 0, len(products))
+			for _, p := range products {
+				names = append(names, *p.Name)
 			}
+			assert.Equal(t, tt.expectedNames, names)
 		})
 	}
 }
 
+func TestServer_GetMenu(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		db := setupTestDB(t)
+		_, err := db.Exec(`INSERT INTO products (id, name, price, category) VALUES
+			('PROD5', 'Sundae', 4.0, 'Dessert'),
+			('PROD6', 'Cheeseburger', 12.0, 'Main'),
+			('PROD7', 'Milkshake', 6.0, 'Drink')`)
+		require.NoError(t, err)
+		require.NoError(t, DeleteProduct(context.Background(), db, "PROD2", false))
+
+		w := httptest.NewRecorder()
+		NewServer(nil, db).GetMenu(w, httptest.NewRequest(http.MethodGet, "/menu", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var menu []MenuCategory
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&menu))
+
+		names := make(map[string][]string)
+		var categories []string
+		for _, group := range menu {
+			categories = append(categories, group.Category)
+			for _, p := range group.Products {
+				assert.Equal(t, group.Category, *p.Category)
+				names[group.Category] = append(names[group.Category], *p.Name)
+			}
+		}
+		// Side only held the deleted Fries, so it is left out
+		assert.Equal(t, []string{"Dessert", "Drink", "Main"}, categories)
+		assert.Equal(t, map[string][]string{
+			"Dessert": {"Sundae"},
+			"Drink":   {"Coke", "Milkshake"},
+			"Main":    {"Burger", "Cheeseburger"},
+		}, names)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		db := setupTestDB(t)
+		_, err := db.Exec(`DELETE FROM product_tiers; DELETE FROM products`)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		NewServer(nil, db).GetMenu(w, httptest.NewRequest(http.MethodGet, "/menu", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, "[]", w.Body.String())
+	})
+
+	t.Run("InternalServerError_DBError", func(t *testing.T) {
+		db := setupTestDB(t)
+		db.Close()
+
+		w := httptest.NewRecorder()
+		NewServer(nil, db).GetMenu(w, httptest.NewRequest(http.MethodGet, "/menu", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
 func TestServer_GetProduct(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -353,8 +900,7 @@ func TestServer_GetProduct(t *testing.T) {
 				require.NoError(t, err)
 			}
 
-			server := NewServer(nil, db)
-			s := server.(*Server)
+			s := NewServer(nil, db)
 
 			req := httptest.NewRequest(http.MethodGet, "/products/123", nil) // URL doesn't matter for direct method call
 			w := httptest.NewRecorder()
@@ -375,3 +921,1214 @@ func TestServer_GetProduct(t *testing.T) {
 		})
 	}
 }
+
+// TestServer_DeleteProduct covers hard and soft deletes through the router
+func TestServer_DeleteProduct(t *testing.T) {
+	tests := []struct {
+		name           string
+		apiKey         string
+		path           string
+		expectedStatus int
+		expectedError  string
+	}{
+		{name: "Unreferenced", apiKey: apiKey, path: "/product/2", expectedStatus: http.StatusNoContent},
+		{name: "Referenced", apiKey: apiKey, path: "/product/1", expectedStatus: http.StatusConflict, expectedError: "use soft=true"},
+		{name: "Referenced_Soft", apiKey: apiKey, path: "/product/1?soft=true", expectedStatus: http.StatusNoContent},
+		{name: "NotFound", apiKey: apiKey, path: "/product/999", expectedStatus: http.StatusNotFound, expectedError: "Product not found"},
+		{name: "Unauthorized", path: "/product/2", expectedStatus: http.StatusUnauthorized, expectedError: "Invalid or missing API key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			_, err := db.Exec("INSERT INTO products (id, name, price, category) VALUES ('1', 'Ordered', 10.0, 'Test'), ('2', 'Never Ordered', 5.0, 'Test')")
+			require.NoError(t, err)
+			_, err = CreateOrder(context.Background(), db, nil, nil, 1000, []OrderItem{{ProductID: "1", Quantity: 1}}, time.Now())
+			require.NoError(t, err)
+			ts := httptest.NewServer(NewServer(nil, db).Routes())
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+tt.path, nil)
+			require.NoError(t, err)
+			if tt.apiKey != "" {
+				req.Header.Set("api_key", tt.apiKey)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedError != "" {
+				var errResp map[string]string
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+				assert.Contains(t, errResp["error"], tt.expectedError)
+				return
+			}
+
+			// Deleted products leave the menu
+			resp, err = http.Get(ts.URL + strings.Split(tt.path, "?")[0])
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	}
+}
+
+func TestServer_UpdateProduct(t *testing.T) {
+	const readOnlyKey = "kiosk"
+	tests := []struct {
+		name             string
+		apiKey           string
+		path             string
+		body             string
+		allowed          []string
+		expectedStatus   int
+		expectedError    string
+		expectedCategory string
+	}{
+		{
+			name:             "Update",
+			apiKey:           apiKey,
+			path:             "/product/1",
+			body:             `{"name":"Big Burger","price":12.5,"category":"  main   COURSE "}`,
+			expectedStatus:   http.StatusOK,
+			expectedCategory: "Main Course",
+		},
+		{
+			name:             "AllowedCategory",
+			apiKey:           apiKey,
+			path:             "/product/1",
+			body:             `{"name":"Big Burger","price":12.5,"category":"burger"}`,
+			allowed:          []string{"Burger", "Drink"},
+			expectedStatus:   http.StatusOK,
+			expectedCategory: "Burger",
+		},
+		{
+			name:           "UnknownCategory",
+			apiKey:         apiKey,
+			path:           "/product/1",
+			body:           `{"name":"Big Burger","price":12.5,"category":"Burgers"}`,
+			allowed:        []string{"Burger", "Drink"},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid product category, must be one of Burger, Drink",
+		},
+		{
+			name:           "BlankCategory",
+			apiKey:         apiKey,
+			path:           "/product/1",
+			body:           `{"name":"Big Burger","price":12.5,"category":"  "}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Product category must not be empty",
+		},
+		{
+			name:           "EmptyName",
+			apiKey:         apiKey,
+			path:           "/product/1",
+			body:           `{"name":" ","price":12.5,"category":"Burger"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Product name must not be empty",
+		},
+		{
+			name:           "NegativePrice",
+			apiKey:         apiKey,
+			path:           "/product/1",
+			body:           `{"name":"Big Burger","price":-1,"category":"Burger"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Product price must not be negative",
+		},
+		{
+			name:           "InvalidBody",
+			apiKey:         apiKey,
+			path:           "/product/1",
+			body:           `{"name":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid request body",
+		},
+		{
+			name:           "NotFound",
+			apiKey:         apiKey,
+			path:           "/product/999",
+			body:           `{"name":"Big Burger","price":12.5,"category":"Burger"}`,
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "Product not found",
+		},
+		{
+			name:           "ReadOnlyKey",
+			apiKey:         readOnlyKey,
+			path:           "/product/1",
+			body:           `{"name":"Big Burger","price":12.5,"category":"Burger"}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "API key is read-only",
+		},
+		{
+			name:           "Unauthorized",
+			path:           "/product/1",
+			body:           `{"name":"Big Burger","price":12.5,"category":"Burger"}`,
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "Invalid or missing API key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			_, err := db.Exec("INSERT INTO products (id, name, price, category) VALUES ('1', 'Burger', 10.0, 'Burger')")
+			require.NoError(t, err)
+			s := NewServer(nil, db,
+				WithAPIKeys(map[string]Scope{apiKey: ScopeReadWrite, readOnlyKey: ScopeRead}),
+				WithAllowedCategories(tt.allowed))
+			ts := httptest.NewServer(s.Routes())
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPut, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.apiKey != "" {
+				req.Header.Set("api_key", tt.apiKey)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedError != "" {
+				var errResp map[string]string
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+				assert.Equal(t, tt.expectedError, errResp["error"])
+
+				stored, err := GetProductByID(context.Background(), db, "1")
+				require.NoError(t, err)
+				assert.Equal(t, "Burger", *stored.Name, "a rejected update must leave the product unchanged")
+				return
+			}
+
+			var product Product
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&product))
+			assert.Equal(t, "Big Burger", *product.Name)
+			assert.Equal(t, Money(1250), *product.Price)
+			assert.Equal(t, tt.expectedCategory, *product.Category)
+
+			history, err := GetPriceHistory(context.Background(), db, "1")
+			require.NoError(t, err)
+			require.Len(t, history, 1)
+			assert.Equal(t, Money(1250), *history[0].Price)
+		})
+	}
+}
+
+// TestServer_UpdateProduct_SoftDeleted verifies a soft-deleted product is not
+// found and is left unchanged, without a price history entry
+func TestServer_UpdateProduct_SoftDeleted(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.Exec("INSERT INTO products (id, name, price, category, deleted_at) VALUES ('1', 'Burger', 10.0, 'Burger', CURRENT_TIMESTAMP)")
+	require.NoError(t, err)
+	s := NewServer(nil, db, WithAPIKeys(map[string]Scope{apiKey: ScopeReadWrite}))
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/product/1", strings.NewReader(`{"name":"Big Burger","price":12.5,"category":"Drink"}`))
+	require.NoError(t, err)
+	req.Header.Set("api_key", apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var name, category string
+	var price Money
+	require.NoError(t, db.QueryRow("SELECT name, price, category FROM products WHERE id = '1'").Scan(&name, &price, &category))
+	assert.Equal(t, "Burger", name)
+	assert.Equal(t, Money(1000), price)
+	assert.Equal(t, "Burger", category)
+
+	history, err := GetPriceHistory(context.Background(), db, "1")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestServer_GetProductPriceHistory(t *testing.T) {
+	tests := []struct {
+		name           string
+		productID      int64
+		priceChanges   []Money
+		closeDB        bool
+		expectedStatus int
+		expectedPrices []Money
+	}{
+		{
+			name:           "WithHistory",
+			productID:      42,
+			priceChanges:   []Money{1150, 1250},
+			expectedStatus: http.StatusOK,
+			expectedPrices: []Money{1150, 1250},
+		},
+		{
+			name:           "WithoutHistory",
+			productID:      42,
+			expectedStatus: http.StatusOK,
+			expectedPrices: []Money{},
+		},
+		{
+			name:           "NotFound",
+			productID:      999,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "InternalServerError_DBError",
+			productID:      42,
+			closeDB:        true,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			_, err := db.Exec("INSERT INTO products (id, name, price, category) VALUES ('42', 'Numeric Product', 10.0, 'Test')")
+			require.NoError(t, err)
+			for _, price := range tt.priceChanges {
+				require.NoError(t, UpdateProduct(context.Background(), db, "42", "Numeric Product", price, "Test", nil))
+			}
+			if tt.closeDB {
+				db.Close()
+			}
+
+			s := NewServer(nil, db)
+
+			req := httptest.NewRequest(http.MethodGet, "/product/42/price-history", nil)
+			w := httptest.NewRecorder()
+
+			s.GetProductPriceHistory(w, req, tt.productID)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedStatus == http.StatusOK {
+				var history []PriceChange
+				err := json.NewDecoder(resp.Body).Decode(&history)
+				require.NoError(t, err)
+				require.NotNil(t, history, "History should be an empty array, not null")
+
+				prices := make([]Money, 0, len(history))
+				for _, change := range history {
+					assert.NotNil(t, change.ChangedAt)
+					prices = append(prices, *change.Price)
+				}
+				assert.Equal(t, tt.expectedPrices, prices)
+			}
+		})
+	}
+}
+
+func TestServer_ListRelatedProducts(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+
+	tests := []struct {
+		name           string
+		productID      int64
+		limit          *int
+		closeDB        bool
+		expectedStatus int
+		expectedNames  []string
+	}{
+		{
+			name:           "SameCategory",
+			productID:      40,
+			expectedStatus: http.StatusOK,
+			expectedNames:  []string{"Brownie", "Cheesecake", "Ice Cream"},
+		},
+		{
+			name:           "Limited",
+			productID:      40,
+			limit:          intPtr(2),
+			expectedStatus: http.StatusOK,
+			expectedNames:  []string{"Brownie", "Cheesecake"},
+		},
+		{
+			name:           "NoneRelated",
+			productID:      44,
+			expectedStatus: http.StatusOK,
+			expectedNames:  []string{},
+		},
+		{
+			name:           "NotFound",
+			productID:      999,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "InvalidLimit",
+			productID:      40,
+			limit:          intPtr(0),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "LimitTooLarge",
+			productID:      40,
+			limit:          intPtr(51),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "InternalServerError_DBError",
+			productID:      40,
+			closeDB:        true,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			_, err := db.Exec(`INSERT INTO products (id, name, price, category) VALUES
+			('40', 'Apple Pie', 6.99, 'Dessert'),
+			('41', 'Ice Cream', 5.99, 'Dessert'),
+			('42', 'Brownie', 6.49, 'Dessert'),
+			('43', 'Cheesecake', 7.99, 'Dessert'),
+			('44', 'Soup', 4.5, 'Starter')`)
+			require.NoError(t, err)
+			if tt.closeDB {
+				db.Close()
+			}
+
+			s := NewServer(nil, db)
+
+			req := httptest.NewRequest(http.MethodGet, "/product/40/related", nil)
+			w := httptest.NewRecorder()
+
+			s.ListRelatedProducts(w, req, tt.productID, ListRelatedProductsParams{Limit: tt.limit})
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedStatus == http.StatusOK {
+				var products []Product
+				err := json.NewDecoder(resp.Body).Decode(&products)
+				require.NoError(t, err)
+				require.NotNil(t, products, "Related products should be an empty array, not null")
+
+				names := make([]string, 0, len(products))
+				for _, p := range products {
+					assert.Equal(t, "Dessert", *p.Category)
+					assert.NotEqual(t, "40", *p.Id, "The queried product should be excluded")
+					names = append(names, *p.Name)
+				}
+				assert.Equal(t, tt.expectedNames, names)
+			}
+		})
+	}
+}
+
+func TestServer_ExportOrders(t *testing.T) {
+	header := "order_id,created_at,coupon_code,total,item_count"
+	order1 := "ORDER1,2025-01-10T09:30:00Z,SAVE10,26.00,2"
+	order2 := "ORDER2,2025-02-05T18:00:00Z,,2.50,1"
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		query          string
+		closeDB        bool
+		expectedStatus int
+		expectedError  string
+		expectedLines  []string
+	}{
+		{
+			name:           "AllOrders",
+			apiKey:         apiKey,
+			expectedStatus: http.StatusOK,
+			expectedLines:  []string{header, order1, order2},
+		},
+		{
+			name:           "DateRange",
+			apiKey:         apiKey,
+			query:          "?from=2025-01-01&to=2025-01-10",
+			expectedStatus: http.StatusOK,
+			expectedLines:  []string{header, order1},
+		},
+		{
+			name:           "FromOnly",
+			apiKey:         apiKey,
+			query:          "?from=2025-02-01",
+			expectedStatus: http.StatusOK,
+			expectedLines:  []string{header, order2},
+		},
+		{
+			name:           "NoMatches",
+			apiKey:         apiKey,
+			query:          "?from=2026-01-01",
+			expectedStatus: http.StatusOK,
+			expectedLines:  []string{header},
+		},
+		{
+			name:           "Unauthorized",
+			apiKey:         "",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "Invalid or missing API key",
+		},
+		{
+			name:           "InvalidDate",
+			apiKey:         apiKey,
+			query:          "?from=10-01-2025",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid from date, must be YYYY-MM-DD",
+		},
+		{
+			name:           "FromAfterTo",
+			apiKey:         apiKey,
+			query:          "?from=2025-02-01&to=2025-01-01",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid date range, from must not be after to",
+		},
+		{
+			name:           "DBError",
+			apiKey:         apiKey,
+			closeDB:        true,
+			expectedStatus: http.StatusInternalServerError,
+			expectedError:  "Failed to export orders",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			_, err := db.Exec(`
+			INSERT INTO orders (id, created_at, coupon_code, total) VALUES
+			('ORDER1', '2025-01-10 09:30:00', 'SAVE10', 26.0),
+			('ORDER2', '2025-02-05 18:00:00', NULL, 2.5);
+			INSERT INTO order_items (order_id, product_id, quantity) VALUES
+			('ORDER1', 'PROD1', 2),
+			('ORDER1', 'PROD2', 1),
+			('ORDER2', 'PROD3', 1);
+			`)
+			require.NoError(t, err)
+			if tt.closeDB {
+				db.Close()
+			}
+
+			ts := httptest.NewServer(NewServer(nil, db).Routes())
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/orders/export.csv"+tt.query, nil)
+			require.NoError(t, err)
+			if tt.apiKey != "" {
+				req.Header.Set("api_key", tt.apiKey)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedError != "" {
+				var errResp map[string]string
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+				assert.Equal(t, tt.expectedError, errResp["error"])
+				return
+			}
+
+			assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedLines, strings.Split(strings.TrimSpace(string(body)), "\n"))
+		})
+	}
+}
+
+func TestServer_ExportOrders_Range(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.Exec(`
+	INSERT INTO orders (id, created_at, coupon_code, total) VALUES
+	('ORDER1', '2025-01-10 09:30:00', 'SAVE10', 26.0),
+	('ORDER2', '2025-02-05 18:00:00', NULL, 2.5);
+	INSERT INTO order_items (order_id, product_id, quantity) VALUES
+	('ORDER1', 'PROD1', 2),
+	('ORDER2', 'PROD3', 1);
+	`)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(NewServer(nil, db).Routes())
+	defer ts.Close()
+
+	get := func(headers map[string]string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/orders/export.csv", nil)
+		require.NoError(t, err)
+		req.Header.Set("api_key", apiKey)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, body
+	}
+
+	resp, full := get(nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		expectedStatus int
+		expectedRange  string
+		expectedBody   []byte
+	}{
+		{
+			name:           "Range",
+			headers:        map[string]string{"Range": "bytes=10-29"},
+			expectedStatus: http.StatusPartialContent,
+			expectedRange:  fmt.Sprintf("bytes 10-29/%d", len(full)),
+			expectedBody:   full[10:30],
+		},
+		{
+			name:           "Resume",
+			headers:        map[string]string{"Range": "bytes=40-", "If-Range": etag},
+			expectedStatus: http.StatusPartialContent,
+			expectedRange:  fmt.Sprintf("bytes 40-%d/%d", len(full)-1, len(full)),
+			expectedBody:   full[40:],
+		},
+		{
+			// The export changed since, so the whole export is sent again
+			name:           "StaleIfRange",
+			headers:        map[string]string{"Range": "bytes=40-", "If-Range": `"stale"`},
+			expectedStatus: http.StatusOK,
+			expectedBody:   full,
+		},
+		{
+			name:           "Unsatisfiable",
+			headers:        map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(full)+10)},
+			expectedStatus: http.StatusRequestedRangeNotSatisfiable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := get(tt.headers)
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedBody == nil {
+				return
+			}
+			assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+			assert.Equal(t, tt.expectedRange, resp.Header.Get("Content-Range"))
+			assert.Equal(t, tt.expectedBody, body)
+		})
+	}
+}
+
+func TestServer_ListCustomerOrders(t *testing.T) {
+	db := setupTestDB(t)
+	ts := httptest.NewServer(NewServer(nil, db).Routes())
+	defer ts.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("api_key", apiKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Two orders for the customer, and a guest order
+	var placed []string
+	for _, body := range []string{
+		`{"customerId":"cust_42","items":[{"productId":"PROD1","quantity":1}]}`,
+		`{"customerId":"cust_42","items":[{"productId":"PROD2","quantity":2}]}`,
+		`{"items":[{"productId":"PROD1","quantity":1}]}`,
+	} {
+		resp := do(http.MethodPost, "/order", body)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var order Order
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&order))
+		resp.Body.Close()
+		placed = append(placed, *order.Id)
+	}
+
+	t.Run("CustomerOrders", func(t *testing.T) {
+		resp := do(http.MethodGet, "/customers/cust_42/orders", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var orders []Order
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&orders))
+		ids := make([]string, len(orders))
+		for i, order := range orders {
+			ids[i] = *order.Id
+			assert.Equal(t, "cust_42", *order.CustomerId)
+		}
+		assert.ElementsMatch(t, placed[:2], ids)
+	})
+
+	t.Run("NoOrders", func(t *testing.T) {
+		resp := do(http.MethodGet, "/customers/nobody/orders", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, "[]", string(body))
+	})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "InvalidCustomerID",
+			method:         http.MethodGet,
+			path:           "/customers/bad%20id/orders",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid customer ID, must be up to 64 letters, digits, - or _",
+		},
+		{
+			name:           "OrderWithInvalidCustomerID",
+			method:         http.MethodPost,
+			path:           "/order",
+			body:           `{"customerId":"bad id","items":[{"productId":"PROD1","quantity":1}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid customer ID, must be up to 64 letters, digits, - or _",
+		},
+		{
+			name:           "OrderWithEmptyCustomerID",
+			method:         http.MethodPost,
+			path:           "/order",
+			body:           `{"customerId":"","items":[{"productId":"PROD1","quantity":1}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid customer ID, must be up to 64 letters, digits, - or _",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(tt.method, tt.path, tt.body)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			var errResp map[string]string
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+			assert.Equal(t, tt.expectedError, errResp["error"])
+		})
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/customers/cust_42/orders")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestServer_GetOrder(t *testing.T) {
+	coupon := "HAPPYHRS"
+	items := []OrderItem{{ProductID: "PROD1", Quantity: 2}, {ProductID: "PROD2", Quantity: 1}}
+
+	tests := []struct {
+		name           string
+		orderID        string
+		apiKey         string
+		closeDB        bool
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Success",
+			apiKey:         apiKey,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "NotFound",
+			orderID:        "no-such-order",
+			apiKey:         apiKey,
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "Order not found",
+		},
+		{
+			name:           "Unauthorized",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "InternalServerError_DBError",
+			orderID:        "no-such-order",
+			apiKey:         apiKey,
+			closeDB:        true,
+			expectedStatus: http.StatusInternalServerError,
+			expectedError:  "Failed to fetch order",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			orderID, err := CreateOrder(context.Background(), db, &coupon, nil, 2600, items, time.Now())
+			require.NoError(t, err)
+			if tt.orderID != "" {
+				orderID = tt.orderID
+			}
+			if tt.closeDB {
+				db.Close()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/orders/"+orderID, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("api_key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+
+			NewServer(nil, db).Routes().ServeHTTP(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedStatus != http.StatusOK {
+				if tt.expectedError != "" {
+					var errResp map[string]string
+					require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+					assert.Equal(t, tt.expectedError, errResp["error"])
+				}
+				return
+			}
+
+			var order Order
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&order))
+			assert.Equal(t, orderID, *order.Id)
+			assert.Equal(t, coupon, *order.CouponCode)
+			assert.Equal(t, Money(2600), *order.Total)
+			require.Len(t, *order.Items, 2)
+			require.Len(t, *order.Products, 2)
+		})
+	}
+}
+
+// TestServer_OrderItemsByCategory verifies a fetched order lists its items by category, then name
+func TestServer_GetCouponUsage(t *testing.T) {
+	items := []OrderItem{{ProductID: "PROD1", Quantity: 1}}
+
+	tests := []struct {
+		name           string
+		code           string
+		apiKey         string
+		closeDB        bool
+		expectedStatus int
+		expectedOrders int
+		expectedError  string
+	}{
+		{
+			name:           "Used",
+			code:           "HAPPYHRS",
+			apiKey:         apiKey,
+			expectedStatus: http.StatusOK,
+			expectedOrders: 3,
+		},
+		{
+			name:           "LowerCase",
+			code:           "happyhrs",
+			apiKey:         apiKey,
+			expectedStatus: http.StatusOK,
+			expectedOrders: 3,
+		},
+		{
+			name:           "NeverUsed",
+			code:           "FIFTYOFF",
+			apiKey:         apiKey,
+			expectedStatus: http.StatusOK,
+			expectedOrders: 0,
+		},
+		{
+			name:           "UnknownCode",
+			code:           "NOTACODE",
+			apiKey:         apiKey,
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "Coupon not found",
+		},
+		{
+			name:           "Unauthorized",
+			code:           "HAPPYHRS",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "InternalServerError_DBError",
+			code:           "HAPPYHRS",
+			apiKey:         apiKey,
+			closeDB:        true,
+			expectedStatus: http.StatusInternalServerError,
+			expectedError:  "Failed to count coupon usage",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			happy, other := "HAPPYHRS", "SUPER100"
+			for _, coupon := range []*string{&happy, &happy, &other, nil, &happy} {
+				_, err := CreateOrder(context.Background(), db, coupon, nil, 1050, items, time.Now())
+				require.NoError(t, err)
+			}
+			if tt.closeDB {
+				db.Close()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/coupons/"+tt.code+"/usage", nil)
+			if tt.apiKey != "" {
+				req.Header.Set("api_key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+
+			NewServer([]string{"HAPPYHRS", "FIFTYOFF", "SUPER100"}, db).Routes().ServeHTTP(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedStatus != http.StatusOK {
+				if tt.expectedError != "" {
+					var body map[string]string
+					require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+					assert.Equal(t, tt.expectedError, body["error"])
+				}
+				return
+			}
+
+			var usage CouponUsage
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&usage))
+			assert.Equal(t, NormalizeCoupon(tt.code), usage.Code)
+			assert.Equal(t, tt.expectedOrders, usage.Orders)
+		})
+	}
+}
+
+func TestServer_OrderItemsByCategory(t *testing.T) {
+	db := setupTestDB(t)
+	ts := httptest.NewServer(NewServer(nil, db).Routes())
+	defer ts.Close()
+
+	// Side, Drink, Main
+	body := `{"customerId":"cust_1","items":[{"productId":"PROD2","quantity":1},{"productId":"PROD3","quantity":1},{"productId":"PROD1","quantity":1}]}`
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/order", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("api_key", apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/customers/cust_1/orders", nil)
+	require.NoError(t, err)
+	req.Header.Set("api_key", apiKey)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var orders []Order
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&orders))
+	require.Len(t, orders, 1)
+
+	var categories []string
+	for _, p := range *orders[0].Products {
+		categories = append(categories, *p.Category)
+	}
+	assert.Equal(t, []string{"Drink", "Main", "Side"}, categories)
+	assert.Equal(t, "PROD3", *(*orders[0].Items)[0].ProductId)
+}
+
+// TestServer_ProductPriceJSON verifies prices are serialised exactly as stored, without float32 rounding
+func TestServer_ProductPriceJSON(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.Exec("INSERT INTO products (id, name, price, category) VALUES ('7', 'Veggie Burger', 8.49, 'Burger')")
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(NewServer(nil, db).Routes())
+	defer srv.Close()
+
+	for _, path := range []string{"/product", "/product/7"} {
+		t.Run(path, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + path)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Contains(t, string(body), `"price":8.49`)
+			assert.NotContains(t, string(body), "8.4899")
+		})
+	}
+}
+
+func TestServer_Routes(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		apiKey         string
+		expectedStatus int
+		expectedBody   map[string]string
+	}{
+		{
+			name:           "ListProducts",
+			method:         http.MethodGet,
+			path:           "/product",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "GetProduct",
+			method:         http.MethodGet,
+			path:           "/product/7",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "GetProduct_NotFound",
+			method:         http.MethodGet,
+			path:           "/product/999",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "GetProduct_InvalidID",
+			method:         http.MethodGet,
+			path:           "/product/abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "GetProductPriceHistory",
+			method:         http.MethodGet,
+			path:           "/product/7/price-history",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "PlaceOrder",
+			method:         http.MethodPost,
+			path:           "/order",
+			body:           `{"items":[{"productId":"7","quantity":1}]}`,
+			apiKey:         apiKey,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "MethodNotAllowed",
+			method:         http.MethodDelete,
+			path:           "/product",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "UnknownRoute",
+			method:         http.MethodGet,
+			path:           "/unknown",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"error": "not found", "code": "not_found"},
+		},
+		{
+			name:           "UnknownSubRoute",
+			method:         http.MethodGet,
+			path:           "/product/7/unknown",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"error": "not found", "code": "not_found"},
+		},
+	}
+
+	db := setupTestDB(t)
+	_, err := db.Exec("INSERT INTO products (id, name, price, category) VALUES ('7', 'Numeric Product', 10.0, 'Test')")
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(NewServer(nil, db).Routes())
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			if tt.apiKey != "" {
+				req.Header.Set("api_key", tt.apiKey)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedBody != nil {
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+				var body map[string]string
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tt.expectedBody, body)
+			}
+		})
+	}
+}
+
+// TestServer_APIKeyScopes verifies read-only keys can browse the menu but not place orders
+func TestServer_APIKeyScopes(t *testing.T) {
+	db := setupTestDB(t)
+	s := NewServer(nil, db, WithAPIKeys(map[string]Scope{
+		"kiosk":   ScopeRead,
+		"partner": ScopeReadWrite,
+	}))
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+
+	orderBody := `{"items":[{"productId":"PROD1","quantity":1}]}`
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		apiKey         string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "ReadOnlyKeyListsProducts",
+			method:         http.MethodGet,
+			path:           "/product",
+			apiKey:         "kiosk",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "ReadOnlyKeyExportsOrders",
+			method:         http.MethodGet,
+			path:           "/orders/export.csv",
+			apiKey:         "kiosk",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "ReadOnlyKeyPlacesOrder",
+			method:         http.MethodPost,
+			path:           "/order",
+			apiKey:         "kiosk",
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "API key is read-only",
+		},
+		{
+			name:           "ReadWriteKeyPlacesOrder",
+			method:         http.MethodPost,
+			path:           "/order",
+			apiKey:         "partner",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "DefaultKeyReplaced",
+			method:         http.MethodPost,
+			path:           "/order",
+			apiKey:         apiKey,
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "Invalid or missing API key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.method == http.MethodPost {
+				body = strings.NewReader(orderBody)
+			}
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, body)
+			require.NoError(t, err)
+			req.Header.Set("api_key", tt.apiKey)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedError != "" {
+				var errResp map[string]string
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+				assert.Equal(t, tt.expectedError, errResp["error"])
+			}
+		})
+	}
+}
+
+// TestServer_PartnerKeys verifies every partner's key is accepted on its own,
+// while an unknown key still gets a 401
+func TestServer_PartnerKeys(t *testing.T) {
+	s := NewServer(nil, setupTestDB(t),
+		WithAPIKeys(map[string]Scope{
+			"key-acme":   ScopeReadWrite,
+			"key-globex": ScopeReadWrite,
+		}),
+		WithPartners(map[string]string{
+			"key-acme":   "Acme",
+			"key-globex": "Globex",
+		}))
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		expectedStatus int
+	}{
+		{name: "FirstPartner", apiKey: "key-acme", expectedStatus: http.StatusOK},
+		{name: "SecondPartner", apiKey: "key-globex", expectedStatus: http.StatusOK},
+		{name: "UnknownKey", apiKey: "key-initech", expectedStatus: http.StatusUnauthorized},
+		{name: "MissingKey", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/order", strings.NewReader(`{"items":[{"productId":"PROD1","quantity":1}]}`))
+			require.NoError(t, err)
+			req.Header.Set("api_key", tt.apiKey)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+// TestServer_PlaceOrder_ExpectedTotal verifies an order is only placed when the
+// client's expected total matches the computed one to within a cent
+func TestServer_PlaceOrder_ExpectedTotal(t *testing.T) {
+	tests := []struct {
+		name           string
+		expectedTotal  Money
+		expectedStatus int
+	}{
+		{name: "Match", expectedTotal: 2600, expectedStatus: http.StatusOK},
+		{name: "WithinACent", expectedTotal: 2599, expectedStatus: http.StatusOK},
+		{name: "StaleMenu", expectedTotal: 2400, expectedStatus: http.StatusConflict},
+		{name: "JustOverACent", expectedTotal: 2602, expectedStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			s := NewServer(nil, db)
+
+			// 2 x PROD1 at 10.00 and 1 x PROD2 at 6.00 come to 26.00
+			body := fmt.Sprintf(`{"items":[{"productId":"PROD1","quantity":2},{"productId":"PROD2","quantity":1}],"expectedTotal":%s}`, tt.expectedTotal)
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+			req.Header.Set("api_key", apiKey)
+			w := httptest.NewRecorder()
+
+			s.PlaceOrder(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+
+			var orders int
+			require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&orders))
+
+			if tt.expectedStatus == http.StatusConflict {
+				var mismatch PriceMismatch
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&mismatch))
+				assert.Equal(t, "price mismatch", mismatch.Error)
+				assert.Equal(t, tt.expectedTotal, mismatch.ExpectedTotal)
+				assert.Equal(t, Money(2600), mismatch.Total)
+				assert.Zero(t, orders, "No order should be placed on a mismatch")
+				return
+			}
+
+			var order Order
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&order))
+			require.NotNil(t, order.Total)
+			assert.Equal(t, Money(2600), *order.Total)
+			assert.Equal(t, 1, orders)
+		})
+	}
+}
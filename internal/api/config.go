@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// API keys shorter than this are redacted entirely by GetConfig, as their
+// last characters would give away too much of them
+const minPartlyRedactedKeyLength = 12
+
+// WithDeploymentInfo records the listen address, database path and promo
+// codes file the server was started with, which it can't know otherwise.
+// They are only reported by GetConfig.
+func WithDeploymentInfo(addr, dbPath, promoCodesFile string) Option {
+	return func(s *Server) {
+		s.addr = addr
+		s.dbPath = dbPath
+		s.promoCodesFile = promoCodesFile
+	}
+}
+
+// GetConfig returns the configuration the server is running with, so a
+// deployment can be checked without reading its flags. API keys are redacted
+// with redactAPIKey.
+func (s *Server) GetConfig(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAPIKey(w, r) {
+		return
+	}
+
+	var duplicateWindow time.Duration
+	if s.duplicates != nil {
+		duplicateWindow = s.duplicates.window
+	}
+
+	keys := make([]string, 0, len(s.apiKeys))
+	for key := range s.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	apiKeys := make([]ApiKeyConfig, len(keys))
+	for i, key := range keys {
+		apiKeys[i] = ApiKeyConfig{Key: redactAPIKey(key), Scope: ApiKeyConfigScope(s.apiKeys[key])}
+		if partner, ok := s.partners[key]; ok {
+			apiKeys[i].Partner = &partner
+		}
+	}
+
+	// Reported as an empty list rather than null when any category is allowed
+	allowedCategories := []string{}
+	for _, category := range s.allowedCategories {
+		allowedCategories = append(allowedCategories, normalizeCategory(category))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ServerConfig{
+		Addr:                 s.addr,
+		DbPath:               s.dbPath,
+		PromoCodesFile:       s.promoCodesFile,
+		PromoCodesCompressed: strings.HasSuffix(s.promoCodesFile, ".gz"),
+		PromoCodes:           len(s.promoCodes),
+		CouponExpiries:       len(s.couponExpiry),
+		CouponTimezone:       s.couponZone.String(),
+		Discounts:            len(s.discounts),
+		Timeout:              s.timeout.String(),
+		RateLimit:            s.rateLimit,
+		RateBurst:            s.rateBurst,
+		KeyRateLimit:         s.keyRateLimit,
+		KeyRateBurst:         s.keyRateBurst,
+		MaxConcurrentOrders:  cap(s.orderSlots),
+		MaxItemQuantity:      s.maxItemQuantity,
+		MaxQueryLength:       s.maxQueryLength,
+		DuplicateWindow:      duplicateWindow.String(),
+		TaxPercent:           s.taxPercent,
+		DbCheckInterval:      s.dbCheckInterval.String(),
+		RequestLog:           s.requestLog != nil,
+		AllowedCategories:    allowedCategories,
+		ApiKeys:              apiKeys,
+	})
+}
+
+// redactAPIKey hides an API key, keeping the last 4 characters of keys long
+// enough that they still tell keys apart without weakening them
+func redactAPIKey(key string) string {
+	if len(key) < minPartlyRedactedKeyLength {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
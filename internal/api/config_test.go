@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_GetConfig(t *testing.T) {
+	const (
+		partnerKey = "k3y-acme-5f9e2c"
+		kioskKey   = "kiosk"
+	)
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	require.NoError(t, err)
+
+	s := NewServer([]string{"SAVE10", "WELCOME"}, setupTestDB(t),
+		WithDeploymentInfo(":9090", "/data/orders.db", "/data/valid_codes.txt.gz"),
+		WithTimeout(5*time.Second),
+		WithRateLimit(10, 30),
+		WithMaxConcurrentOrders(4),
+		WithMaxItemQuantity(50),
+		WithDuplicateWindow(10*time.Second),
+		WithCouponExpiry(map[string]time.Time{"SAVE10": time.Date(2025, 8, 31, 0, 0, 0, 0, time.UTC)}, sydney),
+		WithDiscounts(map[string]Discount{"SAVE10": {Percent: 10}}),
+		WithTaxRate(10),
+		WithAllowedCategories([]string{"waffle", " Burger"}),
+		WithAPIKeys(map[string]Scope{partnerKey: ScopeReadWrite, kioskKey: ScopeRead}),
+		WithPartners(map[string]string{partnerKey: "Acme"}))
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		expectedStatus int
+	}{
+		{name: "ReadWriteKey", apiKey: partnerKey, expectedStatus: http.StatusOK},
+		{name: "ReadOnlyKey", apiKey: kioskKey, expectedStatus: http.StatusOK},
+		{name: "UnknownKey", apiKey: "nope", expectedStatus: http.StatusUnauthorized},
+		{name: "MissingKey", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/admin/config", nil)
+			require.NoError(t, err)
+			req.Header.Set("api_key", tt.apiKey)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.NotContains(t, string(body), partnerKey)
+			assert.NotContains(t, string(body), kioskKey)
+
+			var config ServerConfig
+			require.NoError(t, json.Unmarshal(body, &config))
+			acme := "Acme"
+			assert.Equal(t, ServerConfig{
+				Addr:                 ":9090",
+				DbPath:               "/data/orders.db",
+				PromoCodesFile:       "/data/valid_codes.txt.gz",
+				PromoCodesCompressed: true,
+				PromoCodes:           2,
+				CouponExpiries:       1,
+				CouponTimezone:       "Australia/Sydney",
+				Discounts:            1,
+				Timeout:              "5s",
+				RateLimit:            10,
+				RateBurst:            30,
+				MaxConcurrentOrders:  4,
+				MaxItemQuantity:      50,
+				MaxQueryLength:       defaultMaxQueryLength,
+				DuplicateWindow:      "10s",
+				TaxPercent:           10,
+				DbCheckInterval:      "0s",
+				AllowedCategories:    []string{"Waffle", "Burger"},
+				ApiKeys: []ApiKeyConfig{
+					{Key: "****9e2c", Scope: ReadWrite, Partner: &acme},
+					{Key: "****", Scope: Read},
+				},
+			}, config)
+		})
+	}
+}
+
+func TestRedactAPIKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{name: "Empty", key: "", expected: "****"},
+		{name: "Short", key: "oolio", expected: "****"},
+		{name: "JustTooShort", key: "abcdefghijk", expected: "****"},
+		{name: "Long", key: "abcdefghijkl", expected: "****ijkl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, redactAPIKey(tt.key))
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// Size of the random salt used when none is configured with WithCouponLog
+const couponSaltSize = 32
+
+// WithCouponLog sets the logger that records rejected coupon codes and the
+// salt their hashes are keyed with. Codes are never logged in the clear, so
+// the log can't leak valid ones; a fixed salt keeps hashes comparable across
+// restarts. By default rejections go to slog.Default() with a random salt.
+func WithCouponLog(logger *slog.Logger, salt []byte) Option {
+	return func(s *Server) {
+		if logger != nil {
+			s.couponLog = logger
+		}
+		if len(salt) > 0 {
+			s.couponSalt = salt
+		}
+	}
+}
+
+// newCouponSalt returns a random salt for hashing rejected coupon codes
+func newCouponSalt() []byte {
+	salt := make([]byte, couponSaltSize)
+	rand.Read(salt)
+	return salt
+}
+
+// hashCoupon returns the hex HMAC-SHA256 of code keyed with salt
+func hashCoupon(salt []byte, code string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// logCouponRejection records a rejected coupon code with the client that sent
+// it, so repeated guessing from one client stands out. The entry is
+// timestamped by the server clock.
+func (s *Server) logCouponRejection(r *http.Request, code string) {
+	ctx := r.Context()
+	if !s.couponLog.Enabled(ctx, slog.LevelWarn) {
+		return
+	}
+	record := slog.NewRecord(s.now(), slog.LevelWarn, "coupon rejected", 0)
+	record.AddAttrs(
+		slog.String("codeHash", hashCoupon(s.couponSalt, code)),
+		slog.String("clientIP", clientIP(r)),
+	)
+	s.couponLog.Handler().Handle(ctx, record)
+}
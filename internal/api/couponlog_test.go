@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServer_PlaceOrder_CouponRejectionLog verifies rejected coupons are logged
+// hashed with the client IP and time, and accepted ones aren't logged
+func TestServer_PlaceOrder_CouponRejectionLog(t *testing.T) {
+	db := setupTestDB(t)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	salt := []byte("test-salt")
+	rejectedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := NewServer([]string{"SAVE10"}, db,
+		WithCouponLog(logger, salt),
+		WithClock(func() time.Time { return rejectedAt }),
+	)
+
+	placeOrder := func(coupon string) int {
+		body := `{"items":[{"productId":"PROD1","quantity":1}],"couponCode":"` + coupon + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+		req.Header.Set("api_key", apiKey)
+		req.RemoteAddr = "203.0.113.7:51234"
+		w := httptest.NewRecorder()
+		s.PlaceOrder(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, placeOrder("SAVE10"))
+	assert.Empty(t, buf.String(), "Accepted coupons should not be logged")
+
+	require.Equal(t, http.StatusUnprocessableEntity, placeOrder("GUESSME1"))
+	assert.NotContains(t, buf.String(), "GUESSME1", "The raw code must not be logged")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "coupon rejected", entry["msg"])
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, rejectedAt.Format(time.RFC3339), entry["time"])
+	assert.Equal(t, hashCoupon(salt, "GUESSME1"), entry["codeHash"])
+	assert.Equal(t, "203.0.113.7", entry["clientIP"])
+}
+
+// TestHashCoupon verifies hashes are stable for a salt and differ across salts
+func TestHashCoupon(t *testing.T) {
+	assert.Equal(t, hashCoupon([]byte("a"), "SAVE10"), hashCoupon([]byte("a"), "SAVE10"))
+	assert.NotEqual(t, hashCoupon([]byte("a"), "SAVE10"), hashCoupon([]byte("b"), "SAVE10"))
+	assert.NotEqual(t, hashCoupon([]byte("a"), "SAVE10"), hashCoupon([]byte("a"), "SAVE20"))
+	assert.Len(t, hashCoupon([]byte("a"), "SAVE10"), 64)
+}
@@ -1,13 +1,22 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// sqliteTimestampFormat is the UTC text format of CURRENT_TIMESTAMP.
+// Timestamps written or compared in SQL use it so they sort consistently.
+const sqliteTimestampFormat = "2006-01-02 15:04:05"
+
 // InitDB initializes and returns a SQLite database connection
 func InitDB(dbPath string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", dbPath)
@@ -23,11 +32,54 @@ func InitDB(dbPath string) (*sql.DB, error) {
 	return db, nil
 }
 
-// GetAllProducts fetches all products from the database
-func GetAllProducts(db *sql.DB) ([]Product, error) {
-	query := `SELECT id, name, price, category FROM products ORDER BY category, name`
+// productSortClauses maps the allowed sort values to their ORDER BY clause.
+// Only values in this allowlist ever reach the query, so user input can't inject SQL.
+var productSortClauses = map[string]string{
+	"":          "category, name",
+	"name":      "name",
+	"-name":     "name DESC",
+	"price":     "price, name",
+	"-price":    "price DESC, name",
+	"category":  "category, name",
+	"-category": "category DESC, name",
+}
+
+// GetAllProducts fetches all products on the menu, leaving out soft-deleted ones.
+// sort is one of the keys of productSortClauses; an empty sort orders by category, then name.
+// It returns ErrInvalidSort for any other value.
+func GetAllProducts(ctx context.Context, db *sql.DB, sort string) ([]Product, error) {
+	orderBy, ok := productSortClauses[sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSort, sort)
+	}
+	return queryProducts(ctx, db, `SELECT id, name, price, category, image_url FROM products WHERE deleted_at IS NULL ORDER BY `+orderBy)
+}
+
+// GetProductsPage fetches up to limit products on the menu in the given sort
+// order, skipping the first offset, like GetAllProducts. Products tied on the
+// sort keys are ordered by ID so pages don't overlap.
+func GetProductsPage(ctx context.Context, db *sql.DB, sort string, limit, offset int) ([]Product, error) {
+	orderBy, ok := productSortClauses[sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSort, sort)
+	}
+	query := `SELECT id, name, price, category, image_url FROM products WHERE deleted_at IS NULL ORDER BY ` + orderBy + `, id LIMIT ? OFFSET ?`
+	return queryProducts(ctx, db, query, limit, offset)
+}
+
+// CountProducts returns the number of products on the menu, leaving out soft-deleted ones
+func CountProducts(ctx context.Context, db *sql.DB) (int, error) {
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM products WHERE deleted_at IS NULL`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count products: %w", err)
+	}
+	return count, nil
+}
 
-	rows, err := db.Query(query)
+// queryProducts runs a query selecting id, name, price, category and image_url
+// and returns the products it finds
+func queryProducts(ctx context.Context, db *sql.DB, query string, args ...any) ([]Product, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query products: %w", err)
 	}
@@ -37,9 +89,10 @@ func GetAllProducts(db *sql.DB) ([]Product, error) {
 	for rows.Next() {
 		var p Product
 		var id, name, category string
-		var price float32
+		var price Money
+		var imageURL sql.NullString
 
-		if err := rows.Scan(&id, &name, &price, &category); err != nil {
+		if err := rows.Scan(&id, &name, &price, &category, &imageURL); err != nil {
 			return nil, fmt.Errorf("failed to scan product: %w", err)
 		}
 
@@ -47,6 +100,9 @@ func GetAllProducts(db *sql.DB) ([]Product, error) {
 		p.Name = &name
 		p.Price = &price
 		p.Category = &category
+		if imageURL.Valid {
+			p.ImageUrl = &imageURL.String
+		}
 
 		products = append(products, p)
 	}
@@ -58,17 +114,19 @@ func GetAllProducts(db *sql.DB) ([]Product, error) {
 	return products, nil
 }
 
-// GetProductByID fetches a single product by its ID
-func GetProductByID(db *sql.DB, id string) (*Product, error) {
-	query := `SELECT id, name, price, category FROM products WHERE id = ?`
+// GetProductByID fetches a single product by its ID.
+// It returns ErrProductNotFound if no product has the given ID or it was soft-deleted.
+func GetProductByID(ctx context.Context, db *sql.DB, id string) (*Product, error) {
+	query := `SELECT id, name, price, category, image_url FROM products WHERE id = ? AND deleted_at IS NULL`
 
 	var p Product
 	var productID, name, category string
-	var price float32
+	var price Money
+	var imageURL sql.NullString
 
-	err := db.QueryRow(query, id).Scan(&productID, &name, &price, &category)
+	err := db.QueryRowContext(ctx, query, id).Scan(&productID, &name, &price, &category, &imageURL)
 	if err == sql.ErrNoRows {
-		return nil, nil // Product not found
+		return nil, ErrProductNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to query product: %w", err)
@@ -78,18 +136,21 @@ func GetProductByID(db *sql.DB, id string) (*Product, error) {
 	p.Name = &name
 	p.Price = &price
 	p.Category = &category
+	if imageURL.Valid {
+		p.ImageUrl = &imageURL.String
+	}
 
 	return &p, nil
 }
 
-// GetProductsByIDs fetches multiple products by their IDs
-func GetProductsByIDs(db *sql.DB, ids []string) ([]Product, error) {
+// GetProductsByIDs fetches multiple products by their IDs, leaving out soft-deleted ones
+func GetProductsByIDs(ctx context.Context, db *sql.DB, ids []string) ([]Product, error) {
 	if len(ids) == 0 {
 		return []Product{}, nil
 	}
 
 	// Build query with placeholders
-	query := `SELECT id, name, price, category FROM products WHERE id IN (`
+	query := `SELECT id, name, price, category, image_url FROM products WHERE deleted_at IS NULL AND id IN (`
 	args := make([]interface{}, len(ids))
 	for i, id := range ids {
 		if i > 0 {
@@ -100,7 +161,7 @@ func GetProductsByIDs(db *sql.DB, ids []string) ([]Product, error) {
 	}
 	query += ")"
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query products: %w", err)
 	}
@@ -110,9 +171,10 @@ func GetProductsByIDs(db *sql.DB, ids []string) ([]Product, error) {
 	for rows.Next() {
 		var p Product
 		var id, name, category string
-		var price float32
+		var price Money
+		var imageURL sql.NullString
 
-		if err := rows.Scan(&id, &name, &price, &category); err != nil {
+		if err := rows.Scan(&id, &name, &price, &category, &imageURL); err != nil {
 			return nil, fmt.Errorf("failed to scan product: %w", err)
 		}
 
@@ -120,6 +182,9 @@ func GetProductsByIDs(db *sql.DB, ids []string) ([]Product, error) {
 		p.Name = &name
 		p.Price = &price
 		p.Category = &category
+		if imageURL.Valid {
+			p.ImageUrl = &imageURL.String
+		}
 
 		products = append(products, p)
 	}
@@ -135,50 +200,365 @@ func GetProductsByIDs(db *sql.DB, ids []string) ([]Product, error) {
 type OrderItem struct {
 	ProductID string
 	Quantity  int
+	// UnitPrice is the price charged for one unit, with bulk pricing, so
+	// receipts don't depend on later price changes. Nil leaves it unrecorded.
+	UnitPrice *Money
 }
 
-// CreateOrder creates a new order with the given items and returns the order ID
-func CreateOrder(db *sql.DB, couponCode *string, items []OrderItem) (string, error) {
+// CreateOrder creates a new order with the given items and total, placed at createdAt,
+// and returns the order ID
+func CreateOrder(ctx context.Context, db *sql.DB, couponCode, customerID *string, total Money, items []OrderItem, createdAt time.Time) (string, error) {
 	// Generate UUID for the order
 	orderID := uuid.New().String()
+	if err := CreateOrderWithID(ctx, db, orderID, couponCode, customerID, total, items, createdAt); err != nil {
+		return "", err
+	}
+	return orderID, nil
+}
 
+// CreateOrderWithID is CreateOrder with an ID chosen by the caller, e.g. sent
+// by the client. It returns ErrOrderExists if an order already has that ID.
+func CreateOrderWithID(ctx context.Context, db *sql.DB, orderID string, couponCode, customerID *string, total Money, items []OrderItem, createdAt time.Time) error {
 	// Start a transaction
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	// Insert order
-	insertOrderQuery := `INSERT INTO orders (id, coupon_code) VALUES (?, ?)`
-	if _, err := tx.Exec(insertOrderQuery, orderID, couponCode); err != nil {
-		return "", fmt.Errorf("failed to insert order: %w", err)
+	insertOrderQuery := `INSERT INTO orders (id, created_at, coupon_code, customer_id, total) VALUES (?, ?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, insertOrderQuery, orderID, createdAt.UTC().Format(sqliteTimestampFormat), couponCode, customerID, total); err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return fmt.Errorf("%w: %s", ErrOrderExists, orderID)
+		}
+		return fmt.Errorf("failed to insert order: %w", err)
 	}
 
 	// Insert order items
-	insertItemQuery := `INSERT INTO order_items (order_id, product_id, quantity) VALUES (?, ?, ?)`
+	insertItemQuery := `INSERT INTO order_items (order_id, product_id, quantity, unit_price) VALUES (?, ?, ?, ?)`
 	for _, item := range items {
-		if _, err := tx.Exec(insertItemQuery, orderID, item.ProductID, item.Quantity); err != nil {
-			return "", fmt.Errorf("failed to insert order item: %w", err)
+		if _, err := tx.ExecContext(ctx, insertItemQuery, orderID, item.ProductID, item.Quantity, item.UnitPrice); err != nil {
+			return fmt.Errorf("failed to insert order item: %w", err)
 		}
 	}
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
-		return "", fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return orderID, nil
+	return nil
+}
+
+// GetRelatedProducts returns up to limit other products in the same category
+// as the given product, ordered by name.
+// It returns ErrProductNotFound if the product doesn't exist.
+func GetRelatedProducts(ctx context.Context, db *sql.DB, id string, limit int) ([]Product, error) {
+	product, err := GetProductByID(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, name, price, category, image_url FROM products
+		WHERE category = ? AND id != ? AND deleted_at IS NULL
+		ORDER BY name
+		LIMIT ?`
+
+	rows, err := db.QueryContext(ctx, query, *product.Category, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query related products: %w", err)
+	}
+	defer rows.Close()
+
+	products := []Product{}
+	for rows.Next() {
+		var p Product
+		var productID, name, category string
+		var price Money
+		var imageURL sql.NullString
+
+		if err := rows.Scan(&productID, &name, &price, &category, &imageURL); err != nil {
+			return nil, fmt.Errorf("failed to scan product: %w", err)
+		}
+
+		p.Id = &productID
+		p.Name = &name
+		p.Price = &price
+		p.Category = &category
+		if imageURL.Valid {
+			p.ImageUrl = &imageURL.String
+		}
+
+		products = append(products, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating related products: %w", err)
+	}
+
+	return products, nil
+}
+
+// PriceTier is a bulk pricing rule: ordering at least MinQuantity of a
+// product takes UnitDiscount off the price of every unit
+type PriceTier struct {
+	MinQuantity  int
+	UnitDiscount Money
+}
+
+// GetPriceTiers returns the bulk pricing tiers of the given products, keyed by product ID.
+// Products without tiers are absent from the map.
+func GetPriceTiers(ctx context.Context, db *sql.DB, productIDs []string) (map[string][]PriceTier, error) {
+	tiers := make(map[string][]PriceTier)
+	if len(productIDs) == 0 {
+		return tiers, nil
+	}
+
+	// Build query with placeholders
+	query := `SELECT product_id, min_quantity, unit_discount FROM product_tiers WHERE product_id IN (`
+	args := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		if i > 0 {
+			query += ", "
+		}
+		query += "?"
+		args[i] = id
+	}
+	query += ") ORDER BY product_id, min_quantity"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price tiers: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID string
+		var tier PriceTier
+		if err := rows.Scan(&productID, &tier.MinQuantity, &tier.UnitDiscount); err != nil {
+			return nil, fmt.Errorf("failed to scan price tier: %w", err)
+		}
+		tiers[productID] = append(tiers[productID], tier)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating price tiers: %w", err)
+	}
+
+	return tiers, nil
+}
+
+// GetOrderByID fetches an order with its items and the products they refer to.
+// Items are sorted by product category, then name, so receipts group related items.
+// Orders placed without a coupon have a nil CouponCode, and guest orders a nil CustomerId.
+// It returns ErrOrderNotFound if no order has the given ID.
+func GetOrderByID(ctx context.Context, db *sql.DB, id string) (*Order, error) {
+	// coupon_code and customer_id are NULL for orders placed without them
+	var couponCode, customerID sql.NullString
+	var total sql.Null[Money]
+	err := db.QueryRowContext(ctx, `SELECT coupon_code, customer_id, total FROM orders WHERE id = ?`, id).Scan(&couponCode, &customerID, &total)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order: %w", err)
+	}
+
+	query := `SELECT oi.quantity, oi.unit_price, p.id, p.name, p.price, p.category, p.image_url
+		FROM order_items oi
+		JOIN products p ON p.id = oi.product_id
+		WHERE oi.order_id = ?
+		ORDER BY p.category, p.name, oi.rowid`
+
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []struct {
+		ProductId *string `json:"productId,omitempty"`
+		Quantity  *int    `json:"quantity,omitempty"`
+		UnitPrice *Money  `json:"unitPrice,omitempty"`
+	}{}
+	products := []Product{}
+	for rows.Next() {
+		var p Product
+		var quantity int
+		var unitPrice sql.Null[Money]
+		var productID, name, category string
+		var price Money
+		var imageURL sql.NullString
+
+		if err := rows.Scan(&quantity, &unitPrice, &productID, &name, &price, &category, &imageURL); err != nil {
+			return nil, fmt.Errorf("failed to scan order item: %w", err)
+		}
+
+		p.Id = &productID
+		p.Name = &name
+		p.Price = &price
+		p.Category = &category
+		if imageURL.Valid {
+			p.ImageUrl = &imageURL.String
+		}
+
+		item := struct {
+			ProductId *string `json:"productId,omitempty"`
+			Quantity  *int    `json:"quantity,omitempty"`
+			UnitPrice *Money  `json:"unitPrice,omitempty"`
+		}{ProductId: &productID, Quantity: &quantity}
+		// NULL for orders placed before unit prices were recorded
+		if unitPrice.Valid {
+			item.UnitPrice = &unitPrice.V
+		}
+		items = append(items, item)
+		products = append(products, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating order items: %w", err)
+	}
+
+	order := &Order{
+		Id:       &id,
+		Items:    &items,
+		Products: &products,
+	}
+	if couponCode.Valid {
+		order.CouponCode = &couponCode.String
+	}
+	if customerID.Valid {
+		order.CustomerId = &customerID.String
+	}
+	if total.Valid {
+		order.Total = &total.V
+	}
+
+	return order, nil
+}
+
+// CountCouponUsage returns the number of orders placed with the given coupon code
+func CountCouponUsage(ctx context.Context, db *sql.DB, code string) (int, error) {
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM orders WHERE coupon_code = ?`, code).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count coupon usage: %w", err)
+	}
+	return count, nil
+}
+
+// GetOrdersByCustomer fetches the orders placed with the given customer ID, newest first.
+// A customer without orders gets an empty slice.
+func GetOrdersByCustomer(ctx context.Context, db *sql.DB, customerID string) ([]Order, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM orders WHERE customer_id = ? ORDER BY created_at DESC, id`, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query customer orders: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan order ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating customer orders: %w", err)
+	}
+
+	// Customers have few orders, so each is loaded with its items separately
+	orders := make([]Order, 0, len(ids))
+	for _, id := range ids {
+		order, err := GetOrderByID(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *order)
+	}
+
+	return orders, nil
+}
+
+// OrderSummary is a row of the orders export
+type OrderSummary struct {
+	ID        string
+	CreatedAt time.Time
+	// CouponCode is nil for orders placed without a coupon
+	CouponCode *string
+	// Total is nil for orders placed before totals were recorded
+	Total     *Money
+	ItemCount int
+}
+
+// ExportOrders calls fn for every order created in [from, to), oldest first.
+// A zero from or to leaves that end of the range open.
+// Rows are passed to fn as they are read, so the export is never held in memory.
+// Iteration stops at the first error returned by fn.
+func ExportOrders(ctx context.Context, db *sql.DB, from, to time.Time, fn func(OrderSummary) error) error {
+	query := `SELECT o.id, o.created_at, o.coupon_code, o.total, COUNT(oi.product_id)
+		FROM orders o
+		LEFT JOIN order_items oi ON oi.order_id = o.id`
+	var conditions []string
+	var args []interface{}
+	if !from.IsZero() {
+		conditions = append(conditions, "o.created_at >= ?")
+		args = append(args, from.UTC().Format(sqliteTimestampFormat))
+	}
+	if !to.IsZero() {
+		conditions = append(conditions, "o.created_at < ?")
+		args = append(args, to.UTC().Format(sqliteTimestampFormat))
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " GROUP BY o.id ORDER BY o.created_at, o.id"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query orders: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o OrderSummary
+		var couponCode sql.NullString
+		var total sql.Null[Money]
+
+		if err := rows.Scan(&o.ID, &o.CreatedAt, &couponCode, &total, &o.ItemCount); err != nil {
+			return fmt.Errorf("failed to scan order: %w", err)
+		}
+
+		if couponCode.Valid {
+			o.CouponCode = &couponCode.String
+		}
+		if total.Valid {
+			o.Total = &total.V
+		}
+
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating orders: %w", err)
+	}
+
+	return nil
 }
 
-// ValidateProductsExist checks if all product IDs exist in the database
-func ValidateProductsExist(db *sql.DB, productIDs []string) error {
+// ValidateProductsExist checks if all product IDs exist in the database and
+// are on the menu, so soft-deleted products can't be ordered
+func ValidateProductsExist(ctx context.Context, db *sql.DB, productIDs []string) error {
 	if len(productIDs) == 0 {
 		return fmt.Errorf("no products specified")
 	}
 
 	// Build query with placeholders
-	query := `SELECT COUNT(*) FROM products WHERE id IN (`
+	query := `SELECT COUNT(*) FROM products WHERE deleted_at IS NULL AND id IN (`
 	args := make([]interface{}, len(productIDs))
 	for i, id := range productIDs {
 		if i > 0 {
@@ -190,14 +570,225 @@ func ValidateProductsExist(db *sql.DB, productIDs []string) error {
 	query += ")"
 
 	var count int
-	err := db.QueryRow(query, args...).Scan(&count)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&count)
 	if err != nil {
 		return fmt.Errorf("failed to validate products: %w", err)
 	}
 
 	if count != len(productIDs) {
-		return fmt.Errorf("one or more products not found")
+		return fmt.Errorf("one or more products not found: %w", ErrProductNotFound)
 	}
 
 	return nil
 }
+
+// GetQuantitySteps returns the quantity step of each of the given products, keyed by product ID.
+// A product with a step of 6 can only be ordered in multiples of 6.
+func GetQuantitySteps(ctx context.Context, db *sql.DB, productIDs []string) (map[string]int, error) {
+	steps := make(map[string]int, len(productIDs))
+	if len(productIDs) == 0 {
+		return steps, nil
+	}
+
+	// Build query with placeholders
+	query := `SELECT id, qty_step FROM products WHERE id IN (`
+	args := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		if i > 0 {
+			query += ", "
+		}
+		query += "?"
+		args[i] = id
+	}
+	query += ")"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query quantity steps: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var step int
+		if err := rows.Scan(&id, &step); err != nil {
+			return nil, fmt.Errorf("failed to scan quantity step: %w", err)
+		}
+		steps[id] = step
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating quantity steps: %w", err)
+	}
+
+	return steps, nil
+}
+
+// normalizeCategory trims category, collapses runs of whitespace and
+// title-cases each word, so "burger", " Burger" and "BURGER" are one category
+func normalizeCategory(category string) string {
+	words := strings.Fields(category)
+	for i, word := range words {
+		runes := []rune(strings.ToLower(word))
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
+
+// validateCategory normalizes category and checks it against allowed, which
+// is compared after normalization too. An empty allowed accepts any category.
+// It returns ErrInvalidCategory for a blank category or one not in allowed.
+func validateCategory(category string, allowed []string) (string, error) {
+	category = normalizeCategory(category)
+	if category == "" {
+		return "", fmt.Errorf("%w: category is empty", ErrInvalidCategory)
+	}
+	if len(allowed) == 0 {
+		return category, nil
+	}
+	for _, a := range allowed {
+		if normalizeCategory(a) == category {
+			return category, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidCategory, category)
+}
+
+// UpdateProduct updates the name, price and category of a product.
+// The category is normalized with normalizeCategory and, when allowed is not
+// empty, must be one of allowed.
+// When the price changes, the new price is recorded in price_history.
+// It returns ErrInvalidCategory for a rejected category and ErrProductNotFound
+// if no product has the given ID or it was soft-deleted.
+func UpdateProduct(ctx context.Context, db *sql.DB, id, name string, price Money, category string, allowed []string) error {
+	category, err := validateCategory(category, allowed)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldPrice Money
+	err = tx.QueryRowContext(ctx, `SELECT price FROM products WHERE id = ? AND deleted_at IS NULL`, id).Scan(&oldPrice)
+	if err == sql.ErrNoRows {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query product: %w", err)
+	}
+
+	updateQuery := `UPDATE products SET name = ?, price = ?, category = ? WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, updateQuery, name, price, category, id); err != nil {
+		return fmt.Errorf("failed to update product: %w", err)
+	}
+
+	if price != oldPrice {
+		insertHistoryQuery := `INSERT INTO price_history (product_id, price) VALUES (?, ?)`
+		if _, err := tx.ExecContext(ctx, insertHistoryQuery, id, price); err != nil {
+			return fmt.Errorf("failed to insert price history: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteProduct removes a product from the menu. A product that was never
+// ordered is deleted with its price history and tiers. One referenced by
+// order_items returns ErrProductInUse, unless soft is true: it is then marked
+// deleted instead, so past orders keep their items.
+// It returns ErrProductNotFound if no product has the given ID or it was already soft-deleted.
+func DeleteProduct(ctx context.Context, db *sql.DB, id string, soft bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists, referenced bool
+	err = tx.QueryRowContext(ctx, `SELECT
+			EXISTS (SELECT 1 FROM products WHERE id = ? AND deleted_at IS NULL),
+			EXISTS (SELECT 1 FROM order_items WHERE product_id = ?)`, id, id).Scan(&exists, &referenced)
+	if err != nil {
+		return fmt.Errorf("failed to query product: %w", err)
+	}
+	if !exists {
+		return ErrProductNotFound
+	}
+
+	if referenced {
+		if !soft {
+			return ErrProductInUse
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("failed to soft-delete product: %w", err)
+		}
+	} else {
+		for _, query := range []string{
+			`DELETE FROM price_history WHERE product_id = ?`,
+			`DELETE FROM product_tiers WHERE product_id = ?`,
+			`DELETE FROM products WHERE id = ?`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, id); err != nil {
+				return fmt.Errorf("failed to delete product: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetPriceHistory fetches the recorded price changes of a product, oldest first.
+// A product whose price never changed has an empty history.
+// It returns ErrProductNotFound if no product has the given ID.
+func GetPriceHistory(ctx context.Context, db *sql.DB, productID string) ([]PriceChange, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = ?)`, productID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product: %w", err)
+	}
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+
+	query := `SELECT price, changed_at FROM price_history WHERE product_id = ? ORDER BY changed_at, rowid`
+
+	rows, err := db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []PriceChange{}
+	for rows.Next() {
+		var price Money
+		var changedAt time.Time
+
+		if err := rows.Scan(&price, &changedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan price change: %w", err)
+		}
+
+		history = append(history, PriceChange{
+			Price:     &price,
+			ChangedAt: &changedAt,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating price history: %w", err)
+	}
+
+	return history, nil
+}
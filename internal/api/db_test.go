@@ -1,7 +1,10 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -9,7 +12,7 @@ import (
 
 func TestGetAllProducts(t *testing.T) {
 	db := setupTestDB(t)
-	products, err := GetAllProducts(db)
+	products, err := GetAllProducts(context.Background(), db, "")
 	require.NoError(t, err)
 	assert.Len(t, products, 3)
 	assert.Equal(t, "Coke", *products[0].Name)
@@ -17,13 +20,93 @@ func TestGetAllProducts(t *testing.T) {
 	assert.Equal(t, "Fries", *products[2].Name)
 }
 
+func TestGetAllProducts_InvalidSort(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := GetAllProducts(context.Background(), db, "name; DROP TABLE products")
+	assert.ErrorIs(t, err, ErrInvalidSort)
+}
+
 func TestGetAllProducts_DBError(t *testing.T) {
 	db := setupTestDB(t)
 	db.Close()
-	_, err := GetAllProducts(db)
+	_, err := GetAllProducts(context.Background(), db, "")
 	assert.Error(t, err)
 }
 
+// TestDBFunctions_CancelledContext verifies queries stop when the request
+// context is done, e.g. because the client went away, and nothing is written
+func TestDBFunctions_CancelledContext(t *testing.T) {
+	db := setupTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{name: "GetAllProducts", call: func() error {
+			_, err := GetAllProducts(ctx, db, "")
+			return err
+		}},
+		{name: "GetProductByID", call: func() error {
+			_, err := GetProductByID(ctx, db, "PROD1")
+			return err
+		}},
+		{name: "GetProductsByIDs", call: func() error {
+			_, err := GetProductsByIDs(ctx, db, []string{"PROD1"})
+			return err
+		}},
+		{name: "ValidateProductsExist", call: func() error {
+			return ValidateProductsExist(ctx, db, []string{"PROD1"})
+		}},
+		{name: "CreateOrder", call: func() error {
+			_, err := CreateOrder(ctx, db, nil, nil, 1050, []OrderItem{{ProductID: "PROD1", Quantity: 1}}, time.Now())
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.call(), context.Canceled)
+		})
+	}
+
+	var orders int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&orders))
+	assert.Zero(t, orders)
+}
+
+func TestGetProductsPage(t *testing.T) {
+	db := setupTestDB(t)
+
+	page, err := GetProductsPage(context.Background(), db, "name", 2, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "Coke", *page[0].Name)
+	assert.Equal(t, "Fries", *page[1].Name)
+
+	page, err = GetProductsPage(context.Background(), db, "name", 2, 3)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+
+	_, err = GetProductsPage(context.Background(), db, "stock", 2, 0)
+	assert.ErrorIs(t, err, ErrInvalidSort)
+}
+
+func TestCountProducts(t *testing.T) {
+	db := setupTestDB(t)
+	count, err := CountProducts(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Soft-deleted products aren't on the menu
+	_, err = db.Exec(`UPDATE products SET deleted_at = CURRENT_TIMESTAMP WHERE id = 'PROD1'`)
+	require.NoError(t, err)
+	count, err = CountProducts(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
 func TestGetProductByID(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -56,22 +139,49 @@ func TestGetProductByID(t *testing.T) {
 			if tt.closeDB {
 				db.Close()
 			}
-			p, err := GetProductByID(db, tt.id)
+			p, err := GetProductByID(context.Background(), db, tt.id)
 			if tt.expectedErr {
 				assert.Error(t, err)
-			} else {
+			} else if tt.found {
 				require.NoError(t, err)
-				if tt.found {
-					assert.NotNil(t, p)
-					assert.Equal(t, tt.id, *p.Id)
-				} else {
-					assert.Nil(t, p)
-				}
+				assert.NotNil(t, p)
+				assert.Equal(t, tt.id, *p.Id)
+			} else {
+				assert.ErrorIs(t, err, ErrProductNotFound)
+				assert.Nil(t, p)
 			}
 		})
 	}
 }
 
+func TestProductImageURL(t *testing.T) {
+	db := setupTestDB(t)
+	imageURL := "https://example.com/burger.jpg"
+	_, err := db.Exec("UPDATE products SET image_url = ? WHERE id = 'PROD1'", imageURL)
+	require.NoError(t, err)
+
+	p, err := GetProductByID(context.Background(), db, "PROD1")
+	require.NoError(t, err)
+	require.NotNil(t, p.ImageUrl)
+	assert.Equal(t, imageURL, *p.ImageUrl)
+
+	// NULL image_url scans as a nil ImageUrl and is left out of the JSON
+	p, err = GetProductByID(context.Background(), db, "PROD2")
+	require.NoError(t, err)
+	assert.Nil(t, p.ImageUrl)
+	body, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "imageUrl")
+
+	products, err := GetAllProducts(context.Background(), db, "name")
+	require.NoError(t, err)
+	images := make(map[string]*string)
+	for _, p := range products {
+		images[*p.Id] = p.ImageUrl
+	}
+	assert.Equal(t, map[string]*string{"PROD1": &imageURL, "PROD2": nil, "PROD3": nil}, images)
+}
+
 func TestGetProductsByIDs(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -109,7 +219,7 @@ func TestGetProductsByIDs(t *testing.T) {
 			if tt.closeDB {
 				db.Close()
 			}
-			products, err := GetProductsByIDs(db, tt.ids)
+			products, err := GetProductsByIDs(context.Background(), db, tt.ids)
 			if tt.expectedErr {
 				assert.Error(t, err)
 			} else {
@@ -128,15 +238,19 @@ func TestCreateOrder(t *testing.T) {
 		{ProductID: "PROD2", Quantity: 1},
 	}
 
-	orderID, err := CreateOrder(db, &coupon, items)
+	createdAt := time.Date(2025, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	orderID, err := CreateOrder(context.Background(), db, &coupon, nil, 1500, items, createdAt)
 	require.NoError(t, err)
 	assert.NotEmpty(t, orderID)
 
 	// Verify order exists
 	var dbCoupon string
-	err = db.QueryRow("SELECT coupon_code FROM orders WHERE id = ?", orderID).Scan(&dbCoupon)
+	var dbCreatedAt time.Time
+	err = db.QueryRow("SELECT coupon_code, created_at FROM orders WHERE id = ?", orderID).Scan(&dbCoupon, &dbCreatedAt)
 	require.NoError(t, err)
 	assert.Equal(t, coupon, dbCoupon)
+	assert.True(t, createdAt.Equal(dbCreatedAt), "created_at = %v, want %v", dbCreatedAt, createdAt)
 
 	// Verify items exist
 	var count int
@@ -150,7 +264,163 @@ func TestCreateOrder_DBError(t *testing.T) {
 	db.Close()
 	coupon := "SAVE10"
 	items := []OrderItem{{ProductID: "PROD1", Quantity: 1}}
-	_, err := CreateOrder(db, &coupon, items)
+	_, err := CreateOrder(context.Background(), db, &coupon, nil, 1500, items, time.Now())
+	assert.Error(t, err)
+}
+
+func TestCreateOrderWithID(t *testing.T) {
+	db := setupTestDB(t)
+	items := []OrderItem{{ProductID: "PROD1", Quantity: 1}}
+	const orderID = "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+
+	require.NoError(t, CreateOrderWithID(context.Background(), db, orderID, nil, nil, 1050, items, time.Now()))
+	order, err := GetOrderByID(context.Background(), db, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, orderID, *order.Id)
+
+	// The existing order and its items are left as they were
+	err = CreateOrderWithID(context.Background(), db, orderID, nil, nil, 2100, []OrderItem{{ProductID: "PROD2", Quantity: 2}}, time.Now())
+	assert.ErrorIs(t, err, ErrOrderExists)
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM order_items WHERE order_id = ?", orderID).Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestGetOrderByID(t *testing.T) {
+	coupon := "SAVE10"
+
+	tests := []struct {
+		name        string
+		couponCode  *string
+		closeDB     bool
+		missing     bool
+		expectedErr error
+	}{
+		{
+			name:       "WithCoupon",
+			couponCode: &coupon,
+		},
+		{
+			name:       "WithoutCoupon", // coupon_code is stored as NULL
+			couponCode: nil,
+		},
+		{
+			name:        "NotFound",
+			missing:     true,
+			expectedErr: ErrOrderNotFound,
+		},
+		{
+			name:    "DBError",
+			closeDB: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			items := []OrderItem{
+				{ProductID: "PROD1", Quantity: 2},
+				{ProductID: "PROD2", Quantity: 1},
+			}
+			orderID, err := CreateOrder(context.Background(), db, tt.couponCode, nil, 2600, items, time.Now())
+			require.NoError(t, err)
+			if tt.missing {
+				orderID = "NONEXISTENT"
+			}
+			if tt.closeDB {
+				db.Close()
+			}
+
+			order, err := GetOrderByID(context.Background(), db, orderID)
+			if tt.closeDB {
+				assert.Error(t, err)
+				return
+			}
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, orderID, *order.Id)
+			assert.Equal(t, tt.couponCode, order.CouponCode)
+			require.NotNil(t, order.Total)
+			assert.Equal(t, Money(2600), *order.Total)
+			require.Len(t, *order.Items, 2)
+			assert.Equal(t, "PROD1", *(*order.Items)[0].ProductId)
+			assert.Equal(t, 2, *(*order.Items)[0].Quantity)
+			assert.Equal(t, "PROD2", *(*order.Items)[1].ProductId)
+			assert.Equal(t, 1, *(*order.Items)[1].Quantity)
+			assert.Len(t, *order.Products, 2)
+		})
+	}
+}
+
+// TestGetOrderByID_ItemOrder verifies items come back grouped by category, then by name
+func TestGetOrderByID_ItemOrder(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.Exec(`INSERT INTO products (id, name, price, category) VALUES
+	('PROD4', 'Apple Juice', 3.0, 'Drink'),
+	('PROD5', 'Onion Rings', 4.0, 'Side')`)
+	require.NoError(t, err)
+
+	// Placed in no particular order, across Drink, Main and Side
+	items := []OrderItem{
+		{ProductID: "PROD5", Quantity: 1}, // Onion Rings, Side
+		{ProductID: "PROD3", Quantity: 2}, // Coke, Drink
+		{ProductID: "PROD2", Quantity: 1}, // Fries, Side
+		{ProductID: "PROD1", Quantity: 1}, // Burger, Main
+		{ProductID: "PROD4", Quantity: 1}, // Apple Juice, Drink
+	}
+	orderID, err := CreateOrder(context.Background(), db, nil, nil, 0, items, time.Now())
+	require.NoError(t, err)
+
+	order, err := GetOrderByID(context.Background(), db, orderID)
+	require.NoError(t, err)
+
+	var itemIDs, productNames []string
+	for _, item := range *order.Items {
+		itemIDs = append(itemIDs, *item.ProductId)
+	}
+	for _, p := range *order.Products {
+		productNames = append(productNames, *p.Name)
+	}
+	assert.Equal(t, []string{"PROD4", "PROD3", "PROD1", "PROD2", "PROD5"}, itemIDs)
+	assert.Equal(t, []string{"Apple Juice", "Coke", "Burger", "Fries", "Onion Rings"}, productNames)
+}
+
+func TestGetOrdersByCustomer(t *testing.T) {
+	db := setupTestDB(t)
+	customer := "cust_42"
+	other := "cust_7"
+	items := []OrderItem{{ProductID: "PROD1", Quantity: 1}}
+
+	first, err := CreateOrder(context.Background(), db, nil, &customer, 1000, items, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	second, err := CreateOrder(context.Background(), db, nil, &customer, 1000, items, time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	_, err = CreateOrder(context.Background(), db, nil, &other, 1000, items, time.Now())
+	require.NoError(t, err)
+	_, err = CreateOrder(context.Background(), db, nil, nil, 1000, items, time.Now()) // Guest order
+	require.NoError(t, err)
+
+	orders, err := GetOrdersByCustomer(context.Background(), db, customer)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	assert.Equal(t, second, *orders[0].Id, "Newest order should come first")
+	assert.Equal(t, first, *orders[1].Id)
+	for _, order := range orders {
+		assert.Equal(t, &customer, order.CustomerId)
+		assert.Len(t, *order.Items, 1)
+	}
+
+	orders, err = GetOrdersByCustomer(context.Background(), db, "nobody")
+	require.NoError(t, err)
+	assert.NotNil(t, orders)
+	assert.Empty(t, orders)
+
+	db.Close()
+	_, err = GetOrdersByCustomer(context.Background(), db, customer)
 	assert.Error(t, err)
 }
 
@@ -190,7 +460,7 @@ func TestValidateProductsExist(t *testing.T) {
 			if tt.closeDB {
 				db.Close()
 			}
-			err := ValidateProductsExist(db, tt.productIDs)
+			err := ValidateProductsExist(context.Background(), db, tt.productIDs)
 			if tt.expectedErr {
 				assert.Error(t, err)
 			} else {
@@ -200,6 +470,132 @@ func TestValidateProductsExist(t *testing.T) {
 	}
 }
 
+func TestUpdateProduct(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Same price doesn't record history
+	err := UpdateProduct(context.Background(), db, "PROD1", "Big Burger", 1050, "Main", nil)
+	require.NoError(t, err)
+	history, err := GetPriceHistory(context.Background(), db, "PROD1")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	err = UpdateProduct(context.Background(), db, "PROD1", "Big Burger", 1150, "Main", nil)
+	require.NoError(t, err)
+
+	p, err := GetProductByID(context.Background(), db, "PROD1")
+	require.NoError(t, err)
+	assert.Equal(t, "Big Burger", *p.Name)
+	assert.Equal(t, Money(1150), *p.Price)
+
+	history, err = GetPriceHistory(context.Background(), db, "PROD1")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, Money(1150), *history[0].Price)
+}
+
+func TestUpdateProduct_NotFound(t *testing.T) {
+	db := setupTestDB(t)
+	err := UpdateProduct(context.Background(), db, "NONEXISTENT", "Ghost", 1, "Main", nil)
+	assert.ErrorIs(t, err, ErrProductNotFound)
+}
+
+func TestUpdateProduct_Category(t *testing.T) {
+	tests := []struct {
+		name             string
+		category         string
+		allowed          []string
+		expectedCategory string
+		expectedErr      error
+	}{
+		{name: "Lower case", category: "burger", expectedCategory: "Burger"},
+		{name: "Upper case", category: "BURGER", expectedCategory: "Burger"},
+		{name: "Padded words", category: "  side   DISHES ", expectedCategory: "Side Dishes"},
+		{name: "Blank", category: "   ", expectedErr: ErrInvalidCategory},
+		{name: "Allowed", category: "drink", allowed: []string{"Burger", "drink"}, expectedCategory: "Drink"},
+		{name: "Not allowed", category: "Pizza", allowed: []string{"Burger", "Drink"}, expectedErr: ErrInvalidCategory},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			err := UpdateProduct(context.Background(), db, "PROD1", "Burger", 1050, tt.category, tt.allowed)
+
+			p, getErr := GetProductByID(context.Background(), db, "PROD1")
+			require.NoError(t, getErr)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Equal(t, "Main", *p.Category, "a rejected category should not be written")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCategory, *p.Category)
+		})
+	}
+}
+
+func TestDeleteProduct(t *testing.T) {
+	tests := []struct {
+		name        string
+		productID   string
+		soft        bool
+		expectedErr error
+		// Whether the product row is kept, hidden from the menu
+		softDeleted bool
+	}{
+		{name: "Unreferenced", productID: "PROD2"},
+		{name: "Unreferenced with soft is still hard deleted", productID: "PROD2", soft: true},
+		{name: "Referenced", productID: "PROD1", expectedErr: ErrProductInUse},
+		{name: "Referenced with soft", productID: "PROD1", soft: true, softDeleted: true},
+		{name: "Not found", productID: "NONEXISTENT", expectedErr: ErrProductNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			orderID, err := CreateOrder(context.Background(), db, nil, nil, 1050, []OrderItem{{ProductID: "PROD1", Quantity: 1}}, time.Now())
+			require.NoError(t, err)
+
+			err = DeleteProduct(context.Background(), db, tt.productID, tt.soft)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				_, err := GetProductByID(context.Background(), db, "PROD1")
+				assert.NoError(t, err, "A failed delete must leave the menu unchanged")
+				return
+			}
+			require.NoError(t, err)
+
+			_, err = GetProductByID(context.Background(), db, tt.productID)
+			assert.ErrorIs(t, err, ErrProductNotFound)
+			products, err := GetAllProducts(context.Background(), db, "")
+			require.NoError(t, err)
+			for _, p := range products {
+				assert.NotEqual(t, tt.productID, *p.Id)
+			}
+			assert.ErrorIs(t, ValidateProductsExist(context.Background(), db, []string{tt.productID}), ErrProductNotFound, "Deleted products can't be ordered")
+
+			var rows int
+			require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM products WHERE id = ?", tt.productID).Scan(&rows))
+			if tt.softDeleted {
+				assert.Equal(t, 1, rows)
+				order, err := GetOrderByID(context.Background(), db, orderID)
+				require.NoError(t, err)
+				require.Len(t, *order.Products, 1, "Past orders keep soft-deleted products")
+				assert.Equal(t, tt.productID, *(*order.Products)[0].Id)
+				assert.ErrorIs(t, DeleteProduct(context.Background(), db, tt.productID, true), ErrProductNotFound, "Deleting twice")
+			} else {
+				assert.Zero(t, rows)
+			}
+		})
+	}
+}
+
+func TestGetPriceHistory_NotFound(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := GetPriceHistory(context.Background(), db, "NONEXISTENT")
+	assert.ErrorIs(t, err, ErrProductNotFound)
+}
+
 func TestInitDB_Error(t *testing.T) {
 	// Try to open a database in a non-existent directory
 	_, err := InitDB("/non/existent/path/test.db")
@@ -0,0 +1,27 @@
+package api
+
+// Discount is what a coupon takes off an order's subtotal: a percentage, a
+// flat amount, or both, with the percentage applied first
+type Discount struct {
+	// Percent is taken off the subtotal, from 0 to 100
+	Percent int
+	// Amount is taken off after Percent
+	Amount Money
+}
+
+// WithDiscounts sets the discount each coupon code gives. Valid codes without
+// a discount are accepted but leave the total unchanged, the default.
+// Codes are normalized with NormalizeCoupon.
+func WithDiscounts(discounts map[string]Discount) Option {
+	return func(s *Server) {
+		s.discounts = normalizeCouponKeys(discounts)
+	}
+}
+
+// Apply returns subtotal with the discount taken off, rounding the percentage
+// to the nearest cent. The result is never negative. The percentage is taken
+// off dollars and cents separately, so it can't overflow for any subtotal.
+func (d Discount) Apply(subtotal Money) Money {
+	off := subtotal/100*Money(d.Percent) + (subtotal%100*Money(d.Percent)+50)/100
+	return max(subtotal-off-d.Amount, 0)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscount_Apply(t *testing.T) {
+	tests := []struct {
+		name     string
+		discount Discount
+		subtotal Money
+		expected Money
+	}{
+		{name: "none", subtotal: 2600, expected: 2600},
+		{name: "percent", discount: Discount{Percent: 10}, subtotal: 2600, expected: 2340},
+		{name: "percent rounds to the nearest cent", discount: Discount{Percent: 15}, subtotal: 999, expected: 849}, // 149.85 cents off, rounded to 150
+		{name: "flat", discount: Discount{Amount: 500}, subtotal: 2600, expected: 2100},
+		{name: "percent then flat", discount: Discount{Percent: 50, Amount: 100}, subtotal: 2600, expected: 1200},
+		{name: "flat above subtotal clamps at zero", discount: Discount{Amount: 5000}, subtotal: 2600, expected: 0},
+		{name: "full percent", discount: Discount{Percent: 100}, subtotal: 2600, expected: 0},
+		{name: "percent of a huge subtotal doesn't overflow", discount: Discount{Percent: 50}, subtotal: math.MaxInt64 - 7, expected: (math.MaxInt64 - 7) / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.discount.Apply(tt.subtotal))
+		})
+	}
+}
+
+// TestServer_PlaceOrder_Discount verifies coupons take their discount off the
+// total, which is what gets stored
+func TestServer_PlaceOrder_Discount(t *testing.T) {
+	tests := []struct {
+		name             string
+		couponCode       string
+		expectedStatus   int
+		expectedSubtotal Money
+		expectedTotal    Money
+	}{
+		{name: "NoCoupon", expectedStatus: http.StatusOK, expectedSubtotal: 2600, expectedTotal: 2600},
+		{name: "Percent", couponCode: "SAVE10", expectedStatus: http.StatusOK, expectedSubtotal: 2600, expectedTotal: 2340},
+		{name: "FlatAboveSubtotal", couponCode: "FREEMEAL", expectedStatus: http.StatusOK, expectedSubtotal: 2600, expectedTotal: 0},
+		{name: "ValidWithoutDiscount", couponCode: "WELCOME", expectedStatus: http.StatusOK, expectedSubtotal: 2600, expectedTotal: 2600},
+		{name: "Invalid", couponCode: "NOTACODE", expectedStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			s := NewServer([]string{"SAVE10", "FREEMEAL", "WELCOME"}, db, WithDiscounts(map[string]Discount{
+				"SAVE10":   {Percent: 10},
+				"FREEMEAL": {Amount: 5000},
+			}))
+
+			body := fmt.Sprintf(`{"items":[{"productId":"PROD1","quantity":2},{"productId":"PROD2","quantity":1}],"couponCode":%q}`, tt.couponCode)
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+			req.Header.Set("api_key", apiKey)
+			w := httptest.NewRecorder()
+
+			s.PlaceOrder(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var order Order
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&order))
+			require.NotNil(t, order.Subtotal)
+			require.NotNil(t, order.Total)
+			assert.Equal(t, tt.expectedSubtotal, *order.Subtotal)
+			assert.Equal(t, tt.expectedTotal, *order.Total)
+
+			stored, err := GetOrderByID(context.Background(), db, *order.Id)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedTotal, *stored.Total)
+		})
+	}
+}
+
+// TestServer_PlaceOrder_DiscountMode orders 10 of PROD3, which lists at 2.50
+// and is bulk priced at 2.00 from 10, with a coupon and each discount mode
+func TestServer_PlaceOrder_DiscountMode(t *testing.T) {
+	tests := []struct {
+		name             string
+		couponCode       string
+		mode             string
+		expectedStatus   int
+		expectedSubtotal Money
+		expectedTotal    Money
+	}{
+		{name: "DefaultStacks", couponCode: "SAVE10", expectedStatus: http.StatusOK, expectedSubtotal: 2000, expectedTotal: 1800},
+		{name: "Stack", couponCode: "SAVE10", mode: "stack", expectedStatus: http.StatusOK, expectedSubtotal: 2000, expectedTotal: 1800},
+		{name: "BestBulkWins", couponCode: "SAVE10", mode: "best", expectedStatus: http.StatusOK, expectedSubtotal: 2000, expectedTotal: 2000},
+		{name: "BestCouponWins", couponCode: "HALFOFF", mode: "best", expectedStatus: http.StatusOK, expectedSubtotal: 2500, expectedTotal: 1250},
+		{name: "Invalid", couponCode: "SAVE10", mode: "both", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			s := NewServer([]string{"SAVE10", "HALFOFF"}, db, WithDiscounts(map[string]Discount{
+				"SAVE10":  {Percent: 10},
+				"HALFOFF": {Percent: 50},
+			}))
+
+			body := fmt.Sprintf(`{"items":[{"productId":"PROD3","quantity":10}],"couponCode":%q`, tt.couponCode)
+			if tt.mode != "" {
+				body += fmt.Sprintf(`,"discountMode":%q`, tt.mode)
+			}
+			body += "}"
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(body))
+			req.Header.Set("api_key", apiKey)
+			w := httptest.NewRecorder()
+
+			s.PlaceOrder(w, req)
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var order Order
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&order))
+			require.NotNil(t, order.Subtotal)
+			require.NotNil(t, order.Total)
+			assert.Equal(t, tt.expectedSubtotal, *order.Subtotal)
+			assert.Equal(t, tt.expectedTotal, *order.Total)
+		})
+	}
+}
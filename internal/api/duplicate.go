@@ -0,0 +1,100 @@
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orderHash identifies the content of an order placed by a client
+type orderHash [sha256.Size]byte
+
+// duplicateDetector remembers the orders placed within the last window, so an
+// identical order from the same client, typically a double-submit, can be rejected
+type duplicateDetector struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	placed    map[orderHash]time.Time
+	lastSweep time.Time
+}
+
+// WithDuplicateWindow rejects with 409 an order identical in items and coupon
+// to one the same client placed less than window ago. The client is the
+// customer ID and client IP. Zero or less disables the check, the default.
+func WithDuplicateWindow(window time.Duration) Option {
+	return func(s *Server) {
+		if window > 0 {
+			s.duplicates = &duplicateDetector{window: window, placed: make(map[orderHash]time.Time)}
+		} else {
+			s.duplicates = nil
+		}
+	}
+}
+
+// hashOrder hashes the client and content of an order. Items are sorted
+// first, so the same items listed in another order hash the same.
+func hashOrder(r *http.Request, customerID, couponCode *string, items []OrderItem) orderHash {
+	lines := make([]string, 0, len(items)+3)
+	lines = append(lines, clientIP(r), deref(customerID), deref(couponCode))
+
+	sorted := make([]string, len(items))
+	for i, item := range items {
+		sorted[i] = fmt.Sprintf("%s:%d", item.ProductID, item.Quantity)
+	}
+	slices.Sort(sorted)
+
+	return sha256.Sum256([]byte(strings.Join(append(lines, sorted...), "\n")))
+}
+
+// deref returns the string p points to, or "" if p is nil
+func deref(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+// claim records an order with hash h placed at now. It reports false if an
+// identical order was claimed less than window ago, in which case nothing is recorded.
+func (d *duplicateDetector) claim(h orderHash, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(now)
+
+	if placed, ok := d.placed[h]; ok && now.Sub(placed) < d.window {
+		return false
+	}
+	d.placed[h] = now
+	return true
+}
+
+// release forgets an order claimed at placed, for when it wasn't created after all
+func (d *duplicateDetector) release(h orderHash, placed time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.placed[h].Equal(placed) {
+		delete(d.placed, h)
+	}
+}
+
+// sweep drops the orders placed longer than window ago, at most once per
+// window. Must be called with mu held.
+func (d *duplicateDetector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.window {
+		return
+	}
+	d.lastSweep = now
+
+	for h, placed := range d.placed {
+		if now.Sub(placed) >= d.window {
+			delete(d.placed, h)
+		}
+	}
+}
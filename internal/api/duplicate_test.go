@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_PlaceOrder_DuplicateWindow(t *testing.T) {
+	order := `{"items":[{"productId":"PROD1","quantity":1},{"productId":"PROD2","quantity":2}],"couponCode":"SAVE10"}`
+
+	tests := []struct {
+		name           string
+		body           string
+		remoteAddr     string
+		elapsed        time.Duration
+		expectedStatus int
+	}{
+		{name: "First", body: order, remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusOK},
+		{name: "RapidDuplicate", body: order, remoteAddr: "10.0.0.1:1235", elapsed: time.Second, expectedStatus: http.StatusConflict},
+		{
+			name:           "ItemsReordered",
+			body:           `{"items":[{"productId":"PROD2","quantity":2},{"productId":"PROD1","quantity":1}],"couponCode":"SAVE10"}`,
+			remoteAddr:     "10.0.0.1:1236",
+			elapsed:        time.Second,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "OtherQuantity",
+			body:           `{"items":[{"productId":"PROD1","quantity":3},{"productId":"PROD2","quantity":2}],"couponCode":"SAVE10"}`,
+			remoteAddr:     "10.0.0.1:1237",
+			elapsed:        time.Second,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "WithoutCoupon",
+			body:           `{"items":[{"productId":"PROD1","quantity":1},{"productId":"PROD2","quantity":2}]}`,
+			remoteAddr:     "10.0.0.1:1238",
+			elapsed:        time.Second,
+			expectedStatus: http.StatusOK,
+		},
+		{name: "OtherClient", body: order, remoteAddr: "10.0.0.2:1234", elapsed: time.Second, expectedStatus: http.StatusOK},
+		{name: "AfterWindow", body: order, remoteAddr: "10.0.0.1:1239", elapsed: 10 * time.Second, expectedStatus: http.StatusOK},
+	}
+
+	db := setupTestDB(t)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := NewServer([]string{"SAVE10"}, db,
+		WithDuplicateWindow(10*time.Second),
+		WithClock(func() time.Time { return now }))
+
+	// Subtests run in order and share the server, each elapsed after the previous one
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.elapsed)
+			req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(tt.body))
+			req.Header.Set("api_key", apiKey)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+
+			s.PlaceOrder(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+		})
+	}
+}
+
+// TestServer_PlaceOrder_DuplicateWindowDisabled verifies identical orders are
+// all placed without WithDuplicateWindow
+func TestServer_PlaceOrder_DuplicateWindowDisabled(t *testing.T) {
+	s := NewServer(nil, setupTestDB(t))
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewBufferString(`{"items":[{"productId":"PROD1","quantity":1}]}`))
+		req.Header.Set("api_key", apiKey)
+		w := httptest.NewRecorder()
+
+		s.PlaceOrder(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+// TestDuplicateDetector_Release verifies an order that failed to
+// be created can be retried at once
+func TestDuplicateDetector_Release(t *testing.T) {
+	d := &duplicateDetector{window: time.Minute, placed: make(map[orderHash]time.Time)}
+	now := time.Now()
+	h := orderHash{1}
+
+	require.True(t, d.claim(h, now))
+	require.False(t, d.claim(h, now.Add(time.Second)))
+	d.release(h, now)
+	assert.True(t, d.claim(h, now.Add(time.Second)))
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Sentinel errors returned by the database and validation layer.
+// Handlers map them to HTTP status codes with statusForError instead of
+// matching on error strings.
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrOrderNotFound   = errors.New("order not found")
+	ErrOrderExists     = errors.New("order ID already used")
+	ErrCouponInvalid   = errors.New("invalid coupon code")
+	ErrCouponExpired   = errors.New("coupon code has expired")
+	ErrProductInUse    = errors.New("product is referenced by orders")
+	ErrInvalidSort     = errors.New("invalid sort value")
+	ErrInvalidCategory = errors.New("invalid product category")
+	ErrAmountOverflow  = errors.New("amount out of range")
+)
+
+// statusForError maps an error to the HTTP status code the handlers respond with.
+// Errors that don't wrap one of the sentinels are treated as internal errors.
+func statusForError(err error) int {
+	switch {
+	case isTransient(err):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrInvalidSort), errors.Is(err, ErrInvalidCategory), errors.Is(err, ErrAmountOverflow):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrProductNotFound), errors.Is(err, ErrOrderNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrCouponInvalid), errors.Is(err, ErrCouponExpired):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrProductInUse), errors.Is(err, ErrOrderExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// isTransient reports whether err is a database error that may succeed if retried,
+// such as SQLITE_BUSY when another connection holds the write lock.
+func isTransient(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusForError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+	}{
+		{
+			name:           "InvalidSort",
+			err:            ErrInvalidSort,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "InvalidCategory",
+			err:            ErrInvalidCategory,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "AmountOverflow",
+			err:            fmt.Errorf("product PROD1: %w", ErrAmountOverflow),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "OrderExists",
+			err:            ErrOrderExists,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "ProductNotFound",
+			err:            ErrProductNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "OrderNotFound",
+			err:            ErrOrderNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "CouponInvalid",
+			err:            ErrCouponInvalid,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "CouponExpired",
+			err:            ErrCouponExpired,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "ProductInUse",
+			err:            ErrProductInUse,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "WrappedSentinel",
+			err:            fmt.Errorf("one or more products not found: %w", ErrProductNotFound),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "UnknownError",
+			err:            errors.New("database is locked"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedStatus, statusForError(tt.err))
+		})
+	}
+}
+
+func TestValidateCoupon(t *testing.T) {
+	server := NewServer([]string{"SAVE10"}, nil)
+	empty := ""
+	valid := "SAVE10"
+	invalid := "INVALID"
+
+	assert.NoError(t, server.validateCoupon(nil))
+	assert.NoError(t, server.validateCoupon(&empty))
+	assert.NoError(t, server.validateCoupon(&valid))
+	assert.ErrorIs(t, server.validateCoupon(&invalid), ErrCouponInvalid)
+}
+
+// BenchmarkValidateCoupon checks coupon lookups take the same time however
+// many promo codes are loaded
+func BenchmarkValidateCoupon(b *testing.B) {
+	for _, numCodes := range []int{1_000, 100_000, 1_000_000} {
+		codes := make([]string, numCodes)
+		for i := range codes {
+			codes[i] = fmt.Sprintf("CODE%06d", i)
+		}
+		server := NewServer(codes, nil)
+		valid, invalid := codes[numCodes-1], "NOTACODE"
+
+		b.Run(fmt.Sprintf("codes=%d", numCodes), func(b *testing.B) {
+			for b.Loop() {
+				if err := server.validateCoupon(&valid); err != nil {
+					b.Fatal(err)
+				}
+				if err := server.validateCoupon(&invalid); err == nil {
+					b.Fatal("expected an invalid coupon")
+				}
+			}
+		})
+	}
+}
+
+// TestValidateCoupon_Expiry verifies a coupon stays valid until the end of its
+// last day in the configured zone, not until midnight UTC
+func TestValidateCoupon_Expiry(t *testing.T) {
+	// New York in winter, 5 hours behind UTC
+	est := time.FixedZone("EST", -5*60*60)
+	// Only the date matters, whatever zone the time is in
+	lastDay := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	endOfDay := time.Date(2025, 2, 1, 0, 0, 0, 0, est)
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected error
+	}{
+		{name: "Earlier in the day", now: time.Date(2025, 1, 31, 9, 0, 0, 0, est)},
+		{name: "Past midnight UTC", now: time.Date(2025, 2, 1, 0, 30, 0, 0, time.UTC)},
+		{name: "Just before end of day", now: endOfDay.Add(-time.Second)},
+		{name: "At end of day", now: endOfDay, expected: ErrCouponExpired},
+		{name: "Just after end of day", now: endOfDay.Add(time.Second).In(time.UTC), expected: ErrCouponExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer([]string{"WINTER", "FOREVER"}, nil,
+				WithCouponExpiry(map[string]time.Time{"WINTER": lastDay}, est),
+				WithClock(func() time.Time { return tt.now }),
+			)
+			expiring, forever := "WINTER", "FOREVER"
+
+			if tt.expected != nil {
+				assert.ErrorIs(t, server.validateCoupon(&expiring), tt.expected)
+			} else {
+				assert.NoError(t, server.validateCoupon(&expiring))
+			}
+			assert.NoError(t, server.validateCoupon(&forever), "Coupons without an expiry never expire")
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How long a health check waits for the database to answer a ping
+const healthPingTimeout = 2 * time.Second
+
+// WithDBReconnect has MonitorDB ping the database every interval and, when
+// the ping fails, reopen it with open through ReloadDB. Without it MonitorDB
+// returns immediately and ReloadDB fails.
+func WithDBReconnect(interval time.Duration, open func() (*sql.DB, error)) Option {
+	return func(s *Server) {
+		s.dbCheckInterval = interval
+		s.openDB = open
+	}
+}
+
+// conn returns the current database connection, which ReloadDB may replace
+func (s *Server) conn() *sql.DB {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db
+}
+
+// ReloadDB opens a new database connection with the opener set by
+// WithDBReconnect and swaps it in for the current one, which is closed.
+// Requests already using the old connection may fail; later ones use the new
+// one. On error the current connection is kept.
+func (s *Server) ReloadDB() error {
+	if s.openDB == nil {
+		return errors.New("no database opener configured")
+	}
+	db, err := s.openDB()
+	if err != nil {
+		return fmt.Errorf("failed to reopen database: %w", err)
+	}
+
+	s.dbMu.Lock()
+	old := s.db
+	s.db = db
+	s.dbMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Close closes the current database connection
+func (s *Server) Close() error {
+	return s.conn().Close()
+}
+
+// MonitorDB pings the database at the interval set by WithDBReconnect until
+// ctx is done, reopening it with ReloadDB whenever a ping fails. It logs when
+// the connection is lost and when it recovers. Run it in its own goroutine.
+func (s *Server) MonitorDB(ctx context.Context) {
+	if s.dbCheckInterval <= 0 || s.openDB == nil {
+		return
+	}
+
+	ticker := time.NewTicker(s.dbCheckInterval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := s.pingDB(ctx)
+		if err == nil {
+			continue
+		}
+		if healthy {
+			log.Printf("Database ping failed, reopening: %v", err)
+			healthy = false
+		}
+
+		if err := s.ReloadDB(); err != nil {
+			log.Printf("Database still unavailable: %v", err)
+			continue
+		}
+		if err := s.pingDB(ctx); err != nil {
+			log.Printf("Database still unavailable after reopening: %v", err)
+			continue
+		}
+		log.Printf("Database connection recovered")
+		healthy = true
+	}
+}
+
+// pingDB checks the current database connection answers
+func (s *Server) pingDB(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	defer cancel()
+	return s.conn().PingContext(ctx)
+}
+
+// HealthCheck reports whether the database is reachable, for load balancer
+// checks. It needs no API key.
+func (s *Server) HealthCheck(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, r)
+}
+
+// GetHealth serves /healthz, the same check as HealthCheck under the path
+// orchestrators such as Kubernetes conventionally probe
+func (s *Server) GetHealth(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, r)
+}
+
+// writeHealth pings the database with a short timeout and responds 200 with
+// status ok, or 503 with status unavailable if it doesn't answer
+func (s *Server) writeHealth(w http.ResponseWriter, r *http.Request) {
+	status, health := http.StatusOK, Health{Status: Ok}
+	if err := s.pingDB(r.Context()); err != nil {
+		log.Printf("Health check failed: %v", err)
+		status, health = http.StatusServiceUnavailable, Health{Status: Unavailable}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
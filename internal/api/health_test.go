@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dbPath returns the file of the main database of db
+func dbPath(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	var seq int
+	var name, file string
+	require.NoError(t, db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file))
+	return file
+}
+
+// getHealth calls a health check path without an API key and returns its
+// status code and body
+func getHealth(t *testing.T, s *Server, path string) (int, Health) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	w := httptest.NewRecorder()
+	s.Routes().ServeHTTP(w, req)
+
+	var health Health
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&health))
+	return w.Code, health
+}
+
+func TestServer_HealthCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		closeDB        bool
+		expectedStatus int
+		expectedHealth HealthStatus
+	}{
+		{name: "Healthy", path: "/health", expectedStatus: http.StatusOK, expectedHealth: Ok},
+		{name: "Closed DB", path: "/health", closeDB: true, expectedStatus: http.StatusServiceUnavailable, expectedHealth: Unavailable},
+		{name: "Healthy healthz", path: "/healthz", expectedStatus: http.StatusOK, expectedHealth: Ok},
+		{name: "Closed DB healthz", path: "/healthz", closeDB: true, expectedStatus: http.StatusServiceUnavailable, expectedHealth: Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			s := NewServer(nil, db)
+			if tt.closeDB {
+				db.Close()
+			}
+
+			code, health := getHealth(t, s, tt.path)
+			assert.Equal(t, tt.expectedStatus, code)
+			assert.Equal(t, tt.expectedHealth, health.Status)
+		})
+	}
+}
+
+// TestServer_MonitorDB verifies a server whose connection was closed recovers
+// once MonitorDB reopens the database
+func TestServer_MonitorDB(t *testing.T) {
+	db := setupTestDB(t)
+	path := dbPath(t, db)
+
+	opens := make(chan struct{}, 10)
+	s := NewServer(nil, db, WithDBReconnect(10*time.Millisecond, func() (*sql.DB, error) {
+		opens <- struct{}{}
+		return InitDB(path)
+	}))
+	defer s.Close()
+
+	require.NoError(t, db.Close())
+	code, _ := getHealth(t, s, "/healthz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.MonitorDB(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool {
+		code, _ := getHealth(t, s, "/healthz")
+		return code == http.StatusOK
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.NotEmpty(t, opens, "the database should have been reopened")
+
+	// Requests use the reopened connection and see the same data
+	req := httptest.NewRequest(http.MethodGet, "/product", nil)
+	w := httptest.NewRecorder()
+	s.Routes().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var products []Product
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&products))
+	assert.NotEmpty(t, products)
+}
+
+func TestServer_ReloadDB(t *testing.T) {
+	t.Run("No opener", func(t *testing.T) {
+		s := NewServer(nil, setupTestDB(t))
+		assert.Error(t, s.ReloadDB())
+	})
+
+	t.Run("Failed reopen keeps the connection", func(t *testing.T) {
+		db := setupTestDB(t)
+		s := NewServer(nil, db, WithDBReconnect(time.Minute, func() (*sql.DB, error) {
+			return nil, assert.AnError
+		}))
+
+		err := s.ReloadDB()
+		require.ErrorIs(t, err, assert.AnError)
+		assert.Same(t, db, s.conn())
+		assert.NoError(t, db.Ping())
+	})
+}
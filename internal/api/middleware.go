@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Recover returns a middleware that turns a panic in a handler into a 500
+// with a JSON error body, instead of letting it take down the server.
+// The panic is logged with its stack under a request id, which is returned
+// in the body so a report can be matched to the log.
+func Recover() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				// ErrAbortHandler is how a handler deliberately aborts a response
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				requestID := uuid.NewString()
+				log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID, rec, debug.Stack())
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error":     "Internal server error",
+					"requestId": requestID,
+				})
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MaxQueryLength returns a middleware that rejects requests whose query
+// string is longer than n bytes with a 414 and a JSON error body, before a
+// handler parses it, e.g. a product search with gigantic parameters.
+func MaxQueryLength(n int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(r.URL.RawQuery) > n {
+				writeError(w, http.StatusRequestURITooLong, "Query string too long")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// statusRecorder is a ResponseWriter that records the status code and the
+// number of body bytes of the response it passes on
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	// Later calls are ignored by the wrapped writer too
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the implicit 200 a handler gets by writing without WriteHeader
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer, e.g. to flush
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// RequestLog returns a middleware that logs every request to logger once it
+// completes, as a "request" entry with its method, path, status, durationMs and
+// bytes, the size of the response body. A handler that writes nothing is
+// logged with the implicit 200.
+func RequestLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Float64("durationMs", float64(time.Since(start).Microseconds())/1000),
+				slog.Int64("bytes", rec.bytes),
+			)
+		})
+	}
+}
+
+// Scope is what an API key may do
+type Scope string
+
+const (
+	// ScopeRead keys may only make read requests, e.g. to browse the menu
+	ScopeRead Scope = "read"
+	// ScopeReadWrite keys may also place orders
+	ScopeReadWrite Scope = "read-write"
+)
+
+// RequireScope returns a middleware that rejects requests changing state,
+// i.e. anything but GET, HEAD and OPTIONS, made with a read-only key in keys.
+// They get a 403 with a JSON error body. Requests without a key, or with an
+// unknown one, are passed on for the handler to authenticate if it needs to.
+func RequireScope(keys map[string]Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope, ok := keys[r.Header.Get("api_key")]
+			readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+			if ok && scope != ScopeReadWrite && !readOnly {
+				writeError(w, http.StatusForbidden, "API key is read-only")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// partnerContextKey is the request context key of the partner an API key was issued to
+type partnerContextKey struct{}
+
+// IdentifyPartner returns a middleware attaching to the request context the
+// partner in partners that the request's api_key was issued to, for
+// PartnerFromContext. Requests with any other key pass through unchanged.
+func IdentifyPartner(partners map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if partner, ok := partners[r.Header.Get("api_key")]; ok {
+				r = r.WithContext(context.WithValue(r.Context(), partnerContextKey{}, partner))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PartnerFromContext returns the partner set by IdentifyPartner, and false if
+// the request's API key isn't issued to a named partner
+func PartnerFromContext(ctx context.Context) (string, bool) {
+	partner, ok := ctx.Value(partnerContextKey{}).(string)
+	return partner, ok
+}
+
+// Timeout returns a middleware that bounds how long a handler may run.
+// Handlers exceeding the timeout get a 503 with a JSON error body, and the
+// request context is cancelled so in-flight database work can stop early.
+//
+// TimeoutHandler buffers the whole response, so requests for skipPaths bypass
+// the timeout; use it for streaming responses such as exports. Paths are
+// matched within the router the middleware belongs to, so they still match
+// when it is mounted under a prefix.
+func Timeout(timeout time.Duration, skipPaths ...string) func(http.Handler) http.Handler {
+	body, _ := json.Marshal(map[string]string{
+		"error": "Request timed out",
+	})
+
+	return func(next http.Handler) http.Handler {
+		th := http.TimeoutHandler(next, timeout, string(body))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(skipPaths, routePath(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// TimeoutHandler doesn't set a Content-Type for its error body.
+			// Handlers that complete in time overwrite this with their own.
+			w.Header().Set("Content-Type", "application/json")
+			th.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routePath returns the path of r within the chi router serving it, without
+// the prefix it is mounted under, as chi routes it
+func routePath(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+		return rctx.RoutePath
+	}
+	return r.URL.Path
+}
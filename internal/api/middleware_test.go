@@ -0,0 +1,294 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		mount          string
+		path           string
+		handlerDelay   time.Duration
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "FastHandler",
+			path:           "/product",
+			handlerDelay:   0,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "SlowHandler",
+			path:           "/product",
+			handlerDelay:   time.Second,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedError:  "Request timed out",
+		},
+		{
+			name:           "SkippedPath",
+			path:           "/orders/export.csv",
+			handlerDelay:   100 * time.Millisecond,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "MountedSlowHandler",
+			mount:          "/api",
+			path:           "/api/product",
+			handlerDelay:   time.Second,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedError:  "Request timed out",
+		},
+		{
+			// Skipped paths are relative to where the router is mounted
+			name:           "MountedSkippedPath",
+			mount:          "/api",
+			path:           "/api/orders/export.csv",
+			handlerDelay:   100 * time.Millisecond,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.handlerDelay):
+				case <-r.Context().Done():
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			var h http.Handler = Timeout(50*time.Millisecond, "/orders/export.csv")(handler)
+			if tt.mount != "" {
+				parent := chi.NewRouter()
+				parent.Mount(tt.mount, h)
+				h = parent
+			}
+			h.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+			if tt.expectedError != "" {
+				var errResp map[string]string
+				err := json.NewDecoder(resp.Body).Decode(&errResp)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedError, errResp["error"])
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Wrapped in Timeout as in Routes, which re-panics on the serving goroutine
+	handler := Recover()(Timeout(time.Second)(panicking))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/panic")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "Internal server error", body["error"])
+	assert.NotEmpty(t, body["requestId"])
+
+	// The server keeps serving after the panic
+	resp, err = http.Get(server.URL + "/ok")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMaxQueryLength(t *testing.T) {
+	s := NewServer(nil, setupTestDB(t), WithMaxQueryLength(64))
+	handler := s.Routes()
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{name: "NoQuery", expectedStatus: http.StatusOK},
+		{name: "Short", query: "?limit=10", expectedStatus: http.StatusOK},
+		{name: "AtLimit", query: "?limit=10&x=" + strings.Repeat("a", 64-len("limit=10&x=")), expectedStatus: http.StatusOK},
+		{name: "OverLimit", query: "?limit=10&x=" + strings.Repeat("a", 65-len("limit=10&x=")), expectedStatus: http.StatusRequestURITooLong},
+		{name: "Huge", query: "?q=" + strings.Repeat("a", 100_000), expectedStatus: http.StatusRequestURITooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/product"+tt.query, nil)
+			req.Header.Set("api_key", apiKey)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusRequestURITooLong {
+				assert.JSONEq(t, `{"error":"Query string too long"}`, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIdentifyPartner(t *testing.T) {
+	var partner string
+	var identified bool
+	handler := IdentifyPartner(map[string]string{
+		"key-acme":   "Acme",
+		"key-globex": "Globex",
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		partner, identified = PartnerFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name               string
+		apiKey             string
+		expectedPartner    string
+		expectedIdentified bool
+	}{
+		{name: "FirstPartner", apiKey: "key-acme", expectedPartner: "Acme", expectedIdentified: true},
+		{name: "SecondPartner", apiKey: "key-globex", expectedPartner: "Globex", expectedIdentified: true},
+		{name: "UnnamedKey", apiKey: apiKey},
+		{name: "NoKey"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/product", nil)
+			if tt.apiKey != "" {
+				req.Header.Set("api_key", tt.apiKey)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.expectedIdentified, identified)
+			assert.Equal(t, tt.expectedPartner, partner)
+		})
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		expectedStatus int
+		expectedBytes  int64
+	}{
+		{
+			name: "ExplicitStatus",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, http.StatusNotFound, "Product not found")
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBytes:  int64(len(`{"error":"Product not found"}` + "\n")),
+		},
+		{
+			name: "ImplicitStatusOnWrite",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBytes:  5,
+		},
+		{
+			name:           "NothingWritten",
+			handler:        func(w http.ResponseWriter, r *http.Request) {},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "SecondWriteHeaderIgnored",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logged := RequestLog(slog.New(slog.NewJSONHandler(&buf, nil)))(tt.handler)
+
+			req := httptest.NewRequest(http.MethodPost, "/order?debug=1", nil)
+			w := httptest.NewRecorder()
+			logged.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var entry struct {
+				Msg        string  `json:"msg"`
+				Method     string  `json:"method"`
+				Path       string  `json:"path"`
+				Status     int     `json:"status"`
+				DurationMs float64 `json:"durationMs"`
+				Bytes      int64   `json:"bytes"`
+			}
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &entry), buf.String())
+			assert.Equal(t, "request", entry.Msg)
+			assert.Equal(t, http.MethodPost, entry.Method)
+			assert.Equal(t, "/order", entry.Path)
+			assert.Equal(t, tt.expectedStatus, entry.Status)
+			assert.GreaterOrEqual(t, entry.DurationMs, 0.0)
+			assert.Equal(t, tt.expectedBytes, entry.Bytes)
+		})
+	}
+}
+
+// TestServer_RequestLog verifies Routes logs requests with WithRequestLog,
+// including those for unknown routes
+func TestServer_RequestLog(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewServer(nil, setupTestDB(t), WithRequestLog(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	for _, path := range []string{"/product", "/no-such-route"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		s.Routes().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var entries []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry map[string]any
+		require.NoError(t, dec.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/product", entries[0]["path"])
+	assert.EqualValues(t, http.StatusOK, entries[0]["status"])
+	assert.Positive(t, entries[0]["bytes"])
+	assert.Equal(t, "/no-such-route", entries[1]["path"])
+	assert.EqualValues(t, http.StatusNotFound, entries[1]["status"])
+}
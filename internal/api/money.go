@@ -0,0 +1,144 @@
+package api
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in cents. Prices and totals are Money so arithmetic on
+// them is exact, and they always serialise with two decimals, e.g. 12.50.
+type Money int64
+
+// MoneyFromFloat converts an amount in dollars to Money, rounding to the nearest cent
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * 100))
+}
+
+// Float64 returns the amount in dollars
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// Mul returns the amount multiplied by a quantity, e.g. a unit price by the number of units
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// MulChecked is Mul that fails with ErrAmountOverflow instead of wrapping
+// around when the product doesn't fit in an int64
+func (m Money) MulChecked(quantity int) (Money, error) {
+	product := m * Money(quantity)
+	if quantity != 0 && (product/Money(quantity) != m || (quantity == -1 && m == math.MinInt64)) {
+		return 0, fmt.Errorf("%s times %d: %w", m, quantity, ErrAmountOverflow)
+	}
+	return product, nil
+}
+
+// AddChecked returns m+n, failing with ErrAmountOverflow instead of wrapping
+// around when the sum doesn't fit in an int64
+func (m Money) AddChecked(n Money) (Money, error) {
+	sum := m + n
+	if (n > 0 && sum < m) || (n < 0 && sum > m) {
+		return 0, fmt.Errorf("%s plus %s: %w", m, n, ErrAmountOverflow)
+	}
+	return sum, nil
+}
+
+// String formats the amount in dollars with two decimals, e.g. "12.50"
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// ParseMoney parses an amount in dollars with at most two decimals, e.g. "12.5"
+func ParseMoney(s string) (Money, error) {
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	negative := strings.HasPrefix(whole, "-")
+	digits := strings.TrimPrefix(whole, "-")
+	if digits == "" || strings.HasPrefix(digits, "+") || (hasFrac && (frac == "" || len(frac) > 2)) {
+		return 0, fmt.Errorf("invalid amount %q: must be dollars with at most two decimals", s)
+	}
+
+	dollars, err := strconv.ParseUint(digits, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	var cents uint64
+	if hasFrac {
+		for len(frac) < 2 {
+			frac += "0"
+		}
+		cents, err = strconv.ParseUint(frac, 10, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+		}
+	}
+	if dollars > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("invalid amount %q: out of range", s)
+	}
+
+	m := Money(dollars*100 + cents)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// MarshalJSON encodes the amount as a JSON number with two decimals, e.g. 12.50
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes an amount from a JSON number or string with at most two decimals
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan implements sql.Scanner, reading an amount in dollars from a REAL or INTEGER column
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case float64:
+		*m = MoneyFromFloat(v)
+	case int64:
+		*m = Money(v * 100)
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+// scanString parses a textual column value, which SQLite may hold with any number of decimals
+func (m *Money) scanString(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into Money: %w", s, err)
+	}
+	*m = MoneyFromFloat(f)
+	return nil
+}
+
+// Value implements driver.Valuer, storing the amount in dollars to match the REAL columns
+func (m Money) Value() (driver.Value, error) {
+	return m.Float64(), nil
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoney_JSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		money    Money
+		expected string
+	}{
+		{name: "Whole", money: 1200, expected: "12.00"},
+		{name: "Cents", money: 849, expected: "8.49"},
+		{name: "TrailingZero", money: 1250, expected: "12.50"},
+		{name: "UnderADollar", money: 5, expected: "0.05"},
+		{name: "Zero", money: 0, expected: "0.00"},
+		{name: "Negative", money: -150, expected: "-1.50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.money)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(data))
+
+			var decoded Money
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			assert.Equal(t, tt.money, decoded)
+		})
+	}
+}
+
+func TestMoney_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Money
+		wantErr  bool
+	}{
+		{input: `12.5`, expected: 1250},
+		{input: `12`, expected: 1200},
+		{input: `"8.49"`, expected: 849},
+		{input: `0.1`, expected: 10},
+		{input: `12.345`, wantErr: true},
+		{input: `1e3`, wantErr: true},
+		{input: `"abc"`, wantErr: true},
+		{input: `12.`, wantErr: true},
+		{input: `.5`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var m Money
+			err := json.Unmarshal([]byte(tt.input), &m)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, m)
+		})
+	}
+}
+
+// TestMoney_Arithmetic verifies line totals add up exactly, where floats would drift
+func TestMoney_Arithmetic(t *testing.T) {
+	var total Money
+	for i := 0; i < 10; i++ {
+		total += Money(10).Mul(1) // 0.10 ten times
+	}
+	assert.Equal(t, Money(100), total)
+	assert.Equal(t, "1.00", total.String())
+
+	total = Money(1099).Mul(3) + Money(249).Mul(2)
+	assert.Equal(t, "37.95", total.String())
+	assert.InDelta(t, 37.95, total.Float64(), 1e-9)
+}
+
+func TestMoney_DB(t *testing.T) {
+	db := setupTestDB(t)
+
+	// REAL columns round to the nearest cent
+	var m Money
+	require.NoError(t, db.QueryRow("SELECT 0.1 + 0.2").Scan(&m))
+	assert.Equal(t, Money(30), m)
+	require.NoError(t, db.QueryRow("SELECT 7").Scan(&m))
+	assert.Equal(t, Money(700), m)
+
+	// Values are stored as dollars
+	_, err := db.Exec("UPDATE products SET price = ? WHERE id = 'PROD1'", Money(1999))
+	require.NoError(t, err)
+	var price float64
+	require.NoError(t, db.QueryRow("SELECT price FROM products WHERE id = 'PROD1'").Scan(&price))
+	assert.Equal(t, 19.99, price)
+
+	assert.Error(t, db.QueryRow("SELECT NULL").Scan(&m), "NULL needs sql.Null[Money]")
+}
@@ -0,0 +1,87 @@
+package api
+
+import "fmt"
+
+// tieredUnitPrice returns the unit price of a product ordered in the given
+// quantity. The applicable tier with the largest discount wins, and the price
+// never drops below zero. Bulk pricing is applied before any coupon discount.
+func tieredUnitPrice(price Money, quantity int, tiers []PriceTier) Money {
+	var discount Money
+	for _, tier := range tiers {
+		if quantity >= tier.MinQuantity && tier.UnitDiscount > discount {
+			discount = tier.UnitDiscount
+		}
+	}
+	return max(price-discount, 0)
+}
+
+// priceOrder returns the subtotal of an order before the coupon discount and
+// the total charged, for a coupon giving discount, or none if nil, and records
+// the unit price charged for every item in its UnitPrice. How bulk pricing and
+// the discount combine depends on mode, so the total doesn't depend on the
+// order they happen to be applied in:
+//   - Stack, the default: bulk pricing applies first, then the discount is
+//     taken off the bulk priced subtotal
+//   - Best: they don't stack, and the order costs the lower of the bulk
+//     priced subtotal and the discount taken off the list price subtotal,
+//     which is then the subtotal. Bulk pricing wins ties.
+func priceOrder(items []OrderItem, products []Product, tiers map[string][]PriceTier, discount *Discount, mode OrderReqDiscountMode) (subtotal, total Money, err error) {
+	subtotal, err = orderTotal(items, products, tiers)
+	if err != nil {
+		return 0, 0, err
+	}
+	total = subtotal
+	switch {
+	case discount == nil:
+	case mode != Best:
+		total = discount.Apply(subtotal)
+	default:
+		listSubtotal, err := orderTotal(items, products, nil)
+		if err != nil {
+			return 0, 0, err
+		}
+		if couponTotal := discount.Apply(listSubtotal); couponTotal < subtotal {
+			// The coupon replaces bulk pricing, so items are charged list prices
+			tiers = nil
+			subtotal, total = listSubtotal, couponTotal
+		}
+	}
+
+	setUnitPrices(items, products, tiers)
+	return subtotal, total, nil
+}
+
+// setUnitPrices sets the UnitPrice of every item to its tiered unit price.
+// Items must refer to products present in products.
+func setUnitPrices(items []OrderItem, products []Product, tiers map[string][]PriceTier) {
+	prices := make(map[string]Money, len(products))
+	for _, p := range products {
+		prices[*p.Id] = *p.Price
+	}
+	for i, item := range items {
+		unitPrice := tieredUnitPrice(prices[item.ProductID], item.Quantity, tiers[item.ProductID])
+		items[i].UnitPrice = &unitPrice
+	}
+}
+
+// orderTotal sums the tiered price of every item.
+// Items must refer to products present in products. It fails with
+// ErrAmountOverflow if a line total or the sum doesn't fit in Money.
+func orderTotal(items []OrderItem, products []Product, tiers map[string][]PriceTier) (Money, error) {
+	prices := make(map[string]Money, len(products))
+	for _, p := range products {
+		prices[*p.Id] = *p.Price
+	}
+
+	var total Money
+	for _, item := range items {
+		line, err := tieredUnitPrice(prices[item.ProductID], item.Quantity, tiers[item.ProductID]).MulChecked(item.Quantity)
+		if err != nil {
+			return 0, fmt.Errorf("product %s: %w", item.ProductID, err)
+		}
+		if total, err = total.AddChecked(line); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredUnitPrice(t *testing.T) {
+	tiers := []PriceTier{
+		{MinQuantity: 5, UnitDiscount: 50},
+		{MinQuantity: 10, UnitDiscount: 100},
+	}
+
+	tests := []struct {
+		name     string
+		price    Money
+		quantity int
+		tiers    []PriceTier
+		expected Money
+	}{
+		{name: "NoTiers", price: 400, quantity: 20, tiers: nil, expected: 400},
+		{name: "BelowTier", price: 400, quantity: 4, tiers: tiers, expected: 400},
+		{name: "AtTier", price: 400, quantity: 5, tiers: tiers, expected: 350},
+		{name: "BestTierWins", price: 400, quantity: 12, tiers: tiers, expected: 300},
+		{name: "NeverNegative", price: 75, quantity: 10, tiers: tiers, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tieredUnitPrice(tt.price, tt.quantity, tt.tiers))
+		})
+	}
+}
+
+// TestOrderTotal verifies large carts are summed exactly in int64 cents, and
+// rejected rather than wrapped around once they no longer fit
+func TestOrderTotal(t *testing.T) {
+	var products []Product
+	for id, price := range map[string]Money{"PROD1": 1050, "PROD2": 500, "PROD3": 250} {
+		products = append(products, Product{Id: &id, Price: &price})
+	}
+	tiers := map[string][]PriceTier{"PROD3": {{MinQuantity: 10, UnitDiscount: 50}}}
+
+	tests := []struct {
+		name          string
+		items         []OrderItem
+		expected      Money
+		expectedError bool
+	}{
+		{
+			name:     "Small",
+			items:    []OrderItem{{ProductID: "PROD1", Quantity: 2}, {ProductID: "PROD3", Quantity: 10}},
+			expected: 2100 + 2000,
+		},
+		{
+			// Well past what an int32 of cents holds
+			name: "LargeQuantities",
+			items: []OrderItem{
+				{ProductID: "PROD1", Quantity: 1_000_000},
+				{ProductID: "PROD2", Quantity: 2_000_000},
+				{ProductID: "PROD3", Quantity: 3_000_000},
+			},
+			expected: 1_050_000_000 + 1_000_000_000 + 600_000_000,
+		},
+		{
+			name:     "LineAtLimit",
+			items:    []OrderItem{{ProductID: "PROD2", Quantity: math.MaxInt64 / 500}},
+			expected: math.MaxInt64 / 500 * 500,
+		},
+		{
+			name:          "LineOverflows",
+			items:         []OrderItem{{ProductID: "PROD1", Quantity: math.MaxInt64/1050 + 1}},
+			expectedError: true,
+		},
+		{
+			name: "SumOverflows",
+			items: []OrderItem{
+				{ProductID: "PROD2", Quantity: math.MaxInt64 / 500},
+				{ProductID: "PROD1", Quantity: 1},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, err := orderTotal(tt.items, products, tiers)
+			if tt.expectedError {
+				assert.ErrorIs(t, err, ErrAmountOverflow)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, total)
+		})
+	}
+}
+
+func TestPriceOrder(t *testing.T) {
+	var products []Product
+	for id, price := range map[string]Money{"PROD1": 1050, "PROD3": 250} {
+		products = append(products, Product{Id: &id, Price: &price})
+	}
+	tiers := map[string][]PriceTier{"PROD3": {{MinQuantity: 10, UnitDiscount: 50}}}
+	// 10 x PROD3 lists at 2500 and is bulk priced at 2000
+	bulk := []OrderItem{{ProductID: "PROD3", Quantity: 10}}
+
+	tests := []struct {
+		name              string
+		items             []OrderItem
+		discount          *Discount
+		mode              OrderReqDiscountMode
+		expectedSubtotal  Money
+		expectedTotal     Money
+		expectedUnitPrice Money
+		expectedError     bool
+	}{
+		{name: "NoCoupon", items: bulk, mode: Stack, expectedSubtotal: 2000, expectedTotal: 2000, expectedUnitPrice: 200},
+		{name: "NoCouponBest", items: bulk, mode: Best, expectedSubtotal: 2000, expectedTotal: 2000, expectedUnitPrice: 200},
+		{
+			// Tier first, then 10% off the tiered subtotal: 2000 - 200
+			name: "Stack", items: bulk, discount: &Discount{Percent: 10}, mode: Stack,
+			expectedSubtotal: 2000, expectedTotal: 1800, expectedUnitPrice: 200,
+		},
+		{
+			// 10% off the list price (2250) is worse than bulk pricing (2000)
+			name: "BestBulkWins", items: bulk, discount: &Discount{Percent: 10}, mode: Best,
+			expectedSubtotal: 2000, expectedTotal: 2000, expectedUnitPrice: 200,
+		},
+		{
+			// 50% off the list price (1250) beats bulk pricing (2000)
+			name: "BestCouponWins", items: bulk, discount: &Discount{Percent: 50}, mode: Best,
+			expectedSubtotal: 2500, expectedTotal: 1250, expectedUnitPrice: 250,
+		},
+		{
+			// 500 off the list price (2000) ties with bulk pricing
+			name: "BestTie", items: bulk, discount: &Discount{Amount: 500}, mode: Best,
+			expectedSubtotal: 2000, expectedTotal: 2000, expectedUnitPrice: 200,
+		},
+		{
+			// Without a tier both modes take the coupon off the same subtotal
+			name: "BestWithoutTier", items: []OrderItem{{ProductID: "PROD1", Quantity: 2}}, discount: &Discount{Percent: 10}, mode: Best,
+			expectedSubtotal: 2100, expectedTotal: 1890, expectedUnitPrice: 1050,
+		},
+		{
+			name:          "Overflows",
+			items:         []OrderItem{{ProductID: "PROD1", Quantity: math.MaxInt64/1050 + 1}},
+			discount:      &Discount{Percent: 10},
+			mode:          Best,
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := slices.Clone(tt.items)
+			subtotal, total, err := priceOrder(items, products, tiers, tt.discount, tt.mode)
+			if tt.expectedError {
+				assert.ErrorIs(t, err, ErrAmountOverflow)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedSubtotal, subtotal)
+			assert.Equal(t, tt.expectedTotal, total)
+			require.NotNil(t, items[0].UnitPrice)
+			assert.Equal(t, tt.expectedUnitPrice, *items[0].UnitPrice, "unit price charged")
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often idle buckets are swept from the limiter
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket holds the remaining budget of a single client
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client IP. Each bucket holds up to
+// burst tokens and refills at rate tokens per second.
+type rateLimiter struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// allow takes a token from the bucket of key if one is available.
+// It returns the tokens left afterwards and how long until the bucket is full again.
+func (l *rateLimiter) allow(key string, now time.Time) (ok bool, remaining float64, untilFull time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill for the time elapsed since the last request
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	}
+
+	untilFull = time.Duration((float64(l.burst) - b.tokens) / l.rate * float64(time.Second))
+	return ok, b.tokens, untilFull
+}
+
+// sweep drops buckets that have refilled completely, as they are
+// indistinguishable from a new client. Must be called with mu held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimit returns a middleware limiting each client IP to rate requests per
+// second, with bursts of up to burst requests. Responses carry the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers, the
+// latter being the Unix time at which the client's budget is full again.
+// Requests over the limit get a 429 with a JSON error body.
+func RateLimit(rate float64, burst int) func(http.Handler) http.Handler {
+	return rateLimitBy(rate, burst, clientIP)
+}
+
+// APIKeyRateLimit is RateLimit with a budget per api_key header value rather
+// than per client IP, so a partner's key is limited however many addresses it
+// calls from. Requests without a key aren't limited; they are left for the
+// handler to reject.
+func APIKeyRateLimit(rate float64, burst int) func(http.Handler) http.Handler {
+	return rateLimitBy(rate, burst, func(r *http.Request) string {
+		return r.Header.Get("api_key")
+	})
+}
+
+// rateLimitBy returns a middleware keeping a token bucket per value of key.
+// Requests whose key is empty pass through unlimited.
+func rateLimitBy(rate float64, burst int, key func(r *http.Request) string) func(http.Handler) http.Handler {
+	l := &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now()
+			ok, remaining, untilFull := l.allow(k, now)
+
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(burst))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+			h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(untilFull).Unix(), 10))
+
+			if !ok {
+				// Seconds until the next token is available
+				retryAfter := math.Ceil((1 - remaining) / rate)
+				h.Set("Retry-After", strconv.Itoa(int(retryAfter)))
+				writeError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the IP address of the client that sent r
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
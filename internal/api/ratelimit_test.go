@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	// A rate this low won't refill a token during the test
+	limited := RateLimit(0.001, 3)(handler)
+
+	tests := []struct {
+		name              string
+		remoteAddr        string
+		expectedStatus    int
+		expectedRemaining string
+	}{
+		{name: "First", remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusOK, expectedRemaining: "2"},
+		{name: "Second", remoteAddr: "10.0.0.1:1235", expectedStatus: http.StatusOK, expectedRemaining: "1"},
+		{name: "Third", remoteAddr: "10.0.0.1:1236", expectedStatus: http.StatusOK, expectedRemaining: "0"},
+		{name: "OverLimit", remoteAddr: "10.0.0.1:1237", expectedStatus: http.StatusTooManyRequests, expectedRemaining: "0"},
+		{name: "OtherClient", remoteAddr: "10.0.0.2:1234", expectedStatus: http.StatusOK, expectedRemaining: "2"},
+	}
+
+	// Subtests run in order and share the limiter
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/product", nil)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+
+			limited.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+			assert.Equal(t, tt.expectedRemaining, w.Header().Get("X-RateLimit-Remaining"))
+
+			reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+			assert.NoError(t, err)
+			assert.GreaterOrEqual(t, reset, time.Now().Unix())
+
+			if tt.expectedStatus == http.StatusTooManyRequests {
+				assert.NotEmpty(t, w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	limited := APIKeyRateLimit(0.001, 2)(handler)
+
+	tests := []struct {
+		name           string
+		key            string
+		remoteAddr     string
+		expectedStatus int
+	}{
+		{name: "First", key: "partner-a", remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusOK},
+		{name: "SecondFromOtherIP", key: "partner-a", remoteAddr: "10.0.0.2:1234", expectedStatus: http.StatusOK},
+		{name: "OverLimit", key: "partner-a", remoteAddr: "10.0.0.3:1234", expectedStatus: http.StatusTooManyRequests},
+		{name: "OtherKey", key: "partner-b", remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusOK},
+		{name: "NoKey", remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusOK},
+		{name: "NoKeyAgain", remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusOK},
+	}
+
+	// Subtests run in order and share the limiter
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/order", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.key != "" {
+				req.Header.Set("api_key", tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			limited.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusTooManyRequests {
+				assert.NotEmpty(t, w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
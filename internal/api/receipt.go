@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Width of the amount column of plain text receipts
+const receiptAmountWidth = 10
+
+// receipt is a printable summary of a placed order
+type receipt struct {
+	OrderID string
+	Lines   []receiptLine
+	// Subtotal is the sum of the lines, before the coupon discount
+	Subtotal   Money
+	CouponCode string
+	// Discount is what the coupon took off the subtotal
+	Discount Money
+	// Total is what was charged
+	Total Money
+	// Tax is the part of Total that is tax, at TaxPercent; 0 when no rate is set
+	Tax        Money
+	TaxPercent int
+}
+
+// receiptLine is an item of a receipt, priced with its bulk pricing tier
+type receiptLine struct {
+	Name      string
+	Quantity  int
+	UnitPrice Money
+	Amount    Money
+}
+
+// WithTaxRate sets the tax rate, in percent, included in menu prices, e.g. 10
+// for GST. Receipts then show how much of the total is tax. Prices and totals
+// are unchanged. Defaults to 0, which leaves tax off receipts.
+func WithTaxRate(percent int) Option {
+	return func(s *Server) {
+		s.taxPercent = percent
+	}
+}
+
+// buildReceipt prices the items of order at the unit prices charged when it
+// was placed, so later price changes don't show. Items placed before unit
+// prices were recorded are priced at the current prices of their products.
+// The total is the one charged, so any difference from the subtotal is shown
+// as the coupon discount.
+func buildReceipt(order *Order, tiers map[string][]PriceTier, taxPercent int) receipt {
+	rc := receipt{OrderID: *order.Id, TaxPercent: taxPercent}
+	for i, item := range *order.Items {
+		p := (*order.Products)[i]
+		var unitPrice Money
+		if item.UnitPrice != nil {
+			unitPrice = *item.UnitPrice
+		} else {
+			unitPrice = tieredUnitPrice(*p.Price, *item.Quantity, tiers[*p.Id])
+		}
+		line := receiptLine{
+			Name:      *p.Name,
+			Quantity:  *item.Quantity,
+			UnitPrice: unitPrice,
+			Amount:    unitPrice.Mul(*item.Quantity),
+		}
+		rc.Lines = append(rc.Lines, line)
+		rc.Subtotal += line.Amount
+	}
+
+	rc.Total = rc.Subtotal
+	if order.Total != nil {
+		rc.Total = *order.Total
+	}
+	if order.CouponCode != nil {
+		rc.CouponCode = *order.CouponCode
+		rc.Discount = max(rc.Subtotal-rc.Total, 0)
+	}
+	if taxPercent > 0 {
+		// Prices include tax, so the tax is total * rate / (1 + rate)
+		rc.Tax = (rc.Total*Money(taxPercent)*2 + Money(100+taxPercent)) / Money(2*(100+taxPercent))
+	}
+	return rc
+}
+
+// writeText writes the receipt as plain text with the amounts right aligned
+func (rc receipt) writeText(w io.Writer) error {
+	width := receiptAmountWidth
+	for _, line := range rc.Lines {
+		width = max(width, len(fmt.Sprintf("%d x %s @ %s", line.Quantity, line.Name, line.UnitPrice)))
+	}
+	row := func(label string, amount Money) string {
+		return fmt.Sprintf("%-*s %*s\n", width, label, receiptAmountWidth, amount)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Order %s\n\n", rc.OrderID)
+	for _, line := range rc.Lines {
+		b.WriteString(row(fmt.Sprintf("%d x %s @ %s", line.Quantity, line.Name, line.UnitPrice), line.Amount))
+	}
+	b.WriteString("\n")
+	b.WriteString(row("Subtotal", rc.Subtotal))
+	if rc.CouponCode != "" {
+		b.WriteString(row(fmt.Sprintf("Discount (%s)", rc.CouponCode), -rc.Discount))
+	}
+	b.WriteString(row("Total", rc.Total))
+	if rc.TaxPercent > 0 {
+		b.WriteString(row(fmt.Sprintf("Includes tax (%d%%)", rc.TaxPercent), rc.Tax))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var receiptHTML = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Order {{.OrderID}}</title></head>
+<body>
+<h1>Order {{.OrderID}}</h1>
+<table>
+<thead><tr><th>Item</th><th>Qty</th><th>Unit price</th><th>Amount</th></tr></thead>
+<tbody>
+{{- range .Lines}}
+<tr><td>{{.Name}}</td><td>{{.Quantity}}</td><td>{{.UnitPrice}}</td><td>{{.Amount}}</td></tr>
+{{- end}}
+</tbody>
+<tfoot>
+<tr><th colspan="3">Subtotal</th><td>{{.Subtotal}}</td></tr>
+{{- if .CouponCode}}
+<tr><th colspan="3">Discount ({{.CouponCode}})</th><td>-{{.Discount}}</td></tr>
+{{- end}}
+<tr><th colspan="3">Total</th><td>{{.Total}}</td></tr>
+{{- if .TaxPercent}}
+<tr><th colspan="3">Includes tax ({{.TaxPercent}}%)</th><td>{{.Tax}}</td></tr>
+{{- end}}
+</tfoot>
+</table>
+</body>
+</html>
+`))
+
+// GetOrderReceipt renders a placed order as a printable receipt, in HTML if
+// the client accepts it and plain text otherwise
+func (s *Server) GetOrderReceipt(w http.ResponseWriter, r *http.Request, orderId string) {
+	if !s.requireAPIKey(w, r) {
+		return
+	}
+
+	order, err := withRetry(r.Context(), func() (*Order, error) {
+		return GetOrderByID(r.Context(), s.conn(), orderId)
+	})
+	if errors.Is(err, ErrOrderNotFound) {
+		writeError(w, statusForError(err), "Order not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch order: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch order")
+		return
+	}
+
+	productIDs := make([]string, len(*order.Products))
+	for i, p := range *order.Products {
+		productIDs[i] = *p.Id
+	}
+	tiers, err := withRetry(r.Context(), func() (map[string][]PriceTier, error) {
+		return GetPriceTiers(r.Context(), s.conn(), productIDs)
+	})
+	if err != nil {
+		log.Printf("Failed to fetch price tiers: %v", err)
+		writeError(w, statusForError(err), "Failed to fetch product pricing")
+		return
+	}
+
+	rc := buildReceipt(order, tiers, s.taxPercent)
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		receiptHTML.Execute(w, rc)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	rc.writeText(w)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildReceipt_Tax(t *testing.T) {
+	tests := []struct {
+		name       string
+		total      Money
+		taxPercent int
+		expected   Money
+	}{
+		{name: "no rate", total: 1100, expected: 0},
+		{name: "exact", total: 1100, taxPercent: 10, expected: 100},
+		{name: "rounds down", total: 3690, taxPercent: 10, expected: 335}, // 3.3545
+		{name: "rounds up", total: 1000, taxPercent: 10, expected: 91},    // 0.9090
+		{name: "fifteen percent", total: 2300, taxPercent: 15, expected: 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := "order-1"
+			order := &Order{Id: &id, Items: &[]struct {
+				ProductId *string `json:"productId,omitempty"`
+				Quantity  *int    `json:"quantity,omitempty"`
+				UnitPrice *Money  `json:"unitPrice,omitempty"`
+			}{}, Products: &[]Product{}, Total: &tt.total}
+
+			rc := buildReceipt(order, nil, tt.taxPercent)
+			assert.Equal(t, tt.expected, rc.Tax)
+		})
+	}
+}
+
+func TestServer_GetOrderReceipt(t *testing.T) {
+	db := setupTestDB(t)
+	s := NewServer([]string{"SAVE10"}, db, WithDiscounts(map[string]Discount{"SAVE10": {Percent: 10}}), WithTaxRate(10))
+	ts := httptest.NewServer(s.Routes())
+	defer ts.Close()
+
+	get := func(path, accept string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("api_key", apiKey)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// 10 Cokes reach the bulk pricing tier, 2.00 each
+	body := `{"items":[{"productId":"PROD1","quantity":2},{"productId":"PROD3","quantity":10}],"couponCode":"SAVE10"}`
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/order", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("api_key", apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var order Order
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&order))
+	resp.Body.Close()
+	require.Equal(t, Money(3690), *order.Total)
+
+	t.Run("Text", func(t *testing.T) {
+		resp := get("/orders/"+*order.Id+"/receipt", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+		text, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		lines := strings.Split(string(text), "\n")
+
+		assert.Equal(t, "Order "+*order.Id, lines[0])
+		assertReceiptLine(t, lines, "10 x Coke @ 2.00", "20.00")
+		assertReceiptLine(t, lines, "2 x Burger @ 10.50", "21.00")
+		assertReceiptLine(t, lines, "Subtotal", "41.00")
+		assertReceiptLine(t, lines, "Discount (SAVE10)", "-4.10")
+		assertReceiptLine(t, lines, "Total", "36.90")
+		assertReceiptLine(t, lines, "Includes tax (10%)", "3.35")
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		resp := get("/orders/"+*order.Id+"/receipt", "text/html,application/xhtml+xml")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		html := string(body)
+
+		assert.Contains(t, html, "<h1>Order "+*order.Id+"</h1>")
+		assert.Contains(t, html, "<tr><td>Coke</td><td>10</td><td>2.00</td><td>20.00</td></tr>")
+		assert.Contains(t, html, "<tr><td>Burger</td><td>2</td><td>10.50</td><td>21.00</td></tr>")
+		assert.Contains(t, html, `<tr><th colspan="3">Total</th><td>36.90</td></tr>`)
+	})
+
+	t.Run("AfterPriceChange", func(t *testing.T) {
+		// Lines keep the prices charged, so they still add up to the total
+		_, err := db.Exec(`UPDATE products SET price = 12.0 WHERE id = 'PROD1';
+			DELETE FROM product_tiers WHERE product_id = 'PROD3';`)
+		require.NoError(t, err)
+
+		resp := get("/orders/"+*order.Id+"/receipt", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		text, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		lines := strings.Split(string(text), "\n")
+
+		assertReceiptLine(t, lines, "10 x Coke @ 2.00", "20.00")
+		assertReceiptLine(t, lines, "2 x Burger @ 10.50", "21.00")
+		assertReceiptLine(t, lines, "Subtotal", "41.00")
+		assertReceiptLine(t, lines, "Discount (SAVE10)", "-4.10")
+		assertReceiptLine(t, lines, "Total", "36.90")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		resp := get("/orders/no-such-order/receipt", "")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/orders/" + *order.Id + "/receipt")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+// assertReceiptLine checks a plain text receipt has a line with label on the
+// left and amount on the right
+func assertReceiptLine(t *testing.T, lines []string, label, amount string) {
+	t.Helper()
+	for _, line := range lines {
+		if strings.HasPrefix(line, label+" ") {
+			assert.Equal(t, amount, strings.TrimSpace(strings.TrimPrefix(line, label)), "Amount of %q", label)
+			return
+		}
+	}
+	assert.Fail(t, "receipt line not found", "%q in %q", label, lines)
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// Number of attempts made for a database call failing with a transient error
+	dbRetryAttempts = 3
+
+	// Delay before the first retry, doubled on every further retry
+	dbRetryBackoff = 20 * time.Millisecond
+)
+
+// withRetry calls fn, retrying it while it fails with a transient database error
+// (see isTransient). Other errors are returned immediately. Once the attempts are
+// exhausted or ctx is done, the last error is returned, which statusForError
+// maps to 503 if it's transient.
+func withRetry[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	backoff := dbRetryBackoff
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil || !isTransient(err) || attempt == dbRetryAttempts {
+			return result, err
+		}
+
+		log.Printf("Transient database error (attempt %d/%d), retrying in %s: %v", attempt, dbRetryAttempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return result, err
+		}
+		backoff *= 2
+	}
+}
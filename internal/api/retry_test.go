@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRetry(t *testing.T) {
+	busyErr := fmt.Errorf("failed to query products: %w", sqlite3.Error{Code: sqlite3.ErrBusy})
+	permanentErr := errors.New("no such table: products")
+
+	tests := []struct {
+		name             string
+		errs             []error // error returned by each successive call, nil once exhausted
+		expectedErr      error
+		expectedAttempts int
+		expectedStatus   int
+	}{
+		{
+			name:             "SucceedsFirstTime",
+			errs:             nil,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "TransientSucceedsOnRetry",
+			errs:             []error{busyErr},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "TransientExhaustsAttempts",
+			errs:             []error{busyErr, busyErr, busyErr, busyErr},
+			expectedErr:      busyErr,
+			expectedAttempts: dbRetryAttempts,
+			expectedStatus:   http.StatusServiceUnavailable,
+		},
+		{
+			name:             "PermanentNotRetried",
+			errs:             []error{permanentErr},
+			expectedErr:      permanentErr,
+			expectedAttempts: 1,
+			expectedStatus:   http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			result, err := withRetry(context.Background(), func() (string, error) {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return "", tt.errs[attempts-1]
+				}
+				return "ok", nil
+			})
+
+			assert.Equal(t, tt.expectedAttempts, attempts)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Equal(t, tt.expectedStatus, statusForError(err))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "ok", result)
+			}
+		})
+	}
+}
+
+func TestWithRetry_ContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	_, err := withRetry(ctx, func() (struct{}, error) {
+		attempts++
+		return struct{}{}, sqlite3.Error{Code: sqlite3.ErrLocked}
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts, "Cancelled context should stop retries")
+}
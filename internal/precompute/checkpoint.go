@@ -0,0 +1,136 @@
+package precompute
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Name of the checkpoint file written into Options.ResumeDir
+const checkpointFile = "checkpoint.json"
+
+// checkpoint records how far partitioning into a resume directory got. It is
+// rewritten after every input file, so a run that crashed can resume after
+// the last file it completed.
+type checkpoint struct {
+	// Files are the input files, in the order they are partitioned
+	Files []string `json:"files"`
+	// Settings describes how codes were filtered and assigned to buckets,
+	// which must be the same for the bucket files to be resumed
+	Settings string `json:"settings"`
+	// Completed is the number of input files fully partitioned
+	Completed int `json:"completed"`
+	// BucketSizes are the sizes of the bucket files, by name, once Completed
+	// files were partitioned. Anything written after is discarded on resume.
+	BucketSizes map[string]int64 `json:"bucketSizes"`
+
+	// Counts of the completed files, restored into Stats on resume
+	LinesRead        int64        `json:"linesRead"`
+	CodesRead        int64        `json:"codesRead"`
+	CodesPartitioned int64        `json:"codesPartitioned"`
+	Filtered         FilterCounts `json:"filtered"`
+}
+
+// checkpointSettings describes the options that decide what is written to
+// the bucket files, which a resumed run must share with the one it resumes
+func (o Options) checkpointSettings() string {
+	_, partitionBy, prefixLength, _ := o.bucketFunc() // Checked when the run is prepared
+	numBuckets, _ := o.bucketCount()
+	f := o.codeFilter()
+	return fmt.Sprintf("buckets=%d partitionBy=%s prefixLength=%d length=%d-%d graphemes=%t invalidUTF8=%s tokenize=%t",
+		numBuckets, partitionBy, prefixLength, f.minLength, f.maxLength, f.graphemes, f.invalidUTF8, f.tokenize)
+}
+
+// loadCheckpoint returns the checkpoint in dir, or a new one for files if dir
+// is empty. It fails if dir holds a checkpoint of other files or settings, or
+// other files without a checkpoint, so nothing unrelated is appended to. On
+// resume, bucket files are cut back to their checkpointed sizes.
+func loadCheckpoint(dir string, files []string, settings string) (*checkpoint, error) {
+	content, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read resume directory: %w", err)
+		}
+		if len(entries) > 0 {
+			return nil, fmt.Errorf("resume directory %s isn't empty and holds no checkpoint", dir)
+		}
+		return &checkpoint{Files: files, Settings: settings, BucketSizes: make(map[string]int64)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(content, &cp); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint in %s: %w", dir, err)
+	}
+	if !slices.Equal(cp.Files, files) {
+		return nil, fmt.Errorf("resume directory %s holds a checkpoint of other input files", dir)
+	}
+	if cp.Settings != settings {
+		return nil, fmt.Errorf("resume directory %s holds a checkpoint of a run with other settings: %s", dir, cp.Settings)
+	}
+	if cp.BucketSizes == nil {
+		cp.BucketSizes = make(map[string]int64)
+	}
+
+	if err := cp.restoreBuckets(dir); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// restoreBuckets cuts the bucket files in dir back to their checkpointed
+// sizes, dropping what a crashed run wrote after, possibly a torn line, and
+// removes those created since
+func (cp *checkpoint) restoreBuckets(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "bucket_*.txt"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		size, ok := cp.BucketSizes[filepath.Base(path)]
+		if !ok {
+			err = os.Remove(path)
+		} else {
+			err = os.Truncate(path, size)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore bucket file: %w", err)
+		}
+	}
+	return nil
+}
+
+// save records that completed files are partitioned into buckets, whose
+// writes must be flushed, replacing the checkpoint file in dir atomically
+func (cp *checkpoint) save(dir string, completed int, buckets *bucketSet) error {
+	for _, f := range buckets.files {
+		if f == nil {
+			continue
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to checkpoint: %w", err)
+		}
+		cp.BucketSizes[filepath.Base(f.Name())] = info.Size()
+	}
+	cp.Completed = completed
+
+	content, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	tmp := filepath.Join(dir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, checkpointFile)); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package precompute
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeResumeInput writes four input files whose valid codes each need two
+// neighbouring files, so a resumed run must combine files from both sessions
+func writeResumeInput(t *testing.T) (dir string, files []string) {
+	dir = t.TempDir()
+	contents := []string{
+		"SHARED01\nZEROONE1\n",
+		"SHARED01\nZEROONE1\nONETWO12\nshort\n",
+		"ONETWO12\nTWOTHREE\n\n",
+		"TWOTHREE\nLONELY99\n",
+	}
+	for i, content := range contents {
+		path := filepath.Join(dir, fmt.Sprintf("file%d.txt", i))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		files = append(files, path)
+	}
+	return dir, files
+}
+
+// TestFindValidCodes_Resume verifies a run interrupted after the second file
+// resumes from its checkpoint, partitioning only the remaining files, and
+// gives the same codes and counts as an uninterrupted run
+func TestFindValidCodes_Resume(t *testing.T) {
+	inputDir, files := writeResumeInput(t)
+	expected, err := FindValidCodes(inputDir, Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ONETWO12", "SHARED01", "TWOTHREE", "ZEROONE1"}, expected.Codes)
+
+	resumeDir := filepath.Join(t.TempDir(), "resume")
+	t.Cleanup(func() { testHookPartitionFile, testHookPartitioned = nil, nil })
+
+	// Interrupt the run once the first two files are partitioned
+	testHookPartitionFile = func(tempDir, filename string) {
+		if filename == files[2] {
+			panic("interrupted")
+		}
+	}
+	assert.PanicsWithValue(t, "interrupted", func() {
+		FindValidCodes(inputDir, Options{ResumeDir: resumeDir})
+	})
+
+	content, err := os.ReadFile(filepath.Join(resumeDir, checkpointFile))
+	require.NoError(t, err)
+	var cp checkpoint
+	require.NoError(t, json.Unmarshal(content, &cp))
+	assert.Equal(t, 2, cp.Completed)
+	assert.Equal(t, files, cp.Files)
+
+	// A crash may leave a torn line after the checkpoint, which must be dropped
+	buckets, err := filepath.Glob(filepath.Join(resumeDir, "bucket_*.txt"))
+	require.NoError(t, err)
+	require.NotEmpty(t, buckets)
+	f, err := os.OpenFile(buckets[0], os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("TORNCO")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	var partitioned []string
+	testHookPartitionFile = func(tempDir, filename string) {
+		partitioned = append(partitioned, filename)
+	}
+	testHookPartitioned = func(tempDir string) {
+		content, err := os.ReadFile(buckets[0])
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "TORNCO")
+	}
+
+	result, err := FindValidCodes(inputDir, Options{ResumeDir: resumeDir})
+	require.NoError(t, err)
+	assert.Equal(t, files[2:], partitioned)
+	assert.Equal(t, expected.Codes, result.Codes)
+	assert.Equal(t, expected.Stats.LinesRead, result.Stats.LinesRead)
+	assert.Equal(t, expected.Stats.CodesRead, result.Stats.CodesRead)
+	assert.Equal(t, expected.Stats.CodesFiltered, result.Stats.CodesFiltered)
+	assert.Equal(t, expected.Stats.Filtered, result.Stats.Filtered)
+	assert.NoDirExists(t, resumeDir, "the resume directory should be removed once the run succeeds")
+}
+
+func TestFindValidCodes_ResumeErrors(t *testing.T) {
+	inputDir, files := writeResumeInput(t)
+
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, resumeDir string)
+		opts        Options
+		expectedErr string
+	}{
+		{
+			name:        "read concurrency",
+			opts:        Options{ReadConcurrency: 2},
+			expectedErr: "resuming requires reading one file at a time",
+		},
+		{
+			name:        "sharded buckets",
+			opts:        Options{ShardBuckets: true},
+			expectedErr: "resuming requires reading one file at a time",
+		},
+		{
+			name: "unrelated files",
+			setup: func(t *testing.T, resumeDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(resumeDir, "notes.txt"), []byte("keep me"), 0644))
+			},
+			expectedErr: "isn't empty and holds no checkpoint",
+		},
+		{
+			name: "other input files",
+			setup: func(t *testing.T, resumeDir string) {
+				cp := checkpoint{Files: []string{"other.txt"}, Settings: Options{}.checkpointSettings()}
+				content, err := json.Marshal(cp)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(filepath.Join(resumeDir, checkpointFile), content, 0644))
+			},
+			expectedErr: "holds a checkpoint of other input files",
+		},
+		{
+			name: "other settings",
+			setup: func(t *testing.T, resumeDir string) {
+				cp := checkpoint{Files: files, Settings: Options{Buckets: 4}.checkpointSettings()}
+				content, err := json.Marshal(cp)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(filepath.Join(resumeDir, checkpointFile), content, 0644))
+			},
+			expectedErr: "holds a checkpoint of a run with other settings",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resumeDir := t.TempDir()
+			if tt.setup != nil {
+				tt.setup(t, resumeDir)
+			}
+
+			opts := tt.opts
+			opts.ResumeDir = resumeDir
+			_, err := FindValidCodes(inputDir, opts)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+			assert.DirExists(t, resumeDir, "a failed run should keep its resume directory")
+		})
+	}
+}
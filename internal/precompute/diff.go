@@ -0,0 +1,68 @@
+package precompute
+
+import (
+	"fmt"
+)
+
+// Diff compares the valid codes of two input directories, e.g. last week's
+// campaign files against this week's. Each list is sorted alphabetically.
+type Diff struct {
+	// Added are valid in the new directory only
+	Added []string
+	// Removed are valid in the old directory only
+	Removed []string
+	// Unchanged are valid in both
+	Unchanged []string
+}
+
+// DiffValidCodes finds the valid codes of oldDir and newDir with the same
+// options and compares them. Both runs are sorted alphabetically whatever
+// opts.Sort is, so the sets can be compared in a single merge pass.
+func DiffValidCodes(oldDir, newDir string, opts Options) (*Diff, error) {
+	// A code can leave the top K without becoming invalid
+	if opts.TopK > 0 {
+		return nil, fmt.Errorf("top-K runs can't be compared, as codes outside the top K are still valid")
+	}
+	// Each side would keep an arbitrary subset, reporting the rest as changed
+	if opts.MaxOutput > 0 {
+		return nil, fmt.Errorf("runs limited by MaxOutput can't be compared, as the codes kept on each side are arbitrary")
+	}
+	opts.Sort = SortAlpha
+
+	opts.progress(fmt.Sprintf("Finding valid codes in %s", oldDir))
+	oldResult, err := FindValidCodes(oldDir, opts)
+	if err != nil {
+		return nil, fmt.Errorf("old inputs %s: %w", oldDir, err)
+	}
+
+	opts.progress(fmt.Sprintf("Finding valid codes in %s", newDir))
+	newResult, err := FindValidCodes(newDir, opts)
+	if err != nil {
+		return nil, fmt.Errorf("new inputs %s: %w", newDir, err)
+	}
+
+	return diffSorted(oldResult.Codes, newResult.Codes), nil
+}
+
+// diffSorted compares two alphabetically sorted lists of distinct codes
+func diffSorted(oldCodes, newCodes []string) *Diff {
+	diff := &Diff{}
+	i, j := 0, 0
+	for i < len(oldCodes) && j < len(newCodes) {
+		switch {
+		case oldCodes[i] == newCodes[j]:
+			diff.Unchanged = append(diff.Unchanged, oldCodes[i])
+			i++
+			j++
+		case oldCodes[i] < newCodes[j]:
+			diff.Removed = append(diff.Removed, oldCodes[i])
+			i++
+		default:
+			diff.Added = append(diff.Added, newCodes[j])
+			j++
+		}
+	}
+	diff.Removed = append(diff.Removed, oldCodes[i:]...)
+	diff.Added = append(diff.Added, newCodes[j:]...)
+	return diff
+}
@@ -0,0 +1,79 @@
+package precompute
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDiffValidCodes verifies codes are split into added, removed and unchanged
+// between two directories with overlapping valid codes
+func TestDiffValidCodes(t *testing.T) {
+	tmpDir := t.TempDir()
+	dirs := map[string]map[string]string{
+		"last-week": {
+			"file1.txt": "KEEPCODE1\nOLDCODE1\nOLDCODE2\nKEEPCODE2\n",
+			"file2.txt": "KEEPCODE1\nOLDCODE1\nKEEPCODE2\n",
+			"file3.txt": "OLDCODE2\nNEWCODE1\n",
+		},
+		"this-week": {
+			"file1.txt": "KEEPCODE1\nNEWCODE1\nKEEPCODE2\nOLDCODE1\n",
+			"file2.txt": "KEEPCODE2\nNEWCODE2\n",
+			"file3.txt": "KEEPCODE1\nNEWCODE1\nNEWCODE2\n",
+		},
+	}
+	for dir, files := range dirs {
+		require.NoError(t, os.Mkdir(filepath.Join(tmpDir, dir), 0755))
+		for filename, content := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(tmpDir, dir, filename), []byte(content), 0644))
+		}
+	}
+
+	// Sort is overridden so the sets can be merged
+	diff, err := DiffValidCodes(filepath.Join(tmpDir, "last-week"), filepath.Join(tmpDir, "this-week"), Options{Sort: SortLength})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"NEWCODE1", "NEWCODE2"}, diff.Added)
+	assert.Equal(t, []string{"OLDCODE1", "OLDCODE2"}, diff.Removed)
+	assert.Equal(t, []string{"KEEPCODE1", "KEEPCODE2"}, diff.Unchanged)
+}
+
+func TestDiffValidCodes_TopK(t *testing.T) {
+	_, err := DiffValidCodes(t.TempDir(), t.TempDir(), Options{TopK: 10})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "top-K runs can't be compared")
+}
+
+func TestDiffValidCodes_MaxOutput(t *testing.T) {
+	_, err := DiffValidCodes(t.TempDir(), t.TempDir(), Options{MaxOutput: 10})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "runs limited by MaxOutput can't be compared")
+}
+
+func TestDiffSorted(t *testing.T) {
+	tests := []struct {
+		name     string
+		oldCodes []string
+		newCodes []string
+		expected Diff
+	}{
+		{name: "both empty"},
+		{name: "all added", newCodes: []string{"A", "B"}, expected: Diff{Added: []string{"A", "B"}}},
+		{name: "all removed", oldCodes: []string{"A", "B"}, expected: Diff{Removed: []string{"A", "B"}}},
+		{
+			name:     "interleaved",
+			oldCodes: []string{"A", "C", "D", "F"},
+			newCodes: []string{"B", "C", "E", "F", "G"},
+			expected: Diff{Added: []string{"B", "E", "G"}, Removed: []string{"A", "D"}, Unchanged: []string{"C", "F"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, &tt.expected, diffSorted(tt.oldCodes, tt.newCodes))
+		})
+	}
+}
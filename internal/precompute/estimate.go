@@ -0,0 +1,117 @@
+package precompute
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// sampleResolution is the granularity of the sample rate: codes are sampled
+// by comparing their hash modulo sampleResolution against the rate
+const sampleResolution = 1_000_000
+
+// minReliableSample is the number of sampled valid codes below which an
+// estimate is flagged as unreliable
+const minReliableSample = 30
+
+// Estimate is an approximate count of the valid codes of an input directory
+type Estimate struct {
+	ValidCodes   int64   `json:"validCodes"`   // Extrapolated number of valid codes
+	Margin       int64   `json:"margin"`       // Half-width of the 95% confidence interval
+	SampledValid int     `json:"sampledValid"` // Valid codes found in the sample
+	SampleRate   float64 `json:"sampleRate"`
+	Note         string  `json:"note"`
+}
+
+// EstimateValidCount estimates how many valid codes FindValidCodes would find
+// in dirPath with opts by counting them exactly for a fraction sampleRate of
+// the codes and extrapolating. Codes are filtered, need as many files and must
+// pass Accept as they would in the run; options that only change how it runs
+// are ignored.
+//
+// The sample is taken over distinct codes rather than lines: a code is either
+// sampled with every occurrence or not at all, chosen by hash. Sampling lines
+// would lose occurrences and undercount codes seen in few files. Every file is
+// still read, but only sampled codes are kept, so memory and time are a small
+// fraction of a full run.
+func EstimateValidCount(dirPath string, sampleRate float64, opts Options) (*Estimate, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("sample rate must be in (0, 1], got %g", sampleRate)
+	}
+
+	files, opts, _, err := prepareRun(dirPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	trusted, _ := opts.trustedFiles(files) // Checked when the run is prepared
+	filter := opts.codeFilter()
+	minFiles := opts.minFiles(len(files))
+
+	threshold := uint64(math.Round(sampleRate * sampleResolution))
+	if threshold == 0 {
+		return nil, fmt.Errorf("sample rate %g is below the minimum of %g", sampleRate, 1.0/sampleResolution)
+	}
+
+	// Files are read one after another, so the last file a code was seen in
+	// is enough to count the distinct files it appears in
+	type seen struct {
+		lastFile int
+		files    int
+		trusted  bool
+	}
+	sampled := make(map[string]*seen)
+	var stats Stats
+
+	for i, file := range files {
+		err := scanCodes(file, filter, &stats, func(code string) {
+			if sampleHash(code)%sampleResolution >= threshold {
+				return
+			}
+			s := sampled[code]
+			if s == nil {
+				sampled[code] = &seen{lastFile: i, files: 1, trusted: isTrusted(trusted, i)}
+				return
+			}
+			if s.lastFile != i {
+				s.lastFile = i
+				s.files++
+				s.trusted = s.trusted || isTrusted(trusted, i)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	valid := 0
+	for code, s := range sampled {
+		if s.files >= minFiles && s.trusted && (opts.Accept == nil || opts.Accept(code)) {
+			valid++
+		}
+	}
+
+	// Each valid code is in the sample with probability p, so the sampled
+	// count is binomial: the estimate is n/p with standard error sqrt(n(1-p))/p
+	p := float64(threshold) / sampleResolution
+	estimate := &Estimate{
+		ValidCodes:   int64(math.Round(float64(valid) / p)),
+		Margin:       int64(math.Round(1.96 * math.Sqrt(float64(valid)*(1-p)) / p)),
+		SampledValid: valid,
+		SampleRate:   p,
+	}
+	estimate.Note = fmt.Sprintf("%d ± %d valid codes at 95%% confidence, from %d valid codes in a %g%% sample",
+		estimate.ValidCodes, estimate.Margin, valid, p*100)
+	if valid < minReliableSample {
+		estimate.Note += "; the sample is too small for a reliable estimate, use a higher sample rate"
+	}
+
+	return estimate, nil
+}
+
+// sampleHash hashes a code for sampling. It uses 64-bit FNV-1a so the sample
+// doesn't line up with the 32-bit hash that assigns codes to buckets.
+func sampleHash(code string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(code))
+	return h.Sum64()
+}
@@ -0,0 +1,107 @@
+package precompute
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEstimateValidCount verifies the estimate lands near the exact count
+func TestEstimateValidCount(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Three files of 20k codes each: codes shared by file pairs are valid,
+	// the rest appear once
+	for f := 0; f < 3; f++ {
+		var sb strings.Builder
+		for i := 0; i < 20_000; i++ {
+			if i%2 == 0 {
+				fmt.Fprintf(&sb, "SHARED%04d\n", i/2) // In every file
+			} else {
+				fmt.Fprintf(&sb, "ONLY%d%05d\n", f, i) // In this file only
+			}
+		}
+		path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", f))
+		require.NoError(t, os.WriteFile(path, []byte(sb.String()), 0644))
+	}
+
+	exact, err := FindValidCodes(tmpDir, Options{})
+	require.NoError(t, err)
+	require.Equal(t, 10_000, exact.Stats.ValidCodes)
+
+	estimate, err := EstimateValidCount(tmpDir, 0.1, Options{})
+	require.NoError(t, err)
+
+	assert.InDelta(t, exact.Stats.ValidCodes, estimate.ValidCodes, 1500)
+	assert.InDelta(t, exact.Stats.ValidCodes, estimate.ValidCodes, float64(2*estimate.Margin))
+	assert.Positive(t, estimate.SampledValid)
+	assert.Equal(t, 0.1, estimate.SampleRate)
+	assert.Contains(t, estimate.Note, "95% confidence")
+	assert.NotContains(t, estimate.Note, "too small")
+
+	// Sampling everything is exact
+	full, err := EstimateValidCount(tmpDir, 1, Options{})
+	require.NoError(t, err)
+	assert.EqualValues(t, exact.Stats.ValidCodes, full.ValidCodes)
+	assert.Zero(t, full.Margin)
+}
+
+// TestEstimateValidCount_InvalidRate verifies rates outside (0, 1] are rejected
+func TestEstimateValidCount_InvalidRate(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("CODE1234\n"), 0644))
+
+	for _, rate := range []float64{0, -0.5, 1.5, 1e-9} {
+		_, err := EstimateValidCount(tmpDir, rate, Options{})
+		assert.Error(t, err, "rate %g", rate)
+	}
+}
+
+// TestEstimateValidCount_Options verifies a full sample counts exactly the
+// codes the run would find with the same options
+func TestEstimateValidCount_Options(t *testing.T) {
+	tmpDir := t.TempDir()
+	contents := map[string]string{
+		"official.txt": "SHORT1\nLONGERCODE99\nEIGHT888\nALL3CODE\nWORD1234 WORD5678\n\xffBADUTF8\n",
+		"partner1.txt": "SHORT1\nLONGERCODE99\nEIGHT888\nALL3CODE\nWORD1234 WORD5678\n\xffBADUTF8\nPAIR5678\n",
+		"partner2.txt": "ALL3CODE\nPAIR5678\n",
+	}
+	for name, content := range contents {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644))
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected int64
+	}{
+		{name: "defaults", opts: Options{}, expected: 4},
+		{name: "length", opts: Options{MinLength: 6, MaxLength: 12}, expected: 6},
+		{name: "min files", opts: Options{MinFiles: 3}, expected: 1},
+		{name: "require all", opts: Options{RequireAll: true}, expected: 1},
+		{name: "tokenize", opts: Options{Tokenize: true}, expected: 6},
+		{name: "skip invalid UTF-8", opts: Options{InvalidUTF8: UTF8Skip}, expected: 3},
+		{name: "trusted files", opts: Options{TrustedFiles: []string{"official.txt"}}, expected: 3},
+		{name: "accept", opts: Options{Accept: func(code string) bool { return !strings.HasPrefix(code, "EIGHT") }}, expected: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exact, err := FindValidCodes(tmpDir, tt.opts)
+			require.NoError(t, err)
+			require.EqualValues(t, tt.expected, exact.Stats.ValidCodes)
+
+			estimate, err := EstimateValidCount(tmpDir, 1, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, estimate.ValidCodes)
+		})
+	}
+
+	_, err := EstimateValidCount(tmpDir, 1, Options{LengthUnit: "furlongs"})
+	assert.Error(t, err, "invalid options should be rejected")
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package precompute
+
+import "os"
+
+// fileIDOf reports false, as FileInfo carries no device and inode here;
+// files are then compared with os.SameFile
+func fileIDOf(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,89 @@
+package precompute
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Input formats recognised by detectFormat
+const (
+	formatPlain = "plain"
+	formatGzip  = "gzip"
+	formatCSV   = "csv"
+	formatJSON  = "json"
+)
+
+// Number of leading bytes sampled from each file to classify its format
+const formatSampleSize = 512
+
+// detectFormat classifies a file by sampling its first bytes.
+// Only plain files are parsed correctly, including plain files compressed as
+// *.gz, which are sampled after decompression. The other formats are detected
+// so a mixed input directory can be reported instead of silently misparsed.
+func detectFormat(filename string) (string, error) {
+	f, err := openInputFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, formatSampleSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read file %s: %w", filename, err)
+	}
+	sample := buf[:n]
+
+	// gzip streams start with the magic bytes 0x1f 0x8b; without a .gz
+	// name the file isn't decompressed
+	if bytes.HasPrefix(sample, []byte{0x1f, 0x8b}) {
+		return formatGzip, nil
+	}
+
+	trimmed := bytes.TrimLeft(sample, " \t\r\n")
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return formatJSON, nil
+	}
+
+	firstLine, _, _ := bytes.Cut(trimmed, []byte("\n"))
+	if bytes.ContainsRune(firstLine, ',') {
+		return formatCSV, nil
+	}
+
+	return formatPlain, nil
+}
+
+// checkFormats classifies every input file and reports when they don't share a format.
+// Mixed formats are an error unless opts.AllowMixedFormats is set, in which case
+// a warning is sent through the progress callback instead.
+func checkFormats(files []string, opts Options) error {
+	byFormat := make(map[string][]string)
+	for _, file := range files {
+		format, err := detectFormat(file)
+		if err != nil {
+			return err
+		}
+		byFormat[format] = append(byFormat[format], filepath.Base(file))
+	}
+
+	if len(byFormat) <= 1 {
+		return nil
+	}
+
+	formats := make([]string, 0, len(byFormat))
+	for format, names := range byFormat {
+		formats = append(formats, fmt.Sprintf("%s (%s)", format, strings.Join(names, ", ")))
+	}
+	sort.Strings(formats)
+	msg := fmt.Sprintf("input files have inconsistent formats: %s", strings.Join(formats, "; "))
+
+	if !opts.AllowMixedFormats {
+		return fmt.Errorf("%s; use --allow-mixed-formats to process them anyway", msg)
+	}
+	opts.progress("Warning: " + msg)
+	return nil
+}
@@ -0,0 +1,81 @@
+package precompute
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{name: "plain codes", content: "HAPPYHRS\nFIFTYOFF\n", expected: formatPlain},
+		{name: "empty file", content: "", expected: formatPlain},
+		{name: "csv", content: "code,discount\nHAPPYHRS,10\n", expected: formatCSV},
+		{name: "json array", content: "  [\"HAPPYHRS\"]", expected: formatJSON},
+		{name: "json object", content: "{\"codes\": []}", expected: formatJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "codes.txt")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+
+			format, err := detectFormat(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, format)
+		})
+	}
+}
+
+// TestFindValidCodes_MixedFormats verifies a gzip file among plain files is
+// reported when it isn't named *.gz, so isn't decompressed
+func TestFindValidCodes_MixedFormats(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "file1.txt"), []byte("HAPPYHRS\nFIFTYOFF\n"), 0644))
+
+	f, err := os.Create(filepath.Join(tmpDir, "file2.dat"))
+	require.NoError(t, err)
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write([]byte("HAPPYHRS\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	t.Run("rejected by default", func(t *testing.T) {
+		_, err := FindValidCodes(tmpDir, Options{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "inconsistent formats")
+		assert.Contains(t, err.Error(), "gzip (file2.dat)")
+		assert.Contains(t, err.Error(), "plain (file1.txt)")
+	})
+
+	t.Run("warns when allowed", func(t *testing.T) {
+		var warnings []string
+		_, err := FindValidCodes(tmpDir, Options{
+			AllowMixedFormats: true,
+			Progress: func(msg string) {
+				if strings.HasPrefix(msg, "Warning:") {
+					warnings = append(warnings, msg)
+				}
+			},
+		})
+		require.NoError(t, err)
+		require.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], "inconsistent formats")
+	})
+}
@@ -0,0 +1,12 @@
+package precompute
+
+import "github.com/rivo/uniseg"
+
+// graphemeCount returns the number of user-perceived characters in s, i.e.
+// extended grapheme clusters as in Unicode UAX #29, so an accented letter
+// written with a combining mark, a flag, a family emoji joined with ZWJ or a
+// Hangul syllable written as jamo each count as one. Invalid UTF-8 counts one
+// per byte that isn't part of a valid rune.
+func graphemeCount(s string) int {
+	return uniseg.GraphemeClusterCount(s)
+}
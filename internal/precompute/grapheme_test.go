@@ -0,0 +1,44 @@
+package precompute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphemeCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		expected int
+	}{
+		{name: "Empty", s: "", expected: 0},
+		{name: "ASCII", s: "SAVE10", expected: 6},
+		{name: "Precomposed", s: "café", expected: 4},
+		{name: "CombiningMark", s: "cafe\u0301", expected: 4},
+		{name: "StackedMarks", s: "a\u0323\u0301b", expected: 2},
+		{name: "Emoji", s: "🎉party🎉!", expected: 8},
+		{name: "SkinTone", s: "👍\U0001F3FDok", expected: 3},
+		{name: "VariationSelector", s: "❤\ufe0fx", expected: 2},
+		{name: "ZWJSequence", s: "👨\u200d👩\u200d👧x", expected: 2},
+		{name: "ZWJWithSkinTones", s: "👩\U0001F3FD\u200d💻x", expected: 2},
+		{name: "ZWJFlag", s: "🏳\ufe0f\u200d🌈x", expected: 2},
+		{name: "ZWJBetweenLetters", s: "a\u200db", expected: 2},
+		{name: "Flags", s: "🇫🇷🇩🇪", expected: 2},
+		{name: "ThreeFlags", s: "🇯🇵🇰🇷🇺🇸", expected: 3},
+		{name: "UnpairedRegionalIndicator", s: "🇫🇷🇩", expected: 2},
+		{name: "SubdivisionFlag", s: "🏴\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007Fx", expected: 2},
+		{name: "HangulSyllables", s: "한국", expected: 2},
+		{name: "HangulJamo", s: "\u1112\u1161\u11ab\u1100\u116e\u11a8", expected: 2},
+		{name: "HangulSyllableWithTrailingJamo", s: "\ud55c\u11a8x", expected: 2},
+		{name: "CRLF", s: "a\r\nb", expected: 3},
+		{name: "LeadingMark", s: "\u0301a", expected: 2},
+		{name: "InvalidUTF8", s: "ab\xffc", expected: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, graphemeCount(tt.s))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package precompute
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// Extension of gzip compressed input files, e.g. codes.txt.gz
+const gzipExt = ".gz"
+
+// gzipFile is a decompressing reader over an open file
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if cerr := g.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openInputFile opens an input file for reading. A file named *.gz is
+// decompressed as it is read, so it can sit next to plain files.
+func openInputFile(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(filename, gzipExt) {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: zr, f: f}, nil
+}
+
+// newCodeScanner returns a scanner over the lines of an input file, which may
+// end in LF, CRLF or a bare CR, as in old Mac exports
+func newCodeScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, scannerInitialBuffer)
+	scanner.Buffer(buf, scannerMaxBuffer)
+	scanner.Split(scanAnyLines)
+	return scanner
+}
+
+// newBucketScanner returns a scanner over the lines of a bucket or run file.
+// Its lines hold a code read by newCodeScanner and a file index, so any code
+// that could be read from the inputs fits. The buffer starts small, as a
+// spilled bucket is merged from many run files at once.
+func newBucketScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, scannerMaxBuffer+bucketLineOverhead)
+	return scanner
+}
+
+// scanAnyLines is a bufio.SplitFunc like bufio.ScanLines that also splits on
+// a bare CR. A CRLF pair is a single line ending.
+func scanAnyLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	i := bytes.IndexAny(data, "\r\n")
+	switch {
+	case i < 0 && atEOF:
+		// Final line without a line ending
+		return len(data), data, nil
+	case i < 0:
+		return 0, nil, nil
+	case data[i] == '\n':
+		return i + 1, data[:i], nil
+	case i+1 < len(data) && data[i+1] == '\n':
+		return i + 2, data[:i], nil
+	case i+1 == len(data) && !atEOF:
+		// Read more to know whether the CR is followed by LF
+		return 0, nil, nil
+	default:
+		return i + 1, data[:i], nil
+	}
+}
@@ -0,0 +1,155 @@
+package precompute
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanAnyLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{name: "empty"},
+		{name: "LF", input: "CODE1\nCODE2\n", expected: []string{"CODE1", "CODE2"}},
+		{name: "CRLF", input: "CODE1\r\nCODE2\r\n", expected: []string{"CODE1", "CODE2"}},
+		{name: "CR", input: "CODE1\rCODE2\r", expected: []string{"CODE1", "CODE2"}},
+		{name: "no final line ending", input: "CODE1\rCODE2", expected: []string{"CODE1", "CODE2"}},
+		{name: "mixed", input: "CODE1\rCODE2\r\nCODE3\nCODE4", expected: []string{"CODE1", "CODE2", "CODE3", "CODE4"}},
+		{name: "empty lines", input: "CODE1\r\rCODE2\n\nCODE3\r\n\r\n", expected: []string{"CODE1", "", "CODE2", "", "CODE3", ""}},
+		{name: "LF CR is two line endings", input: "CODE1\n\rCODE2", expected: []string{"CODE1", "", "CODE2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reading a byte at a time splits CRLF pairs across reads
+			for _, oneByte := range []bool{false, true} {
+				var r io.Reader = strings.NewReader(tt.input)
+				if oneByte {
+					r = iotest.OneByteReader(r)
+				}
+
+				var lines []string
+				scanner := newCodeScanner(r)
+				for scanner.Scan() {
+					lines = append(lines, scanner.Text())
+				}
+				require.NoError(t, scanner.Err())
+				assert.Equal(t, tt.expected, lines, "one byte at a time: %v", oneByte)
+			}
+		})
+	}
+}
+
+// TestFindValidCodes_LineEndings verifies codes in a CR-delimited file match
+// the same codes in LF and CRLF files, by both algorithms
+func TestFindValidCodes_LineEndings(t *testing.T) {
+	codes := []string{"HAPPYHRS", "FIFTYOFF", "SUPER100"}
+	files := map[string]string{
+		"lf.txt":   strings.Join(codes, "\n") + "\n",
+		"cr.txt":   strings.Join(codes, "\r") + "\r",
+		"crlf.txt": strings.Join(codes, "\r\n") + "\r\n",
+	}
+
+	for _, names := range [][]string{{"cr.txt", "lf.txt"}, {"cr.txt", "crlf.txt"}, {"cr.txt", "crlf.txt", "lf.txt"}} {
+		t.Run(strings.Join(names, "+"), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for _, name := range names {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(files[name]), 0644))
+			}
+
+			result, err := FindValidCodes(tmpDir, Options{})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"FIFTYOFF", "HAPPYHRS", "SUPER100"}, result.Codes)
+			assert.Equal(t, int64(0), result.Stats.CodesFiltered)
+			assert.Equal(t, int64(len(codes)*len(names)), result.Stats.CodesRead)
+		})
+	}
+}
+
+// writeGzip writes content to path compressed with gzip
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+}
+
+// TestFindValidCodes_Gzip verifies *.gz files are decompressed and can be
+// mixed with plain files, by both algorithms
+func TestFindValidCodes_Gzip(t *testing.T) {
+	tests := []struct {
+		name     string
+		plain    map[string]string
+		gzipped  map[string]string
+		expected []string
+	}{
+		{
+			name:     "two files",
+			plain:    map[string]string{"file1.txt": "HAPPYHRS\nFIFTYOFF\nSHORT\n"},
+			gzipped:  map[string]string{"file2.txt.gz": "FIFTYOFF\nHAPPYHRS\nSUPER100\n"},
+			expected: []string{"FIFTYOFF", "HAPPYHRS"},
+		},
+		{
+			name:  "three files",
+			plain: map[string]string{"file1.txt": "HAPPYHRS\nFIFTYOFF\n"},
+			gzipped: map[string]string{
+				"file2.txt.gz": "FIFTYOFF\r\nSUPER100\r\nSHORT\r\n",
+				"file3.txt.gz": "SUPER100\n",
+			},
+			expected: []string{"FIFTYOFF", "SUPER100"},
+		},
+		{
+			name: "only gzip",
+			gzipped: map[string]string{
+				"file1.txt.gz": "HAPPYHRS\nFIFTYOFF\n",
+				"file2.txt.gz": "FIFTYOFF\n",
+			},
+			expected: []string{"FIFTYOFF"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			lines := 0
+			for name, content := range tt.plain {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644))
+				lines += strings.Count(content, "\n")
+			}
+			for name, content := range tt.gzipped {
+				writeGzip(t, filepath.Join(tmpDir, name), content)
+				lines += strings.Count(content, "\n")
+			}
+
+			result, err := FindValidCodes(tmpDir, Options{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.Codes)
+			assert.Equal(t, int64(lines), result.Stats.CodesRead)
+		})
+	}
+}
+
+func TestFindValidCodes_CorruptGzip(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "file1.txt"), []byte("HAPPYHRS\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "file2.txt.gz"), []byte("HAPPYHRS\nFIFTYOFF\n"), 0644))
+
+	_, err := FindValidCodes(tmpDir, Options{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "file2.txt.gz")
+	assert.ErrorIs(t, err, gzip.ErrHeader)
+}
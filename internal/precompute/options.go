@@ -0,0 +1,496 @@
+package precompute
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Output orders for Options.Sort
+const (
+	SortAlpha  = "alpha"  // Alphabetical
+	SortLength = "length" // Shortest first in LengthUnit, then alphabetical
+	SortCount  = "count"  // Most files first, then alphabetical; requires TopK
+	SortNone   = "none"   // Unspecified, skipping the final sort
+)
+
+// Bucket assignments for Options.PartitionBy
+const (
+	PartitionHash   = "hash"   // FNV-1a hash of the whole code
+	PartitionPrefix = "prefix" // First PrefixLength characters, in sort order
+)
+
+// Handling of codes that aren't valid UTF-8, for Options.InvalidUTF8
+const (
+	UTF8Keep  = "keep"  // Counted like any other code
+	UTF8Skip  = "skip"  // Dropped and counted in Stats.CodesFiltered
+	UTF8Error = "error" // Fails the run, naming the file and line
+)
+
+// Units of Options.MinLength and Options.MaxLength, for Options.LengthUnit
+const (
+	LengthBytes     = "bytes"     // Bytes of the code as read
+	LengthGraphemes = "graphemes" // User-perceived characters, so an emoji counts as one
+)
+
+// Bounds and default of Options.PrefixLength
+const (
+	defaultPrefixLength = 2
+	maxPrefixLength     = 3
+)
+
+// Options configures a precompute run.
+// The zero value reproduces the behaviour of FindValidCodesHashPartition with default workers.
+type Options struct {
+	// Workers is the number of parallel workers for bucket processing.
+	// If 0 or negative, uses runtime.NumCPU().
+	Workers int
+
+	// ReadConcurrency bounds how many input files are read at the same time
+	// while partitioning. If 0 or negative, files are read one at a time,
+	// which suits spinning disks; SSDs benefit from higher values.
+	ReadConcurrency int
+
+	// Buckets is the number of bucket files codes are partitioned into. Fewer
+	// spare small inputs the I/O of mostly empty files; more keep each bucket
+	// of a huge input small enough to process in memory. Every bucket is an
+	// open file while partitioning. If 0, 1000 is used.
+	Buckets int
+
+	// ShardBuckets gives each of the ReadConcurrency readers a private set of
+	// bucket files, so readers never wait on each other's writes, and phase 2
+	// reads every shard of a bucket. It suits NVMe storage with a high
+	// ReadConcurrency, at the cost of ReadConcurrency times as many temp files
+	// and open files. The valid codes are the same either way.
+	ShardBuckets bool
+
+	// MaxBucketBytes caps the size of a bucket processed in memory. Larger
+	// buckets, e.g. from skewed input, are sorted on disk and scanned
+	// sequentially instead. If 0 or negative, every bucket is processed in memory.
+	MaxBucketBytes int64
+
+	// DisableEarlyExit keeps tracking the files a code appears in after it is
+	// found valid, where they are normally freed. Memory use then depends only
+	// on the bucket contents, not on the order codes are read in, which gives
+	// predictable numbers for benchmarks and worst-case memory profiling at the
+	// cost of more memory. The valid codes are the same either way.
+	DisableEarlyExit bool
+
+	// TempFileMode sets the permissions of the bucket temp files written while
+	// partitioning. If 0, they are created with mode 0600.
+	TempFileMode os.FileMode
+
+	// AllowMixedFormats lets a run continue when the input files don't share
+	// a format, e.g. one is gzipped or JSON. A warning is reported instead.
+	AllowMixedFormats bool
+
+	// AllowDuplicateFiles counts an input file reached through several names,
+	// e.g. a symlink next to its target or a hard link, once per name. By
+	// default only the first name is read, since a code in that file would
+	// otherwise appear to be in two files. Skipped names are reported through
+	// Progress and recorded in Stats.DuplicateFiles.
+	AllowDuplicateFiles bool
+
+	// MinFiles is the number of input files a code must appear in to be
+	// valid. If 0, 2 is used.
+	MinFiles int
+
+	// RequireAll only accepts codes that appear in every input file, rather
+	// than in at least MinFiles of them.
+	RequireAll bool
+
+	// TopK keeps only the K valid codes that appear in the most files, ranked
+	// by file count with ties broken alphabetically. Codes are returned in
+	// rank order rather than alphabetically. If 0 or negative, all valid
+	// codes are kept. Buckets are counted in memory, ignoring MaxBucketBytes.
+	TopK int
+
+	// MaxOutput stops a run once more than this many valid codes are
+	// collected, keeping this many, as a guard against a misconfiguration
+	// filling the disk with output. Buckets finish in any order, so which codes
+	// are kept is not predictable; they are still sorted by Sort. Codes dropped
+	// by Accept are not replaced, so fewer may be returned. Stats.Truncated
+	// records that valid codes were dropped and a warning is reported through
+	// Progress. If 0 or negative, there is no cap.
+	MaxOutput int
+
+	// PartitionBy chooses how codes are assigned to buckets: PartitionHash
+	// spreads them evenly, while PartitionPrefix assigns them by their first
+	// PrefixLength characters, so each bucket holds a contiguous range of
+	// codes in sort order. Prefix buckets are uneven for codes drawn from a
+	// small alphabet, which MaxBucketBytes can offset. The valid codes are the
+	// same either way. If empty, PartitionHash is used.
+	PartitionBy string
+
+	// PrefixLength is the number of leading characters used by PartitionPrefix,
+	// from 1 to 3. If 0, 2 is used. Shorter codes are padded.
+	PrefixLength int
+
+	// Sort is the order of the returned codes: SortAlpha, SortLength,
+	// SortCount or SortNone. File counts are only computed for TopK, so
+	// SortCount requires it. If empty, codes are sorted alphabetically, or by
+	// count with TopK.
+	Sort string
+
+	// MinLength and MaxLength are the inclusive bounds on the length of a
+	// valid code, in LengthUnit. If 0, 8 and 10 are used.
+	MinLength int
+	MaxLength int
+
+	// LengthUnit is what MinLength and MaxLength count: LengthBytes, or
+	// LengthGraphemes for codes meant to be typed by people, where an
+	// accented letter or an emoji is one character however many bytes it
+	// takes. If empty, LengthBytes is used.
+	LengthUnit string
+
+	// InvalidUTF8 chooses what happens to a code that isn't valid UTF-8, e.g.
+	// from a file in a legacy encoding: UTF8Keep counts it like any other
+	// code, UTF8Skip drops it and UTF8Error fails the run. If empty, UTF8Keep
+	// is used.
+	InvalidUTF8 string
+
+	// Tokenize splits every input line on whitespace and treats each token
+	// as a candidate code, for exports that pack several codes per line. The
+	// length and encoding filters apply to each token. Not supported for
+	// tagged input.
+	Tokenize bool
+
+	// TrustedFiles are patterns, in the syntax of filepath.Match, marking the
+	// input files whose base name matches one as trusted sources. When set, a
+	// code is only valid if at least one of the files it appears in is
+	// trusted, on top of appearing in MinFiles files, e.g. a partner export
+	// that must be confirmed by an official list. At least one input file
+	// must match. Not supported for tagged input.
+	TrustedFiles []string
+
+	// ResumeDir writes the bucket files into this directory instead of a temp
+	// directory, with a checkpoint after every input file is partitioned. If
+	// it holds the checkpoint of a run over the same files and settings, e.g.
+	// one that crashed hours in, partitioning resumes after the last file
+	// completed. The directory is removed once the run succeeds and kept
+	// otherwise. The elapsed time of a resumed run only covers its part. Requires a
+	// ReadConcurrency of 1 without ShardBuckets. Not supported for tagged input;
+	// runs over two files don't partition, so they ignore it.
+	ResumeDir string
+
+	// SkipBadBuckets skips a bucket that can't be processed, e.g. because
+	// a temp file was corrupted, instead of failing the run. The codes of the
+	// other buckets are still returned; skipped buckets are counted in
+	// Stats.SkippedBuckets and reported through Progress.
+	SkipBadBuckets bool
+
+	// BucketStats records the line count, size, distinct codes and valid
+	// codes of every processed bucket in Stats.Buckets, to diagnose skew and
+	// tune the number of buckets. Top-K and two-file runs don't record them.
+	BucketStats bool
+
+	// Accept is a final filter applied to codes that are valid by length and
+	// file count, for bespoke rules such as a checksum digit. Codes it returns
+	// false for are dropped. If nil, every such code is accepted.
+	Accept func(code string) bool
+
+	// Progress receives human readable progress messages. May be nil.
+	// Messages may be sent from several goroutines at once.
+	Progress func(string)
+
+	// Verbose also reports through Progress how many buckets each worker
+	// processed, e.g. to diagnose uneven work between workers
+	Verbose bool
+}
+
+// Result holds the valid codes found by a run along with statistics about it
+type Result struct {
+	// Codes are the valid codes, in the order set by Options.Sort
+	Codes []string
+	Stats Stats
+}
+
+// Stats describes a precompute run. It is serialised as the summary sidecar.
+type Stats struct {
+	// Algorithm is the strategy used: "hash-partition" or "two-file"
+	Algorithm  string   `json:"algorithm"`
+	InputFiles []string `json:"inputFiles"`
+	// DuplicateFiles are input names skipped as the same file as an earlier one
+	DuplicateFiles []string `json:"duplicateFiles,omitempty"`
+
+	// LinesRead counts every line read from the input files, including empty ones
+	LinesRead int64 `json:"linesRead"`
+	// CodesRead counts every line read from the input files, including empty
+	// ones, or every token with Options.Tokenize
+	CodesRead int64 `json:"codesRead"`
+	// CodesFiltered counts lines dropped before counting, e.g. empty or of invalid length
+	CodesFiltered int64 `json:"codesFiltered"`
+	// Filtered breaks CodesFiltered down by why the lines were dropped
+	Filtered FilterCounts `json:"filtered"`
+	// CodesRejected counts codes valid by length and file count that Options.Accept dropped
+	CodesRejected int `json:"codesRejected"`
+	ValidCodes    int `json:"validCodes"`
+	// SkippedBuckets counts buckets dropped with Options.SkipBadBuckets; their codes are missing
+	SkippedBuckets int `json:"skippedBuckets"`
+	// Truncated is set when the run found more than Options.MaxOutput codes; the others are missing
+	Truncated bool `json:"truncated,omitempty"`
+	// Buckets are the stats of every processed bucket with Options.BucketStats,
+	// by bucket number. They are left out of the summary; see WriteBucketStatsFile.
+	Buckets []BucketStats `json:"-"`
+
+	ElapsedSeconds float64    `json:"elapsedSeconds"`
+	Parameters     Parameters `json:"parameters"`
+}
+
+// FilterCounts counts the lines, or tokens with Options.Tokenize, dropped
+// before counting for each reason. They add up to Stats.CodesFiltered.
+type FilterCounts struct {
+	Empty    int64 `json:"empty"`
+	TooShort int64 `json:"tooShort"`
+	TooLong  int64 `json:"tooLong"`
+	// InvalidUTF8 is only counted with UTF8Skip
+	InvalidUTF8 int64 `json:"invalidUTF8"`
+	// Malformed counts rows of tagged input without a file id
+	Malformed int64 `json:"malformed"`
+}
+
+// add counts a code dropped for reason; kept codes aren't counted
+func (c *FilterCounts) add(reason filterReason) {
+	switch reason {
+	case filteredEmpty:
+		c.Empty++
+	case filteredTooShort:
+		c.TooShort++
+	case filteredTooLong:
+		c.TooLong++
+	case filteredInvalidUTF8:
+		c.InvalidUTF8++
+	case filteredMalformed:
+		c.Malformed++
+	}
+}
+
+// merge adds the counts of o to c
+func (c *FilterCounts) merge(o FilterCounts) {
+	c.Empty += o.Empty
+	c.TooShort += o.TooShort
+	c.TooLong += o.TooLong
+	c.InvalidUTF8 += o.InvalidUTF8
+	c.Malformed += o.Malformed
+}
+
+// Parameters records the effective settings of a run
+type Parameters struct {
+	Workers         int `json:"workers"`
+	ReadConcurrency int `json:"readConcurrency"`
+	Buckets         int `json:"buckets"`
+	MinLength       int `json:"minLength"`
+	MaxLength       int `json:"maxLength"`
+	MinFiles        int `json:"minFiles"`
+	// MaxBucketBytes is 0 when buckets are always processed in memory
+	MaxBucketBytes int64 `json:"maxBucketBytes"`
+	// TopK is 0 when every valid code is kept
+	TopK int `json:"topK"`
+	// MaxOutput is 0 when the number of output codes isn't capped
+	MaxOutput int `json:"maxOutput,omitempty"`
+	// Sort is the order of the output codes, one of the Sort constants
+	Sort string `json:"sort"`
+	// PartitionBy is how codes were assigned to buckets, one of the Partition constants
+	PartitionBy string `json:"partitionBy"`
+	// PrefixLength is only set with PartitionPrefix
+	PrefixLength int `json:"prefixLength,omitempty"`
+	// LengthUnit is what MinLength and MaxLength count, one of the Length constants
+	LengthUnit string `json:"lengthUnit"`
+	// InvalidUTF8 is how codes that aren't valid UTF-8 were handled, one of the UTF8 constants
+	InvalidUTF8 string `json:"invalidUTF8"`
+	// NoEarlyExit is set when codes kept tracking their files after being found valid
+	NoEarlyExit bool `json:"noEarlyExit,omitempty"`
+	// Tokenize is set when lines were split into whitespace separated codes
+	Tokenize bool `json:"tokenize,omitempty"`
+	// Shards is the number of files each bucket is split into, 0 when buckets aren't sharded
+	Shards int `json:"shards,omitempty"`
+	// TrustedFiles are the patterns of Options.TrustedFiles, empty when no file is required
+	TrustedFiles []string `json:"trustedFiles,omitempty"`
+}
+
+// minFiles returns how many of numFiles input files a code must appear in to be valid
+func (o Options) minFiles(numFiles int) int {
+	if o.RequireAll {
+		return numFiles
+	}
+	if o.MinFiles != 0 {
+		return o.MinFiles
+	}
+	return defaultMinFiles
+}
+
+// trustedFiles reports which of files match TrustedFiles by base name, or
+// returns nil when no pattern is set. It fails on a malformed pattern or when
+// no file matches, since no code could then be valid.
+func (o Options) trustedFiles(files []string) ([]bool, error) {
+	if len(o.TrustedFiles) == 0 {
+		return nil, nil
+	}
+
+	trusted := make([]bool, len(files))
+	matched := false
+	for i, file := range files {
+		for _, pattern := range o.TrustedFiles {
+			ok, err := filepath.Match(pattern, filepath.Base(file))
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted file pattern %q: %w", pattern, err)
+			}
+			if ok {
+				trusted[i] = true
+				matched = true
+				break
+			}
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("no input file matches the trusted file patterns %q, so no code can be valid", o.TrustedFiles)
+	}
+	return trusted, nil
+}
+
+// isTrusted reports whether the input file fileIdx is trusted, always true
+// when trusted is nil as no trusted file is required
+func isTrusted(trusted []bool, fileIdx int) bool {
+	return trusted == nil || (fileIdx >= 0 && fileIdx < len(trusted) && trusted[fileIdx])
+}
+
+// bucketShards returns the number of shards each bucket is split into, one
+// per reader with ShardBuckets, or 0 when readers share the buckets
+func (o Options) bucketShards() int {
+	if !o.ShardBuckets {
+		return 0
+	}
+	return max(o.ReadConcurrency, 1)
+}
+
+// sortOrder returns the effective Sort of a run, or an error if it is unknown
+// or needs counts the run doesn't compute
+func (o Options) sortOrder() (string, error) {
+	switch o.Sort {
+	case "":
+		if o.TopK > 0 {
+			return SortCount, nil
+		}
+		return SortAlpha, nil
+	case SortAlpha, SortLength, SortNone:
+		return o.Sort, nil
+	case SortCount:
+		if o.TopK <= 0 {
+			return "", fmt.Errorf("sorting by count requires a top-K run, as file counts aren't kept otherwise")
+		}
+		return o.Sort, nil
+	default:
+		return "", fmt.Errorf("unknown sort order %q: must be one of %s, %s, %s or %s", o.Sort, SortAlpha, SortLength, SortCount, SortNone)
+	}
+}
+
+// utf8Mode returns the effective InvalidUTF8, or an error if it is unknown
+func (o Options) utf8Mode() (string, error) {
+	switch o.InvalidUTF8 {
+	case "":
+		return UTF8Keep, nil
+	case UTF8Keep, UTF8Skip, UTF8Error:
+		return o.InvalidUTF8, nil
+	default:
+		return "", fmt.Errorf("unknown invalid UTF-8 handling %q: must be one of %s, %s or %s", o.InvalidUTF8, UTF8Keep, UTF8Skip, UTF8Error)
+	}
+}
+
+// lengthUnit returns the effective LengthUnit, or an error if it is unknown
+func (o Options) lengthUnit() (string, error) {
+	switch o.LengthUnit {
+	case "":
+		return LengthBytes, nil
+	case LengthBytes, LengthGraphemes:
+		return o.LengthUnit, nil
+	default:
+		return "", fmt.Errorf("unknown length unit %q: must be %s or %s", o.LengthUnit, LengthBytes, LengthGraphemes)
+	}
+}
+
+// codeLength returns the length of code in unit, LengthBytes or LengthGraphemes
+func codeLength(code, unit string) int {
+	if unit == LengthGraphemes {
+		return graphemeCount(code)
+	}
+	return len(code)
+}
+
+// bucketCount returns the effective Buckets, or an error if it is negative
+func (o Options) bucketCount() (int, error) {
+	switch {
+	case o.Buckets == 0:
+		return defaultBuckets, nil
+	case o.Buckets < 0:
+		return 0, fmt.Errorf("number of buckets must be positive, got %d", o.Buckets)
+	default:
+		return o.Buckets, nil
+	}
+}
+
+// lengthBounds returns the effective MinLength and MaxLength
+func (o Options) lengthBounds() (minLength, maxLength int) {
+	minLength, maxLength = o.MinLength, o.MaxLength
+	if minLength == 0 {
+		minLength = minCodeLength
+	}
+	if maxLength == 0 {
+		maxLength = maxCodeLength
+	}
+	return minLength, maxLength
+}
+
+// codeFilter returns the filter keeping the codes counted by a run
+func (o Options) codeFilter() codeFilter {
+	minLength, maxLength := o.lengthBounds()
+	mode, _ := o.utf8Mode() // Checked when the run is prepared
+	unit, _ := o.lengthUnit()
+	return codeFilter{minLength: minLength, maxLength: maxLength, graphemes: unit == LengthGraphemes, invalidUTF8: mode, tokenize: o.Tokenize}
+}
+
+// bucketFunc returns the function assigning codes to buckets for PartitionBy,
+// along with the effective PartitionBy and PrefixLength
+func (o Options) bucketFunc() (bucketOf func(code string, numBuckets int) int, partitionBy string, prefixLength int, err error) {
+	switch o.PartitionBy {
+	case "", PartitionHash:
+		return hashCode, PartitionHash, 0, nil
+	case PartitionPrefix:
+		k := o.PrefixLength
+		if k == 0 {
+			k = defaultPrefixLength
+		}
+		if k < 1 || k > maxPrefixLength {
+			return nil, "", 0, fmt.Errorf("prefix length must be between 1 and %d, got %d", maxPrefixLength, k)
+		}
+		return prefixBucketer(k), PartitionPrefix, k, nil
+	default:
+		return nil, "", 0, fmt.Errorf("unknown partition mode %q: must be %s or %s", o.PartitionBy, PartitionHash, PartitionPrefix)
+	}
+}
+
+// validateParameters checks that the effective parameters of a run can match
+// at least one code, so an impossible configuration fails before any input is
+// read instead of silently producing no codes. numFiles is the number of input
+// files; 0 skips the checks that depend on it.
+func validateParameters(p Parameters, numFiles int) error {
+	if p.MinLength < 1 {
+		return fmt.Errorf("minimum code length must be at least 1, got %d", p.MinLength)
+	}
+	if p.MinLength > p.MaxLength {
+		return fmt.Errorf("minimum code length %d is greater than the maximum %d, so no code can be valid", p.MinLength, p.MaxLength)
+	}
+	if p.MinFiles < 1 {
+		return fmt.Errorf("codes must appear in at least 1 file, got %d", p.MinFiles)
+	}
+	if numFiles > 0 && p.MinFiles > numFiles {
+		return fmt.Errorf("codes must appear in %d files but there are only %d input files, so no code can be valid", p.MinFiles, numFiles)
+	}
+	return nil
+}
+
+// progress reports msg through the Progress callback if one is set
+func (o Options) progress(msg string) {
+	if o.Progress != nil {
+		o.Progress(msg)
+	}
+}
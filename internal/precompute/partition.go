@@ -2,28 +2,159 @@ package precompute
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"iter"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/sync/errgroup"
 )
 
 const (
-	// Number of hash buckets for partitioning codes
-	numBuckets = 1000
+	// Number of buckets for partitioning codes unless Options.Buckets is set
+	defaultBuckets = 1000
 
 	// Scanner buffer sizes for reading files
 	scannerInitialBuffer = 64 * 1024   // 64 KB
 	scannerMaxBuffer     = 1024 * 1024 // 1 MB
+	// Room in a bucket line for the separator and file index after a code
+	bucketLineOverhead = 32
 
 	// Progress reporting interval for partitioning phase
 	progressReportInterval = 10_000_000 // Report every 10M codes
+
+	// Inclusive length bounds for a valid code unless Options.MinLength or
+	// Options.MaxLength are set
+	minCodeLength = 8
+	maxCodeLength = 10
+
+	// Number of files a code must appear in unless Options.RequireAll is set
+	defaultMinFiles = 2
+
+	// Permissions of bucket temp files unless Options.TempFileMode is set
+	defaultTempFileMode os.FileMode = 0600
+)
+
+// testHookPartitionFile is called before each input file is partitioned.
+// Tests use it to inject failures; it is nil otherwise.
+var testHookPartitionFile func(tempDir, filename string)
+
+// testHookPartitioned is called once every input file is partitioned, before
+// the buckets are processed. Tests use it to inspect the bucket files.
+var testHookPartitioned func(tempDir string)
+
+// panicError carries a panic recovered in a worker goroutine back to the
+// goroutine that started the run, so it can clean up and re-panic there
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("panic in worker: %v\n%s", p.value, p.stack)
+}
+
+// recoverPanic turns a panic in the calling goroutine into a *panicError stored in err.
+// It must be deferred directly.
+func recoverPanic(err *error) {
+	if p := recover(); p != nil {
+		*err = &panicError{value: p, stack: debug.Stack()}
+	}
+}
+
+// codeFilter decides which codes read from the input files are counted
+type codeFilter struct {
+	// Inclusive length bounds, in bytes unless graphemes is set
+	minLength, maxLength int
+	// graphemes measures codes in user-perceived characters
+	graphemes bool
+	// invalidUTF8 is one of the UTF8 constants
+	invalidUTF8 string
+	// tokenize splits lines into whitespace separated codes
+	tokenize bool
+}
+
+// defaultCodeFilter keeps codes of the default lengths, whatever their encoding
+var defaultCodeFilter = codeFilter{minLength: minCodeLength, maxLength: maxCodeLength, invalidUTF8: UTF8Keep}
+
+// codes returns the candidate codes of an input line: the line itself, or
+// with tokenize each of its whitespace separated tokens
+func (f codeFilter) codes(line string) iter.Seq[string] {
+	if f.tokenize {
+		return strings.FieldsSeq(line)
+	}
+	return func(yield func(string) bool) {
+		yield(line)
+	}
+}
+
+// filterReason is why codeFilter dropped a code, or kept if it didn't
+type filterReason int
+
+const (
+	kept filterReason = iota
+	filteredEmpty
+	filteredTooShort
+	filteredTooLong
+	filteredInvalidUTF8
+	// filteredMalformed is a row of tagged input without a file id
+	filteredMalformed
 )
 
+// check returns kept if code is non-empty, within the length bounds and,
+// unless invalidUTF8 is UTF8Keep, valid UTF-8, or else why it is dropped.
+// With UTF8Error, it returns errInvalidUTF8 for a code that isn't valid UTF-8.
+func (f codeFilter) check(code string) (filterReason, error) {
+	if code == "" {
+		return filteredEmpty, nil
+	}
+	length := len(code)
+	if f.graphemes {
+		// A grapheme is at least a byte, so longer codes in bytes can still fit
+		if length < f.minLength {
+			return filteredTooShort, nil
+		}
+		length = codeLength(code, LengthGraphemes)
+	}
+	switch {
+	case length < f.minLength:
+		return filteredTooShort, nil
+	case length > f.maxLength:
+		return filteredTooLong, nil
+	}
+	if ok, err := checkUTF8(code, f.invalidUTF8); !ok {
+		return filteredInvalidUTF8, err
+	}
+	return kept, nil
+}
+
+// errInvalidUTF8 is returned for a code that isn't valid UTF-8 with UTF8Error
+var errInvalidUTF8 = errors.New("code is not valid UTF-8")
+
+// checkUTF8 reports whether code is kept under the InvalidUTF8 mode, returning
+// errInvalidUTF8 if it isn't valid UTF-8 and mode is UTF8Error
+func checkUTF8(code, mode string) (bool, error) {
+	switch {
+	case mode != UTF8Skip && mode != UTF8Error, utf8.ValidString(code):
+		return true, nil
+	case mode == UTF8Error:
+		return false, errInvalidUTF8
+	default:
+		return false, nil
+	}
+}
+
 // hashCode hashes a string code to a bucket number using FNV-1a
 func hashCode(code string, numBuckets int) int {
 	h := fnv.New32a()
@@ -31,6 +162,24 @@ func hashCode(code string, numBuckets int) int {
 	return int(h.Sum32() % uint32(numBuckets))
 }
 
+// prefixBucketer returns a bucket function that reads the first k bytes of a
+// code as a big-endian number and scales it to the bucket range. Buckets are
+// therefore ordered: every code in a bucket sorts before those in later buckets.
+// Codes shorter than k are padded with zero bytes, so they sort first.
+func prefixBucketer(k int) func(code string, numBuckets int) int {
+	space := 1 << (8 * k)
+	return func(code string, numBuckets int) int {
+		prefix := 0
+		for i := 0; i < k; i++ {
+			prefix <<= 8
+			if i < len(code) {
+				prefix |= int(code[i])
+			}
+		}
+		return prefix * numBuckets / space
+	}
+}
+
 // FindValidCodesHashPartition uses hash-based partitioning to find valid promo codes.
 // This approach partitions codes into buckets, processes each bucket independently.
 //
@@ -38,40 +187,369 @@ func hashCode(code string, numBuckets int) int {
 // 1. It appears in at least 2 files
 // 2. Its length is between 8 and 10 characters (inclusive)
 //
+// When the directory holds exactly two files, a single in-memory intersection
+// is used instead of partitioning to disk. Both paths produce identical results.
+//
 // workers: Number of parallel workers for bucket processing. If 0 or negative, uses runtime.NumCPU().
 func FindValidCodesHashPartition(dirPath string, progressCallback func(string), workers int) ([]string, error) {
+	result, err := FindValidCodes(dirPath, Options{
+		Workers:  workers,
+		Progress: progressCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Codes, nil
+}
+
+// FindValidCodes finds valid promo codes in the files of dirPath like
+// FindValidCodesHashPartition, and also returns statistics about the run.
+func FindValidCodes(dirPath string, opts Options) (*Result, error) {
+	start := time.Now()
+
+	files, opts, stats, err := prepareRun(dirPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var validCodes []string
+	if stats.Algorithm == "two-file" {
+		validCodes, err = findValidCodesTwoFiles(files[0], files[1], opts, &stats)
+	} else {
+		validCodes, err = findValidCodesPartitioned(files, opts, &stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Top-K runs apply Accept while ranking
+	if opts.Accept != nil && opts.TopK <= 0 {
+		validCodes = acceptCodes(validCodes, opts.Accept, &stats)
+	}
+	orderCodes(validCodes, opts.Sort, opts.TopK, opts.LengthUnit)
+	validCodes = limitOutput(validCodes, opts, &stats)
+
+	stats.ValidCodes = len(validCodes)
+	stats.ElapsedSeconds = time.Since(start).Seconds()
+
+	return &Result{Codes: validCodes, Stats: stats}, nil
+}
+
+// prepareRun lists and checks the input files of dirPath, fills in the defaults
+// of opts and returns the initial stats of the run, with its algorithm and
+// effective parameters. It fails if the run could never find a valid code.
+func prepareRun(dirPath string, opts Options) ([]string, Options, Stats, error) {
+	files, err := listInputFiles(dirPath)
+	if err != nil {
+		return nil, opts, Stats{}, err
+	}
+	var duplicates []string
+	if !opts.AllowDuplicateFiles {
+		if files, duplicates, err = dedupeFiles(files, opts); err != nil {
+			return nil, opts, Stats{}, err
+		}
+	}
 
-	// Get list of files in directory
+	// A stray gzip or JSON file would otherwise be read as codes
+	if err := checkFormats(files, opts); err != nil {
+		return nil, opts, Stats{}, err
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.ReadConcurrency <= 0 {
+		opts.ReadConcurrency = 1
+	}
+	if opts.Sort, err = opts.sortOrder(); err != nil {
+		return nil, opts, Stats{}, err
+	}
+	if opts.InvalidUTF8, err = opts.utf8Mode(); err != nil {
+		return nil, opts, Stats{}, err
+	}
+	if opts.LengthUnit, err = opts.lengthUnit(); err != nil {
+		return nil, opts, Stats{}, err
+	}
+	if opts.Buckets, err = opts.bucketCount(); err != nil {
+		return nil, opts, Stats{}, err
+	}
+	if _, err := opts.trustedFiles(files); err != nil {
+		return nil, opts, Stats{}, err
+	}
+	if opts.ResumeDir != "" && (opts.ReadConcurrency > 1 || opts.ShardBuckets) {
+		return nil, opts, Stats{}, fmt.Errorf("resuming requires reading one file at a time, without sharded buckets")
+	}
+	opts.MinLength, opts.MaxLength = opts.lengthBounds()
+	_, partitionBy, prefixLength, err := opts.bucketFunc()
+	if err != nil {
+		return nil, opts, Stats{}, err
+	}
+
+	stats := Stats{
+		InputFiles:     files,
+		DuplicateFiles: duplicates,
+		Parameters: Parameters{
+			Workers:         opts.Workers,
+			ReadConcurrency: opts.ReadConcurrency,
+			Buckets:         opts.Buckets,
+			MinLength:       opts.MinLength,
+			MaxLength:       opts.MaxLength,
+			LengthUnit:      opts.LengthUnit,
+			MinFiles:        opts.minFiles(len(files)),
+			MaxBucketBytes:  opts.MaxBucketBytes,
+			TopK:            max(opts.TopK, 0),
+			MaxOutput:       max(opts.MaxOutput, 0),
+			Sort:            opts.Sort,
+			PartitionBy:     partitionBy,
+			PrefixLength:    prefixLength,
+			InvalidUTF8:     opts.InvalidUTF8,
+			NoEarlyExit:     opts.DisableEarlyExit,
+			Tokenize:        opts.Tokenize,
+			Shards:          opts.bucketShards(),
+			TrustedFiles:    opts.TrustedFiles,
+		},
+	}
+	if err := validateParameters(stats.Parameters, len(files)); err != nil {
+		return nil, opts, Stats{}, err
+	}
+
+	// Two files don't need the partition-to-disk machinery when codes must be
+	// in both: a set built from the first file and probed with the second
+	// gives the same answer. With trusted files, at least one of the two
+	// matched, so every code in both is confirmed by one.
+	if len(files) == 2 && stats.Parameters.MinFiles == 2 {
+		stats.Algorithm = "two-file"
+	} else {
+		stats.Algorithm = "hash-partition"
+	}
+
+	return files, opts, stats, nil
+}
+
+// limitOutput keeps the first Options.MaxOutput codes. Dropping codes, here or
+// when processing buckets stopped early as stats.Truncated records, is
+// reported as a warning, since valid codes are missing.
+func limitOutput(codes []string, opts Options, stats *Stats) []string {
+	if opts.MaxOutput > 0 && len(codes) > opts.MaxOutput {
+		codes = codes[:opts.MaxOutput]
+		stats.Truncated = true
+	}
+	if stats.Truncated {
+		opts.progress(fmt.Sprintf("Warning: stopped at the maximum output of %d valid codes; any other valid codes are missing", opts.MaxOutput))
+	}
+	return codes
+}
+
+// orderCodes puts codes in the given Sort order, measuring SortLength in
+// unit. Codes arrive sorted alphabetically, or by count when topK is set,
+// unless the order is SortNone.
+func orderCodes(codes []string, order string, topK int, unit string) {
+	switch order {
+	case SortAlpha:
+		if topK > 0 {
+			sort.Strings(codes)
+		}
+	case SortLength:
+		// Measured once per code, as counting graphemes isn't free
+		byLength := codesByLength{codes: codes, lengths: make([]int, len(codes))}
+		for i, code := range codes {
+			byLength.lengths[i] = codeLength(code, unit)
+		}
+		sort.Sort(byLength)
+	}
+}
+
+// codesByLength sorts codes shortest first, then alphabetically, by the
+// lengths at the same index
+type codesByLength struct {
+	codes   []string
+	lengths []int
+}
+
+func (c codesByLength) Len() int { return len(c.codes) }
+func (c codesByLength) Less(i, j int) bool {
+	if c.lengths[i] != c.lengths[j] {
+		return c.lengths[i] < c.lengths[j]
+	}
+	return c.codes[i] < c.codes[j]
+}
+func (c codesByLength) Swap(i, j int) {
+	c.codes[i], c.codes[j] = c.codes[j], c.codes[i]
+	c.lengths[i], c.lengths[j] = c.lengths[j], c.lengths[i]
+}
+
+// listInputFiles returns the paths of the files in dirPath. Subdirectories are
+// not scanned, and a directory without files is an error.
+func listInputFiles(dirPath string) ([]string, error) {
 	entries, err := os.ReadDir(dirPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read directory %s: %w", dirPath, err)
 	}
 
 	var files []string
+	subdirs := 0
 	for _, entry := range entries {
-		if !entry.IsDir() {
-			files = append(files, filepath.Join(dirPath, entry.Name()))
+		if entry.IsDir() {
+			subdirs++
+			continue
 		}
+		files = append(files, filepath.Join(dirPath, entry.Name()))
 	}
 
 	if len(files) == 0 {
-		return nil, fmt.Errorf("no files found in directory %s", dirPath)
+		// Nested exports are a common mistake, so point the user at the subdirectories
+		if subdirs > 0 {
+			return nil, fmt.Errorf("no files found in directory %s: it contains only subdirectories (%d), which are not scanned; point --input at the directory holding the code files", dirPath, subdirs)
+		}
+		return nil, fmt.Errorf("no files found in directory %s: directory is empty", dirPath)
 	}
 
-	// Create temporary directory for bucket files
-	tempDir, err := os.MkdirTemp("", "hash_partition_*")
+	return files, nil
+}
+
+// fileID identifies a physical file by its device and inode
+type fileID struct {
+	dev, ino uint64
+}
+
+// dedupeFiles drops files that are the same physical file as an earlier one,
+// e.g. a symlink to another input, so each file is given one index. The
+// dropped names are returned and reported through Progress. Files are looked
+// up by device and inode, falling back to comparing each with every earlier
+// one using os.SameFile where the platform doesn't provide them.
+func dedupeFiles(files []string, opts Options) (unique []string, duplicates []string, err error) {
+	ids := make(map[fileID]int, len(files))
+	var infos []os.FileInfo // Of files without an ID, with their index in unique
+	var infoIdx []int
+	for _, file := range files {
+		// Stat follows symlinks, so a link and its target compare equal
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to stat input file %s: %w", file, err)
+		}
+
+		duplicateOf := -1
+		id, hasID := fileIDOf(info)
+		if hasID {
+			if i, ok := ids[id]; ok {
+				duplicateOf = i
+			}
+		} else {
+			for i, seen := range infos {
+				if os.SameFile(seen, info) {
+					duplicateOf = infoIdx[i]
+					break
+				}
+			}
+		}
+		if duplicateOf >= 0 {
+			duplicates = append(duplicates, file)
+			opts.progress(fmt.Sprintf("Warning: skipping %s, the same file as %s", file, unique[duplicateOf]))
+			continue
+		}
+
+		if hasID {
+			ids[id] = len(unique)
+		} else {
+			infos = append(infos, info)
+			infoIdx = append(infoIdx, len(unique))
+		}
+		unique = append(unique, file)
+	}
+	return unique, duplicates, nil
+}
+
+// acceptCodes filters codes in place, keeping those accept returns true for.
+// The number of codes dropped is recorded in stats.
+func acceptCodes(codes []string, accept func(string) bool, stats *Stats) []string {
+	kept := codes[:0]
+	for _, code := range codes {
+		if accept(code) {
+			kept = append(kept, code)
+		}
+	}
+	stats.CodesRejected = len(codes) - len(kept)
+	return kept
+}
+
+// findValidCodesPartitioned runs the two phase hash partition algorithm over the given files
+func findValidCodesPartitioned(files []string, opts Options, stats *Stats) ([]string, error) {
+	bucketOf, _, _, err := opts.bucketFunc()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		return nil, err
+	}
+
+	numBuckets, _ := opts.bucketCount()    // Checked when the run is prepared
+	trusted, _ := opts.trustedFiles(files) // Checked when the run is prepared
+	return runPartitioned(opts, stats, trusted, func(tempDir string) (int, error) {
+		var cp *checkpoint
+		if opts.ResumeDir != "" {
+			loaded, err := loadCheckpoint(tempDir, files, opts.checkpointSettings())
+			if err != nil {
+				return 0, err
+			}
+			cp = loaded
+		}
+		err := partitionFiles(files, numBuckets, bucketOf, tempDir, opts.bucketShards(), opts.codeFilter(), opts.Progress, opts.ReadConcurrency, opts.TempFileMode, cp, stats)
+		return len(files), err
+	})
+}
+
+// runPartitioned runs the two phase hash partition algorithm. partition writes
+// the codes into bucket files in tempDir and returns the number of input files
+// they came from; the buckets are then processed to find the valid codes,
+// requiring a trusted file unless trusted is nil.
+// With Options.TopK set, only the top codes are returned, ranked, and
+// Options.Accept is applied while ranking them.
+func runPartitioned(opts Options, stats *Stats, trusted []bool, partition func(tempDir string) (numFiles int, err error)) ([]string, error) {
+	progressCallback := opts.Progress
+
+	// Create temporary directory for bucket files, or use the resume
+	// directory, which is kept until the run succeeds so it can be resumed
+	tempDir := opts.ResumeDir
+	if tempDir != "" {
+		if err := os.MkdirAll(tempDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create resume directory: %w", err)
+		}
+	} else {
+		var err error
+		tempDir, err = os.MkdirTemp("", "hash_partition_*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		defer os.RemoveAll(tempDir)
+	}
+
+	// A panic in a worker goroutine would otherwise crash the program without
+	// running the deferred cleanup, so workers recover and hand it back as a
+	// *panicError. Remove the temp files, then re-panic with the original value.
+	defer func() {
+		if p := recover(); p != nil {
+			if opts.ResumeDir == "" {
+				os.RemoveAll(tempDir)
+			}
+			panic(p)
+		}
+	}()
+	rethrow := func(err error) error {
+		var pe *panicError
+		if errors.As(err, &pe) {
+			panic(pe.value)
+		}
+		return err
 	}
-	defer os.RemoveAll(tempDir)
 
 	// Phase 1: Partition files into buckets
 	if progressCallback != nil {
 		progressCallback("Phase 1: Partitioning files into buckets...")
 	}
 
-	if err := partitionFiles(files, numBuckets, tempDir, progressCallback); err != nil {
-		return nil, err
+	numFiles, err := partition(tempDir)
+	if err != nil {
+		return nil, rethrow(err)
+	}
+	if testHookPartitioned != nil {
+		testHookPartitioned(tempDir)
 	}
 
 	// Phase 2: Process each bucket to find valid codes
@@ -79,182 +557,497 @@ func FindValidCodesHashPartition(dirPath string, progressCallback func(string),
 		progressCallback("Phase 2: Processing buckets to find valid codes...")
 	}
 
-	validCodes, err := processBuckets(numBuckets, tempDir, progressCallback, workers)
+	var onBadBucket func(path string, err error)
+	if opts.SkipBadBuckets {
+		var mu sync.Mutex
+		onBadBucket = func(path string, err error) {
+			mu.Lock()
+			stats.SkippedBuckets++
+			mu.Unlock()
+			opts.progress(fmt.Sprintf("Warning: skipping bucket %s: %v", filepath.Base(path), err))
+		}
+	}
+
+	var onBucket func(BucketStats)
+	if opts.BucketStats && opts.TopK <= 0 {
+		var mu sync.Mutex
+		onBucket = func(b BucketStats) {
+			mu.Lock()
+			stats.Buckets = append(stats.Buckets, b)
+			mu.Unlock()
+		}
+	}
+
+	numBuckets, _ := opts.bucketCount() // Checked when the run is prepared
+	var validCodes []string
+	if opts.TopK > 0 {
+		opts.progress(fmt.Sprintf("  Ranking codes to keep the top %d", opts.TopK))
+		validCodes, err = selectTopK(numBuckets, tempDir, opts.bucketShards(), opts.Workers, opts.minFiles(numFiles), trusted, opts.TopK, opts.Accept, onBadBucket, stats)
+	} else {
+		validCodes, stats.Truncated, err = processBuckets(numBuckets, tempDir, opts.bucketShards(), progressCallback, opts.Verbose, opts.Workers, opts.MaxBucketBytes, opts.minFiles(numFiles), trusted, !opts.DisableEarlyExit, max(opts.MaxOutput, 0), onBadBucket, onBucket)
+	}
 	if err != nil {
-		return nil, err
+		return nil, rethrow(err)
+	}
+	// Buckets finish in any order
+	sort.Slice(stats.Buckets, func(i, j int) bool { return stats.Buckets[i].Bucket < stats.Buckets[j].Bucket })
+
+	// Buckets finish in any order, so sort for consistent output
+	if opts.TopK <= 0 && opts.Sort != SortNone {
+		sort.Strings(validCodes)
 	}
 
 	if progressCallback != nil {
 		progressCallback(fmt.Sprintf("Found %d valid codes", len(validCodes)))
 	}
 
+	// Nothing is left to resume
+	if opts.ResumeDir != "" {
+		os.RemoveAll(tempDir)
+	}
+
 	return validCodes, nil
 }
 
 // partitionFiles partitions all input files into bucket files
-func partitionFiles(files []string, numBuckets int, tempDir string, progressCallback func(string)) error {
-	// Create bucket file handles
-	bucketFiles := make([]*os.File, numBuckets)
-	bucketWriters := make([]*bufio.Writer, numBuckets)
-
-	for i := 0; i < numBuckets; i++ {
-		bucketPath := filepath.Join(tempDir, fmt.Sprintf("bucket_%03d.txt", i))
-		f, err := os.Create(bucketPath)
-		if err != nil {
-			// Close any already opened files
-			for j := 0; j < i; j++ {
-				bucketWriters[j].Flush()
-				bucketFiles[j].Close()
-			}
-			return fmt.Errorf("failed to create bucket file %d: %w", i, err)
+// Up to readConcurrency files are read at the same time. With shards of 0 they
+// share one set of bucket files, and writes to a bucket are serialised by its
+// lock. Otherwise each reader takes one of shards private sets of bucket files
+// and writes without locking; phase 2 reads every shard of a bucket.
+// Bucket files are created on their first write with fileMode, or
+// defaultTempFileMode if it is 0, so buckets no code lands in have no file.
+// Only codes kept by filter are partitioned.
+// With cp set, files must be read one at a time without shards: the files cp
+// completed are skipped, and cp is saved into tempDir after every file.
+// The number of codes read and filtered out are recorded in stats.
+func partitionFiles(files []string, numBuckets int, bucketOf func(code string, numBuckets int) int, tempDir string, shards int, filter codeFilter, progressCallback func(string), readConcurrency int, fileMode os.FileMode, cp *checkpoint, stats *Stats) error {
+	// One set of bucket files per shard
+	sets := make([]*bucketSet, max(shards, 1))
+	for i := range sets {
+		shard := i
+		if shards == 0 {
+			shard = noShard
 		}
-		bucketFiles[i] = f
-		bucketWriters[i] = bufio.NewWriter(f)
+		sets[i] = newBucketSet(numBuckets, tempDir, shard, fileMode)
+		sets[i].appendFiles = cp != nil
+		// Ensure all bucket files are closed at the end
+		defer sets[i].close()
 	}
 
-	// Ensure all bucket files are closed at the end
-	defer func() {
-		for i := 0; i < numBuckets; i++ {
-			bucketWriters[i].Flush()
-			bucketFiles[i].Close()
+	// Shared buckets are locked; sharded readers take a free shard instead
+	var bucketLocks []sync.Mutex
+	freeShards := make(chan int, shards)
+	if shards == 0 {
+		bucketLocks = make([]sync.Mutex, numBuckets)
+	}
+	for shard := 0; shard < shards; shard++ {
+		freeShards <- shard
+	}
+
+	// Process input files, bounded by the read concurrency
+	var totalLinesRead atomic.Int64
+	var totalCodesRead atomic.Int64
+	var totalCodesPartitioned atomic.Int64
+	var filteredMu sync.Mutex
+	var totalFiltered FilterCounts
+
+	// Carry over the counts of the files partitioned before resuming
+	if cp != nil {
+		totalLinesRead.Store(cp.LinesRead)
+		totalCodesRead.Store(cp.CodesRead)
+		totalCodesPartitioned.Store(cp.CodesPartitioned)
+		totalFiltered = cp.Filtered
+		if cp.Completed > 0 && progressCallback != nil {
+			progressCallback(fmt.Sprintf("  Resuming after file %d/%d from the checkpoint in %s", cp.Completed, len(files), tempDir))
 		}
-	}()
+	}
 
-	// Process each input file
-	totalCodesRead := 0
-	totalCodesPartitioned := 0
+	var eg errgroup.Group
+	eg.SetLimit(max(readConcurrency, 1))
 
 	for fileIdx, filename := range files {
-		if progressCallback != nil {
-			progressCallback(fmt.Sprintf("  Partitioning file %d/%d: %s", fileIdx+1, len(files), filepath.Base(filename)))
+		if cp != nil && fileIdx < cp.Completed {
+			continue
 		}
 
-		f, err := os.Open(filename)
-		if err != nil {
-			return fmt.Errorf("failed to open file %s: %w", filename, err)
-		}
+		eg.Go(func() (err error) {
+			defer recoverPanic(&err)
 
-		scanner := bufio.NewScanner(f)
-		buf := make([]byte, 0, scannerInitialBuffer)
-		scanner.Buffer(buf, scannerMaxBuffer)
+			if testHookPartitionFile != nil {
+				testHookPartitionFile(tempDir, filename)
+			}
 
-		fileCodesRead := 0
-		fileCodesPartitioned := 0
+			if progressCallback != nil {
+				progressCallback(fmt.Sprintf("  Partitioning file %d/%d: %s", fileIdx+1, len(files), filepath.Base(filename)))
+			}
 
-		for scanner.Scan() {
-			code := scanner.Text()
-			fileCodesRead++
-			totalCodesRead++
+			buckets := sets[0]
+			if shards > 0 {
+				shard := <-freeShards
+				defer func() { freeShards <- shard }()
+				buckets = sets[shard]
+			}
 
-			// Skip empty lines
-			if code == "" {
-				continue
+			f, err := openInputFile(filename)
+			if err != nil {
+				return fmt.Errorf("failed to open file %s: %w", filename, err)
 			}
+			defer f.Close()
 
-			// Filter: only partition codes with length 8-10
-			if len(code) < 8 || len(code) > 10 {
-				continue
+			scanner := newCodeScanner(f)
+
+			line := 0
+			fileCodesRead := 0
+			fileCodesPartitioned := 0
+			var fileFiltered FilterCounts
+
+			for scanner.Scan() {
+				line++
+				for code := range filter.codes(scanner.Text()) {
+					fileCodesRead++
+
+					// Filter: only partition non-empty codes of a valid length and encoding
+					if reason, err := filter.check(code); reason != kept {
+						if err != nil {
+							return fmt.Errorf("%s line %d: %w", filename, line, err)
+						}
+						fileFiltered.add(reason)
+						continue
+					}
+
+					bucketNum := bucketOf(code, numBuckets)
+
+					// Write to bucket file: "code|fileIndex\n"
+					if bucketLocks != nil {
+						bucketLocks[bucketNum].Lock()
+					}
+					err := buckets.writeLine(bucketNum, formatBucketLine(code, fileIdx))
+					if bucketLocks != nil {
+						bucketLocks[bucketNum].Unlock()
+					}
+					if err != nil {
+						return err
+					}
+
+					fileCodesPartitioned++
+
+					// Report progress periodically
+					if progressCallback != nil && fileCodesRead%progressReportInterval == 0 {
+						progressCallback(fmt.Sprintf("    Processed %dM codes (%dM valid length)",
+							fileCodesRead/1_000_000, fileCodesPartitioned/1_000_000))
+					}
+				}
 			}
 
-			// Hash to bucket
-			bucketNum := hashCode(code, numBuckets)
+			totalLinesRead.Add(int64(line))
+			totalCodesRead.Add(int64(fileCodesRead))
+			totalCodesPartitioned.Add(int64(fileCodesPartitioned))
+			filteredMu.Lock()
+			totalFiltered.merge(fileFiltered)
+			filteredMu.Unlock()
 
-			// Write to bucket file: "code|fileIndex\n"
-			if _, err := bucketWriters[bucketNum].WriteString(fmt.Sprintf("%s|%d\n", code, fileIdx)); err != nil {
-				f.Close()
-				return fmt.Errorf("failed to write to bucket %d: %w", bucketNum, err)
+			if err := scanner.Err(); err != nil {
+				return fmt.Errorf("error reading file %s: %w", filename, err)
 			}
 
-			fileCodesPartitioned++
-			totalCodesPartitioned++
+			// Files are read one at a time when checkpointing, in order, but later
+			// files are still read after one fails, which must not be skipped on resume
+			if cp != nil && cp.Completed == fileIdx {
+				if err := buckets.flush(); err != nil {
+					return err
+				}
+				cp.LinesRead, cp.CodesRead, cp.CodesPartitioned = totalLinesRead.Load(), totalCodesRead.Load(), totalCodesPartitioned.Load()
+				cp.Filtered = totalFiltered
+				if err := cp.save(tempDir, fileIdx+1, buckets); err != nil {
+					return err
+				}
+			}
 
-			// Report progress periodically
-			if progressCallback != nil && fileCodesRead%progressReportInterval == 0 {
-				progressCallback(fmt.Sprintf("    Processed %dM codes (%dM valid length)",
-					fileCodesRead/1_000_000, fileCodesPartitioned/1_000_000))
+			if progressCallback != nil {
+				progressCallback(fmt.Sprintf("    File %d complete: %d codes read, %d codes partitioned (%d-%d chars)",
+					fileIdx+1, fileCodesRead, fileCodesPartitioned, filter.minLength, filter.maxLength))
 			}
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// Flush all bucket writers
+	for _, buckets := range sets {
+		if err := buckets.flush(); err != nil {
+			return err
 		}
+	}
+
+	stats.LinesRead += totalLinesRead.Load()
+	stats.CodesRead += totalCodesRead.Load()
+	stats.CodesFiltered += totalCodesRead.Load() - totalCodesPartitioned.Load()
+	stats.Filtered.merge(totalFiltered)
+
+	if progressCallback != nil {
+		progressCallback(fmt.Sprintf("  Partitioning complete: %d total codes read, %d codes partitioned into %d buckets",
+			totalCodesRead.Load(), totalCodesPartitioned.Load(), numBuckets))
+	}
 
+	return nil
+}
+
+// noShard is the shard of bucket files that aren't sharded
+const noShard = -1
+
+// bucketPath returns the path of a bucket file in tempDir, or of one shard of
+// it unless shard is noShard
+func bucketPath(tempDir string, bucketNum, shard int) string {
+	if shard == noShard {
+		return filepath.Join(tempDir, fmt.Sprintf("bucket_%03d.txt", bucketNum))
+	}
+	return filepath.Join(tempDir, fmt.Sprintf("bucket_%03d.shard_%d.txt", bucketNum, shard))
+}
+
+// bucketPaths returns the files holding a bucket: its single file, or one per
+// shard when shards is above 0
+func bucketPaths(tempDir string, bucketNum, shards int) []string {
+	if shards == 0 {
+		return []string{bucketPath(tempDir, bucketNum, noShard)}
+	}
+	paths := make([]string, shards)
+	for shard := range paths {
+		paths[shard] = bucketPath(tempDir, bucketNum, shard)
+	}
+	return paths
+}
+
+// bucketSize returns the total size of the files of a bucket. Missing files are empty.
+func bucketSize(paths []string) (int64, error) {
+	var size int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat bucket file %s: %w", path, err)
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// multiFileReader reads several files one after another as a single stream
+type multiFileReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (m *multiFileReader) Close() error {
+	for _, f := range m.files {
 		f.Close()
+	}
+	return nil
+}
 
-		if err := scanner.Err(); err != nil {
-			return fmt.Errorf("error reading file %s: %w", filename, err)
+// openBucket opens the files of a bucket as a single stream. A missing shard
+// is empty, as its file is only created on the first write, but at least one
+// file of the bucket must exist.
+func openBucket(paths []string) (io.ReadCloser, error) {
+	m := &multiFileReader{}
+	readers := make([]io.Reader, 0, len(paths))
+	var missing error
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			if missing == nil {
+				missing = fmt.Errorf("failed to open bucket file %s: %w", path, err)
+			}
+			continue
+		}
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to open bucket file %s: %w", path, err)
 		}
+		m.files = append(m.files, f)
+		readers = append(readers, f)
+	}
+	if len(m.files) == 0 && missing != nil {
+		return nil, missing
+	}
+	m.Reader = io.MultiReader(readers...)
+	return m, nil
+}
 
-		if progressCallback != nil {
-			progressCallback(fmt.Sprintf("    File %d complete: %d codes read, %d codes partitioned (8-10 chars)",
-				fileIdx+1, fileCodesRead, fileCodesPartitioned))
+// bucketSet is the bucket files of one shard, or noShard, in tempDir. A
+// bucket's file is only created on its first write, so buckets that stay
+// empty never touch the disk. Writes to different buckets may run
+// concurrently; writes to the same bucket must be serialised.
+type bucketSet struct {
+	tempDir  string
+	shard    int
+	fileMode os.FileMode
+	files    []*os.File
+	writers  []*bufio.Writer
+	// appendFiles appends to existing bucket files rather than truncating
+	// them, to resume from a checkpoint
+	appendFiles bool
+}
+
+// newBucketSet returns a set of numBuckets bucket files, none created yet.
+// Files are created with fileMode, or defaultTempFileMode if it is 0.
+func newBucketSet(numBuckets int, tempDir string, shard int, fileMode os.FileMode) *bucketSet {
+	if fileMode == 0 {
+		fileMode = defaultTempFileMode
+	}
+	return &bucketSet{
+		tempDir:  tempDir,
+		shard:    shard,
+		fileMode: fileMode,
+		files:    make([]*os.File, numBuckets),
+		writers:  make([]*bufio.Writer, numBuckets),
+	}
+}
+
+// writeLine writes a line to a bucket, creating its file if needed
+func (b *bucketSet) writeLine(bucketNum int, line string) error {
+	w := b.writers[bucketNum]
+	if w == nil {
+		flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		if b.appendFiles {
+			flag = os.O_RDWR | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(bucketPath(b.tempDir, bucketNum, b.shard), flag, b.fileMode)
+		if err != nil {
+			return fmt.Errorf("failed to create bucket file %d: %w", bucketNum, err)
 		}
+		w = bufio.NewWriter(f)
+		b.files[bucketNum] = f
+		b.writers[bucketNum] = w
 	}
+	if _, err := w.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to write to bucket %d: %w", bucketNum, err)
+	}
+	return nil
+}
 
-	// Flush all bucket writers
-	for i := 0; i < numBuckets; i++ {
-		if err := bucketWriters[i].Flush(); err != nil {
+// flush flushes every bucket written to, returning the first error
+func (b *bucketSet) flush() error {
+	for i, w := range b.writers {
+		if w == nil {
+			continue
+		}
+		if err := w.Flush(); err != nil {
 			return fmt.Errorf("failed to flush bucket %d: %w", i, err)
 		}
 	}
+	return nil
+}
 
-	if progressCallback != nil {
-		progressCallback(fmt.Sprintf("  Partitioning complete: %d total codes read, %d codes partitioned into %d buckets",
-			totalCodesRead, totalCodesPartitioned, numBuckets))
+// close flushes and closes the bucket files, ignoring errors
+func (b *bucketSet) close() {
+	for i, f := range b.files {
+		if f == nil {
+			continue
+		}
+		b.writers[i].Flush()
+		f.Close()
 	}
-
-	return nil
 }
 
-// processBuckets processes all bucket files to find valid codes
-// Uses a worker pool for parallel processing
-func processBuckets(numBuckets int, tempDir string, progressCallback func(string), workers int) ([]string, error) {
+// processBuckets processes all bucket files to find valid codes, reading every
+// shard of a bucket when shards is above 0
+// Uses a worker pool for parallel processing; with verbose, each worker
+// reports the buckets it processed through progressCallback
+// With maxOutput above 0, the workers are stopped once more than that many
+// valid codes are collected, only the first maxOutput are returned and
+// truncated is set.
+// If onBucket is set, it receives the stats of every bucket processed, from
+// several goroutines at once.
+func processBuckets(numBuckets int, tempDir string, shards int, progressCallback func(string), verbose bool, workers int, maxBucketBytes int64, minFiles int, trusted []bool, earlyExit bool, maxOutput int, onBadBucket func(path string, err error), onBucket func(BucketStats)) ([]string, bool, error) {
 	// Use runtime.NumCPU() if workers is 0 or negative
 	workerPoolSize := workers
 	if workerPoolSize <= 0 {
 		workerPoolSize = runtime.NumCPU()
 	}
 
-	bucketPaths := make(chan string, numBuckets)
+	buckets := make(chan []string, numBuckets)
 	results := make(chan []string, workerPoolSize)
+	stop := make(chan struct{})
+
+	var workerProgress func(string)
+	if verbose {
+		workerProgress = progressCallback
+	}
+
+	// Workers only see the paths of a bucket, so look its number up by the first
+	var workerOnBucket func(paths []string, stats BucketStats)
+	if onBucket != nil {
+		bucketNums := make(map[string]int, numBuckets)
+		for bucketNum := range numBuckets {
+			bucketNums[bucketPaths(tempDir, bucketNum, shards)[0]] = bucketNum
+		}
+		workerOnBucket = func(paths []string, stats BucketStats) {
+			stats.Bucket = bucketNums[paths[0]]
+			onBucket(stats)
+		}
+	}
 
 	// Start worker pool
 	var eg errgroup.Group
 	for w := 1; w <= workerPoolSize; w++ {
-		eg.Go(func() error {
-			return processBucketsWorker(w, bucketPaths, results)
+		eg.Go(func() (err error) {
+			defer recoverPanic(&err)
+			return processBucketsWorker(w, buckets, results, stop, maxBucketBytes, minFiles, trusted, earlyExit, onBadBucket, workerOnBucket, workerProgress)
 		})
 	}
 
 	// Send all bucket paths to workers
 	bucketsProcessed := 0
 	for bucketNum := 0; bucketNum < numBuckets; bucketNum++ {
-		bucketPath := filepath.Join(tempDir, fmt.Sprintf("bucket_%03d.txt", bucketNum))
+		paths := bucketPaths(tempDir, bucketNum, shards)
 
-		// Check if bucket file exists and is not empty
-		info, err := os.Stat(bucketPath)
+		// Check if bucket files exist and are not empty
+		size, err := bucketSize(paths)
 		if err != nil {
-			if os.IsNotExist(err) {
-				continue // Skip empty buckets
-			}
-			return nil, fmt.Errorf("failed to stat bucket file %d: %w", bucketNum, err)
+			return nil, false, err
 		}
 
-		if info.Size() == 0 {
+		if size == 0 {
 			continue // Skip empty buckets
 		}
 
-		bucketPaths <- bucketPath
+		buckets <- paths
 		bucketsProcessed++
 	}
-	close(bucketPaths)
+	close(buckets)
 
 	// Collect results in a separate goroutine
 	var allValidCodes []string
+	stopped := false
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
 		resultCount := 0
 		for codes := range results {
+			// Drain the buckets still in progress once stopped
+			if stopped {
+				continue
+			}
 			allValidCodes = append(allValidCodes, codes...)
 			resultCount++
 
+			// Exactly maxOutput codes drop none, so keep going until there are more
+			if maxOutput > 0 && len(allValidCodes) > maxOutput {
+				allValidCodes = allValidCodes[:maxOutput]
+				stopped = true
+				close(stop)
+				if progressCallback != nil {
+					progressCallback(fmt.Sprintf("    Stopping after %d/%d buckets: collected more than the maximum of %d valid codes",
+						resultCount, bucketsProcessed, maxOutput))
+				}
+				continue
+			}
+
 			// Report progress every 100 results or when complete
 			if progressCallback != nil && (resultCount%100 == 0 || resultCount == bucketsProcessed) {
 				progressCallback(fmt.Sprintf("    Processed %d/%d buckets (%d valid codes found so far)",
@@ -265,7 +1058,7 @@ func processBuckets(numBuckets int, tempDir string, progressCallback func(string
 
 	// Wait for all workers to finish
 	if err := eg.Wait(); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	// Close results channel to signal collector we're done
@@ -274,13 +1067,10 @@ func processBuckets(numBuckets int, tempDir string, progressCallback func(string
 	// Wait for result collector to finish
 	<-done
 
-	// Sort codes alphabetically for consistent output
-	sort.Strings(allValidCodes)
-
 	if progressCallback != nil {
 		progressCallback(fmt.Sprintf("  Processing complete: %d buckets processed, %d valid codes found",
 			bucketsProcessed, len(allValidCodes)))
 	}
 
-	return allValidCodes, nil
+	return allValidCodes, stopped, nil
 }
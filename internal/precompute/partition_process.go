@@ -1,83 +1,179 @@
 package precompute
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
-	"os"
+	"math"
 	"strconv"
 	"strings"
 )
 
+// formatBucketLine formats a bucket file entry: the code and the index of the
+// input file it was read from, as "code|fileIndex". The code is written as is,
+// even if it contains "|".
+func formatBucketLine(code string, fileIdx int) string {
+	return code + "|" + strconv.Itoa(fileIdx)
+}
+
+// parseBucketLine parses an entry written by formatBucketLine.
+// The index follows the last "|", so codes may themselves contain "|".
+// It reports false for malformed lines, including negative or out of range file indices.
+func parseBucketLine(line string) (code string, fileIdx int, ok bool) {
+	sep := strings.LastIndexByte(line, '|')
+	if sep <= 0 {
+		return "", 0, false
+	}
+	code, idx := line[:sep], line[sep+1:]
+
+	// ParseUint rejects signs, unlike Atoi
+	n, err := strconv.ParseUint(idx, 10, strconv.IntSize-1)
+	if err != nil {
+		return "", 0, false
+	}
+	return code, int(n), true
+}
+
+// parseBucketLineBytes is parseBucketLine for a line still in the scanner's
+// buffer. It parses the index in place rather than through a string, so
+// processBucket only allocates when it meets a new code. The returned code
+// aliases line.
+func parseBucketLineBytes(line []byte) (code []byte, fileIdx int, ok bool) {
+	sep := bytes.LastIndexByte(line, '|')
+	if sep <= 0 || sep == len(line)-1 {
+		return nil, 0, false
+	}
+
+	// Digits only, as parseBucketLine rejects signs, capped at math.MaxInt
+	n := 0
+	for _, c := range line[sep+1:] {
+		if c < '0' || c > '9' {
+			return nil, 0, false
+		}
+		d := int(c - '0')
+		if n > (math.MaxInt-d)/10 {
+			return nil, 0, false
+		}
+		n = n*10 + d
+	}
+	return line[:sep], n, true
+}
+
+// BucketStats describes a processed bucket, to diagnose skew between buckets
+// and size their number
+type BucketStats struct {
+	Bucket int
+	// Lines and Bytes are the entries of the bucket, malformed ones included
+	Lines int64
+	Bytes int64
+	// DistinctCodes counts the different codes in the bucket, ValidCodes those
+	// seen in enough files, before Options.Accept
+	DistinctCodes int
+	ValidCodes    int
+}
+
 // codeInfo tracks file indices and validation status for a code
 type codeInfo struct {
 	fileIndices map[int]struct{}
 	isValid     bool
+	// trusted is set once the code is seen in a trusted file
+	trusted bool
 }
 
-// processBucket processes a single bucket file to find valid codes
+// processBucket processes the files of a single bucket, its shards if it was
+// sharded, to find the codes seen in at least minFiles files, one of them
+// trusted unless trusted is nil
 // Optimized single-pass approach: builds valid codes list as we read
-func processBucket(bucketPath string) ([]string, error) {
-	f, err := os.Open(bucketPath)
+// With earlyExit, a code stops tracking its files once it is valid, which saves
+// memory; without it, every file of every code is tracked, so memory depends
+// only on the bucket contents rather than on the order codes are read.
+// The returned stats leave Bucket to the caller.
+func processBucket(bucketPaths []string, minFiles int, trusted []bool, earlyExit bool) ([]string, BucketStats, error) {
+	f, err := openBucket(bucketPaths)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open bucket file %s: %w", bucketPath, err)
+		return nil, BucketStats{}, err
 	}
 	defer f.Close()
 
 	codeMap := make(map[string]*codeInfo)
 	var validCodes []string
+	var stats BucketStats
 
-	scanner := bufio.NewScanner(f)
+	scanner := newBucketScanner(f)
 	for scanner.Scan() {
-		line := scanner.Text()
+		line := scanner.Bytes()
+		stats.Lines++
+		stats.Bytes += int64(len(line)) + 1
 
-		// Parse line: "code|fileIndex"
-		parts := strings.Split(line, "|")
-		if len(parts) != 2 {
+		code, fileIdx, ok := parseBucketLineBytes(line)
+		if !ok {
 			continue // Skip malformed lines
 		}
 
-		code := parts[0]
-		fileIdx, err := strconv.Atoi(parts[1])
-		if err != nil {
-			continue // Skip lines with invalid file index
-		}
-
-		// Get or create code info
-		info := codeMap[code]
+		// Get or create code info; the lookup converts code without allocating
+		info := codeMap[string(code)]
 		if info == nil {
 			info = &codeInfo{fileIndices: make(map[int]struct{})}
-			codeMap[code] = info
+			codeMap[string(code)] = info
 		}
 
 		// Only track file indices if not yet confirmed valid
-		if !info.isValid {
-			info.fileIndices[fileIdx] = struct{}{}
+		if info.isValid && earlyExit {
+			continue
+		}
+		info.fileIndices[fileIdx] = struct{}{}
+		info.trusted = info.trusted || isTrusted(trusted, fileIdx)
 
-			// As soon as we see 2+ files, mark as valid!
-			if len(info.fileIndices) >= 2 {
-				info.isValid = true
-				validCodes = append(validCodes, code)
+		// As soon as we see minFiles files, one of them trusted, mark as valid!
+		if !info.isValid && info.trusted && len(info.fileIndices) >= minFiles {
+			info.isValid = true
+			validCodes = append(validCodes, string(code))
+			if earlyExit {
 				info.fileIndices = nil // Free memory immediately!
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading bucket file %s: %w", bucketPath, err)
+		return nil, BucketStats{}, fmt.Errorf("error reading bucket file %s: %w", bucketPaths[0], err)
 	}
 
-	return validCodes, nil
+	stats.DistinctCodes = len(codeMap)
+	stats.ValidCodes = len(validCodes)
+	return validCodes, stats, nil
 }
 
-func processBucketsWorker(id int, bucketPath <-chan string, results chan<- []string) error {
+// processBucketsWorker processes buckets, given as the paths of their files,
+// from buckets until it is closed.
+// Buckets larger than maxBucketBytes are processed on disk; 0 disables the cap.
+// Codes are valid once seen in minFiles files, one of them trusted unless trusted
+// is nil; earlyExit is passed to processBucket.
+// A bucket that fails is passed to onBadBucket and skipped, or ends the worker if onBadBucket is nil.
+// If progress is set, the worker reports how many buckets it processed when it finishes.
+// The worker stops taking buckets once stop is closed.
+// If onBucket is set, it receives the paths and stats of every bucket processed.
+func processBucketsWorker(id int, buckets <-chan []string, results chan<- []string, stop <-chan struct{}, maxBucketBytes int64, minFiles int, trusted []bool, earlyExit bool, onBadBucket func(path string, err error), onBucket func(paths []string, stats BucketStats), progress func(string)) error {
 	processCount := 0
-	for path := range bucketPath {
+loop:
+	for paths := range buckets {
+		select {
+		case <-stop:
+			break loop
+		default:
+		}
 		processCount++
-		validCodes, err := processBucket(path)
-		if err != nil {
+		validCodes, stats, err := processBucketCapped(paths, maxBucketBytes, minFiles, trusted, earlyExit)
+		if err != nil && onBadBucket != nil {
+			onBadBucket(paths[0], err)
+			validCodes = nil
+		} else if err != nil {
 			return err
+		} else if onBucket != nil {
+			onBucket(paths, stats)
 		}
 		results <- validCodes
 	}
+	if progress != nil {
+		progress(fmt.Sprintf("    Worker %d finished: %d buckets processed", id, processCount))
+	}
 	return nil
 }
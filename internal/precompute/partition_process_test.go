@@ -2,9 +2,13 @@ package precompute
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -135,7 +139,7 @@ GOOD CODE|1`,
 			err := os.WriteFile(bucketPath, []byte(tt.content), 0644)
 			require.NoError(t, err, "Failed to create test bucket file")
 
-			validCodes, err := processBucket(bucketPath)
+			validCodes, _, err := processBucket([]string{bucketPath}, 2, nil, true)
 			require.NoError(t, err, "processBucket should not return error")
 
 			sort.Strings(validCodes)
@@ -170,7 +174,7 @@ func TestProcessBucket_LargeDataset(t *testing.T) {
 	err := os.WriteFile(bucketPath, []byte(content), 0644)
 	require.NoError(t, err, "Failed to create test bucket file")
 
-	validCodes, err := processBucket(bucketPath)
+	validCodes, _, err := processBucket([]string{bucketPath}, 2, nil, true)
 	require.NoError(t, err, "processBucket should not return error")
 
 	// All 1,000 codes should be valid
@@ -249,7 +253,7 @@ CODE1|1`,
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tmpDir := t.TempDir()
-			bucketPaths := make(chan string, len(tt.buckets)+len(tt.invalidBuckets))
+			buckets := make(chan []string, len(tt.buckets)+len(tt.invalidBuckets))
 			results := make(chan []string, len(tt.buckets)+len(tt.invalidBuckets))
 
 			// Create bucket files
@@ -257,21 +261,21 @@ CODE1|1`,
 				bucketPath := filepath.Join(tmpDir, fmt.Sprintf("bucket_%d.txt", i))
 				err := os.WriteFile(bucketPath, []byte(content), 0644)
 				require.NoError(t, err)
-				bucketPaths <- bucketPath
+				buckets <- []string{bucketPath}
 			}
 
 			// Add invalid buckets
 			for _, path := range tt.invalidBuckets {
-				bucketPaths <- filepath.Join(tmpDir, path)
+				buckets <- []string{filepath.Join(tmpDir, path)}
 			}
-			close(bucketPaths)
+			close(buckets)
 
 			// Run workers
 			errors := make(chan error, tt.numWorkers)
 			for w := 0; w < tt.numWorkers; w++ {
 				workerID := w
 				go func() {
-					errors <- processBucketsWorker(workerID, bucketPaths, results)
+					errors <- processBucketsWorker(workerID, buckets, results, nil, 0, 2, nil, true, nil, nil, nil)
 				}()
 			}
 
@@ -328,16 +332,16 @@ func BenchmarkProcessBucketsWorker_SingleWorker(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		bucketPaths := make(chan string, numBuckets)
+		buckets := make(chan []string, numBuckets)
 		results := make(chan []string, numBuckets)
 
 		for j := 0; j < numBuckets; j++ {
 			bucketPath := filepath.Join(tmpDir, "bucket_"+string(rune('0'+j))+".txt")
-			bucketPaths <- bucketPath
+			buckets <- []string{bucketPath}
 		}
-		close(bucketPaths)
+		close(buckets)
 
-		err := processBucketsWorker(1, bucketPaths, results)
+		err := processBucketsWorker(1, buckets, results, nil, 0, 2, nil, true, nil, nil, nil)
 		if err != nil {
 			b.Fatalf("processBucketsWorker() error = %v", err)
 		}
@@ -370,15 +374,15 @@ func BenchmarkProcessBucketsWorker_MultipleWorkers(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		bucketPaths := make(chan string, numBuckets)
+		buckets := make(chan []string, numBuckets)
 		results := make(chan []string, numBuckets)
 
 		// Fill bucket paths
 		for j := 0; j < numBuckets; j++ {
 			bucketPath := filepath.Join(tmpDir, "bucket_"+string(rune('0'+(j%10)))+string(rune('0'+(j/10)%10))+".txt")
-			bucketPaths <- bucketPath
+			buckets <- []string{bucketPath}
 		}
-		close(bucketPaths)
+		close(buckets)
 
 		// Run 4 workers
 		numWorkers := 4
@@ -386,7 +390,7 @@ func BenchmarkProcessBucketsWorker_MultipleWorkers(b *testing.B) {
 		for w := 0; w < numWorkers; w++ {
 			workerID := w
 			go func() {
-				errors <- processBucketsWorker(workerID, bucketPaths, results)
+				errors <- processBucketsWorker(workerID, buckets, results, nil, 0, 2, nil, true, nil, nil, nil)
 			}()
 		}
 
@@ -403,3 +407,214 @@ func BenchmarkProcessBucketsWorker_MultipleWorkers(b *testing.B) {
 		}
 	}
 }
+
+// TestBucketLine_RoundTrip verifies file indices survive being written to and read back from bucket lines
+func TestBucketLine_RoundTrip(t *testing.T) {
+	for _, fileIdx := range []int{0, 1, 2, 99_999, 100_000, math.MaxInt32, math.MaxInt32 + 1, math.MaxInt} {
+		code, idx, ok := parseBucketLine(formatBucketLine("HAPPYHRS", fileIdx))
+		require.True(t, ok, "index %d should parse", fileIdx)
+		assert.Equal(t, "HAPPYHRS", code)
+		assert.Equal(t, fileIdx, idx)
+	}
+
+	// Codes may contain the separator, as the index follows the last one
+	for _, code := range []string{"AB|CDEFGH", "ABCDEFGH|", "|ABCDEFGH", "A||B|1"} {
+		parsed, idx, ok := parseBucketLine(formatBucketLine(code, 7))
+		require.True(t, ok, "code %q should parse", code)
+		assert.Equal(t, code, parsed)
+		assert.Equal(t, 7, idx)
+	}
+
+	malformed := []string{
+		"",
+		"HAPPYHRS",
+		"HAPPYHRS|",
+		"|3",
+		"HAPPYHRS|-1",
+		"HAPPYHRS|+1",
+		"HAPPYHRS|1|x",
+		"HAPPYHRS|abc",
+		"HAPPYHRS|9223372036854775808", // MaxInt64 + 1
+	}
+	for _, line := range malformed {
+		_, _, ok := parseBucketLine(line)
+		assert.False(t, ok, "line %q should be rejected", line)
+	}
+}
+
+// bucketParserLines are bucket lines, well formed and malformed, both parsers must agree on
+var bucketParserLines = []string{
+	"HAPPYHRS|0",
+	"HAPPYHRS|007",
+	"AB|CDEFGH|12",
+	"ABCDEFGH||3",
+	"A||B|1",
+	"HAPPYHRS|9223372036854775807", // MaxInt64
+	"",
+	"|",
+	"HAPPYHRS",
+	"HAPPYHRS|",
+	"|3",
+	"HAPPYHRS|-1",
+	"HAPPYHRS|+1",
+	"HAPPYHRS| 1",
+	"HAPPYHRS|1 ",
+	"HAPPYHRS|1|x",
+	"HAPPYHRS|abc",
+	"HAPPYHRS|1_000",
+	"HAPPYHRS|0x10",
+	"HAPPYHRS|9223372036854775808",  // MaxInt64 + 1
+	"HAPPYHRS|18446744073709551616", // MaxUint64 + 1
+}
+
+// TestParseBucketLineBytes verifies the byte parser used by processBucket
+// returns the same results as parseBucketLine, including for malformed lines
+func TestParseBucketLineBytes(t *testing.T) {
+	for _, line := range bucketParserLines {
+		t.Run(line, func(t *testing.T) {
+			code, idx, ok := parseBucketLine(line)
+			codeBytes, idxBytes, okBytes := parseBucketLineBytes([]byte(line))
+			assert.Equal(t, ok, okBytes)
+			assert.Equal(t, idx, idxBytes)
+			assert.Equal(t, code, string(codeBytes))
+		})
+	}
+}
+
+func BenchmarkParseBucketLine(b *testing.B) {
+	lines := make([][]byte, len(bucketParserLines))
+	for i, line := range bucketParserLines {
+		lines[i] = []byte(line)
+	}
+
+	// String is what processBucket did before: a string per scanned line
+	b.Run("String", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, line := range lines {
+				parseBucketLine(string(line))
+			}
+		}
+	})
+	b.Run("Bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, line := range lines {
+				parseBucketLineBytes(line)
+			}
+		}
+	})
+}
+
+// TestProcessBucket_HighFileIndices verifies codes are matched by index when indices are large
+func TestProcessBucket_HighFileIndices(t *testing.T) {
+	tmpDir := t.TempDir()
+	bucketPath := filepath.Join(tmpDir, "bucket_000.txt")
+
+	// Indices that share a prefix or differ only past 32 bits must stay distinct
+	content := strings.Join([]string{
+		formatBucketLine("SAMEFILE", 100_000),
+		formatBucketLine("SAMEFILE", 100_000),
+		formatBucketLine("PREFIXED", 10_000),
+		formatBucketLine("PREFIXED", 100_000),
+		formatBucketLine("WIDEINDEX", 1<<32),
+		formatBucketLine("WIDEINDEX", 0),
+	}, "\n")
+	require.NoError(t, os.WriteFile(bucketPath, []byte(content), 0644))
+
+	validCodes, _, err := processBucket([]string{bucketPath}, 2, nil, true)
+	require.NoError(t, err)
+	sort.Strings(validCodes)
+	assert.Equal(t, []string{"PREFIXED", "WIDEINDEX"}, validCodes)
+}
+
+// TestRunPartitioned_SkipBadBuckets verifies a corrupt bucket is skipped while the good buckets still contribute
+func TestRunPartitioned_SkipBadBuckets(t *testing.T) {
+	// Bucket 1 holds a line longer than the scanner accepts, as a truncated
+	// or garbled temp file might
+	partition := func(tempDir string) (int, error) {
+		buckets := map[int]string{
+			0: "GOODCODE1|0\nGOODCODE1|1\n",
+			1: "BADCODE1|0\nBADCODE1|1\n" + strings.Repeat("X", scannerMaxBuffer+bucketLineOverhead+1) + "\n",
+			2: "GOODCODE2|1\nGOODCODE2|2\nONEFILE1|0\n",
+		}
+		for n, content := range buckets {
+			path := filepath.Join(tempDir, fmt.Sprintf("bucket_%03d.txt", n))
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				return 0, err
+			}
+		}
+		return 3, nil
+	}
+
+	for _, topK := range []int{0, 10} {
+		t.Run(fmt.Sprintf("TopK=%d", topK), func(t *testing.T) {
+			var stats Stats
+			_, err := runPartitioned(Options{Workers: 2, TopK: topK}, &stats, nil, partition)
+			require.Error(t, err, "A bad bucket should fail the run by default")
+
+			var warnings []string
+			opts := Options{
+				Workers:        2,
+				TopK:           topK,
+				SkipBadBuckets: true,
+				Progress: func(msg string) {
+					if strings.HasPrefix(msg, "Warning:") {
+						warnings = append(warnings, msg)
+					}
+				},
+			}
+			stats = Stats{}
+			codes, err := runPartitioned(opts, &stats, nil, partition)
+			require.NoError(t, err)
+			sort.Strings(codes)
+			assert.Equal(t, []string{"GOODCODE1", "GOODCODE2"}, codes)
+			assert.Equal(t, 1, stats.SkippedBuckets)
+			require.Len(t, warnings, 1)
+			assert.Contains(t, warnings[0], "bucket_001.txt")
+		})
+	}
+}
+
+// TestFindValidCodes_WorkerOutput verifies workers don't write to stdout, and
+// only report their bucket counts through Progress when Verbose is set
+func TestFindValidCodes_WorkerOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeSyntheticFiles(t, tmpDir, 3)
+
+	for _, verbose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verbose=%v", verbose), func(t *testing.T) {
+			stdout := os.Stdout
+			r, w, err := os.Pipe()
+			require.NoError(t, err)
+			os.Stdout = w
+			defer func() { os.Stdout = stdout }()
+
+			var mu sync.Mutex
+			var workerMessages []string
+			_, err = FindValidCodes(tmpDir, Options{Workers: 3, Verbose: verbose, Progress: func(msg string) {
+				mu.Lock()
+				defer mu.Unlock()
+				if strings.Contains(msg, "Worker") {
+					workerMessages = append(workerMessages, msg)
+				}
+			}})
+			require.NoError(t, err)
+
+			require.NoError(t, w.Close())
+			os.Stdout = stdout
+			written, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Empty(t, string(written), "Nothing should be written to stdout")
+
+			if !verbose {
+				assert.Empty(t, workerMessages)
+				return
+			}
+			require.Len(t, workerMessages, 3)
+			for _, msg := range workerMessages {
+				assert.Regexp(t, `Worker \d finished: \d+ buckets processed`, msg)
+			}
+		})
+	}
+}
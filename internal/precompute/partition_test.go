@@ -1,9 +1,12 @@
 package precompute
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -118,14 +121,16 @@ func TestFindValidCodesHashPartition_Scenarios(t *testing.T) {
 			files:         map[string]string{},
 			workerCount:   0,
 			expectedError: true,
+			errorContains: "directory is empty",
 		},
 		{
 			name: "LengthFiltering",
 			files: map[string]string{
 				"codes1.txt": "AB\nABCDEFG\nGOODCODE\nVERYLONGCODE123\nPERFECT10",
+				"codes2.txt": "AB\nABCDEFG\nGOODCODE\nVERYLONGCODE123\nPERFECT10",
 			},
 			workerCount:   1,
-			expectedCodes: nil, // Need 2+ files for validity, we only have 1, plus length filtering
+			expectedCodes: []string{"GOODCODE", "PERFECT10"}, // Too short and too long codes are dropped
 		},
 		{
 			name: "LargeWorkerCount",
@@ -202,6 +207,22 @@ func TestHashPartition_NonExistentDirectory(t *testing.T) {
 	assert.Error(t, err, "Expected error for non-existent directory")
 }
 
+// TestHashPartition_OnlySubdirectories tests the error for a directory holding only nested directories
+func TestHashPartition_OnlySubdirectories(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	for _, sub := range []string{"export1", "export2"} {
+		subDir := filepath.Join(tmpDir, sub)
+		require.NoError(t, os.Mkdir(subDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(subDir, "codes.txt"), []byte("TESTCODE\n"), 0644))
+	}
+
+	_, err := FindValidCodesHashPartition(tmpDir, nil, 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "contains only subdirectories (2)")
+}
+
 // TestHashPartition_MultipleRuns verifies consistent results across runs
 func TestHashPartition_MultipleRuns(t *testing.T) {
 	// Create a temporary test directory with multiple files
@@ -274,6 +295,32 @@ BESTCODE`
 	}
 }
 
+// TestHashPartition_ReadConcurrency verifies results don't depend on how many files are read at once
+func TestHashPartition_ReadConcurrency(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Spread codes over enough files that several readers run at the same time
+	for fileIdx := 0; fileIdx < 10; fileIdx++ {
+		content := ""
+		for i := 0; i < 500; i++ {
+			content += fmt.Sprintf("CODE%04d\n", (i*(fileIdx+1))%1000)
+		}
+		path := filepath.Join(tmpDir, fmt.Sprintf("codes%d.txt", fileIdx))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	serial, err := FindValidCodes(tmpDir, Options{ReadConcurrency: 1})
+	require.NoError(t, err)
+
+	parallel, err := FindValidCodes(tmpDir, Options{ReadConcurrency: 8})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, serial.Codes)
+	assert.Equal(t, serial.Codes, parallel.Codes, "Read concurrency should not change the result")
+	assert.Equal(t, serial.Stats.CodesRead, parallel.Stats.CodesRead)
+	assert.Equal(t, 8, parallel.Stats.Parameters.ReadConcurrency)
+}
+
 // TestHashCode_Collision tests that different codes can hash to same bucket
 func TestHashCode_Collision(t *testing.T) {
 	t.Parallel()
@@ -341,7 +388,7 @@ func BenchmarkProcessBucket_Small(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := processBucket(bucketPath)
+		_, _, err := processBucket([]string{bucketPath}, 2, nil, true)
 		if err != nil {
 			b.Fatalf("processBucket() error = %v", err)
 		}
@@ -366,7 +413,7 @@ func BenchmarkProcessBucket_Large(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := processBucket(bucketPath)
+		_, _, err := processBucket([]string{bucketPath}, 2, nil, true)
 		if err != nil {
 			b.Fatalf("processBucket() error = %v", err)
 		}
@@ -397,3 +444,1323 @@ func BenchmarkFindValidCodesHashPartition(b *testing.B) {
 		}
 	}
 }
+
+// TestHashPartition_TempFileMode verifies bucket files are created with the configured permissions
+func TestHashPartition_TempFileMode(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         os.FileMode
+		expectedMode os.FileMode
+	}{
+		{name: "default", mode: 0, expectedMode: 0600},
+		{name: "read only for owner", mode: 0400, expectedMode: 0400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for i, content := range []string{"HAPPYHRS\n", "HAPPYHRS\n", "FIFTYOFF\n"} {
+				path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			}
+
+			var bucketModes []os.FileMode
+			testHookPartitioned = func(tempDir string) {
+				paths, _ := filepath.Glob(filepath.Join(tempDir, "bucket_*.txt"))
+				for _, path := range paths {
+					info, err := os.Stat(path)
+					require.NoError(t, err)
+					bucketModes = append(bucketModes, info.Mode().Perm())
+				}
+			}
+			t.Cleanup(func() { testHookPartitioned = nil })
+
+			result, err := FindValidCodes(tmpDir, Options{TempFileMode: tt.mode})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"HAPPYHRS"}, result.Codes)
+			require.NotEmpty(t, bucketModes)
+			for _, mode := range bucketModes {
+				assert.Equal(t, tt.expectedMode, mode)
+			}
+		})
+	}
+}
+
+// TestHashPartition_SparseBuckets verifies only buckets that codes land in
+// get a file, by shared and sharded buckets
+func TestHashPartition_SparseBuckets(t *testing.T) {
+	codes := []string{"HAPPYHRS", "FIFTYOFF", "SUPER100"}
+	expectedBuckets := make(map[int]bool)
+	for _, code := range codes {
+		expectedBuckets[hashCode(code, defaultBuckets)] = true
+	}
+
+	tmpDir := t.TempDir()
+	for i := range 3 {
+		content := strings.Join(codes, "\n") + "\nSHORT\n"
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i)), []byte(content), 0644))
+	}
+
+	for _, opts := range []Options{{}, {ShardBuckets: true, ReadConcurrency: 2}} {
+		t.Run(fmt.Sprintf("shards=%d", opts.bucketShards()), func(t *testing.T) {
+			buckets := make(map[int]bool)
+			var bucketFiles []string
+			testHookPartitioned = func(tempDir string) {
+				bucketFiles, _ = filepath.Glob(filepath.Join(tempDir, "bucket_*.txt"))
+				for _, path := range bucketFiles {
+					var bucketNum int
+					_, err := fmt.Sscanf(filepath.Base(path), "bucket_%03d", &bucketNum)
+					require.NoError(t, err)
+					buckets[bucketNum] = true
+				}
+			}
+			t.Cleanup(func() { testHookPartitioned = nil })
+
+			result, err := FindValidCodes(tmpDir, opts)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"FIFTYOFF", "HAPPYHRS", "SUPER100"}, result.Codes)
+			assert.Equal(t, expectedBuckets, buckets)
+			assert.Less(t, len(bucketFiles), defaultBuckets)
+		})
+	}
+}
+
+// TestFindValidCodes_BucketStats verifies a run with BucketStats records one
+// entry per non-empty bucket, with the counts of the codes that landed in it,
+// whether buckets are processed in memory, on disk or sharded
+func TestFindValidCodes_BucketStats(t *testing.T) {
+	// Code i appears in 1 + i%3 of the 3 files, so two thirds are valid
+	tmpDir := t.TempDir()
+	files := make([][]string, 3)
+	expected := make(map[int]*BucketStats)
+	for i := range 300 {
+		code := fmt.Sprintf("STATS%04d", i)
+		b := hashCode(code, defaultBuckets)
+		if expected[b] == nil {
+			expected[b] = &BucketStats{Bucket: b}
+		}
+		expected[b].DistinctCodes++
+		if i%3 > 0 {
+			expected[b].ValidCodes++
+		}
+		for f := range 1 + i%3 {
+			files[f] = append(files[f], code)
+			expected[b].Lines++
+			expected[b].Bytes += int64(len(formatBucketLine(code, f))) + 1
+		}
+	}
+	for i, codes := range files {
+		content := strings.Join(codes, "\n") + "\nSHORT\n"
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i)), []byte(content), 0644))
+	}
+
+	var expectedBuckets []BucketStats
+	for b := range defaultBuckets {
+		if expected[b] != nil {
+			expectedBuckets = append(expectedBuckets, *expected[b])
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "InMemory", opts: Options{BucketStats: true}},
+		{name: "OnDisk", opts: Options{BucketStats: true, MaxBucketBytes: 1}},
+		{name: "Sharded", opts: Options{BucketStats: true, ShardBuckets: true, ReadConcurrency: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := FindValidCodes(tmpDir, tt.opts)
+			require.NoError(t, err)
+			assert.Len(t, result.Codes, 200)
+			assert.Equal(t, expectedBuckets, result.Stats.Buckets)
+		})
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		result, err := FindValidCodes(tmpDir, Options{})
+		require.NoError(t, err)
+		assert.Empty(t, result.Stats.Buckets)
+	})
+}
+
+// TestHashPartition_CleanupOnPanic verifies the temp directory is removed when a
+// worker panics, and that the panic still reaches the caller
+func TestHashPartition_CleanupOnPanic(t *testing.T) {
+	tmpRoot := t.TempDir()
+	t.Setenv("TMPDIR", tmpRoot)
+
+	inputDir := t.TempDir()
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(inputDir, fmt.Sprintf("file%d.txt", i))
+		require.NoError(t, os.WriteFile(path, []byte("HAPPYHRS\n"), 0644))
+	}
+
+	testHookPartitionFile = func(tempDir, filename string) {
+		panic("injected failure")
+	}
+	t.Cleanup(func() { testHookPartitionFile = nil })
+
+	assert.PanicsWithValue(t, "injected failure", func() {
+		FindValidCodes(inputDir, Options{})
+	})
+
+	entries, err := os.ReadDir(tmpRoot)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "temp directory should be removed after a panic")
+}
+
+// writeSyntheticFiles generates numFiles code files in dir. Every file holds a
+// code unique to it, and the first and last files also share "EDGECODE".
+// It returns the codes that are valid across the generated files.
+func writeSyntheticFiles(t testing.TB, dir string, numFiles int) []string {
+	t.Helper()
+
+	for i := 0; i < numFiles; i++ {
+		content := fmt.Sprintf("U%08d\n", i)
+		if i == 0 || i == numFiles-1 {
+			content += "EDGECODE\n"
+		}
+		path := filepath.Join(dir, fmt.Sprintf("codes_%06d.txt", i))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	return []string{"EDGECODE"}
+}
+
+// TestHashPartition_HighFileCount verifies file indices stay distinct with a large number of input files
+func TestHashPartition_HighFileCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping high file count test in short mode")
+	}
+
+	tmpDir := t.TempDir()
+	expected := writeSyntheticFiles(t, tmpDir, 100_000)
+
+	result, err := FindValidCodes(tmpDir, Options{ReadConcurrency: 8})
+	require.NoError(t, err)
+	assert.Equal(t, expected, result.Codes)
+	assert.Len(t, result.Stats.InputFiles, 100_000)
+}
+
+// TestFindValidCodes_Accept verifies the predicate drops codes that are valid by count
+// on both the partitioned and the two-file paths
+func TestFindValidCodes_Accept(t *testing.T) {
+	digitSuffix := func(code string) bool {
+		last := code[len(code)-1]
+		return last >= '0' && last <= '9'
+	}
+
+	tests := []struct {
+		name             string
+		contents         []string
+		expectedCodes    []string
+		expectedRejected int
+	}{
+		{
+			name: "partitioned",
+			contents: []string{
+				"HAPPYHRS\nSUPER100\nTESTCODE1\n",
+				"HAPPYHRS\nSUPER100\n",
+				"TESTCODE1\nONLYONCE1\n",
+			},
+			expectedCodes:    []string{"SUPER100", "TESTCODE1"},
+			expectedRejected: 1,
+		},
+		{
+			name: "two files",
+			contents: []string{
+				"HAPPYHRS\nFIFTYOFF\nSUPER100\n",
+				"HAPPYHRS\nFIFTYOFF\nSUPER100\n",
+			},
+			expectedCodes:    []string{"SUPER100"},
+			expectedRejected: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for i, content := range tt.contents {
+				path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			}
+
+			result, err := FindValidCodes(tmpDir, Options{Accept: digitSuffix})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCodes, result.Codes)
+			assert.Equal(t, tt.expectedRejected, result.Stats.CodesRejected)
+			assert.Equal(t, len(tt.expectedCodes), result.Stats.ValidCodes)
+		})
+	}
+}
+
+// TestFindValidCodes_RequireAll verifies only codes present in every file are valid
+func TestFindValidCodes_RequireAll(t *testing.T) {
+	tests := []struct {
+		name          string
+		contents      []string
+		opts          Options
+		expectedCodes []string
+	}{
+		{
+			name: "three files",
+			contents: []string{
+				"EVERYWHERE\nTWOFILES1\nHAPPYHRS\n",
+				"EVERYWHERE\nTWOFILES1\n",
+				"EVERYWHERE\nHAPPYHRS\n",
+			},
+			opts:          Options{RequireAll: true},
+			expectedCodes: []string{"EVERYWHERE"},
+		},
+		{
+			// Repeats within a file must not count towards the threshold
+			name: "repeats in one file",
+			contents: []string{
+				"EVERYWHERE\nREPEATED1\nREPEATED1\nREPEATED1\nREPEATED1\n",
+				"EVERYWHERE\nREPEATED1\n",
+				"EVERYWHERE\n",
+				"EVERYWHERE\nREPEATED1\n",
+				"EVERYWHERE\nREPEATED1\n",
+			},
+			opts:          Options{RequireAll: true},
+			expectedCodes: []string{"EVERYWHERE"},
+		},
+		{
+			name: "spilled buckets",
+			contents: []string{
+				"EVERYWHERE\nTWOFILES1\n",
+				"EVERYWHERE\nTWOFILES1\n",
+				"EVERYWHERE\n",
+			},
+			opts:          Options{RequireAll: true, MaxBucketBytes: 1},
+			expectedCodes: []string{"EVERYWHERE"},
+		},
+		{
+			name: "two files",
+			contents: []string{
+				"EVERYWHERE\nONLYFIRST\n",
+				"EVERYWHERE\nONLYSECOND\n",
+			},
+			opts:          Options{RequireAll: true},
+			expectedCodes: []string{"EVERYWHERE"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for i, content := range tt.contents {
+				path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			}
+
+			result, err := FindValidCodes(tmpDir, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCodes, result.Codes)
+			assert.Equal(t, len(tt.contents), result.Stats.Parameters.MinFiles)
+		})
+	}
+}
+
+// TestFindValidCodes_MinFiles verifies codes are valid once they appear in
+// MinFiles input files
+func TestFindValidCodes_MinFiles(t *testing.T) {
+	fiveFiles := []string{
+		"INFIVE001\nINTHREE01\nINTWO0001\nINONE0001\n",
+		"INFIVE001\nINTHREE01\nINTWO0001\n",
+		"INFIVE001\nINTHREE01\n",
+		"INFIVE001\n",
+		"INFIVE001\n",
+	}
+
+	tests := []struct {
+		name              string
+		contents          []string
+		opts              Options
+		expectedCodes     []string
+		expectedAlgorithm string
+		expectedErr       string
+	}{
+		{
+			name:              "one",
+			contents:          fiveFiles,
+			opts:              Options{MinFiles: 1},
+			expectedCodes:     []string{"INFIVE001", "INONE0001", "INTHREE01", "INTWO0001"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "default two",
+			contents:          fiveFiles,
+			expectedCodes:     []string{"INFIVE001", "INTHREE01", "INTWO0001"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "three",
+			contents:          fiveFiles,
+			opts:              Options{MinFiles: 3},
+			expectedCodes:     []string{"INFIVE001", "INTHREE01"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "three spilled",
+			contents:          fiveFiles,
+			opts:              Options{MinFiles: 3, MaxBucketBytes: 1},
+			expectedCodes:     []string{"INFIVE001", "INTHREE01"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "three top-K",
+			contents:          fiveFiles,
+			opts:              Options{MinFiles: 3, TopK: 10},
+			expectedCodes:     []string{"INFIVE001", "INTHREE01"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			// The in-memory intersection only applies when codes must be in both files
+			name:              "one of two files",
+			contents:          fiveFiles[:2],
+			opts:              Options{MinFiles: 1},
+			expectedCodes:     []string{"INFIVE001", "INONE0001", "INTHREE01", "INTWO0001"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "two of two files",
+			contents:          fiveFiles[:2],
+			opts:              Options{MinFiles: 2},
+			expectedCodes:     []string{"INFIVE001", "INTHREE01", "INTWO0001"},
+			expectedAlgorithm: "two-file",
+		},
+		{
+			name:        "more than the files",
+			contents:    fiveFiles,
+			opts:        Options{MinFiles: 6},
+			expectedErr: "codes must appear in 6 files but there are only 5 input files",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for i, content := range tt.contents {
+				path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			}
+
+			result, err := FindValidCodes(tmpDir, tt.opts)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			sort.Strings(result.Codes)
+			assert.Equal(t, tt.expectedCodes, result.Codes)
+			assert.Equal(t, tt.expectedAlgorithm, result.Stats.Algorithm)
+			assert.Equal(t, tt.opts.minFiles(len(tt.contents)), result.Stats.Parameters.MinFiles)
+		})
+	}
+}
+
+// TestFindValidCodes_TrustedFiles verifies that with trusted files a code
+// must be in one of them besides being in enough files, by every algorithm
+func TestFindValidCodes_TrustedFiles(t *testing.T) {
+	files := map[string]string{
+		"official.txt":  "TRUSTONLY1\nCONFIRMED1\nEVERYWHERE\n",
+		"partner_a.txt": "UNTRUSTED1\nCONFIRMED1\nEVERYWHERE\n",
+		"partner_b.txt": "UNTRUSTED1\nEVERYWHERE\n",
+	}
+
+	tests := []struct {
+		name              string
+		files             []string
+		opts              Options
+		expectedCodes     []string
+		expectedAlgorithm string
+		expectedErr       string
+	}{
+		{
+			name:              "none trusted",
+			expectedCodes:     []string{"CONFIRMED1", "EVERYWHERE", "UNTRUSTED1"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "trusted",
+			opts:              Options{TrustedFiles: []string{"official.txt"}},
+			expectedCodes:     []string{"CONFIRMED1", "EVERYWHERE"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "pattern",
+			opts:              Options{TrustedFiles: []string{"nothing*", "off*"}},
+			expectedCodes:     []string{"CONFIRMED1", "EVERYWHERE"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "spilled",
+			opts:              Options{TrustedFiles: []string{"official.txt"}, MaxBucketBytes: 1},
+			expectedCodes:     []string{"CONFIRMED1", "EVERYWHERE"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "top-K",
+			opts:              Options{TrustedFiles: []string{"official.txt"}, TopK: 10},
+			expectedCodes:     []string{"CONFIRMED1", "EVERYWHERE"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "without early exit",
+			opts:              Options{TrustedFiles: []string{"official.txt"}, DisableEarlyExit: true},
+			expectedCodes:     []string{"CONFIRMED1", "EVERYWHERE"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "partners trusted",
+			opts:              Options{TrustedFiles: []string{"partner_*"}},
+			expectedCodes:     []string{"CONFIRMED1", "EVERYWHERE", "UNTRUSTED1"},
+			expectedAlgorithm: "hash-partition",
+		},
+		{
+			name:              "two files",
+			files:             []string{"official.txt", "partner_a.txt"},
+			opts:              Options{TrustedFiles: []string{"official.txt"}},
+			expectedCodes:     []string{"CONFIRMED1", "EVERYWHERE"},
+			expectedAlgorithm: "two-file",
+		},
+		{
+			name:        "no match",
+			opts:        Options{TrustedFiles: []string{"missing.txt"}},
+			expectedErr: "no input file matches the trusted file patterns",
+		},
+		{
+			name:        "malformed pattern",
+			opts:        Options{TrustedFiles: []string{"["}},
+			expectedErr: "invalid trusted file pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := tt.files
+			if names == nil {
+				names = []string{"official.txt", "partner_a.txt", "partner_b.txt"}
+			}
+			tmpDir := t.TempDir()
+			for _, name := range names {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(files[name]), 0644))
+			}
+
+			result, err := FindValidCodes(tmpDir, tt.opts)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			sort.Strings(result.Codes)
+			assert.Equal(t, tt.expectedCodes, result.Codes)
+			assert.Equal(t, tt.expectedAlgorithm, result.Stats.Algorithm)
+			assert.Equal(t, tt.opts.TrustedFiles, result.Stats.Parameters.TrustedFiles)
+		})
+	}
+}
+
+// TestFindValidCodes_MaxOutput verifies a run stops at MaxOutput valid codes,
+// keeping exactly that many and warning, by every algorithm
+func TestFindValidCodes_MaxOutput(t *testing.T) {
+	// 200 codes valid in every file, spread over all the buckets
+	var b strings.Builder
+	valid := make(map[string]bool)
+	for i := range 200 {
+		code := fmt.Sprintf("CODE%05d", i)
+		valid[code] = true
+		b.WriteString(code + "\n")
+	}
+	content := b.String()
+
+	tests := []struct {
+		name      string
+		numFiles  int
+		opts      Options
+		expected  int
+		truncated bool
+	}{
+		{name: "partitioned", numFiles: 3, opts: Options{MaxOutput: 10}, expected: 10, truncated: true},
+		{name: "partitioned one worker", numFiles: 3, opts: Options{MaxOutput: 10, Workers: 1}, expected: 10, truncated: true},
+		{name: "spilled", numFiles: 3, opts: Options{MaxOutput: 10, MaxBucketBytes: 1}, expected: 10, truncated: true},
+		{name: "top-K", numFiles: 3, opts: Options{MaxOutput: 10, TopK: 50}, expected: 10, truncated: true},
+		{name: "two files", numFiles: 2, opts: Options{MaxOutput: 10}, expected: 10, truncated: true},
+		{name: "above the valid codes", numFiles: 3, opts: Options{MaxOutput: 500}, expected: 200},
+		// Exactly as many valid codes as the cap drops none
+		{name: "at the valid codes", numFiles: 3, opts: Options{MaxOutput: 200}, expected: 200},
+		{name: "at the valid codes one worker", numFiles: 3, opts: Options{MaxOutput: 200, Workers: 1}, expected: 200},
+		{name: "at the valid codes spilled", numFiles: 3, opts: Options{MaxOutput: 200, MaxBucketBytes: 1}, expected: 200},
+		{name: "at the valid codes top-K", numFiles: 3, opts: Options{MaxOutput: 200, TopK: 500}, expected: 200},
+		{name: "at the valid codes two files", numFiles: 2, opts: Options{MaxOutput: 200}, expected: 200},
+		{name: "no cap", numFiles: 3, expected: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for i := range tt.numFiles {
+				path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			}
+
+			var mu sync.Mutex
+			var warnings []string
+			opts := tt.opts
+			opts.Progress = func(msg string) {
+				if strings.HasPrefix(msg, "Warning:") {
+					mu.Lock()
+					warnings = append(warnings, msg)
+					mu.Unlock()
+				}
+			}
+
+			result, err := FindValidCodes(tmpDir, opts)
+			require.NoError(t, err)
+			require.Len(t, result.Codes, tt.expected)
+			for _, code := range result.Codes {
+				assert.True(t, valid[code], "unexpected code %q", code)
+			}
+			assert.Equal(t, tt.expected, result.Stats.ValidCodes)
+			assert.Equal(t, tt.truncated, result.Stats.Truncated)
+			assert.Equal(t, max(tt.opts.MaxOutput, 0), result.Stats.Parameters.MaxOutput)
+
+			if tt.truncated {
+				require.Len(t, warnings, 1)
+				assert.Contains(t, warnings[0], "maximum output of 10 valid codes")
+				if tt.opts.TopK <= 0 {
+					assert.True(t, sort.StringsAreSorted(result.Codes), "codes should still be sorted")
+				}
+			} else {
+				assert.Empty(t, warnings)
+			}
+		})
+	}
+}
+
+// TestFindValidCodes_Tokenize verifies every token of a multi-code line is a
+// candidate code, filtered by length on its own, by both algorithms
+func TestFindValidCodes_Tokenize(t *testing.T) {
+	files := []string{
+		"HAPPYHRS FIFTYOFF\tSHORT\n  SUPER100   TOOLONGCODE12\n\n",
+		"FIFTYOFF\nSUPER100 ONLYHERE\n",
+		"HAPPYHRS\n",
+	}
+
+	tests := []struct {
+		name      string
+		files     []string
+		opts      Options
+		expected  []string
+		codesRead int64
+	}{
+		{
+			name:      "partitioned",
+			files:     files,
+			opts:      Options{Tokenize: true},
+			expected:  []string{"FIFTYOFF", "HAPPYHRS", "SUPER100"},
+			codesRead: 9,
+		},
+		{
+			name:      "two files",
+			files:     files[:2],
+			opts:      Options{Tokenize: true},
+			expected:  []string{"FIFTYOFF", "SUPER100"},
+			codesRead: 8,
+		},
+		{
+			// Whole lines with several codes are too long to be a code
+			name:      "lines by default",
+			files:     files,
+			expected:  nil,
+			codesRead: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for i, content := range tt.files {
+				path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			}
+
+			result, err := FindValidCodes(tmpDir, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.Codes)
+			assert.Equal(t, tt.codesRead, result.Stats.CodesRead)
+			assert.Equal(t, tt.opts.Tokenize, result.Stats.Parameters.Tokenize)
+		})
+	}
+}
+
+// TestValidateParameters verifies configurations that can't match any code are rejected
+func TestValidateParameters(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    Parameters
+		numFiles  int
+		wantError string
+	}{
+		{
+			name:     "defaults",
+			params:   Parameters{MinLength: 8, MaxLength: 10, MinFiles: 2},
+			numFiles: 3,
+		},
+		{
+			name:     "every file",
+			params:   Parameters{MinLength: 8, MaxLength: 10, MinFiles: 3},
+			numFiles: 3,
+		},
+		{
+			name:     "file count unknown",
+			params:   Parameters{MinLength: 8, MaxLength: 10, MinFiles: 5},
+			numFiles: 0,
+		},
+		{
+			name:      "min length above max length",
+			params:    Parameters{MinLength: 11, MaxLength: 10, MinFiles: 2},
+			numFiles:  3,
+			wantError: "minimum code length 11 is greater than the maximum 10",
+		},
+		{
+			name:      "min length below one",
+			params:    Parameters{MinLength: -1, MaxLength: 10, MinFiles: 2},
+			numFiles:  3,
+			wantError: "minimum code length must be at least 1, got -1",
+		},
+		{
+			name:      "min files above file count",
+			params:    Parameters{MinLength: 8, MaxLength: 10, MinFiles: 4},
+			numFiles:  3,
+			wantError: "codes must appear in 4 files but there are only 3 input files",
+		},
+		{
+			name:      "min files zero",
+			params:    Parameters{MinLength: 8, MaxLength: 10, MinFiles: 0},
+			numFiles:  3,
+			wantError: "codes must appear in at least 1 file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateParameters(tt.params, tt.numFiles)
+			if tt.wantError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantError)
+		})
+	}
+}
+
+// TestFindValidCodes_TooFewFiles verifies a run that needs more files than it has fails before reading them
+func TestFindValidCodes_TooFewFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "only.txt"), []byte("ABCDEFGH\nABCDEFGH\n"), 0644))
+
+	var messages []string
+	_, err := FindValidCodes(tmpDir, Options{Progress: func(msg string) { messages = append(messages, msg) }})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "codes must appear in 2 files but there are only 1 input files")
+	assert.Empty(t, messages, "No work should start")
+}
+
+// TestFindValidCodes_SymlinkedDuplicate verifies a symlink to another input is
+// read once, so codes only in that file aren't counted as in two files
+func TestFindValidCodes_SymlinkedDuplicate(t *testing.T) {
+	tmpDir := t.TempDir()
+	testData := map[string]string{
+		"file1.txt": "SHAREDAA\nONLYINONE\n",
+		"file2.txt": "SHAREDAA\nTWOONLYX\n",
+		"file3.txt": "THREEONLY\n",
+	}
+	for filename, content := range testData {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, filename), []byte(content), 0644))
+	}
+	linkPath := filepath.Join(tmpDir, "link.txt")
+	if err := os.Symlink(filepath.Join(tmpDir, "file1.txt"), linkPath); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		allowDuplicate bool
+		expected       []string
+		duplicates     []string
+	}{
+		{name: "deduplicated", expected: []string{"SHAREDAA"}, duplicates: []string{linkPath}},
+		{name: "duplicates allowed", allowDuplicate: true, expected: []string{"ONLYINONE", "SHAREDAA"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []string
+			result, err := FindValidCodes(tmpDir, Options{
+				AllowDuplicateFiles: tt.allowDuplicate,
+				Progress:            func(msg string) { messages = append(messages, msg) },
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.Codes)
+			assert.Equal(t, tt.duplicates, result.Stats.DuplicateFiles)
+			if tt.duplicates != nil {
+				assert.Len(t, result.Stats.InputFiles, 3)
+				assert.Contains(t, messages, "Warning: skipping "+linkPath+", the same file as "+filepath.Join(tmpDir, "file1.txt"))
+			}
+		})
+	}
+}
+
+// TestFindValidCodes_Sort verifies each output order on a small known set
+func TestFindValidCodes_Sort(t *testing.T) {
+	tmpDir := t.TempDir()
+	testData := map[string]string{
+		"file1.txt": "AAAAAAAAAA\nZZZZZZZZ\nMMMMMMMMM\nBBBBBBBB\n",
+		"file2.txt": "AAAAAAAAAA\nZZZZZZZZ\nMMMMMMMMM\nBBBBBBBB\n",
+		"file3.txt": "ZZZZZZZZ\nONLYHERE\n",
+	}
+	for filename, content := range testData {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, filename), []byte(content), 0644))
+	}
+
+	alpha := []string{"AAAAAAAAAA", "BBBBBBBB", "MMMMMMMMM", "ZZZZZZZZ"}
+
+	tests := []struct {
+		name      string
+		sort      string
+		topK      int
+		expected  []string
+		wantError string
+	}{
+		{name: "default", expected: alpha},
+		{name: "alpha", sort: SortAlpha, expected: alpha},
+		{name: "length", sort: SortLength, expected: []string{"BBBBBBBB", "ZZZZZZZZ", "MMMMMMMMM", "AAAAAAAAAA"}},
+		{name: "none", sort: SortNone, expected: alpha}, // Compared as a set
+		{name: "count", sort: SortCount, topK: 10, expected: []string{"ZZZZZZZZ", "AAAAAAAAAA", "BBBBBBBB", "MMMMMMMMM"}},
+		{name: "default with top-K", topK: 10, expected: []string{"ZZZZZZZZ", "AAAAAAAAAA", "BBBBBBBB", "MMMMMMMMM"}},
+		{name: "alpha with top-K", sort: SortAlpha, topK: 10, expected: alpha},
+		{name: "length with top-K", sort: SortLength, topK: 3, expected: []string{"BBBBBBBB", "ZZZZZZZZ", "AAAAAAAAAA"}},
+		{name: "count without top-K", sort: SortCount, wantError: "sorting by count requires a top-K run"},
+		{name: "unknown", sort: "random", wantError: `unknown sort order "random"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := FindValidCodes(tmpDir, Options{Sort: tt.sort, TopK: tt.topK})
+			if tt.wantError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			if tt.sort == SortNone {
+				assert.ElementsMatch(t, tt.expected, result.Codes)
+				return
+			}
+			assert.Equal(t, tt.expected, result.Codes)
+		})
+	}
+}
+
+// TestFindValidCodes_LongCodes verifies codes longer than bufio.Scanner's
+// default 64 KB limit, which the inputs allow, are read back from buckets
+func TestFindValidCodes_LongCodes(t *testing.T) {
+	long := strings.Repeat("L", 70_000)
+	tmpDir := t.TempDir()
+	// Three files, as two are intersected in memory without buckets
+	for i := 0; i < 3; i++ {
+		content := long + "\nPLAINCODE\n"
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i)), []byte(content), 0644))
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "in memory", opts: Options{}},
+		{name: "spilled", opts: Options{MaxBucketBytes: 16}},
+		{name: "top-K", opts: Options{TopK: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.MaxLength = len(long)
+			result, err := FindValidCodes(tmpDir, opts)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{long, "PLAINCODE"}, result.Codes)
+		})
+	}
+}
+
+// TestFindValidCodes_CodesWithPipes verifies codes containing the bucket line separator are matched across files
+func TestFindValidCodes_CodesWithPipes(t *testing.T) {
+	tmpDir := t.TempDir()
+	testData := map[string]string{
+		"file1.txt": "AB|CD|EFGH\nPIPE|0|ONE\nPLAINCODE\n",
+		"file2.txt": "AB|CD|EFGH\nPIPE|1|ONE\n",
+		"file3.txt": "PLAINCODE\nEND|PIPE|\n",
+		"file4.txt": "END|PIPE|\n",
+	}
+	for filename, content := range testData {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, filename), []byte(content), 0644))
+	}
+
+	result, err := FindValidCodes(tmpDir, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AB|CD|EFGH", "END|PIPE|", "PLAINCODE"}, result.Codes)
+}
+
+// TestPrefixBucketer verifies prefix buckets are in range and ordered like the codes
+func TestPrefixBucketer(t *testing.T) {
+	codes := []string{"", "0", "00000000", "A", "AB", "ABCDEFGH", "ABZZZZZZ", "B", "ZZZZZZZZ", "zzzzzzzz", "\xff\xff\xff"}
+	for k := 1; k <= maxPrefixLength; k++ {
+		bucketOf := prefixBucketer(k)
+		prev := 0
+		for _, code := range codes { // Sorted
+			bucket := bucketOf(code, defaultBuckets)
+			assert.GreaterOrEqual(t, bucket, 0)
+			assert.Less(t, bucket, defaultBuckets)
+			assert.GreaterOrEqual(t, bucket, prev, "k=%d: %q should not go in an earlier bucket than the code before it", k, code)
+			prev = bucket
+		}
+	}
+}
+
+// TestFindValidCodes_PartitionByPrefix verifies prefix partitioning finds the same codes as hash partitioning
+func TestFindValidCodes_PartitionByPrefix(t *testing.T) {
+	tmpDir := t.TempDir()
+	for f := 0; f < 3; f++ {
+		var sb strings.Builder
+		for i := 0; i < 1500; i++ {
+			// Each file overlaps the next by 500 codes, the rest are in one file only
+			n := (i + f*1000) % 4000
+			fmt.Fprintf(&sb, "%c%c%07d\n", 'A'+n%26, '0'+n/26%10, n)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", f)), []byte(sb.String()), 0644))
+	}
+
+	hashed, err := FindValidCodes(tmpDir, Options{})
+	require.NoError(t, err)
+	require.Len(t, hashed.Codes, 1000)
+	assert.Equal(t, PartitionHash, hashed.Stats.Parameters.PartitionBy)
+
+	for k := 0; k <= maxPrefixLength; k++ {
+		t.Run(fmt.Sprintf("k=%d", k), func(t *testing.T) {
+			prefixed, err := FindValidCodes(tmpDir, Options{PartitionBy: PartitionPrefix, PrefixLength: k})
+			require.NoError(t, err)
+			assert.Equal(t, hashed.Codes, prefixed.Codes)
+			assert.Equal(t, PartitionPrefix, prefixed.Stats.Parameters.PartitionBy)
+			wantLength := k
+			if k == 0 {
+				wantLength = defaultPrefixLength
+			}
+			assert.Equal(t, wantLength, prefixed.Stats.Parameters.PrefixLength)
+		})
+	}
+
+	_, err = FindValidCodes(tmpDir, Options{PartitionBy: PartitionPrefix, PrefixLength: 4})
+	assert.ErrorContains(t, err, "prefix length must be between 1 and 3")
+	_, err = FindValidCodes(tmpDir, Options{PartitionBy: "range"})
+	assert.ErrorContains(t, err, `unknown partition mode "range"`)
+}
+
+// TestFindValidCodes_ShardBuckets verifies per-reader bucket shards give the
+// same codes as shared buckets, in memory, on disk and when ranking
+func TestFindValidCodes_ShardBuckets(t *testing.T) {
+	tmpDir := t.TempDir()
+	for f := 0; f < 4; f++ {
+		var sb strings.Builder
+		for i := 0; i < 2000; i++ {
+			// Each file overlaps the next by 1000 codes
+			fmt.Fprintf(&sb, "SHARD%05d\n", i+f*1000)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", f)), []byte(sb.String()), 0644))
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "in memory", opts: Options{}},
+		{name: "on disk", opts: Options{MaxBucketBytes: 16}},
+		{name: "top-K", opts: Options{TopK: 50}},
+	}
+
+	for _, tt := range tests {
+		for _, readConcurrency := range []int{1, 3} {
+			t.Run(fmt.Sprintf("%s/readers=%d", tt.name, readConcurrency), func(t *testing.T) {
+				shared := tt.opts
+				shared.ReadConcurrency = readConcurrency
+				expected, err := FindValidCodes(tmpDir, shared)
+				require.NoError(t, err)
+				require.NotEmpty(t, expected.Codes)
+				assert.Zero(t, expected.Stats.Parameters.Shards)
+
+				sharded := shared
+				sharded.ShardBuckets = true
+				var shardFiles []string
+				testHookPartitioned = func(tempDir string) {
+					shardFiles, _ = filepath.Glob(filepath.Join(tempDir, "bucket_000.shard_*.txt"))
+				}
+				defer func() { testHookPartitioned = nil }()
+
+				result, err := FindValidCodes(tmpDir, sharded)
+				require.NoError(t, err)
+				assert.Equal(t, expected.Codes, result.Codes)
+				assert.Equal(t, expected.Stats.CodesRead, result.Stats.CodesRead)
+				assert.Equal(t, readConcurrency, result.Stats.Parameters.Shards)
+				// A shard is only written once a reader takes it, which a fast
+				// reader finishing every file first can leave unused
+				assert.NotEmpty(t, shardFiles)
+				assert.LessOrEqual(t, len(shardFiles), readConcurrency, "Readers should write no more than one shard each")
+			})
+		}
+	}
+}
+
+// TestFindValidCodes_FilterReasons verifies lines dropped before counting
+// are counted by reason, adding up to CodesFiltered, by every algorithm
+func TestFindValidCodes_FilterReasons(t *testing.T) {
+	// Two empty lines, one too short, one too long, one not UTF-8 and two kept
+	const content = "\nSHORT\nVALIDCODE\nWAYTOOLONGCODE\nBAD\xffCODE1\n\nOTHERCODE\n"
+
+	tests := []struct {
+		name              string
+		numFiles          int
+		opts              Options
+		expectedAlgorithm string
+		expectedLines     int64
+		expectedCodes     int64
+		expectedFiltered  FilterCounts
+	}{
+		{
+			name:              "hash partition",
+			numFiles:          3,
+			opts:              Options{InvalidUTF8: UTF8Skip},
+			expectedAlgorithm: "hash-partition",
+			expectedLines:     21,
+			expectedCodes:     21,
+			expectedFiltered:  FilterCounts{Empty: 6, TooShort: 3, TooLong: 3, InvalidUTF8: 3},
+		},
+		{
+			name:              "two files",
+			numFiles:          2,
+			opts:              Options{InvalidUTF8: UTF8Skip},
+			expectedAlgorithm: "two-file",
+			expectedLines:     14,
+			expectedCodes:     14,
+			expectedFiltered:  FilterCounts{Empty: 4, TooShort: 2, TooLong: 2, InvalidUTF8: 2},
+		},
+		{
+			name:              "invalid UTF-8 kept",
+			numFiles:          3,
+			expectedAlgorithm: "hash-partition",
+			expectedLines:     21,
+			expectedCodes:     21,
+			expectedFiltered:  FilterCounts{Empty: 6, TooShort: 3, TooLong: 3},
+		},
+		{
+			// Empty lines have no tokens, so only the other lines are counted as codes
+			name:              "tokenized",
+			numFiles:          3,
+			opts:              Options{InvalidUTF8: UTF8Skip, Tokenize: true},
+			expectedAlgorithm: "hash-partition",
+			expectedLines:     21,
+			expectedCodes:     15,
+			expectedFiltered:  FilterCounts{TooShort: 3, TooLong: 3, InvalidUTF8: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for i := 0; i < tt.numFiles; i++ {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i)), []byte(content), 0644))
+			}
+
+			result, err := FindValidCodes(tmpDir, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedAlgorithm, result.Stats.Algorithm)
+			assert.Equal(t, tt.expectedLines, result.Stats.LinesRead)
+			assert.Equal(t, tt.expectedCodes, result.Stats.CodesRead)
+			assert.Equal(t, tt.expectedFiltered, result.Stats.Filtered)
+
+			f := tt.expectedFiltered
+			assert.Equal(t, f.Empty+f.TooShort+f.TooLong+f.InvalidUTF8+f.Malformed, result.Stats.CodesFiltered)
+		})
+	}
+}
+
+// TestFindValidCodes_Buckets verifies the number of buckets only changes how
+// codes are spread on disk, not which are valid
+func TestFindValidCodes_Buckets(t *testing.T) {
+	tmpDir := t.TempDir()
+	for f := 0; f < 3; f++ {
+		var sb strings.Builder
+		for i := 0; i < 2000; i++ {
+			// Each file overlaps the next by 1000 codes
+			fmt.Fprintf(&sb, "BUCKET%04d\n", i+f*1000)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", f)), []byte(sb.String()), 0644))
+	}
+
+	expected, err := FindValidCodes(tmpDir, Options{})
+	require.NoError(t, err)
+	require.Len(t, expected.Codes, 2000)
+	assert.Equal(t, defaultBuckets, expected.Stats.Parameters.Buckets)
+
+	tests := []struct {
+		name        string
+		opts        Options
+		expectedErr string
+	}{
+		{name: "few", opts: Options{Buckets: 4}},
+		{name: "many", opts: Options{Buckets: 10_000}},
+		{name: "single", opts: Options{Buckets: 1}},
+		{name: "prefix", opts: Options{Buckets: 4, PartitionBy: PartitionPrefix}},
+		{name: "top-K", opts: Options{Buckets: 4, TopK: 2000}},
+		{name: "negative", opts: Options{Buckets: -1}, expectedErr: "number of buckets must be positive, got -1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bucketFiles []string
+			testHookPartitioned = func(tempDir string) {
+				bucketFiles, _ = filepath.Glob(filepath.Join(tempDir, "bucket_*.txt"))
+			}
+			defer func() { testHookPartitioned = nil }()
+
+			result, err := FindValidCodes(tmpDir, tt.opts)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.ElementsMatch(t, expected.Codes, result.Codes)
+			assert.Equal(t, tt.opts.Buckets, result.Stats.Parameters.Buckets)
+			assert.LessOrEqual(t, len(bucketFiles), tt.opts.Buckets)
+		})
+	}
+}
+
+// TestFindValidCodes_LengthUnit verifies codes with multi-byte characters are
+// measured in bytes by default and in graphemes when asked to, both when
+// filtering and when sorting by length
+func TestFindValidCodes_LengthUnit(t *testing.T) {
+	const (
+		emoji     = "🎉party🎉!"               // 8 graphemes, 13 bytes
+		family    = "famx👨\u200d👩\u200d👧abc" // 8 graphemes, the family being one
+		accented  = "re\u0301sume\u0301s!"   // 8 graphemes, each e and its accent being one
+		tooLong   = "🇫🇷🇩🇪abcdefghi"          // 11 graphemes, each flag being one
+		plainCode = "PLAINCODE"              // 9 of either
+	)
+
+	tests := []struct {
+		name          string
+		lengthUnit    string
+		sort          string
+		expectedCodes []string
+		expectedErr   string
+	}{
+		{name: "default bytes", expectedCodes: []string{plainCode}},
+		{name: "bytes", lengthUnit: LengthBytes, expectedCodes: []string{plainCode}},
+		{name: "graphemes", lengthUnit: LengthGraphemes, expectedCodes: []string{plainCode, family, accented, emoji}},
+		// In bytes, PLAINCODE would be the shortest
+		{name: "graphemes by length", lengthUnit: LengthGraphemes, sort: SortLength, expectedCodes: []string{family, accented, emoji, plainCode}},
+		{name: "unknown", lengthUnit: "runes", expectedErr: `unknown length unit "runes"`},
+	}
+
+	for _, tt := range tests {
+		for _, numFiles := range []int{2, 3} {
+			t.Run(fmt.Sprintf("%s/files=%d", tt.name, numFiles), func(t *testing.T) {
+				tmpDir := t.TempDir()
+				for i := 0; i < numFiles; i++ {
+					path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+					content := strings.Join([]string{emoji, family, accented, tooLong, plainCode}, "\n") + "\n"
+					require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+				}
+
+				result, err := FindValidCodes(tmpDir, Options{LengthUnit: tt.lengthUnit, Sort: tt.sort})
+				if tt.expectedErr != "" {
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), tt.expectedErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedCodes, result.Codes)
+				assert.NotEmpty(t, result.Stats.Parameters.LengthUnit)
+			})
+		}
+	}
+}
+
+// TestFindValidCodes_InvalidUTF8 verifies a code with invalid UTF-8 is kept,
+// skipped or fails the run as configured, by both algorithms
+func TestFindValidCodes_InvalidUTF8(t *testing.T) {
+	const invalid = "BAD\xffCODE1"
+
+	tests := []struct {
+		name          string
+		invalidUTF8   string
+		expectedCodes []string
+		expectedErr   string
+	}{
+		{name: "default keeps", expectedCodes: []string{invalid, "GOODCODE1"}},
+		{name: "keep", invalidUTF8: UTF8Keep, expectedCodes: []string{invalid, "GOODCODE1"}},
+		{name: "skip", invalidUTF8: UTF8Skip, expectedCodes: []string{"GOODCODE1"}},
+		{name: "error", invalidUTF8: UTF8Error, expectedErr: "file0.txt line 2: code is not valid UTF-8"},
+		{name: "unknown", invalidUTF8: "replace", expectedErr: `unknown invalid UTF-8 handling "replace"`},
+	}
+
+	for _, tt := range tests {
+		for _, numFiles := range []int{2, 3} {
+			t.Run(fmt.Sprintf("%s/files=%d", tt.name, numFiles), func(t *testing.T) {
+				tmpDir := t.TempDir()
+				for i := 0; i < numFiles; i++ {
+					path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+					require.NoError(t, os.WriteFile(path, []byte("GOODCODE1\n"+invalid+"\n"), 0644))
+				}
+
+				result, err := FindValidCodes(tmpDir, Options{InvalidUTF8: tt.invalidUTF8})
+				if tt.expectedErr != "" {
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), tt.expectedErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedCodes, result.Codes)
+				assert.NotEmpty(t, result.Stats.Parameters.InvalidUTF8)
+
+				if tt.invalidUTF8 == UTF8Skip {
+					assert.Equal(t, int64(numFiles), result.Stats.CodesFiltered)
+				}
+			})
+		}
+	}
+}
+
+// TestFindValidCodes_LengthBounds verifies codes outside custom length bounds
+// are excluded, by both algorithms
+func TestFindValidCodes_LengthBounds(t *testing.T) {
+	content := "SIX666\nSEVEN77\nEIGHT888\nTEN1010101\nELEVEN11111\nTWELVE121212\nTHIRTEEN13131\n"
+
+	tests := []struct {
+		name          string
+		opts          Options
+		expectedCodes []string
+		expectedErr   string
+	}{
+		{name: "default", expectedCodes: []string{"EIGHT888", "TEN1010101"}},
+		{name: "six only", opts: Options{MinLength: 6, MaxLength: 6}, expectedCodes: []string{"SIX666"}},
+		{name: "twelve only", opts: Options{MinLength: 12, MaxLength: 12}, expectedCodes: []string{"TWELVE121212"}},
+		{
+			name:          "six to twelve",
+			opts:          Options{MinLength: 6, MaxLength: 12},
+			expectedCodes: []string{"EIGHT888", "ELEVEN11111", "SEVEN77", "SIX666", "TEN1010101", "TWELVE121212"},
+		},
+		{name: "only min set", opts: Options{MinLength: 10}, expectedCodes: []string{"TEN1010101"}},
+		{name: "min above default max", opts: Options{MinLength: 12}, expectedErr: "minimum code length 12 is greater than the maximum 10"},
+	}
+
+	for _, tt := range tests {
+		for _, numFiles := range []int{2, 3} {
+			t.Run(fmt.Sprintf("%s/files=%d", tt.name, numFiles), func(t *testing.T) {
+				tmpDir := t.TempDir()
+				for i := 0; i < numFiles; i++ {
+					path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+					require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+				}
+
+				result, err := FindValidCodes(tmpDir, tt.opts)
+				if tt.expectedErr != "" {
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), tt.expectedErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedCodes, result.Codes)
+
+				minLength, maxLength := tt.opts.lengthBounds()
+				assert.Equal(t, minLength, result.Stats.Parameters.MinLength)
+				assert.Equal(t, maxLength, result.Stats.Parameters.MaxLength)
+			})
+		}
+	}
+}
+
+// TestFindValidCodes_DisableEarlyExit verifies tracking every file of every
+// code finds the same valid codes as freeing them once a code is valid
+func TestFindValidCodes_DisableEarlyExit(t *testing.T) {
+	tmpDir := t.TempDir()
+	for f := 0; f < 5; f++ {
+		var sb strings.Builder
+		for i := 0; i < 500; i++ {
+			// Codes repeat within a file and each file overlaps the next by 250 codes
+			fmt.Fprintf(&sb, "EXIT%05d\nEXIT%05d\n", i+f*250, i+f*250)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", f)), []byte(sb.String()), 0644))
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "default", opts: Options{}},
+		{name: "require all", opts: Options{RequireAll: true}},
+		{name: "skewed buckets", opts: Options{PartitionBy: PartitionPrefix, PrefixLength: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, err := FindValidCodes(tmpDir, tt.opts)
+			require.NoError(t, err)
+			assert.False(t, expected.Stats.Parameters.NoEarlyExit)
+
+			full := tt.opts
+			full.DisableEarlyExit = true
+			result, err := FindValidCodes(tmpDir, full)
+			require.NoError(t, err)
+			assert.Equal(t, expected.Codes, result.Codes)
+			assert.True(t, result.Stats.Parameters.NoEarlyExit)
+		})
+	}
+}
+
+// BenchmarkPartitionFiles compares readers sharing buckets with readers
+// writing to their own shards
+func BenchmarkPartitionFiles(b *testing.B) {
+	tmpDir := b.TempDir()
+	files := make([]string, 4)
+	for f := range files {
+		var sb strings.Builder
+		for i := 0; i < 100_000; i++ {
+			fmt.Fprintf(&sb, "BENCH%05d\n", (i*7+f*13)%100_000)
+		}
+		files[f] = filepath.Join(tmpDir, fmt.Sprintf("codes%d.txt", f))
+		if err := os.WriteFile(files[f], []byte(sb.String()), 0644); err != nil {
+			b.Fatalf("Failed to create benchmark file: %v", err)
+		}
+	}
+
+	for _, shards := range []int{0, len(files)} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tempDir := b.TempDir()
+				err := partitionFiles(files, defaultBuckets, hashCode, tempDir, shards, defaultCodeFilter, nil, len(files), 0, nil, &Stats{})
+				if err != nil {
+					b.Fatalf("partitionFiles() error = %v", err)
+				}
+			}
+		})
+	}
+}
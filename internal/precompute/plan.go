@@ -0,0 +1,53 @@
+package precompute
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Plan describes the run FindValidCodes would do, without doing it
+type Plan struct {
+	// Files are the input files in index order, the order they are numbered in bucket files
+	Files      []string
+	Algorithm  string
+	Parameters Parameters
+	// TempBytes is an upper bound on the temp disk space used by bucket files
+	TempBytes int64
+}
+
+// PlanRun checks the input files of dirPath and options as FindValidCodes
+// would, and returns the run it would do. Only file metadata and the first
+// bytes of each file are read.
+func PlanRun(dirPath string, opts Options) (*Plan, error) {
+	files, _, stats, err := prepareRun(dirPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Files:      files,
+		Algorithm:  stats.Algorithm,
+		Parameters: stats.Parameters,
+	}
+
+	// The two-file intersection runs in memory
+	if plan.Algorithm == "two-file" {
+		return plan, nil
+	}
+
+	// Every bucket line is a code of at least MinLength bytes plus its
+	// newline, with "|fileIndex" added. Assuming every line is a valid
+	// minimum length code gives the most lines, and so the most overhead.
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat input file %s: %w", file, err)
+		}
+		size := info.Size()
+		overhead := int64(1 + len(strconv.Itoa(i)))
+		plan.TempBytes += size + size/int64(plan.Parameters.MinLength+1)*overhead
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,44 @@
+package precompute
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := strings.Repeat("ABCDEFGH\n", 1000)
+	for _, filename := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, filename), []byte(content), 0644))
+	}
+
+	plan, err := PlanRun(tmpDir, Options{Workers: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(tmpDir, "a.txt"),
+		filepath.Join(tmpDir, "b.txt"),
+		filepath.Join(tmpDir, "c.txt"),
+	}, plan.Files)
+	assert.Equal(t, "hash-partition", plan.Algorithm)
+	assert.Equal(t, 2, plan.Parameters.Workers)
+	assert.Equal(t, 2, plan.Parameters.MinFiles)
+
+	// The estimate must cover what a real run writes: each line gains "|0" to "|2"
+	assert.Equal(t, int64(3*(len(content)+2*1000)), plan.TempBytes)
+
+	// Two files are intersected in memory
+	require.NoError(t, os.Remove(filepath.Join(tmpDir, "c.txt")))
+	plan, err = PlanRun(tmpDir, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "two-file", plan.Algorithm)
+	assert.Zero(t, plan.TempBytes)
+
+	// Infeasible settings are reported as by FindValidCodes
+	_, err = PlanRun(tmpDir, Options{Sort: SortCount})
+	assert.Error(t, err)
+}
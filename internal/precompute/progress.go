@@ -0,0 +1,29 @@
+package precompute
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// ProgressEvent is a progress message with the time it was reported
+type ProgressEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// JSONLinesProgress returns a Progress function writing each message to w as
+// a ProgressEvent on its own JSON line, so a supervisor can tail a long run.
+// It is safe for concurrent use. Write errors are ignored, as progress must
+// not fail the run.
+func JSONLinesProgress(w io.Writer) func(string) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(msg string) {
+		event := ProgressEvent{Time: time.Now().UTC(), Message: msg}
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(event)
+	}
+}
@@ -0,0 +1,227 @@
+package precompute
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// processBucketCapped processes a bucket file in memory like processBucket,
+// unless the file is larger than maxBytes. Larger buckets are sorted on disk
+// and scanned sequentially by processBucketExternal, which bounds memory
+// however skewed the bucket is. A maxBytes of 0 or less disables the cap.
+func processBucketCapped(bucketPaths []string, maxBytes int64, minFiles int, trusted []bool, earlyExit bool) ([]string, BucketStats, error) {
+	if maxBytes <= 0 {
+		return processBucket(bucketPaths, minFiles, trusted, earlyExit)
+	}
+
+	size, err := bucketSize(bucketPaths)
+	if err != nil {
+		return nil, BucketStats{}, err
+	}
+	if size <= maxBytes {
+		return processBucket(bucketPaths, minFiles, trusted, earlyExit)
+	}
+
+	return processBucketExternal(bucketPaths, maxBytes, minFiles, trusted)
+}
+
+// processBucketExternal finds the valid codes of a bucket without loading it.
+// The bucket is split into sorted runs of about maxBytes each, written next to
+// it, and the runs are merged so every entry of a code is seen consecutively.
+// The returned stats leave Bucket to the caller, like processBucket.
+func processBucketExternal(bucketPaths []string, maxBytes int64, minFiles int, trusted []bool) ([]string, BucketStats, error) {
+	runs, err := writeSortedRuns(bucketPaths, maxBytes)
+	defer func() {
+		for _, run := range runs {
+			os.Remove(run)
+		}
+	}()
+	if err != nil {
+		return nil, BucketStats{}, err
+	}
+
+	var validCodes []string
+	var stats BucketStats
+	var current string
+	var fileIndices map[int]struct{}
+	found, hasTrusted := false, false
+
+	err = mergeRuns(runs, func(line string) {
+		stats.Lines++
+		stats.Bytes += int64(len(line)) + 1
+
+		code, fileIdx, ok := parseBucketLine(line)
+		if !ok {
+			return // Skip malformed lines
+		}
+
+		if code != current || fileIndices == nil {
+			current = code
+			fileIndices = make(map[int]struct{})
+			found, hasTrusted = false, false
+			stats.DistinctCodes++
+		}
+		if found {
+			return
+		}
+
+		fileIndices[fileIdx] = struct{}{}
+		hasTrusted = hasTrusted || isTrusted(trusted, fileIdx)
+		if hasTrusted && len(fileIndices) >= minFiles {
+			validCodes = append(validCodes, code)
+			found = true
+		}
+	})
+	if err != nil {
+		return nil, BucketStats{}, err
+	}
+
+	stats.ValidCodes = len(validCodes)
+	return validCodes, stats, nil
+}
+
+// writeSortedRuns splits the files of a bucket into sorted run files of about
+// maxBytes each and returns their paths. Paths created before an error are
+// returned too, so the caller can remove them.
+func writeSortedRuns(bucketPaths []string, maxBytes int64) ([]string, error) {
+	f, err := openBucket(bucketPaths)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	bucketPath := bucketPaths[0]
+
+	var runs []string
+	var lines []string
+	var size int64
+
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		slices.SortFunc(lines, compareBucketLines)
+
+		run, err := os.CreateTemp(filepath.Dir(bucketPath), filepath.Base(bucketPath)+".run_*")
+		if err != nil {
+			return fmt.Errorf("failed to create run file: %w", err)
+		}
+		runs = append(runs, run.Name())
+		defer run.Close()
+
+		w := bufio.NewWriter(run)
+		for _, line := range lines {
+			w.WriteString(line)
+			w.WriteByte('\n')
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to write run file %s: %w", run.Name(), err)
+		}
+
+		lines = lines[:0]
+		size = 0
+		return nil
+	}
+
+	scanner := newBucketScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lines = append(lines, line)
+		size += int64(len(line)) + 1
+
+		if size >= maxBytes {
+			if err := flush(); err != nil {
+				return runs, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return runs, fmt.Errorf("error reading bucket file %s: %w", bucketPath, err)
+	}
+
+	return runs, flush()
+}
+
+// compareBucketLines orders bucket lines by code, then by file index. Codes may
+// contain '|', so comparing whole lines could sort "CODE|1|0" between "CODE|0"
+// and "CODE|1", splitting up the entries of CODE.
+func compareBucketLines(a, b string) int {
+	codeA, idxA := splitBucketLine(a)
+	codeB, idxB := splitBucketLine(b)
+	if c := strings.Compare(codeA, codeB); c != 0 {
+		return c
+	}
+	return strings.Compare(idxA, idxB)
+}
+
+// splitBucketLine splits a bucket line at its last '|', like parseBucketLine,
+// without parsing the file index. A line without one is all code.
+func splitBucketLine(line string) (code, idx string) {
+	sep := strings.LastIndexByte(line, '|')
+	if sep < 0 {
+		return line, ""
+	}
+	return line[:sep], line[sep+1:]
+}
+
+// runLine is the next line of a run during the merge
+type runLine struct {
+	line    string
+	scanner *bufio.Scanner
+}
+
+// runHeap orders runs by their next line
+type runHeap []runLine
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return compareBucketLines(h[i].line, h[j].line) < 0 }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(runLine)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// mergeRuns calls fn for every line of the sorted run files, in sorted order
+func mergeRuns(runs []string, fn func(line string)) error {
+	h := make(runHeap, 0, len(runs))
+	for _, run := range runs {
+		f, err := os.Open(run)
+		if err != nil {
+			return fmt.Errorf("failed to open run file %s: %w", run, err)
+		}
+		defer f.Close()
+
+		scanner := newBucketScanner(f)
+		if scanner.Scan() {
+			h = append(h, runLine{line: scanner.Text(), scanner: scanner})
+		} else if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading run file %s: %w", run, err)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		next := h[0]
+		fn(next.line)
+
+		if next.scanner.Scan() {
+			h[0].line = next.scanner.Text()
+			heap.Fix(&h, 0)
+			continue
+		}
+		if err := next.scanner.Err(); err != nil {
+			return fmt.Errorf("error reading run file: %w", err)
+		}
+		heap.Pop(&h)
+	}
+
+	return nil
+}
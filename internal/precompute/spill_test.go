@@ -0,0 +1,104 @@
+package precompute
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestProcessBucketExternal_HugeBucket verifies a bucket far larger than the cap
+// gives the same codes as processing it in memory, and leaves no run files behind
+func TestProcessBucketExternal_HugeBucket(t *testing.T) {
+	tmpDir := t.TempDir()
+	bucketPath := filepath.Join(tmpDir, "bucket_000.txt")
+
+	// 5,000 codes: every third appears in one file only, the rest in two or three
+	rng := rand.New(rand.NewSource(1))
+	var lines []string
+	for i := 0; i < 5000; i++ {
+		code := fmt.Sprintf("SKEW%05d", i)
+		files := 1 + i%3
+		for f := 0; f < files; f++ {
+			// Repeat entries from the same file, which must not count twice
+			lines = append(lines, fmt.Sprintf("%s|%d", code, f), fmt.Sprintf("%s|%d", code, f))
+		}
+	}
+	lines = append(lines, "MALFORMED", "BAD|INDEX", "A|B|C")
+	rng.Shuffle(len(lines), func(i, j int) { lines[i], lines[j] = lines[j], lines[i] })
+	require.NoError(t, os.WriteFile(bucketPath, []byte(strings.Join(lines, "\n")), 0644))
+
+	expected, expectedStats, err := processBucket([]string{bucketPath}, 2, nil, true)
+	require.NoError(t, err)
+	sort.Strings(expected)
+
+	// Roughly 100 runs of 2 KB each
+	const maxBytes = 2 * 1024
+	codes, stats, err := processBucketCapped([]string{bucketPath}, maxBytes, 2, nil, true)
+	require.NoError(t, err)
+	sort.Strings(codes)
+
+	assert.Len(t, codes, 5000*2/3)
+	assert.Equal(t, expected, codes)
+	assert.Equal(t, expectedStats, stats)
+	assert.Equal(t, BucketStats{Lines: int64(len(lines)), Bytes: int64(len(strings.Join(lines, "\n")) + 1), DistinctCodes: 5000, ValidCodes: 5000 * 2 / 3}, stats)
+
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "run files should be removed")
+}
+
+// TestFindValidCodes_MaxBucketBytes verifies spilling every bucket gives the same result
+func TestFindValidCodes_MaxBucketBytes(t *testing.T) {
+	tmpDir := t.TempDir()
+	contents := []string{
+		"HAPPYHRS\nFIFTYOFF\nSUPER100\nSHORT\n",
+		"HAPPYHRS\nSUPER100\nONLYONCE1\n",
+		"FIFTYOFF\nTESTCODE1\n",
+	}
+	for i, content := range contents {
+		path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	inMemory, err := FindValidCodes(tmpDir, Options{})
+	require.NoError(t, err)
+
+	spilled, err := FindValidCodes(tmpDir, Options{MaxBucketBytes: 1})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"FIFTYOFF", "HAPPYHRS", "SUPER100"}, spilled.Codes)
+	assert.Equal(t, inMemory.Codes, spilled.Codes)
+	assert.Equal(t, int64(1), spilled.Stats.Parameters.MaxBucketBytes)
+}
+
+// TestFindValidCodes_MaxBucketBytesPipes verifies spilled buckets keep the
+// entries of a code together when another code extends it with '|'
+func TestFindValidCodes_MaxBucketBytesPipes(t *testing.T) {
+	tmpDir := t.TempDir()
+	contents := []string{
+		"abcdefgh\nabcdefgh|0\nPIPE|CODE\n",
+		"abcdefgh\nPIPE|CODE\nPIPE|CODE|1\n",
+		// A third file keeps the run off the two-file path, which never spills
+		"UNRELATED\n",
+	}
+	for i, content := range contents {
+		path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	inMemory, err := FindValidCodes(tmpDir, Options{Buckets: 1})
+	require.NoError(t, err)
+
+	spilled, err := FindValidCodes(tmpDir, Options{Buckets: 1, MaxBucketBytes: 1})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"PIPE|CODE", "abcdefgh"}, spilled.Codes)
+	assert.Equal(t, inMemory.Codes, spilled.Codes)
+}
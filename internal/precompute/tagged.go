@@ -0,0 +1,193 @@
+package precompute
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// FindValidCodesTagged finds valid promo codes in a single file of "code,fileId"
+// rows, as emitted by systems that concatenate their code files. The embedded
+// file id is used as the file a code came from, so a code is valid when it
+// appears with at least 2 distinct file ids (or every file id, with
+// Options.RequireAll), with the same length rules as FindValidCodes.
+// File ids are arbitrary strings. Rows without a comma are counted as filtered.
+func FindValidCodesTagged(path string, opts Options) (*Result, error) {
+	start := time.Now()
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to read tagged input %s: %w", path, err)
+	}
+
+	if opts.Tokenize {
+		return nil, fmt.Errorf("tokenizing isn't supported for tagged input, whose rows are a single code and its file id")
+	}
+	if opts.ResumeDir != "" {
+		return nil, fmt.Errorf("resuming isn't supported for tagged input")
+	}
+	if len(opts.TrustedFiles) > 0 {
+		return nil, fmt.Errorf("trusted files aren't supported for tagged input, whose file ids aren't file names")
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	// A single file is read by a single reader, so there is nothing to shard
+	opts.ShardBuckets = false
+	var err error
+	if opts.Sort, err = opts.sortOrder(); err != nil {
+		return nil, err
+	}
+	if opts.InvalidUTF8, err = opts.utf8Mode(); err != nil {
+		return nil, err
+	}
+	if opts.LengthUnit, err = opts.lengthUnit(); err != nil {
+		return nil, err
+	}
+	if opts.Buckets, err = opts.bucketCount(); err != nil {
+		return nil, err
+	}
+	opts.MinLength, opts.MaxLength = opts.lengthBounds()
+	_, partitionBy, prefixLength, err := opts.bucketFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := Stats{
+		Algorithm:  "tagged",
+		InputFiles: []string{path},
+		Parameters: Parameters{
+			Workers:        opts.Workers,
+			Buckets:        opts.Buckets,
+			MinLength:      opts.MinLength,
+			MaxLength:      opts.MaxLength,
+			LengthUnit:     opts.LengthUnit,
+			MaxBucketBytes: opts.MaxBucketBytes,
+			TopK:           max(opts.TopK, 0),
+			MaxOutput:      max(opts.MaxOutput, 0),
+			Sort:           opts.Sort,
+			PartitionBy:    partitionBy,
+			PrefixLength:   prefixLength,
+			InvalidUTF8:    opts.InvalidUTF8,
+			NoEarlyExit:    opts.DisableEarlyExit,
+		},
+	}
+
+	var numFileIDs int
+	validCodes, err := runPartitioned(opts, &stats, nil, func(tempDir string) (int, error) {
+		n, err := partitionTaggedFile(path, tempDir, opts, &stats)
+		numFileIDs = n
+		if err != nil || n == 0 {
+			return n, err
+		}
+		// The number of file ids is only known once the file is read, so
+		// feasibility is checked before the buckets are processed instead
+		params := stats.Parameters
+		params.MinFiles = opts.minFiles(n)
+		return n, validateParameters(params, n)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Top-K runs apply Accept while ranking
+	if opts.Accept != nil && opts.TopK <= 0 {
+		validCodes = acceptCodes(validCodes, opts.Accept, &stats)
+	}
+	orderCodes(validCodes, opts.Sort, opts.TopK, opts.LengthUnit)
+	validCodes = limitOutput(validCodes, opts, &stats)
+
+	stats.Parameters.MinFiles = opts.minFiles(numFileIDs)
+	stats.ValidCodes = len(validCodes)
+	stats.ElapsedSeconds = time.Since(start).Seconds()
+
+	return &Result{Codes: validCodes, Stats: stats}, nil
+}
+
+// partitionTaggedFile partitions the rows of a tagged input file into bucket files,
+// numbering file ids in order of first appearance. It returns the number of distinct file ids.
+// The number of codes read and filtered out are recorded in stats.
+func partitionTaggedFile(path, tempDir string, opts Options, stats *Stats) (int, error) {
+	opts.progress(fmt.Sprintf("  Partitioning tagged file: %s", path))
+
+	bucketOf, _, _, err := opts.bucketFunc()
+	if err != nil {
+		return 0, err
+	}
+
+	filter := opts.codeFilter()
+	numBuckets, _ := opts.bucketCount() // Checked when the run is prepared
+	buckets := newBucketSet(numBuckets, tempDir, noShard, opts.TempFileMode)
+	defer buckets.close()
+
+	f, err := openInputFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := newCodeScanner(f)
+
+	fileIndices := make(map[string]int)
+	var codesRead, codesPartitioned int64
+	var filtered FilterCounts
+
+	for scanner.Scan() {
+		codesRead++
+
+		code, fileID, ok := strings.Cut(scanner.Text(), ",")
+		if !ok {
+			// An empty line has no file id either, but is counted as empty
+			if code == "" {
+				filtered.add(filteredEmpty)
+			} else {
+				filtered.add(filteredMalformed)
+			}
+			continue
+		}
+		if reason, err := filter.check(code); reason != kept {
+			if err != nil {
+				return 0, fmt.Errorf("%s line %d: %w", path, codesRead, err)
+			}
+			filtered.add(reason)
+			continue
+		}
+
+		fileIdx, seen := fileIndices[fileID]
+		if !seen {
+			fileIdx = len(fileIndices)
+			fileIndices[fileID] = fileIdx
+		}
+
+		bucketNum := bucketOf(code, numBuckets)
+		if err := buckets.writeLine(bucketNum, formatBucketLine(code, fileIdx)); err != nil {
+			return 0, err
+		}
+		codesPartitioned++
+
+		if codesRead%progressReportInterval == 0 {
+			opts.progress(fmt.Sprintf("    Processed %dM codes (%dM valid length)",
+				codesRead/1_000_000, codesPartitioned/1_000_000))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("error reading file %s: %w", path, err)
+	}
+
+	if err := buckets.flush(); err != nil {
+		return 0, err
+	}
+
+	stats.LinesRead += codesRead
+	stats.CodesRead += codesRead
+	stats.CodesFiltered += codesRead - codesPartitioned
+	stats.Filtered.merge(filtered)
+
+	opts.progress(fmt.Sprintf("  Partitioning complete: %d total codes read, %d codes partitioned from %d file ids",
+		codesRead, codesPartitioned, len(fileIndices)))
+
+	return len(fileIndices), nil
+}
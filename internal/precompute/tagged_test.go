@@ -0,0 +1,89 @@
+package precompute
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindValidCodesTagged(t *testing.T) {
+	// Rows from three upstream files, concatenated and interleaved
+	content := `HAPPYHRS,fileA
+SUPER100,fileA
+HAPPYHRS,fileB
+ONLYONCE1,fileB
+SUPER100,fileA
+EVERYWHERE,fileA
+EVERYWHERE,fileB
+EVERYWHERE,fileC
+SHORT,fileA
+SHORT,fileB
+NOCOMMA1
+
+FIFTYOFF,fileC
+FIFTYOFF,fileA
+`
+
+	tests := []struct {
+		name             string
+		opts             Options
+		expectedCodes    []string
+		expectedMinFiles int
+	}{
+		{
+			name:             "at least two file ids",
+			opts:             Options{},
+			expectedCodes:    []string{"EVERYWHERE", "FIFTYOFF", "HAPPYHRS"},
+			expectedMinFiles: 2,
+		},
+		{
+			name:             "every file id",
+			opts:             Options{RequireAll: true},
+			expectedCodes:    []string{"EVERYWHERE"},
+			expectedMinFiles: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tagged.csv")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+			result, err := FindValidCodesTagged(path, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCodes, result.Codes)
+			assert.Equal(t, "tagged", result.Stats.Algorithm)
+			assert.Equal(t, tt.expectedMinFiles, result.Stats.Parameters.MinFiles)
+			assert.EqualValues(t, 14, result.Stats.CodesRead)
+			// SHORT twice, NOCOMMA1 and the empty line
+			assert.EqualValues(t, 4, result.Stats.CodesFiltered)
+			assert.Equal(t, FilterCounts{Empty: 1, TooShort: 2, Malformed: 1}, result.Stats.Filtered)
+		})
+	}
+}
+
+func TestFindValidCodesTagged_MissingFile(t *testing.T) {
+	_, err := FindValidCodesTagged(filepath.Join(t.TempDir(), "missing.csv"), Options{})
+	assert.Error(t, err)
+}
+
+func TestFindValidCodesTagged_Tokenize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tagged.csv")
+	require.NoError(t, os.WriteFile(path, []byte("HAPPYHRS,file1\n"), 0644))
+
+	_, err := FindValidCodesTagged(path, Options{Tokenize: true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tokenizing isn't supported for tagged input")
+}
+
+func TestFindValidCodesTagged_TrustedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tagged.csv")
+	require.NoError(t, os.WriteFile(path, []byte("HAPPYHRS,file1\n"), 0644))
+
+	_, err := FindValidCodesTagged(path, Options{TrustedFiles: []string{"file1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "trusted files aren't supported for tagged input")
+}
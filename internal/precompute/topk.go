@@ -0,0 +1,174 @@
+package precompute
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// codeCount is a valid code with the number of distinct files it appears in
+type codeCount struct {
+	code  string
+	files int
+}
+
+// ranksBelow reports whether a ranks below b: it appears in fewer files,
+// or in as many files but sorts after b alphabetically
+func (a codeCount) ranksBelow(b codeCount) bool {
+	if a.files != b.files {
+		return a.files < b.files
+	}
+	return a.code > b.code
+}
+
+// maxTopKPrealloc caps the capacity the heap starts with, so a K far beyond
+// the valid codes doesn't allocate for all of them up front
+const maxTopKPrealloc = 1 << 16
+
+// topKHeap is a min-heap with the lowest ranked code at the root, so it can be
+// evicted when a better code arrives
+type topKHeap []codeCount
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].ranksBelow(h[j]) }
+func (h topKHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topKHeap) Push(x any)        { *h = append(*h, x.(codeCount)) }
+func (h *topKHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// offer adds c to the heap, keeping at most k codes
+func (h *topKHeap) offer(c codeCount, k int) {
+	if h.Len() < k {
+		heap.Push(h, c)
+		return
+	}
+	if (*h)[0].ranksBelow(c) {
+		(*h)[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+// ranked empties the heap and returns its codes, best ranked first
+func (h *topKHeap) ranked() []string {
+	codes := make([]string, h.Len())
+	for i := len(codes) - 1; i >= 0; i-- {
+		codes[i] = heap.Pop(h).(codeCount).code
+	}
+	return codes
+}
+
+// countBucket counts the distinct files of every code in the files of a bucket,
+// returning the codes that appear in at least minFiles files, one of them
+// trusted unless trusted is nil.
+// Unlike processBucket it can't stop tracking a code once it is valid.
+func countBucket(bucketPaths []string, minFiles int, trusted []bool) ([]codeCount, error) {
+	f, err := openBucket(bucketPaths)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileIndices := make(map[string]map[int]struct{})
+	scanner := newBucketScanner(f)
+	for scanner.Scan() {
+		code, fileIdx, ok := parseBucketLine(scanner.Text())
+		if !ok {
+			continue // Skip malformed lines
+		}
+
+		files := fileIndices[code]
+		if files == nil {
+			files = make(map[int]struct{})
+			fileIndices[code] = files
+		}
+		files[fileIdx] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading bucket file %s: %w", bucketPaths[0], err)
+	}
+
+	var counts []codeCount
+	for code, files := range fileIndices {
+		if len(files) >= minFiles && hasTrustedFile(files, trusted) {
+			counts = append(counts, codeCount{code: code, files: len(files)})
+		}
+	}
+	return counts, nil
+}
+
+// hasTrustedFile reports whether any of files is trusted, always true when
+// trusted is nil
+func hasTrustedFile(files map[int]struct{}, trusted []bool) bool {
+	for fileIdx := range files {
+		if isTrusted(trusted, fileIdx) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectTopK returns the k valid codes that appear in the most files, best
+// ranked first, with ties broken alphabetically. Codes rejected by accept
+// are not ranked and are counted in stats. Buckets are counted in parallel by
+// workers, reading every shard of a bucket when shards is above 0; only k
+// codes are kept across all of them.
+func selectTopK(numBuckets int, tempDir string, shards int, workers, minFiles int, trusted []bool, k int, accept func(string) bool, onBadBucket func(path string, err error), stats *Stats) ([]string, error) {
+	var mu sync.Mutex
+	h := make(topKHeap, 0, min(k, maxTopKPrealloc))
+	rejected := 0
+
+	var eg errgroup.Group
+	eg.SetLimit(max(workers, 1))
+
+	for bucketNum := 0; bucketNum < numBuckets; bucketNum++ {
+		paths := bucketPaths(tempDir, bucketNum, shards)
+
+		// Skip empty buckets
+		size, err := bucketSize(paths)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			continue
+		}
+
+		eg.Go(func() (err error) {
+			defer recoverPanic(&err)
+
+			counts, err := countBucket(paths, minFiles, trusted)
+			if err != nil && onBadBucket != nil {
+				onBadBucket(paths[0], err)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, c := range counts {
+				if accept != nil && !accept(c.code) {
+					rejected++
+					continue
+				}
+				h.offer(c, k)
+			}
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	stats.CodesRejected = rejected
+	return h.ranked(), nil
+}
@@ -0,0 +1,101 @@
+package precompute
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindValidCodes_TopK(t *testing.T) {
+	// Number of files each code appears in
+	fileCounts := map[string]int{
+		"FIVEFILES": 5,
+		"FOURFILE1": 4,
+		"FOURFILE2": 4,
+		"THREEBBB":  3,
+		"THREEAAA":  3,
+		"TWOFILES":  2,
+		"ONEFILE1":  1,
+	}
+
+	tests := []struct {
+		name             string
+		opts             Options
+		expectedCodes    []string
+		expectedRejected int
+	}{
+		{
+			name:          "top three",
+			opts:          Options{TopK: 3},
+			expectedCodes: []string{"FIVEFILES", "FOURFILE1", "FOURFILE2"},
+		},
+		{
+			name:          "ties broken alphabetically",
+			opts:          Options{TopK: 4},
+			expectedCodes: []string{"FIVEFILES", "FOURFILE1", "FOURFILE2", "THREEAAA"},
+		},
+		{
+			name:          "k larger than the valid codes",
+			opts:          Options{TopK: 100},
+			expectedCodes: []string{"FIVEFILES", "FOURFILE1", "FOURFILE2", "THREEAAA", "THREEBBB", "TWOFILES"},
+		},
+		{
+			// Must not preallocate k entries
+			name:          "huge k",
+			opts:          Options{TopK: 1 << 40},
+			expectedCodes: []string{"FIVEFILES", "FOURFILE1", "FOURFILE2", "THREEAAA", "THREEBBB", "TWOFILES"},
+		},
+		{
+			name: "accept applied before ranking",
+			opts: Options{TopK: 2, Accept: func(code string) bool {
+				return !strings.HasPrefix(code, "FOUR")
+			}},
+			expectedCodes:    []string{"FIVEFILES", "THREEAAA"},
+			expectedRejected: 2,
+		},
+		{
+			name:          "with require all",
+			opts:          Options{TopK: 3, RequireAll: true},
+			expectedCodes: []string{"FIVEFILES"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			contents := make([]string, 5)
+			for code, n := range fileCounts {
+				for i := 0; i < n; i++ {
+					// Repeat each code within a file, which must not raise its count
+					contents[i] += code + "\n" + code + "\n"
+				}
+			}
+			for i, content := range contents {
+				path := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", i))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			}
+
+			result, err := FindValidCodes(tmpDir, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCodes, result.Codes)
+			assert.Equal(t, tt.expectedRejected, result.Stats.CodesRejected)
+			assert.Equal(t, len(tt.expectedCodes), result.Stats.ValidCodes)
+			assert.Equal(t, tt.opts.TopK, result.Stats.Parameters.TopK)
+		})
+	}
+}
+
+func TestFindValidCodes_TopKTwoFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("CCCCCCCC\nAAAAAAAA\nBBBBBBBB\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("BBBBBBBB\nCCCCCCCC\nAAAAAAAA\n"), 0644))
+
+	result, err := FindValidCodes(tmpDir, Options{TopK: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AAAAAAAA", "BBBBBBBB"}, result.Codes)
+}
@@ -0,0 +1,99 @@
+package precompute
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// findValidCodesTwoFiles finds the valid codes shared by exactly two files.
+// It builds a set from the first file and probes it with the second, so no
+// temp files are written. Memory is proportional to the distinct valid-length
+// codes in the first file.
+// The number of codes read and filtered out are recorded in stats.
+func findValidCodesTwoFiles(first, second string, opts Options, stats *Stats) ([]string, error) {
+	opts.progress(fmt.Sprintf("Two input files, using in-memory intersection: %s, %s",
+		filepath.Base(first), filepath.Base(second)))
+
+	seen := make(map[string]struct{})
+	err := scanCodes(first, opts.codeFilter(), stats, func(code string) {
+		seen[code] = struct{}{}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Top-K needs every code to rank them. One code past the cap is kept, so
+	// limitOutput can tell valid codes were dropped.
+	maxOutput := 0
+	if opts.TopK <= 0 {
+		maxOutput = max(opts.MaxOutput, 0)
+	}
+	var validCodes []string
+	err = scanCodes(second, opts.codeFilter(), stats, func(code string) {
+		if maxOutput > 0 && len(validCodes) > maxOutput {
+			return
+		}
+		if _, ok := seen[code]; ok {
+			validCodes = append(validCodes, code)
+			delete(seen, code) // Report each code once
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort codes alphabetically for consistent output, and so top-K ties are broken alphabetically
+	if opts.TopK > 0 || opts.Sort != SortNone {
+		sort.Strings(validCodes)
+	}
+
+	// Every code is in both files, so the top K are the first K alphabetically
+	if opts.TopK > 0 {
+		if opts.Accept != nil {
+			validCodes = acceptCodes(validCodes, opts.Accept, stats)
+		}
+		validCodes = validCodes[:min(opts.TopK, len(validCodes))]
+	}
+
+	opts.progress(fmt.Sprintf("Found %d valid codes", len(validCodes)))
+
+	return validCodes, nil
+}
+
+// scanCodes calls fn for every code of the file kept by filter, a line or,
+// if filter tokenizes, a token of one.
+// Codes read and filtered out are counted in stats.
+func scanCodes(filename string, filter codeFilter, stats *Stats, fn func(code string)) error {
+	f, err := openInputFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	scanner := newCodeScanner(f)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		stats.LinesRead++
+		for code := range filter.codes(scanner.Text()) {
+			stats.CodesRead++
+			if reason, err := filter.check(code); reason != kept {
+				if err != nil {
+					return fmt.Errorf("%s line %d: %w", filename, line, err)
+				}
+				stats.CodesFiltered++
+				stats.Filtered.add(reason)
+				continue
+			}
+			fn(code)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading file %s: %w", filename, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package precompute
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFindValidCodesTwoFiles_MatchesPartitioned verifies the two-file fast path
+// returns the same codes as the general hash partition algorithm
+func TestFindValidCodesTwoFiles_MatchesPartitioned(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	file1 := filepath.Join(tmpDir, "codes1.txt")
+	file2 := filepath.Join(tmpDir, "codes2.txt")
+	content1 := "HAPPYHRS\nFIFTYOFF\nSHORT\nVERYLONGCODE123\nTESTCODE1\nHAPPYHRS\n\nDUPLICATE"
+	content2 := "HAPPYHRS\nSUPER100\nSHORT\nTESTCODE1\nVERYLONGCODE123\nDUPLICATE\nDUPLICATE"
+	require.NoError(t, os.WriteFile(file1, []byte(content1), 0644))
+	require.NoError(t, os.WriteFile(file2, []byte(content2), 0644))
+
+	var fastStats, partitionedStats Stats
+	fastCodes, err := findValidCodesTwoFiles(file1, file2, Options{}, &fastStats)
+	require.NoError(t, err)
+
+	partitionedCodes, err := findValidCodesPartitioned([]string{file1, file2}, Options{Workers: 2}, &partitionedStats)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"DUPLICATE", "HAPPYHRS", "TESTCODE1"}, fastCodes)
+	assert.Equal(t, partitionedCodes, fastCodes)
+	assert.Equal(t, partitionedStats.CodesRead, fastStats.CodesRead)
+	assert.Equal(t, partitionedStats.CodesFiltered, fastStats.CodesFiltered)
+}
+
+func TestFindValidCodesTwoFiles_MissingFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "codes1.txt")
+	require.NoError(t, os.WriteFile(file1, []byte("HAPPYHRS"), 0644))
+
+	_, err := findValidCodesTwoFiles(file1, filepath.Join(tmpDir, "missing.txt"), Options{}, &Stats{})
+	assert.Error(t, err)
+}
@@ -1,22 +1,262 @@
 package precompute
 
 import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
-// WriteTextFile writes valid codes to a plain text file.
+// gzipOutputFile compresses what is written to it into an open file
+type gzipOutputFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+// Close ends the gzip stream, then closes the file
+func (g *gzipOutputFile) Close() error {
+	err := g.Writer.Close()
+	if cerr := g.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CheckOutputDir returns a clear error if the directory outputPath would be
+// created in doesn't exist, rather than the bare error of creating the file
+func CheckOutputDir(outputPath string) error {
+	dir := filepath.Dir(outputPath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return fmt.Errorf("output directory does not exist: %s", dir)
+	}
+	return nil
+}
+
+// createOutputFile creates or truncates the file at outputPath. If the path
+// ends in .gz, what is written is compressed with gzip. Callers must check the
+// error of Close, which ends the gzip stream.
+func createOutputFile(outputPath string) (io.WriteCloser, error) {
+	if err := CheckOutputDir(outputPath); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(outputPath, gzipExt) {
+		return f, nil
+	}
+	return &gzipOutputFile{Writer: gzip.NewWriter(f), f: f}, nil
+}
+
+// OpenOutputFile opens an output file written by this package for reading,
+// decompressing it if the path ends in .gz
+func OpenOutputFile(path string) (io.ReadCloser, error) {
+	return openInputFile(path)
+}
+
+// WriteTextFile writes valid codes to a plain text file, or a gzipped one if
+// outputPath ends in .gz, e.g. valid_codes.txt.gz.
 // Each code is on a separate line.
 func WriteTextFile(validCodes []string, outputPath string) error {
-	content := strings.Join(validCodes, "\n")
-	if len(validCodes) > 0 {
-		content += "\n" // Add trailing newline
+	f, err := createOutputFile(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to write text file: %w", err)
 	}
+	defer f.Close()
 
-	if err := os.WriteFile(outputPath, []byte(content), 0644); err != nil {
+	w := bufio.NewWriter(f)
+	for _, code := range validCodes {
+		w.WriteString(code)
+		w.WriteByte('\n')
+	}
+
+	// The bufio.Writer keeps the first write error, returned by Flush
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write text file: %w", err)
+	}
+	if err := f.Close(); err != nil {
 		return fmt.Errorf("failed to write text file: %w", err)
 	}
+	return nil
+}
+
+// WriteJSONFile writes valid codes to a JSON file as {"count": N, "codes": [...]},
+// one code per line, in the order given.
+// Codes are encoded as they are written, so the whole document is never held
+// in memory. Invalid UTF-8 in a code is replaced with U+FFFD. Like
+// WriteTextFile, it compresses the file if outputPath ends in .gz.
+func WriteJSONFile(validCodes []string, outputPath string) error {
+	f, err := createOutputFile(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to write JSON file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "{\"count\": %d, \"codes\": [", len(validCodes))
+	for i, code := range validCodes {
+		encoded, err := json.Marshal(code)
+		if err != nil {
+			return fmt.Errorf("failed to encode code: %w", err)
+		}
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString("\n  ")
+		w.Write(encoded)
+	}
+	if len(validCodes) > 0 {
+		w.WriteByte('\n')
+	}
+	w.WriteString("]}\n")
+
+	// The bufio.Writer keeps the first write error, returned by Flush
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write JSON file: %w", err)
+	}
+	return f.Close()
+}
+
+// WriteTextFileAppend appends valid codes to a plain text file, creating it if needed.
+// Codes already in the file, or repeated in validCodes, are skipped, so running
+// it twice with the same codes leaves the file unchanged.
+// Returns the number of codes appended.
+func WriteTextFileAppend(validCodes []string, outputPath string) (int, error) {
+	existing, err := os.ReadFile(outputPath)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read existing text file: %w", err)
+	}
+
+	seen := make(map[string]struct{})
+	for _, code := range strings.Split(string(existing), "\n") {
+		if code != "" {
+			seen[code] = struct{}{}
+		}
+	}
+
+	var b strings.Builder
+	// Don't join the first new code onto an unterminated last line
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		b.WriteString("\n")
+	}
+	appended := 0
+	for _, code := range validCodes {
+		if _, ok := seen[code]; ok {
+			continue
+		}
+		seen[code] = struct{}{}
+		b.WriteString(code)
+		b.WriteString("\n")
+		appended++
+	}
+
+	if appended == 0 {
+		return 0, nil
+	}
+
+	if err := CheckOutputDir(outputPath); err != nil {
+		return 0, fmt.Errorf("failed to open text file for appending: %w", err)
+	}
+	f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open text file for appending: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(b.String()); err != nil {
+		return 0, fmt.Errorf("failed to append to text file: %w", err)
+	}
+
+	return appended, f.Close()
+}
+
+// WriteTextFilesByLength writes valid codes into one text file per code length,
+// measured in lengthUnit like Options.LengthUnit, so bytes if it is empty.
+// The files are named after outputPath with the length appended, e.g.
+// valid_codes.txt becomes valid_codes_8.txt, valid_codes_9.txt and so on.
+// Codes keep their relative order within each file.
+// Returns the paths of the files written, ordered by length.
+func WriteTextFilesByLength(validCodes []string, outputPath string, lengthUnit string) ([]string, error) {
+	unit, err := Options{LengthUnit: lengthUnit}.lengthUnit()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[int][]string)
+	for _, code := range validCodes {
+		length := codeLength(code, unit)
+		groups[length] = append(groups[length], code)
+	}
+
+	lengths := make([]int, 0, len(groups))
+	for length := range groups {
+		lengths = append(lengths, length)
+	}
+	sort.Ints(lengths)
+
+	paths := make([]string, 0, len(lengths))
+	for _, length := range lengths {
+		path := lengthOutputPath(outputPath, length)
+		if err := WriteTextFile(groups[length], path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// lengthOutputPath returns the output path for codes of the given length
+func lengthOutputPath(outputPath string, length int) string {
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(outputPath, ext), length, ext)
+}
+
+// SummaryPath returns the path of the summary sidecar for an output file,
+// e.g. valid_codes.txt, or valid_codes.txt.gz, becomes valid_codes.summary.json.
+func SummaryPath(outputPath string) string {
+	outputPath = strings.TrimSuffix(outputPath, gzipExt)
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".summary.json"
+}
+
+// WriteSummaryFile writes the run statistics as indented JSON.
+func WriteSummaryFile(stats Stats, outputPath string) error {
+	content, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+
+	if err := os.WriteFile(outputPath, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write summary file: %w", err)
+	}
 
 	return nil
 }
+
+// WriteBucketStatsFile writes the stats of processed buckets as CSV, with a
+// header row and one row per bucket in the order given.
+func WriteBucketStatsFile(buckets []BucketStats, outputPath string) error {
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to write bucket stats file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.WriteString("bucket,lines,bytes,distinctCodes,validCodes\n")
+	for _, b := range buckets {
+		fmt.Fprintf(w, "%d,%d,%d,%d,%d\n", b.Bucket, b.Lines, b.Bytes, b.DistinctCodes, b.ValidCodes)
+	}
+
+	// The bufio.Writer keeps the first write error, returned by Flush
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write bucket stats file: %w", err)
+	}
+	return f.Close()
+}
@@ -1,8 +1,12 @@
 package precompute
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
@@ -104,7 +108,8 @@ func TestWriteTextFile_InvalidPath(t *testing.T) {
 	invalidPath := "/nonexistent/directory/that/should/not/exist/codes.txt"
 
 	err := WriteTextFile([]string{"CODE1", "CODE2"}, invalidPath)
-	assert.Error(t, err, "Expected error when writing to invalid path")
+	require.Error(t, err, "Expected error when writing to invalid path")
+	assert.Contains(t, err.Error(), "output directory does not exist: /nonexistent/directory/that/should/not/exist")
 }
 
 func TestWriteTextFile_Overwrite(t *testing.T) {
@@ -135,6 +140,200 @@ func TestWriteTextFile_Overwrite(t *testing.T) {
 	}
 }
 
+// TestWriteTextFile_Gzip verifies a .gz output path is written gzipped and
+// reads back to the original codes through OpenOutputFile
+func TestWriteTextFile_Gzip(t *testing.T) {
+	t.Parallel()
+
+	for _, codes := range [][]string{{"FIFTYOFF", "HAPPYHRS", "SUPER100"}, {}} {
+		outputPath := filepath.Join(t.TempDir(), "valid_codes.txt.gz")
+		require.NoError(t, WriteTextFile(codes, outputPath))
+
+		// The file itself is a gzip stream
+		raw, err := os.ReadFile(outputPath)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, len(raw), 2)
+		assert.Equal(t, []byte{0x1f, 0x8b}, raw[:2])
+
+		f, err := OpenOutputFile(outputPath)
+		require.NoError(t, err)
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		var read []string
+		if len(content) > 0 {
+			read = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		}
+		assert.Equal(t, len(codes), len(read))
+		for i := range codes {
+			assert.Equal(t, codes[i], read[i])
+		}
+	}
+}
+
+func TestWriteJSONFile_Gzip(t *testing.T) {
+	t.Parallel()
+
+	outputPath := filepath.Join(t.TempDir(), "valid_codes.json.gz")
+	require.NoError(t, WriteJSONFile([]string{"FIFTYOFF", "HAPPYHRS"}, outputPath))
+
+	f, err := OpenOutputFile(outputPath)
+	require.NoError(t, err)
+	defer f.Close()
+	var decoded struct {
+		Count int      `json:"count"`
+		Codes []string `json:"codes"`
+	}
+	require.NoError(t, json.NewDecoder(f).Decode(&decoded))
+	assert.Equal(t, 2, decoded.Count)
+	assert.Equal(t, []string{"FIFTYOFF", "HAPPYHRS"}, decoded.Codes)
+}
+
+func TestSummaryPath(t *testing.T) {
+	tests := []struct {
+		outputPath string
+		expected   string
+	}{
+		{outputPath: "valid_codes.txt", expected: "valid_codes.summary.json"},
+		{outputPath: "valid_codes.txt.gz", expected: "valid_codes.summary.json"},
+		{outputPath: "out/codes.json", expected: "out/codes.summary.json"},
+		{outputPath: "codes", expected: "codes.summary.json"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, SummaryPath(tt.outputPath), "output %s", tt.outputPath)
+	}
+}
+
+func TestWriteJSONFile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		codes []string
+	}{
+		{name: "multiple codes", codes: []string{"FIFTYOFF", "HAPPYHRS", "SUPER100"}},
+		{name: "empty slice", codes: []string{}},
+		{name: "single code", codes: []string{"SINGLECODE"}},
+		{name: "codes needing escapes", codes: []string{`CODE"QUOTE`, `BACK\SLASH`, "<TAG>&"}},
+		{name: "unicode", codes: []string{"CAFÉ2024", "日本語コード"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			outputPath := filepath.Join(t.TempDir(), "valid_codes.json")
+			require.NoError(t, WriteJSONFile(tt.codes, outputPath))
+
+			content, err := os.ReadFile(outputPath)
+			require.NoError(t, err)
+			require.True(t, json.Valid(content), "invalid JSON: %s", content)
+
+			var decoded struct {
+				Count int      `json:"count"`
+				Codes []string `json:"codes"`
+			}
+			require.NoError(t, json.Unmarshal(content, &decoded))
+			assert.Equal(t, len(tt.codes), decoded.Count)
+			assert.Equal(t, tt.codes, decoded.Codes)
+		})
+	}
+}
+
+func TestWriteJSONFile_InvalidPath(t *testing.T) {
+	t.Parallel()
+
+	err := WriteJSONFile([]string{"CODE1"}, filepath.Join(t.TempDir(), "missing", "valid_codes.json"))
+	assert.Error(t, err)
+}
+
+func TestWriteBucketStatsFile(t *testing.T) {
+	t.Parallel()
+
+	outputPath := filepath.Join(t.TempDir(), "buckets.csv")
+	buckets := []BucketStats{
+		{Bucket: 3, Lines: 30, Bytes: 330, DistinctCodes: 12, ValidCodes: 9},
+		{Bucket: 741, Lines: 2, Bytes: 22, DistinctCodes: 2, ValidCodes: 0},
+	}
+	require.NoError(t, WriteBucketStatsFile(buckets, outputPath))
+
+	content, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, "bucket,lines,bytes,distinctCodes,validCodes\n3,30,330,12,9\n741,2,22,2,0\n", string(content))
+
+	err = WriteBucketStatsFile(buckets, filepath.Join(t.TempDir(), "missing", "buckets.csv"))
+	assert.Error(t, err)
+}
+
+func TestWriteTextFileAppend(t *testing.T) {
+	tests := []struct {
+		name             string
+		existing         *string
+		codes            []string
+		expectedContent  string
+		expectedAppended int
+	}{
+		{
+			name:             "union without duplicates",
+			existing:         strPtr("CODE1\nCODE2\n"),
+			codes:            []string{"CODE2", "CODE3", "CODE3", "CODE4"},
+			expectedContent:  "CODE1\nCODE2\nCODE3\nCODE4\n",
+			expectedAppended: 2,
+		},
+		{
+			name:             "nothing new",
+			existing:         strPtr("CODE1\nCODE2\n"),
+			codes:            []string{"CODE2", "CODE1"},
+			expectedContent:  "CODE1\nCODE2\n",
+			expectedAppended: 0,
+		},
+		{
+			name:             "unterminated last line",
+			existing:         strPtr("CODE1"),
+			codes:            []string{"CODE2"},
+			expectedContent:  "CODE1\nCODE2\n",
+			expectedAppended: 1,
+		},
+		{
+			name:             "missing file",
+			existing:         nil,
+			codes:            []string{"CODE1", "CODE1"},
+			expectedContent:  "CODE1\n",
+			expectedAppended: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txtPath := filepath.Join(t.TempDir(), "codes.txt")
+			if tt.existing != nil {
+				require.NoError(t, os.WriteFile(txtPath, []byte(*tt.existing), 0644))
+			}
+
+			appended, err := WriteTextFileAppend(tt.codes, txtPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedAppended, appended)
+
+			content, err := os.ReadFile(txtPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedContent, string(content))
+
+			// Appending the same codes again must not change the file
+			appended, err = WriteTextFileAppend(tt.codes, txtPath)
+			require.NoError(t, err)
+			assert.Zero(t, appended)
+
+			content, err = os.ReadFile(txtPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedContent, string(content))
+		})
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
 func TestWriteTextFile_LargeDataset(t *testing.T) {
 	tmpDir := t.TempDir()
 	txtPath := filepath.Join(tmpDir, "large.txt")
@@ -178,6 +377,105 @@ func TestWriteTextFile_PreservesOrder(t *testing.T) {
 	}
 }
 
+func TestWriteTextFilesByLength(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputPath := filepath.Join(tmpDir, "valid_codes.txt")
+
+	codes := []string{"ABCDEFGH", "ABCDEFGHI", "ABCDEFGHIJ", "BCDEFGHI", "BCDEFGHIJK"}
+
+	paths, err := WriteTextFilesByLength(codes, outputPath, "")
+	require.NoError(t, err, "WriteTextFilesByLength should not return error")
+
+	expectedPaths := []string{
+		filepath.Join(tmpDir, "valid_codes_8.txt"),
+		filepath.Join(tmpDir, "valid_codes_9.txt"),
+		filepath.Join(tmpDir, "valid_codes_10.txt"),
+	}
+	require.Equal(t, expectedPaths, paths)
+
+	var union []string
+	for i, path := range paths {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err, "Failed to read %s", path)
+
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		for _, line := range lines {
+			assert.Len(t, line, 8+i, "File %s should only contain codes of length %d", path, 8+i)
+		}
+		union = append(union, lines...)
+	}
+
+	sort.Strings(union)
+	assert.Equal(t, codes, union, "Union of all length files should be the full valid set")
+
+	_, err = os.Stat(outputPath)
+	assert.True(t, os.IsNotExist(err), "Combined output file should not be written")
+}
+
+func TestWriteTextFilesByLength_Graphemes(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputPath := filepath.Join(tmpDir, "valid_codes.txt")
+
+	// Each 8 graphemes long, though 14 and 12 bytes for the last two
+	codes := []string{"ABCDEFGH", "🎉party🎉!", "re\u0301sume\u0301s!"}
+
+	paths, err := WriteTextFilesByLength(codes, outputPath, LengthGraphemes)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(tmpDir, "valid_codes_8.txt")}, paths)
+
+	content, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join(codes, "\n")+"\n", string(content))
+
+	_, err = WriteTextFilesByLength(codes, outputPath, "runes")
+	assert.ErrorContains(t, err, `unknown length unit "runes"`)
+}
+
+func TestWriteSummaryFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputDir := filepath.Join(tmpDir, "input")
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+
+	testData := map[string]string{
+		"file1.txt": "ABCDEFGH\nTESTCODE1\nSHORT\n",
+		"file2.txt": "ABCDEFGH\nTESTCODE2\nVERYLONGCODE123\n",
+		"file3.txt": "IJKLMNOP\nTESTCODE1\nABCDEFGH\n",
+	}
+	for filename, content := range testData {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, filename), []byte(content), 0644))
+	}
+
+	result, err := FindValidCodes(inputDir, Options{Workers: 2})
+	require.NoError(t, err)
+
+	summaryPath := SummaryPath(filepath.Join(tmpDir, "valid_codes.txt"))
+	assert.Equal(t, filepath.Join(tmpDir, "valid_codes.summary.json"), summaryPath)
+
+	err = WriteSummaryFile(result.Stats, summaryPath)
+	require.NoError(t, err, "WriteSummaryFile should not return error")
+
+	content, err := os.ReadFile(summaryPath)
+	require.NoError(t, err, "Failed to read summary file")
+
+	var summary map[string]any
+	require.NoError(t, json.Unmarshal(content, &summary), "Summary should be valid JSON")
+
+	assert.Equal(t, "hash-partition", summary["algorithm"])
+	assert.Len(t, summary["inputFiles"], 3)
+	assert.EqualValues(t, 9, summary["codesRead"])
+	assert.EqualValues(t, 2, summary["codesFiltered"])
+	assert.EqualValues(t, 2, summary["validCodes"])
+	assert.Contains(t, summary, "elapsedSeconds")
+
+	params, ok := summary["parameters"].(map[string]any)
+	require.True(t, ok, "Summary should contain parameters")
+	assert.EqualValues(t, 2, params["workers"])
+	assert.EqualValues(t, 1000, params["buckets"])
+	assert.EqualValues(t, 8, params["minLength"])
+	assert.EqualValues(t, 10, params["maxLength"])
+	assert.EqualValues(t, 2, params["minFiles"])
+}
+
 // Benchmarks
 
 func BenchmarkWriteTextFile_Small(b *testing.B) {
@@ -226,6 +524,32 @@ func BenchmarkWriteTextFile_Large(b *testing.B) {
 	}
 }
 
+// BenchmarkWriteTextFile_Allocs compares the allocations of WriteTextFile,
+// which streams codes through a buffered writer, with joining every code into
+// one string first, whose allocation grows with the output
+func BenchmarkWriteTextFile_Allocs(b *testing.B) {
+	codes := make([]string, 100_000)
+	for i := range codes {
+		codes[i] = fmt.Sprintf("CODE%06d", i)
+	}
+	txtPath := filepath.Join(b.TempDir(), "bench_allocs.txt")
+
+	b.Run("Streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, WriteTextFile(codes, txtPath))
+		}
+	})
+
+	b.Run("Joined", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			content := strings.Join(codes, "\n") + "\n"
+			require.NoError(b, os.WriteFile(txtPath, []byte(content), 0644))
+		}
+	})
+}
+
 // TestWriteTextFile_UnicodeContent tests writing codes with unicode characters
 func TestWriteTextFile_UnicodeContent(t *testing.T) {
 	t.Parallel()